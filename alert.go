@@ -0,0 +1,120 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAlertThreshold = 10
+	defaultAlertCooldown  = 30 * time.Minute
+	alertTimeout          = 5 * time.Second
+)
+
+// alertNotifier posts a summary to a webhook (e.g: a Slack incoming webhook) once threshold consecutive
+// sends failed permanently, then stays quiet for cooldown. A successful send resets the count, transient
+// failures don't count: the retries and the circuit breaker deal with them.
+type alertNotifier struct {
+	mu        sync.Mutex
+	url       string
+	threshold int
+	cooldown  time.Duration
+	client    *http.Client
+	clock     Clock
+
+	failures  int
+	lastAlert time.Time
+}
+
+func newAlertNotifier(url string, threshold int, cooldown time.Duration, client *http.Client,
+	clock Clock) *alertNotifier {
+	return &alertNotifier{url: url, threshold: threshold, cooldown: cooldown, client: client, clock: clock}
+}
+
+// alert is the summary posted to the webhook. Text is what Slack displays.
+type alert struct {
+	Text      string `json:"text"`
+	Service   string `json:"service"`
+	Dataset   string `json:"dataset"`
+	Failures  int    `json:"failures"`
+	LastError string `json:"last_error"`
+}
+
+// record counts the outcome of a send, and posts the alert when the threshold is reached outside of the
+// cooldown. It is posted inline, as the instance CPU may be throttled once the invocation returns, and
+// its failure is only logged.
+func (n *alertNotifier) record(ctx context.Context, dataset string, err error) {
+	n.mu.Lock()
+	if err == nil {
+		n.failures = 0
+		n.mu.Unlock()
+		return
+	}
+	if !errors.Is(err, ErrPermanent) {
+		n.mu.Unlock()
+		return
+	}
+	n.failures++
+	failures := n.failures
+	due := failures >= n.threshold && (n.lastAlert.IsZero() || since(n.clock, n.lastAlert) >= n.cooldown)
+	if due {
+		n.lastAlert = n.clock.Now()
+	}
+	n.mu.Unlock()
+	if !due {
+		return
+	}
+
+	service := os.Getenv("K_SERVICE")
+	if service == "" {
+		service = serviceName
+	}
+	summary := alert{
+		Text: fmt.Sprintf("%s: %d consecutive sends to Honeycomb failed permanently, the last one for dataset %q: %s",
+			service, failures, dataset, err.Error()),
+		Service:   service,
+		Dataset:   dataset,
+		Failures:  failures,
+		LastError: err.Error(),
+	}
+	if postErr := n.post(ctx, summary); postErr != nil {
+		loggerFrom(ctx).Warn("error posting the failure alert", "dataset", dataset, "error", postErr.Error())
+		return
+	}
+	loggerFrom(ctx).Info("failure alert posted", "dataset", dataset, "failures", failures,
+		"cooldown", n.cooldown.String())
+}
+
+// post sends the alert to the webhook.
+func (n *alertNotifier) post(ctx context.Context, summary alert) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("error encoding alert %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error initializing alert request %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending alert request %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("error alert webhook returned status %d: %s", resp.StatusCode,
+			summarizeBody(resp.Header.Get("Content-Type"), respBody))
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeWebhook is an httptest server standing for the alert webhook, recording the alerts posted to it.
+type fakeWebhook struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	alerts []alert
+}
+
+func newFakeWebhook(t *testing.T, status int) *fakeWebhook {
+	t.Helper()
+	f := &fakeWebhook{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("error decoding the alert: %v", err)
+		}
+		f.mu.Lock()
+		f.alerts = append(f.alerts, a)
+		f.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeWebhook) received() []alert {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]alert(nil), f.alerts...)
+}
+
+func TestAlertNotifier(t *testing.T) {
+	webhook := newFakeWebhook(t, http.StatusOK)
+	clock := newFakeClock(testPublishTime)
+	notifier := newAlertNotifier(webhook.URL, 3, 10*time.Minute, webhook.Client(), clock)
+	permanent := withCategory(errors.New("error honeycomb returned status 403"), ErrPermanent)
+	transient := withCategory(errors.New("error honeycomb returned status 503"), ErrTransient)
+	wantAlerts := func(want int) {
+		t.Helper()
+		if got := len(webhook.received()); got != want {
+			t.Fatalf("%d alerts posted, want %d", got, want)
+		}
+	}
+
+	// Transient failures don't count, nor break the streak
+	notifier.record(quietContext(), "test-dataset", permanent)
+	notifier.record(quietContext(), "test-dataset", transient)
+	notifier.record(quietContext(), "test-dataset", permanent)
+	wantAlerts(0)
+	notifier.record(quietContext(), "test-dataset", permanent)
+	wantAlerts(1)
+	got := webhook.received()[0]
+	if got.Failures != 3 || got.Dataset != "test-dataset" || got.LastError != permanent.Error() ||
+		!strings.Contains(got.Text, "3 consecutive sends") || got.Service == "" {
+		t.Errorf("alert = %+v, want the summary of the 3 failures", got)
+	}
+
+	// Quiet during the cooldown
+	notifier.record(quietContext(), "test-dataset", permanent)
+	clock.Advance(9 * time.Minute)
+	notifier.record(quietContext(), "other-dataset", permanent)
+	wantAlerts(1)
+	clock.Advance(time.Minute)
+	notifier.record(quietContext(), "other-dataset", permanent)
+	wantAlerts(2)
+	if got := webhook.received()[1]; got.Failures != 6 || got.Dataset != "other-dataset" {
+		t.Errorf("alert = %+v, want the 6 failures and the last dataset", got)
+	}
+
+	// A success resets the count
+	clock.Advance(time.Hour)
+	notifier.record(quietContext(), "test-dataset", nil)
+	notifier.record(quietContext(), "test-dataset", permanent)
+	notifier.record(quietContext(), "test-dataset", permanent)
+	wantAlerts(2)
+}
+
+func TestHandlerAlertWebhook(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusForbidden)
+	honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		respondWith(int(status.Load()), `{"error":"unknown API key"}`)(w, r, body)
+	})
+	webhook := newFakeWebhook(t, http.StatusOK)
+	cfg := testConfig(honeycomb)
+	cfg.Clock = newFakeClock(testPublishTime)
+	cfg.AlertWebhookURL = webhook.URL
+	cfg.AlertThreshold = 3
+	handler, _ := NewHandler(cfg)
+	handle := func() error {
+		t.Helper()
+		return handler(context.Background(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)}))
+	}
+
+	for i := 1; i <= 5; i++ {
+		if err := handle(); !errors.Is(err, ErrPermanent) {
+			t.Fatalf("handler error = %v, want a permanent error", err)
+		}
+		want := 0
+		if i >= cfg.AlertThreshold {
+			want = 1 // then the cooldown
+		}
+		if len(webhook.received()) != want {
+			t.Fatalf("%d alerts posted after %d failures, want %d", len(webhook.received()), i, want)
+		}
+	}
+	got := webhook.received()[0]
+	if got.Failures != 3 || got.Dataset != "test-dataset" || !strings.Contains(got.LastError, "403") {
+		t.Errorf("alert = %+v, want the 3 failures of test-dataset with the 403", got)
+	}
+
+	status.Store(http.StatusOK)
+	if err := handle(); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	if n := len(webhook.received()); n != 1 {
+		t.Errorf("%d alerts posted, want none for a success", n)
+	}
+}
+
+func TestHandlerAlertWebhookDown(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, respondWith(http.StatusForbidden, `{"error":"unknown API key"}`))
+	webhook := newFakeWebhook(t, http.StatusInternalServerError)
+	var logs logRecorder
+	cfg := testConfig(honeycomb)
+	cfg.Logger = newRecordingLogger(&logs)
+	cfg.AlertWebhookURL = webhook.URL
+	cfg.AlertThreshold = 1
+
+	// The webhook failure is logged, the send error is returned as is
+	err := handleData(t, cfg, `{"a":1}`)
+	var statusErr *honeycombStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusForbidden {
+		t.Errorf("handler error = %v, want the Honeycomb 403", err)
+	}
+	if len(webhook.received()) != 1 {
+		t.Fatalf("%d alerts posted, want 1", len(webhook.received()))
+	}
+	if records := logs.records(t, "error posting the failure alert"); len(records) != 1 {
+		t.Errorf("alert error logs = %v, want one", records)
+	}
+}
@@ -0,0 +1,76 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// errSubscriptionNotAllowed is returned for a message whose subscription may not write to its dataset.
+var errSubscriptionNotAllowed = withCategory(errors.New("error subscription not allowed"), ErrPermanent)
+
+// subscriptionAllowed reports whether the subscription may write to the dataset. Datasets without
+// patterns accept every subscription. A pattern (path.Match syntax, e.g: "prod-*") matches the full
+// subscription name (projects/<project>/subscriptions/<subscription>) or its last segment.
+func subscriptionAllowed(allowlist map[string][]string, dataset string, subscription string) bool {
+	patterns, ok := allowlist[dataset]
+	if !ok {
+		return true
+	}
+	short := subscription[strings.LastIndex(subscription, "/")+1:]
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, subscription); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, short); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSubscription returns errSubscriptionNotAllowed when the subscription may not write to one of the
+// datasets. Once an allowlist is set, messages without a subscription (CloudEvents from other sources,
+// push requests without one) are refused too: the subscription of a push request comes from its body, and
+// leaving it out mustn't skip the check. SendEvent and replays don't go through it.
+func checkSubscription(allowlist map[string][]string, subscription string, datasets []string) error {
+	if len(allowlist) == 0 {
+		return nil
+	}
+	if subscription == "" {
+		return fmt.Errorf("%w: a message without a subscription may not be forwarded with an allowlist",
+			errSubscriptionNotAllowed)
+	}
+	for _, dataset := range datasets {
+		if !subscriptionAllowed(allowlist, dataset, subscription) {
+			return fmt.Errorf("%w: %q may not write to dataset %q", errSubscriptionNotAllowed, subscription, dataset)
+		}
+	}
+	return nil
+}
+
+// getSubscriptionAllowlistEnvVar reads the optional HONEYCOMB_SUBSCRIPTION_ALLOWLIST JSON object mapping
+// datasets to the patterns of the subscriptions allowed to write to them.
+func getSubscriptionAllowlistEnvVar() (map[string][]string, error) {
+	value := os.Getenv("HONEYCOMB_SUBSCRIPTION_ALLOWLIST")
+	if value == "" {
+		return nil, nil
+	}
+	var allowlist map[string][]string
+	if err := json.Unmarshal([]byte(value), &allowlist); err != nil {
+		return nil, fmt.Errorf("error, HONEYCOMB_SUBSCRIPTION_ALLOWLIST environment variable must be a JSON object "+
+			"of arrays of subscription patterns %w", err)
+	}
+	for dataset, patterns := range allowlist {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("error, invalid subscription pattern %q for dataset %q in "+
+					"HONEYCOMB_SUBSCRIPTION_ALLOWLIST environment variable %w", pattern, dataset, err)
+			}
+		}
+	}
+	return allowlist, nil
+}
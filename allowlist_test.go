@@ -0,0 +1,107 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSubscriptionAllowed(t *testing.T) {
+	allowlist := map[string][]string{
+		"billing": {"projects/prod/subscriptions/billing-*", "audit"},
+		"closed":  {},
+	}
+	tests := []struct {
+		dataset      string
+		subscription string
+		want         bool
+	}{
+		{dataset: "billing", subscription: "projects/prod/subscriptions/billing-eu", want: true},
+		{dataset: "billing", subscription: "projects/staging/subscriptions/billing-eu"},
+		{dataset: "billing", subscription: "projects/staging/subscriptions/audit", want: true},
+		{dataset: "billing", subscription: "audit", want: true},
+		{dataset: "billing", subscription: "projects/prod/subscriptions/orders"},
+		{dataset: "closed", subscription: "projects/prod/subscriptions/orders"},
+		{dataset: "other", subscription: "projects/prod/subscriptions/orders", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dataset+" "+tt.subscription, func(t *testing.T) {
+			if got := subscriptionAllowed(allowlist, tt.dataset, tt.subscription); got != tt.want {
+				t.Errorf("subscriptionAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSubscription(t *testing.T) {
+	allowlist := map[string][]string{"billing": {"billing-*"}}
+	tests := []struct {
+		name         string
+		allowlist    map[string][]string
+		subscription string
+		datasets     []string
+		wantErr      bool
+	}{
+		{name: "no allowlist", subscription: "orders", datasets: []string{"billing"}},
+		{name: "no allowlist nor subscription", datasets: []string{"billing"}},
+		{name: "allowed", allowlist: allowlist, subscription: "projects/p/subscriptions/billing-eu",
+			datasets: []string{"billing", "other"}},
+		{name: "one fan-out dataset refused", allowlist: allowlist, subscription: "projects/p/subscriptions/orders",
+			datasets: []string{"other", "billing"}, wantErr: true},
+		{name: "no subscription", allowlist: allowlist, datasets: []string{"billing"}, wantErr: true},
+		{name: "no subscription for an unlisted dataset", allowlist: allowlist, datasets: []string{"other"},
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSubscription(tt.allowlist, tt.subscription, tt.datasets)
+			if tt.wantErr != (err != nil) || (err != nil && !errors.Is(err, errSubscriptionNotAllowed)) {
+				t.Errorf("checkSubscription() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrPermanent) {
+				t.Errorf("checkSubscription() error = %v, want a permanent error", err)
+			}
+		})
+	}
+}
+
+func TestHandlerSubscriptionAllowlist(t *testing.T) {
+	// A push request can leave the subscription out of its body
+	withoutSubscription, err := pushEvent([]byte(`{"message":{"data":"eyJhIjoxfQ==","messageId":"1"}}`), "")
+	if err != nil {
+		t.Fatalf("pushEvent() error = %v", err)
+	}
+	tests := []struct {
+		name           string
+		allowlist      map[string][]string
+		noSubscription bool
+		wantSent       bool
+	}{
+		{name: "allowed", allowlist: map[string][]string{"test-dataset": {"my-sub"}}, wantSent: true},
+		{name: "refused", allowlist: map[string][]string{"test-dataset": {"other-sub"}}},
+		{name: "no subscription", allowlist: map[string][]string{"test-dataset": {"my-sub"}}, noSubscription: true},
+		{name: "no subscription nor allowlist", noSubscription: true, wantSent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.SubscriptionAllowlist = tt.allowlist
+			e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})
+			if tt.noSubscription {
+				e = withoutSubscription
+			}
+
+			handler, _ := NewHandler(cfg)
+			err := handler(quietContext(), e)
+			if sent := len(honeycomb.received()) > 0; sent != tt.wantSent {
+				t.Errorf("event sent: %v, want %v", sent, tt.wantSent)
+			}
+			if !tt.wantSent && !errors.Is(err, errSubscriptionNotAllowed) {
+				t.Errorf("handler error = %v, want errSubscriptionNotAllowed", err)
+			}
+			if tt.wantSent && err != nil {
+				t.Errorf("handler error = %v", err)
+			}
+		})
+	}
+}
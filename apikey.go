@@ -0,0 +1,129 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Honeycomb API key types.
+// See the documentation for more details:
+// https://docs.honeycomb.io/get-started/configure/environments/manage-api-keys/
+const (
+	keyTypeClassic     = "classic"
+	keyTypeEnvironment = "environment"
+	keyTypeUnknown     = "unknown"
+)
+
+var (
+	classicKeyPattern     = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	environmentKeyPattern = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+)
+
+// honeycombKeyType detects the type of an API key: Classic configuration keys are 32 hex characters and
+// Classic ingest keys start with hcxik_, Environment configuration keys are 22 alphanumeric characters
+// and Environment ingest keys start with hcaik_.
+func honeycombKeyType(key string) string {
+	switch {
+	case strings.HasPrefix(key, "hcxik_"), classicKeyPattern.MatchString(key):
+		return keyTypeClassic
+	case strings.HasPrefix(key, "hcaik_"), environmentKeyPattern.MatchString(key):
+		return keyTypeEnvironment
+	default:
+		return keyTypeUnknown
+	}
+}
+
+// checkDatasetForKey checks the dataset configuration matches the API key type. The events API always
+// takes the dataset in the URL. OTLP data sent with a Classic key needs a dataset, while Environment keys
+// route it by service.name, so the dataset is then only used as the service name.
+func checkDatasetForKey(c Config) error {
+	key := c.APIKey
+	if c.SinkMode == sinkModeOTLP {
+		key = ""
+		for name, value := range c.OTLPHeaders {
+			if strings.EqualFold(name, "x-honeycomb-team") {
+				key = value
+			}
+		}
+	}
+	if key == "" {
+		return nil
+	}
+	keyType := honeycombKeyType(key)
+
+	switch {
+	case keyType == keyTypeUnknown:
+		slog.Warn("the Honeycomb API key format isn't recognized, check it was copied entirely",
+			"api_key", redact(key))
+	case c.SinkMode == sinkModeOTLP && keyType == keyTypeClassic && c.Dataset == "":
+		return fmt.Errorf("error, HONEYCOMB_DATASET environment variable is required with a Honeycomb Classic API key")
+	case c.SinkMode == sinkModeOTLP && keyType == keyTypeEnvironment && c.Dataset != "":
+		slog.Warn("Honeycomb Environment keys route OTLP data by service.name, HONEYCOMB_DATASET is only used "+
+			"as the service name", "dataset", c.Dataset)
+	}
+	return nil
+}
+
+// apiKeyFor returns the API key of a message: the key mapped to one of its PubSub attributes (entries
+// named "<attribute>=<value>", the first attribute by name wins), else the one mapped to its dataset, else
+// the default API key.
+func apiKeyFor(cfg Config, dataset string, attributes map[string]string) string {
+	if len(cfg.APIKeys) == 0 {
+		return cfg.APIKey
+	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if key, ok := cfg.APIKeys[name+"="+attributes[name]]; ok {
+			return key
+		}
+	}
+	if key, ok := cfg.APIKeys[dataset]; ok {
+		return key
+	}
+	return cfg.APIKey
+}
+
+// getAPIKeysEnvVar reads the optional HONEYCOMB_API_KEYS JSON object mapping datasets, or
+// "<attribute>=<value>" PubSub attribute selectors, to API keys. The keys are never part of the errors.
+func getAPIKeysEnvVar() (map[string]string, error) {
+	value := os.Getenv("HONEYCOMB_API_KEYS")
+	if value == "" {
+		return nil, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		// The decoding error may quote the value
+		return nil, fmt.Errorf("error, HONEYCOMB_API_KEYS environment variable must be a JSON object")
+	}
+	keys := make(map[string]string, len(fields))
+	for selector, key := range fields {
+		s, ok := key.(string)
+		if !ok || strings.TrimSpace(s) == "" {
+			return nil, fmt.Errorf("error, HONEYCOMB_API_KEYS environment variable maps %q to an empty or "+
+				"non-string key", selector)
+		}
+		if attribute, _, isAttribute := strings.Cut(selector, "="); isAttribute {
+			if attribute == "" {
+				return nil, fmt.Errorf("error, HONEYCOMB_API_KEYS environment variable has a selector without "+
+					"attribute name: %q", selector)
+			}
+		} else if err := validateDataset(selector); err != nil {
+			return nil, fmt.Errorf("error, invalid dataset in HONEYCOMB_API_KEYS environment variable: %w", err)
+		}
+		if honeycombKeyType(s) == keyTypeUnknown {
+			slog.Warn("the Honeycomb API key format isn't recognized, check it was copied entirely",
+				"selector", selector, "api_key", redact(s))
+		}
+		keys[selector] = s
+	}
+	return keys, nil
+}
@@ -0,0 +1,85 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAsyncQueueSize = 1000
+	// asyncSendTimeout bounds the send of a queued event, retries included, as it no longer has the
+	// deadline of its invocation.
+	asyncSendTimeout = 2 * time.Minute
+)
+
+// asyncSink decouples the invocations from the sends: Send queues the event and returns, a fixed pool of
+// workers forwards the queued events to the next sink. Send blocks while the queue is full, so PubSub
+// throttles the deliveries. The message is ACKed before its event is sent: an event which fails, or is
+// still queued when the instance is killed, is lost.
+type asyncSink struct {
+	next   Sink
+	queue  chan queuedEvent
+	logger *slog.Logger
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// queuedEvent is an event waiting for a worker, with the logger of the invocation which queued it.
+type queuedEvent struct {
+	event  Event
+	logger *slog.Logger
+}
+
+func newAsyncSink(next Sink, workers int, queueSize int, logger *slog.Logger) *asyncSink {
+	s := &asyncSink{next: next, queue: make(chan queuedEvent, queueSize), logger: logger}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+	return s
+}
+
+func (s *asyncSink) Send(ctx context.Context, event Event) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		// Draining, the workers are gone
+		return s.next.Send(ctx, event)
+	}
+	select {
+	case s.queue <- queuedEvent{event: event, logger: loggerFrom(ctx)}:
+		return nil
+	case <-ctx.Done():
+		return withCategory(fmt.Errorf("error waiting for room in the send queue %w", ctx.Err()), ErrTransient)
+	}
+}
+
+func (s *asyncSink) work() {
+	defer s.wg.Done()
+	for queued := range s.queue {
+		ctx, cancel := context.WithTimeout(withLogger(context.Background(), queued.logger), asyncSendTimeout)
+		event := queued.event
+		if err := s.next.Send(ctx, event); err != nil {
+			queued.logger.Error("error sending a queued event, it is lost as its message was acknowledged",
+				"message_id", event.PubSub.Message.MessageID, "dataset", event.Dataset, "error", err.Error())
+		}
+		cancel()
+	}
+}
+
+// drain stops queueing and waits for the workers to send the queued events, e.g: on shutdown.
+func (s *asyncSink) drain() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	s.logger.Info("draining the send queue", "events", len(s.queue))
+	s.wg.Wait()
+}
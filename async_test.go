@@ -0,0 +1,98 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingSink is a Sink recording the events it sends, which waits for release before each send.
+type blockingSink struct {
+	release chan struct{}
+	err     error
+
+	mu   sync.Mutex
+	sent []Event
+}
+
+func (s *blockingSink) Send(ctx context.Context, event Event) error {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, event)
+	return s.err
+}
+
+func (s *blockingSink) events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.sent...)
+}
+
+func queuedTestEvent(id string) Event {
+	return Event{PubSub: MessagePublishedData{Message: PubSubMessage{MessageID: id}}, Dataset: "test-dataset"}
+}
+
+func TestAsyncSink(t *testing.T) {
+	next := &blockingSink{release: make(chan struct{})}
+	s := newAsyncSink(next, 1, 1, discardLogger)
+
+	// The first event is taken by the worker, the second one fills the queue: Send returns before
+	// they are sent
+	if err := s.Send(quietContext(), queuedTestEvent("message-1")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	for len(s.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Send(quietContext(), queuedTestEvent("message-2")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if n := len(next.events()); n != 0 {
+		t.Fatalf("%d events sent, want them queued", n)
+	}
+
+	// The queue is full, Send waits for room
+	ctx, cancel := context.WithTimeout(quietContext(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Send(ctx, queuedTestEvent("message-3")); !errors.Is(err, ErrTransient) {
+		t.Errorf("Send() error = %v, want a transient error while the queue is full", err)
+	}
+
+	// The draining waits for the queued events, then the events are sent directly
+	close(next.release)
+	s.drain()
+	if n := len(next.events()); n != 2 {
+		t.Errorf("%d events sent on drain, want the 2 queued ones", n)
+	}
+	if err := s.Send(quietContext(), queuedTestEvent("message-4")); err != nil {
+		t.Fatalf("Send() after the drain error = %v", err)
+	}
+	if sent := next.events(); len(sent) != 3 || sent[2].PubSub.Message.MessageID != "message-4" {
+		t.Errorf("sent = %+v, want message-4 sent directly once drained", sent)
+	}
+}
+
+func TestAsyncSinkSendFailure(t *testing.T) {
+	next := &blockingSink{release: make(chan struct{}), err: errors.New("error honeycomb returned status 400")}
+	close(next.release)
+	var logs logRecorder
+	s := newAsyncSink(next, 1, 1, discardLogger)
+
+	// The failure of a queued event is logged with the logger of the invocation which queued it
+	ctx := withLogger(context.Background(), newRecordingLogger(&logs).With("subscription", "my-sub"))
+	if err := s.Send(ctx, queuedTestEvent("message-1")); err != nil {
+		t.Fatalf("Send() error = %v, want the failure not returned", err)
+	}
+	s.drain()
+	records := logs.records(t, "error sending a queued event, it is lost as its message was acknowledged")
+	if len(records) != 1 || records[0]["message_id"] != "message-1" || records[0]["subscription"] != "my-sub" {
+		t.Errorf("log records = %v, want the lost event logged with the invocation attributes", records)
+	}
+}
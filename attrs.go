@@ -0,0 +1,102 @@
+package HoneycombSinkHandler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Casings of the attribute keys added with IncludeAttrs.
+const (
+	attrCaseSnake    = "snake"
+	attrCaseCamel    = "camel"
+	attrCasePreserve = "preserve"
+)
+
+// allAttrs selects all the attributes of the message.
+const allAttrs = "*"
+
+// validateAttrCase checks the casing of the included attribute keys.
+func validateAttrCase(casing string) error {
+	switch casing {
+	case attrCaseSnake, attrCaseCamel, attrCasePreserve:
+		return nil
+	}
+	return fmt.Errorf("unknown attribute casing %q, expected %s, %s or %s", casing, attrCaseSnake, attrCaseCamel,
+		attrCasePreserve)
+}
+
+// includedAttributes returns the PubSub attributes selected by names ("*" for all) as fields, their keys
+// converted to casing. When two attributes convert to the same key, the first one in sorted order wins.
+func includedAttributes(attributes map[string]string, names []string, casing string) map[string]any {
+	all := false
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		all = all || name == allAttrs
+		selected[name] = true
+	}
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		if all || selected[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	fields := make(map[string]any, len(keys))
+	for _, key := range keys {
+		name := convertCase(key, casing)
+		if _, exists := fields[name]; name == "" || exists {
+			continue
+		}
+		fields[name] = attributes[key]
+	}
+	return fields
+}
+
+// convertCase converts an attribute key (e.g: "userId", "X-Request-ID", "HTTPStatus") to snake_case
+// ("user_id", "x_request_id", "http_status") or camelCase ("userId", "xRequestId", "httpStatus").
+func convertCase(key string, casing string) string {
+	if casing == attrCasePreserve {
+		return key
+	}
+	words := splitWords(key)
+	if casing == attrCaseCamel {
+		for i := 1; i < len(words); i++ {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+		return strings.Join(words, "")
+	}
+	return strings.Join(words, "_")
+}
+
+// splitWords splits a key into its lowercased words, at the separators, at the lower to upper case
+// transitions and at the end of the acronyms.
+func splitWords(key string) []string {
+	var words []string
+	var word []rune
+	runes := []rune(key)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = nil
+			}
+			continue
+		}
+		if unicode.IsUpper(r) && len(word) > 0 {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextLower) {
+				words = append(words, string(word))
+				word = nil
+			}
+		}
+		word = append(word, unicode.ToLower(r))
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
@@ -0,0 +1,142 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestConvertCase(t *testing.T) {
+	tests := []struct {
+		key       string
+		wantSnake string
+		wantCamel string
+	}{
+		{key: "userId", wantSnake: "user_id", wantCamel: "userId"},
+		{key: "X-Request-ID", wantSnake: "x_request_id", wantCamel: "xRequestId"},
+		{key: "HTTPStatus", wantSnake: "http_status", wantCamel: "httpStatus"},
+		{key: "googclient_schemaencoding", wantSnake: "googclient_schemaencoding",
+			wantCamel: "googclientSchemaencoding"},
+		{key: "logging.googleapis.com/timestamp", wantSnake: "logging_googleapis_com_timestamp",
+			wantCamel: "loggingGoogleapisComTimestamp"},
+		{key: "retry2Count", wantSnake: "retry2_count", wantCamel: "retry2Count"},
+		{key: "  team  ", wantSnake: "team", wantCamel: "team"},
+		{key: "équipeNom", wantSnake: "équipe_nom", wantCamel: "équipeNom"},
+		{key: "---", wantSnake: "", wantCamel: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := convertCase(tt.key, attrCaseSnake); got != tt.wantSnake {
+				t.Errorf("convertCase(%q, snake) = %q, want %q", tt.key, got, tt.wantSnake)
+			}
+			if got := convertCase(tt.key, attrCaseCamel); got != tt.wantCamel {
+				t.Errorf("convertCase(%q, camel) = %q, want %q", tt.key, got, tt.wantCamel)
+			}
+			if got := convertCase(tt.key, attrCasePreserve); got != tt.key {
+				t.Errorf("convertCase(%q, preserve) = %q, want it unchanged", tt.key, got)
+			}
+		})
+	}
+}
+
+func TestIncludedAttributes(t *testing.T) {
+	attributes := map[string]string{"userId": "u-1", "X-Request-ID": "r-1", "team": "payments"}
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		names      []string
+		casing     string
+		want       map[string]any
+	}{
+		{name: "selected", attributes: attributes, names: []string{"userId", "team", "missing"},
+			casing: attrCaseSnake, want: map[string]any{"user_id": "u-1", "team": "payments"}},
+		{name: "all", attributes: attributes, names: []string{allAttrs}, casing: attrCaseCamel,
+			want: map[string]any{"userId": "u-1", "xRequestId": "r-1", "team": "payments"}},
+		{name: "preserved", attributes: attributes, names: []string{"X-Request-ID"}, casing: attrCasePreserve,
+			want: map[string]any{"X-Request-ID": "r-1"}},
+		{name: "names are case sensitive", attributes: attributes, names: []string{"userid"},
+			casing: attrCaseSnake, want: map[string]any{}},
+		{name: "converted keys collide", attributes: map[string]string{"user_id": "second", "userId": "first"},
+			names: []string{allAttrs}, casing: attrCaseSnake, want: map[string]any{"user_id": "first"}}, // I < _
+		{name: "keys without letters skipped", attributes: map[string]string{"--": "x", "a": "1"},
+			names: []string{allAttrs}, casing: attrCaseSnake, want: map[string]any{"a": "1"}},
+		{name: "no attributes", names: []string{allAttrs}, casing: attrCaseSnake, want: map[string]any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := includedAttributes(tt.attributes, tt.names, tt.casing); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("includedAttributes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerIncludeAttrs(t *testing.T) {
+	attributes := map[string]string{"userId": "u-1", "X-Request-ID": "r-1", "level": "attribute"}
+	tests := []struct {
+		name     string
+		include  []string
+		casing   string
+		strategy string
+		data     string
+		want     []string // the event bodies, or the batch event data
+	}{
+		{name: "snake case by default", include: []string{"userId", "X-Request-ID"}, data: `{"a":1}`,
+			want: []string{`{"a":1,"user_id":"u-1","x_request_id":"r-1"}`}},
+		{name: "camel case", include: []string{"X-Request-ID"}, casing: attrCaseCamel, data: `{"a":1}`,
+			want: []string{`{"a":1,"xRequestId":"r-1"}`}},
+		{name: "producer wins a collision", include: []string{allAttrs}, data: `{"level":"payload"}`,
+			want: []string{`{"level":"payload","user_id":"u-1","x_request_id":"r-1"}`}},
+		{name: "prefixed on a collision", include: []string{"level"}, strategy: mergePrefix,
+			data: `{"level":"payload"}`, want: []string{`{"level":"payload","sink.level":"attribute"}`}},
+		{name: "each batch event", include: []string{"userId"}, data: `[{"a":1},{"b":2}]`,
+			want: []string{`{"a":1,"user_id":"u-1"}`, `{"b":2,"user_id":"u-1"}`}},
+		{name: "not an object", include: []string{"userId"}, data: `"text"`, want: []string{`"text"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.IncludeAttrs = tt.include
+			cfg.AttrCase = tt.casing
+			cfg.MergeStrategy = tt.strategy
+
+			e := pubSubEvent(t, PubSubMessage{Data: []byte(tt.data), Attributes: attributes})
+			handler, _ := NewHandler(cfg)
+			if err := handler(quietContext(), e); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			var got []string
+			if len(tt.want) > 1 {
+				for _, event := range honeycomb.lastBatch(t) {
+					got = append(got, string(event.Data))
+				}
+			} else {
+				got = []string{string(honeycomb.received()[0].Body)}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttrCaseFromEnv(t *testing.T) {
+	t.Setenv("HONEYCOMB_DATASET", "test-dataset")
+	t.Setenv("HONEYCOMB_API_KEY", "test-api-key")
+	t.Setenv("HONEYCOMB_INCLUDE_ATTRS", "userId, X-Request-ID")
+	t.Setenv("HONEYCOMB_ATTR_CASE", "camel")
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConfigFromEnv() error = %v", err)
+	}
+	if !reflect.DeepEqual(cfg.IncludeAttrs, []string{"userId", "X-Request-ID"}) || cfg.AttrCase != attrCaseCamel {
+		t.Errorf("IncludeAttrs = %q, AttrCase = %q, want the two attributes in camel case", cfg.IncludeAttrs,
+			cfg.AttrCase)
+	}
+
+	t.Setenv("HONEYCOMB_ATTR_CASE", "kebab")
+	if _, err := ConfigFromEnv(); !errors.Is(err, ErrConfig) {
+		t.Errorf("ConfigFromEnv() error = %v, want a configuration error", err)
+	}
+}
@@ -0,0 +1,228 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit destinations selected with AUDIT_SINK.
+const (
+	auditSinkStdout = "stdout"
+	auditSinkGCS    = "gcs"
+)
+
+const storageUploadURL = "https://storage.googleapis.com/upload/storage/v1/"
+
+// auditRecord is the copy of a forwarded event kept for audit.
+type auditRecord struct {
+	MessageID   string          `json:"message_id"`
+	Dataset     string          `json:"dataset"`
+	ForwardedAt time.Time       `json:"forwarded_at"`
+	PublishTime *time.Time      `json:"publish_time,omitempty"`
+	Event       json.RawMessage `json:"event"`
+}
+
+// auditWriter stores audit records.
+type auditWriter interface {
+	write(ctx context.Context, record auditRecord) error
+}
+
+// auditSink keeps an audit copy of each event the wrapped sink forwarded successfully. An audit failure
+// is logged, and only fails the send when required is set: the message is then redelivered, and sent to
+// Honeycomb again.
+type auditSink struct {
+	next     Sink
+	writer   auditWriter
+	required bool
+	// dryRun skips the audit, nothing was forwarded
+	dryRun bool
+	clock  Clock
+}
+
+func newAuditSink(next Sink, cfg Config) *auditSink {
+	var writer auditWriter = &stdoutAuditWriter{out: os.Stdout}
+	if cfg.AuditSink == auditSinkGCS {
+		writer = newGCSAuditWriter(cfg)
+	}
+	return &auditSink{next: next, writer: writer, required: cfg.AuditRequired, dryRun: cfg.DryRun, clock: cfg.Clock}
+}
+
+func (s *auditSink) Send(ctx context.Context, event Event) error {
+	if err := s.next.Send(ctx, event); err != nil || s.dryRun {
+		return err
+	}
+	record := auditRecord{
+		MessageID:   event.PubSub.Message.MessageID,
+		Dataset:     event.Dataset,
+		ForwardedAt: s.clock.Now().UTC(),
+		Event:       event.PubSub.Message.Data,
+	}
+	if publishTime := event.PubSub.Message.PublishTime; !publishTime.IsZero() {
+		record.PublishTime = &publishTime
+	}
+	if err := s.writer.write(ctx, record); err != nil {
+		if s.required {
+			return withCategory(fmt.Errorf("error writing the audit record of the event %w", err), ErrTransient)
+		}
+		loggerFrom(ctx).Error("error writing the audit record of the event, the event was forwarded",
+			"message_id", record.MessageID, "dataset", record.Dataset, "error", err.Error())
+	}
+	return nil
+}
+
+// stdoutAuditWriter writes the audit records as structured log entries, so a log sink can route them
+// to their retention bucket.
+type stdoutAuditWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *stdoutAuditWriter) write(_ context.Context, record auditRecord) error {
+	line, err := json.Marshal(map[string]any{
+		"severity": "NOTICE",
+		"message":  "audit record",
+		"audit":    record,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding audit record %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.out.Write(append(line, '\n'))
+	return err
+}
+
+// gcsAuditWriter buffers the audit records of the instance and uploads them as NDJSON objects,
+// <prefix>dt=<YYYY-MM-DD>/<instance>-<sequence>.ndjson, once auditMaxRecords are pending or
+// AuditFlushInterval after the first one. Every flush creates a new object, so concurrent invocations and
+// instances never write to the same one: Cloud Storage only sustains about one write per second to an
+// object.
+//
+// A write blocks until the object holding its record is uploaded and returns the outcome of the upload,
+// so AuditRequired still fails the messages whose record was lost.
+type gcsAuditWriter struct {
+	cfg      Config
+	instance string
+
+	mu       sync.Mutex
+	pending  *auditBatch
+	sequence int
+}
+
+// auditBatch is the content of the next audit object of a day.
+type auditBatch struct {
+	day       string
+	lines     []byte
+	records   int
+	waiters   []chan error
+	stopTimer func() bool
+}
+
+// auditMaxRecords bounds the records of an audit object, a burst is flushed without waiting for the
+// interval.
+const auditMaxRecords = 1000
+
+func newGCSAuditWriter(cfg Config) *gcsAuditWriter {
+	if cfg.AuditFlushInterval <= 0 {
+		cfg.AuditFlushInterval = defaultAuditFlushInterval
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	instance := cfg.Clock.Now().UTC().Format("150405") + "-" + hex.EncodeToString(suffix)
+	return &gcsAuditWriter{cfg: cfg, instance: instance}
+}
+
+func (w *gcsAuditWriter) write(ctx context.Context, record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding audit record %w", err)
+	}
+	day := record.ForwardedAt.Format(time.DateOnly)
+	result := make(chan error, 1)
+
+	w.mu.Lock()
+	if w.pending != nil && w.pending.day != day {
+		// The objects of a day only hold the records of the day
+		go w.flush(w.pending)
+		w.pending = nil
+	}
+	batch := w.pending
+	if batch == nil {
+		batch = &auditBatch{day: day}
+		batch.stopTimer = w.cfg.Clock.AfterFunc(w.cfg.AuditFlushInterval, func() { w.flush(batch) })
+		w.pending = batch
+	}
+	batch.lines = append(append(batch.lines, line...), '\n')
+	batch.records++
+	batch.waiters = append(batch.waiters, result)
+	full := batch.records >= auditMaxRecords
+	if full {
+		w.pending = nil
+	}
+	w.mu.Unlock()
+
+	if full {
+		go w.flush(batch)
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for the audit object upload %w", ctx.Err())
+	}
+}
+
+// flush uploads a batch, unless it was already flushed, and notifies its waiters.
+func (w *gcsAuditWriter) flush(batch *auditBatch) {
+	w.mu.Lock()
+	if batch.stopTimer == nil {
+		w.mu.Unlock()
+		return
+	}
+	batch.stopTimer()
+	batch.stopTimer = nil
+	if w.pending == batch {
+		w.pending = nil
+	}
+	w.sequence++
+	object := fmt.Sprintf("%sdt=%s/%s-%06d.ndjson", w.cfg.AuditPrefix, batch.day, w.instance, w.sequence)
+	w.mu.Unlock()
+
+	// The object holds the records of several invocations, it isn't bound to any of their contexts
+	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.Timeout)
+	defer cancel()
+	err := w.upload(ctx, object, batch.lines)
+	for _, waiter := range batch.waiters {
+		waiter <- err
+	}
+}
+
+// flushAll uploads the pending records, e.g: on shutdown.
+func (w *gcsAuditWriter) flushAll() {
+	w.mu.Lock()
+	batch := w.pending
+	w.mu.Unlock()
+	if batch != nil {
+		w.flush(batch)
+	}
+}
+
+// upload creates an object of the audit bucket.
+func (w *gcsAuditWriter) upload(ctx context.Context, object string, data []byte) error {
+	endpoint := storageUploadURL + "b/" + url.PathEscape(w.cfg.AuditBucket) + "/o?uploadType=media&name=" +
+		url.QueryEscape(object)
+	err := callGoogleAPI(ctx, w.cfg.ServicesHTTPClient, w.cfg.Clock, "POST", endpoint, bytes.NewReader(data), nil)
+	if err != nil {
+		return fmt.Errorf("error uploading audit object %s %w", object, err)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeGCS is an httptest server standing for the metadata server and the Cloud Storage upload API,
+// recording the uploaded objects.
+type fakeGCS struct {
+	*httptest.Server
+	failing atomic.Bool
+
+	mu      sync.Mutex
+	names   []string
+	objects map[string]string
+}
+
+func newFakeGCS(t *testing.T) *fakeGCS {
+	t.Helper()
+	f := &fakeGCS{objects: map[string]string{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/computeMetadata/") {
+			_, _ = w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+			return
+		}
+		if r.URL.Path != "/upload/storage/v1/b/audit-bucket/o" || r.URL.Query().Get("uploadType") != "media" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		if f.failing.Load() {
+			http.Error(w, `{"error":{"code":503}}`, http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		name := r.URL.Query().Get("name")
+		f.mu.Lock()
+		if _, exists := f.objects[name]; exists {
+			t.Errorf("object %s written twice", name)
+		}
+		f.names = append(f.names, name)
+		f.objects[name] = string(body)
+		f.mu.Unlock()
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// uploaded returns the names of the objects in upload order, and their content.
+func (f *fakeGCS) uploaded() ([]string, map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	objects := make(map[string]string, len(f.objects))
+	for name, content := range f.objects {
+		objects[name] = content
+	}
+	return append([]string(nil), f.names...), objects
+}
+
+func (f *fakeGCS) config(clock Clock) Config {
+	target, _ := url.Parse(f.URL)
+	services := &http.Client{Transport: redirectAllTransport{target: target, base: http.DefaultTransport}}
+	return Config{
+		AuditSink:          auditSinkGCS,
+		AuditBucket:        "audit-bucket",
+		AuditPrefix:        "sink/",
+		AuditFlushInterval: time.Second,
+		Timeout:            time.Second,
+		Clock:              clock,
+		ServicesHTTPClient: services,
+	}
+}
+
+// pendingRecords returns the number of records waiting for the next upload.
+func (w *gcsAuditWriter) pendingRecords() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending == nil {
+		return 0
+	}
+	return w.pending.records
+}
+
+// writeAll writes the records concurrently, once all are pending it calls flush and returns the errors.
+func writeAll(t *testing.T, w *gcsAuditWriter, records []auditRecord, flush func()) []error {
+	t.Helper()
+	errs := make([]error, len(records))
+	var wg sync.WaitGroup
+	for i, record := range records {
+		wg.Add(1)
+		go func(i int, record auditRecord) {
+			defer wg.Done()
+			errs[i] = w.write(context.Background(), record)
+		}(i, record)
+	}
+	for deadline := time.Now().Add(5 * time.Second); w.pendingRecords() < len(records); {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d records pending, want %d", w.pendingRecords(), len(records))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	flush()
+	wg.Wait()
+	return errs
+}
+
+func auditRecords(n int, forwardedAt time.Time) []auditRecord {
+	records := make([]auditRecord, n)
+	for i := range records {
+		records[i] = auditRecord{MessageID: "message-" + string(rune('a'+i%26)), Dataset: "test-dataset",
+			ForwardedAt: forwardedAt, Event: json.RawMessage(`{"a":1}`)}
+	}
+	return records
+}
+
+func TestGCSAuditWriter(t *testing.T) {
+	gcs := newFakeGCS(t)
+	clock := newFakeClock(testPublishTime)
+	w := newGCSAuditWriter(gcs.config(clock))
+
+	// The records of a window go to one object, the next window to another
+	for window := 1; window <= 2; window++ {
+		errs := writeAll(t, w, auditRecords(3, clock.Now()), func() { clock.Advance(time.Second) })
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+		}
+		names, objects := gcs.uploaded()
+		if len(names) != window {
+			t.Fatalf("%d objects uploaded after window %d, want %d", len(names), window, window)
+		}
+		name := names[window-1]
+		if !strings.HasPrefix(name, "sink/dt=2024-05-06/"+w.instance+"-") || !strings.HasSuffix(name, ".ndjson") {
+			t.Errorf("object name = %s, want a daily object of the instance", name)
+		}
+		lines := strings.Split(strings.TrimSuffix(objects[name], "\n"), "\n")
+		var record auditRecord
+		if len(lines) != 3 || json.Unmarshal([]byte(lines[0]), &record) != nil || record.Dataset != "test-dataset" {
+			t.Errorf("object %s = %q, want the 3 NDJSON records", name, objects[name])
+		}
+	}
+	if clock.Waiters() != 0 {
+		t.Errorf("%d flush timers pending, want none", clock.Waiters())
+	}
+}
+
+func TestGCSAuditWriterFullObject(t *testing.T) {
+	gcs := newFakeGCS(t)
+	clock := newFakeClock(testPublishTime)
+	w := newGCSAuditWriter(gcs.config(clock))
+
+	// A full object is uploaded without waiting for the interval
+	var wg sync.WaitGroup
+	errs := make(chan error, auditMaxRecords)
+	for _, record := range auditRecords(auditMaxRecords, clock.Now()) {
+		wg.Add(1)
+		go func(record auditRecord) {
+			defer wg.Done()
+			errs <- w.write(context.Background(), record)
+		}(record)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("write() error = %v", err)
+		}
+	}
+	names, objects := gcs.uploaded()
+	if len(names) != 1 || strings.Count(objects[names[0]], "\n") != auditMaxRecords {
+		t.Errorf("objects = %v, want one of %d records", names, auditMaxRecords)
+	}
+}
+
+func TestGCSAuditWriterDays(t *testing.T) {
+	gcs := newFakeGCS(t)
+	clock := newFakeClock(testPublishTime)
+	w := newGCSAuditWriter(gcs.config(clock))
+
+	// A record of the next day flushes the records of the previous one
+	previous := make(chan error, 1)
+	go func() { previous <- w.write(context.Background(), auditRecords(1, clock.Now())[0]) }()
+	for w.pendingRecords() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	next := auditRecords(1, clock.Now().Add(24*time.Hour))
+	errs := writeAll(t, w, next, func() {
+		if err := <-previous; err != nil {
+			t.Errorf("write() error = %v", err)
+		}
+		w.flushAll()
+	})
+	if errs[0] != nil {
+		t.Fatalf("write() error = %v", errs[0])
+	}
+	names, _ := gcs.uploaded()
+	if len(names) != 2 || !strings.Contains(names[0], "dt=2024-05-06/") ||
+		!strings.Contains(names[1], "dt=2024-05-07/") {
+		t.Errorf("objects = %v, want one per day", names)
+	}
+}
+
+// failingSink is a Sink returning err.
+type failingSink struct {
+	err error
+}
+
+func (s failingSink) Send(context.Context, Event) error { return s.err }
+
+func TestAuditSink(t *testing.T) {
+	tests := []struct {
+		name     string
+		sendErr  error
+		failing  bool
+		required bool
+		wantErr  error
+		wantLog  bool
+	}{
+		{name: "audited"},
+		{name: "send failed", sendErr: withCategory(errors.New("error honeycomb returned status 503"), ErrTransient),
+			wantErr: ErrTransient},
+		{name: "audit failed", failing: true, wantLog: true},
+		{name: "required audit failed", failing: true, required: true, wantErr: ErrTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcs := newFakeGCS(t)
+			gcs.failing.Store(tt.failing)
+			var logs logRecorder
+			clock := newFakeClock(testPublishTime)
+			cfg := gcs.config(clock)
+			cfg.AuditRequired = tt.required
+			cfg.AuditFlushInterval = time.Millisecond
+			clock.skipWaits = true
+			sink := newAuditSink(failingSink{err: tt.sendErr}, cfg)
+			writer := sink.writer.(*gcsAuditWriter)
+
+			event := Event{Dataset: "test-dataset", PubSub: MessagePublishedData{Message: PubSubMessage{
+				MessageID: "message-1", PublishTime: testPublishTime, Data: []byte(`{"a":1}`)}}}
+			ctx := withLogger(context.Background(), newRecordingLogger(&logs))
+			result := make(chan error, 1)
+			go func() { result <- sink.Send(ctx, event) }()
+			if tt.sendErr == nil {
+				for writer.pendingRecords() == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				clock.Advance(time.Millisecond)
+			}
+			err := <-result
+
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Send() error = %v, want %v", err, tt.wantErr)
+			}
+			names, objects := gcs.uploaded()
+			if audited := len(names) > 0; audited != (tt.sendErr == nil && !tt.failing) {
+				t.Errorf("objects = %v, audited: %v", names, audited)
+			} else if audited && !strings.Contains(objects[names[0]], `"message_id":"message-1"`) {
+				t.Errorf("object = %s, want the record of message-1", objects[names[0]])
+			}
+			records := logs.records(t, "error writing the audit record of the event, the event was forwarded")
+			if logged := len(records) > 0; logged != tt.wantLog {
+				t.Errorf("audit error logged: %v, want %v", logged, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestStdoutAuditWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &stdoutAuditWriter{out: &out}
+	record := auditRecord{MessageID: "message-1", Dataset: "test-dataset", ForwardedAt: testPublishTime,
+		Event: json.RawMessage(`{"a":1}`)}
+	if err := w.write(context.Background(), record); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	var entry struct {
+		Severity string      `json:"severity"`
+		Message  string      `json:"message"`
+		Audit    auditRecord `json:"audit"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("error decoding the log entry %s: %v", out.Bytes(), err)
+	}
+	if entry.Severity != "NOTICE" || entry.Audit.MessageID != "message-1" ||
+		string(entry.Audit.Event) != `{"a":1}` {
+		t.Errorf("log entry = %+v, want the NOTICE audit record", entry)
+	}
+}
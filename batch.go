@@ -0,0 +1,198 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// batchEvent is a single event of a Honeycomb batch request.
+// See the documentation for more details:
+// https://docs.honeycomb.io/api/tag/Events#operation/createEvents
+type batchEvent struct {
+	Time       string          `json:"time,omitempty"`
+	SampleRate int             `json:"samplerate,omitempty"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// batchEventResponse is the per-event status returned by the Honeycomb batch endpoint, in the same
+// order as the events that were sent.
+type batchEventResponse struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchError is returned when Honeycomb rejected some of the events of a batch.
+type batchError struct {
+	Dataset  string
+	Rejected int
+	Total    int
+	// Indexes are the positions of the rejected events in the payload, Reasons a sample of the distinct
+	// rejection reasons. Causes counts the events rejected with a 400 by classified reason.
+	Indexes []int
+	Reasons []string
+	Causes  map[string]int
+	// Transient counts the events rejected with a 429 or a 5xx, which may be accepted on redelivery.
+	Transient int
+}
+
+func (e *batchError) Error() string {
+	events := fmt.Sprint(e.Indexes)
+	if len(e.Indexes) > maxBatchRejectionIndexes {
+		events = fmt.Sprintf("%v and %d more", e.Indexes[:maxBatchRejectionIndexes],
+			len(e.Indexes)-maxBatchRejectionIndexes)
+	}
+	return fmt.Sprintf("error honeycomb rejected %d/%d events of the batch for dataset %q (events %s): %s",
+		e.Rejected, e.Total, e.Dataset, events, strings.Join(e.Reasons, "; "))
+}
+
+// Bounds of the details kept about the rejected events of a batch, so a batch rejected as a whole
+// doesn't flood the logs, nor the error message.
+const (
+	maxBatchRejectionReasons = 3
+	maxBatchRejectionLogs    = 10
+	maxBatchRejectionIndexes = 10
+)
+
+// isJSONArray reports whether the payload is a JSON array, ignoring leading whitespaces.
+func isJSONArray(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// arrayField holds a JSON array payload sent as a single event, when arrays aren't exploded.
+const arrayField = "items"
+
+// explodeArray prepares a JSON array payload to be sent as one event per element, in order. Elements
+// which aren't JSON objects can't be Honeycomb events: they are logged and dropped, and the message fails
+// when no element is left. The number of events is returned with the array.
+func explodeArray(ctx context.Context, data []byte, messageID string) ([]byte, int, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return nil, 0, withCategory(fmt.Errorf("error decoding json array payload %w", err), ErrPermanent)
+	}
+	events := make([]json.RawMessage, 0, len(elements))
+	for i, element := range elements {
+		if trimmed := bytes.TrimSpace(element); len(trimmed) == 0 || trimmed[0] != '{' {
+			loggerFrom(ctx).Warn("JSON array element is not an object, dropping it", "message_id", messageID, "index", i)
+			continue
+		}
+		events = append(events, element)
+	}
+	if len(events) == 0 && len(elements) > 0 {
+		return nil, 0, withCategory(fmt.Errorf("error none of the %d elements of the JSON array payload is an object",
+			len(elements)), ErrPermanent)
+	}
+	if len(events) < len(elements) {
+		loggerFrom(ctx).Warn("JSON array elements dropped",
+			"message_id", messageID, "forwarded", len(events), "dropped", len(elements)-len(events))
+	}
+	exploded, err := json.Marshal(events)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error encoding json array payload %w", err)
+	}
+	return exploded, len(events), nil
+}
+
+// batchEvents wraps the PubSub data into Honeycomb batch events. A JSON array payload becomes one
+// event per element, any other payload a single event. The events are stamped with their event time
+// and the sample rate, if any.
+func batchEvents(cfg Config, msg MessagePublishedData, sampleRate int) ([]batchEvent, error) {
+	var events []json.RawMessage
+	if isJSONArray(msg.Message.Data) {
+		if err := json.Unmarshal(msg.Message.Data, &events); err != nil {
+			return nil, fmt.Errorf("error decoding json array payload %w", err)
+		}
+	} else {
+		events = []json.RawMessage{bytes.TrimSpace(msg.Message.Data)}
+	}
+
+	batch := make([]batchEvent, 0, len(events))
+	for _, data := range events {
+		event := batchEvent{Time: payloadEventTime(cfg, msg, data).Format(time.RFC3339Nano), Data: data}
+		if sampleRate > 1 {
+			event.SampleRate = sampleRate
+		}
+		batch = append(batch, event)
+	}
+	return batch, nil
+}
+
+// buildBatchBody encodes the PubSub data as a Honeycomb batch body and returns it with its number of
+// events.
+func buildBatchBody(cfg Config, msg MessagePublishedData, sampleRate int) ([]byte, int, error) {
+	batch, err := batchEvents(cfg, msg, sampleRate)
+	if err != nil {
+		return nil, 0, err
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error encoding honeycomb batch body %w", err)
+	}
+	return body, len(batch), nil
+}
+
+// decodeBatchResponse decodes the per-event statuses returned by the batch endpoint.
+func decodeBatchResponse(body string, total int) ([]batchEventResponse, error) {
+	var responses []batchEventResponse
+	if err := json.Unmarshal([]byte(body), &responses); err != nil {
+		return nil, fmt.Errorf("error decoding honeycomb batch response %w", err)
+	}
+	if len(responses) != total {
+		return nil, fmt.Errorf("error honeycomb batch response has %d statuses for %d events", len(responses), total)
+	}
+	return responses, nil
+}
+
+// checkBatchResponses maps the per-event statuses back to the events of the batch, in order, and returns
+// a batchError listing the rejected ones when there are any. Rejections are logged individually.
+func checkBatchResponses(ctx context.Context, responses []batchEventResponse, dataset string) error {
+	batchErr := &batchError{Dataset: dataset, Total: len(responses)}
+	for i, r := range responses {
+		if r.Status >= 200 && r.Status <= 299 {
+			continue
+		}
+		batchErr.Rejected++
+		batchErr.Indexes = append(batchErr.Indexes, i)
+		reason := fmt.Sprintf("status %d: %s", r.Status, r.Error)
+		if len(batchErr.Reasons) < maxBatchRejectionReasons && !slices.Contains(batchErr.Reasons, reason) {
+			batchErr.Reasons = append(batchErr.Reasons, reason)
+		}
+		if r.Status == http.StatusTooManyRequests || r.Status >= 500 {
+			batchErr.Transient++
+		}
+		cause := ""
+		if r.Status == http.StatusBadRequest {
+			cause = classifyRejection(r.Error)
+			if batchErr.Causes == nil {
+				batchErr.Causes = map[string]int{}
+			}
+			batchErr.Causes[cause]++
+		}
+		if batchErr.Rejected <= maxBatchRejectionLogs {
+			loggerFrom(ctx).Warn("event rejected by Honeycomb",
+				"dataset", dataset, "index", i, "status_code", r.Status, "reason", cause, "error", r.Error)
+		}
+	}
+	if batchErr.Rejected == 0 {
+		return nil
+	}
+	loggerFrom(ctx).Warn("Honeycomb rejected events of the batch",
+		"dataset", dataset, "accepted", batchErr.Total-batchErr.Rejected, "rejected", batchErr.Rejected)
+	return batchErr
+}
+
+// parseBatchResponse checks the per-event statuses returned by the batch endpoint and returns a
+// batchError when any event was rejected.
+func parseBatchResponse(ctx context.Context, body string, dataset string, total int) error {
+	responses, err := decodeBatchResponse(body, total)
+	if err != nil {
+		return err
+	}
+	return checkBatchResponses(ctx, responses, dataset)
+}
@@ -0,0 +1,233 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBatchResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		total        int
+		wantRejected int
+		wantIndexes  []int
+		wantReasons  []string
+		wantDecode   bool // a malformed response, not a batchError
+		// wantTransient is set when some events may be accepted on redelivery
+		wantTransient bool
+	}{
+		{
+			name:  "all accepted",
+			body:  `[{"status":202},{"status":202},{"status":202}]`,
+			total: 3,
+		},
+		{
+			name: "mixed",
+			body: `[{"status":202},{"status":400,"error":"request body is too large"},{"status":202},` +
+				`{"status":403,"error":"unknown API key"}]`,
+			total:        4,
+			wantRejected: 2,
+			wantIndexes:  []int{1, 3},
+			wantReasons:  []string{"status 400: request body is too large", "status 403: unknown API key"},
+		},
+		{
+			name: "duplicate reasons sampled once",
+			body: `[{"status":400,"error":"bad"},{"status":400,"error":"bad"},{"status":400,"error":"worse"},` +
+				`{"status":400,"error":"worst"},{"status":400,"error":"more"}]`,
+			total:        5,
+			wantRejected: 5,
+			wantIndexes:  []int{0, 1, 2, 3, 4},
+			wantReasons:  []string{"status 400: bad", "status 400: worse", "status 400: worst"},
+		},
+		{
+			name:          "server errors may be retried",
+			body:          `[{"status":400,"error":"bad"},{"status":503,"error":"unavailable"}]`,
+			total:         2,
+			wantRejected:  2,
+			wantIndexes:   []int{0, 1},
+			wantReasons:   []string{"status 400: bad", "status 503: unavailable"},
+			wantTransient: true,
+		},
+		{
+			name:       "fewer statuses than events",
+			body:       `[{"status":202}]`,
+			total:      2,
+			wantDecode: true,
+		},
+		{
+			name:       "not JSON",
+			body:       `<html>bad gateway</html>`,
+			total:      1,
+			wantDecode: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseBatchResponse(quietContext(), tt.body, "test-dataset", tt.total)
+			var batchErr *batchError
+			switch {
+			case tt.wantDecode:
+				if err == nil || errors.As(err, &batchErr) {
+					t.Fatalf("parseBatchResponse() error = %v, want a decoding error", err)
+				}
+				return
+			case tt.wantRejected == 0:
+				if err != nil {
+					t.Fatalf("parseBatchResponse() error = %v, want nil", err)
+				}
+				return
+			case !errors.As(err, &batchErr):
+				t.Fatalf("parseBatchResponse() error = %v, want a batchError", err)
+			}
+			if batchErr.Rejected != tt.wantRejected || batchErr.Total != tt.total {
+				t.Errorf("rejected %d/%d, want %d/%d", batchErr.Rejected, batchErr.Total, tt.wantRejected, tt.total)
+			}
+			if errors.Is(err, ErrTransient) != tt.wantTransient || errors.Is(err, ErrPermanent) == tt.wantTransient {
+				t.Errorf("parseBatchResponse() error = %v, want transient: %v", err, tt.wantTransient)
+			}
+			if !reflect.DeepEqual(batchErr.Indexes, tt.wantIndexes) {
+				t.Errorf("indexes = %v, want %v", batchErr.Indexes, tt.wantIndexes)
+			}
+			if !reflect.DeepEqual(batchErr.Reasons, tt.wantReasons) {
+				t.Errorf("reasons = %q, want %q", batchErr.Reasons, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestHandlerBatchRejections(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, respondWith(http.StatusOK,
+		`[{"status":202},{"status":400,"error":"event has too many columns"},{"status":202}]`))
+
+	err := handleData(t, testConfig(honeycomb), `[{"a":1},{"b":2},{"c":3}]`)
+	var batchErr *batchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("handler error = %v, want a batchError", err)
+	}
+	if batchErr.Rejected != 1 || batchErr.Total != 3 || !reflect.DeepEqual(batchErr.Indexes, []int{1}) {
+		t.Errorf("batchError = %+v, want event 1 of 3 rejected", batchErr)
+	}
+	if !strings.Contains(err.Error(), "too many columns") {
+		t.Errorf("error %q doesn't hold the rejection reason", err)
+	}
+}
+
+func TestBatchErrorMessage(t *testing.T) {
+	indexes := make([]int, 5000)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	tests := []struct {
+		name    string
+		indexes []int
+		want    string
+	}{
+		{name: "few events", indexes: []int{1, 4}, want: "(events [1 4]): too large"},
+		{name: "at the bound", indexes: indexes[:maxBatchRejectionIndexes],
+			want: "(events [0 1 2 3 4 5 6 7 8 9]): too large"},
+		{name: "whole batch", indexes: indexes, want: "(events [0 1 2 3 4 5 6 7 8 9] and 4990 more): too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &batchError{Dataset: "test-dataset", Rejected: len(tt.indexes), Total: len(indexes),
+				Indexes: tt.indexes, Reasons: []string{"too large"}}
+			if got := err.Error(); !strings.HasSuffix(got, tt.want) {
+				t.Errorf("Error() = %q, want it to end with %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExplodeArray(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		want          string
+		wantEvents    int
+		wantPermanent bool
+	}{
+		{name: "objects kept in order", data: `[{"i":1},{"i":2},{"i":3}]`, want: `[{"i":1},{"i":2},{"i":3}]`,
+			wantEvents: 3},
+		{name: "empty array", data: `[]`, want: `[]`},
+		{name: "non-objects dropped", data: `[1,{"i":1},"s",null,[{"i":2}],{"i":3}]`, want: `[{"i":1},{"i":3}]`,
+			wantEvents: 2},
+		{name: "only non-objects", data: `[1,"s",true,null]`, wantPermanent: true},
+		{name: "not an array", data: `{"i":1}`, wantPermanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, events, err := explodeArray(quietContext(), []byte(tt.data), "message-1")
+			if tt.wantPermanent {
+				if !errors.Is(err, ErrPermanent) {
+					t.Fatalf("explodeArray() error = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("explodeArray() error = %v", err)
+			}
+			if string(got) != tt.want || events != tt.wantEvents {
+				t.Errorf("explodeArray() = %s, %d, want %s, %d", got, events, tt.want, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestHandlerExplodeArrays(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		wrap          bool
+		wantPath      string
+		wantEvents    []string
+		wantSingle    string
+		wantNoRequest bool
+		wantPermanent bool
+	}{
+		{name: "exploded", data: `[{"i":1},2,{"i":3}]`, wantPath: "/1/batch/test-dataset",
+			wantEvents: []string{`{"i":1}`, `{"i":3}`}},
+		{name: "empty array skipped", data: `[]`, wantNoRequest: true},
+		{name: "array of non-objects", data: `["a","b"]`, wantNoRequest: true, wantPermanent: true},
+		{name: "wrapped", data: `[1,2]`, wrap: true, wantPath: "/1/events/test-dataset",
+			wantSingle: `{"items":[1,2]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.WrapArrays = tt.wrap
+
+			err := handleData(t, cfg, tt.data)
+			if tt.wantPermanent != errors.Is(err, ErrPermanent) || (!tt.wantPermanent && err != nil) {
+				t.Fatalf("handler error = %v, want permanent %v", err, tt.wantPermanent)
+			}
+			requests := honeycomb.received()
+			if tt.wantNoRequest {
+				if len(requests) != 0 {
+					t.Errorf("%d requests sent, want none", len(requests))
+				}
+				return
+			}
+			if len(requests) != 1 || requests[0].Path != tt.wantPath {
+				t.Fatalf("requests = %+v, want one request to %s", requests, tt.wantPath)
+			}
+			if tt.wantSingle != "" {
+				if got := string(requests[0].Body); got != tt.wantSingle {
+					t.Errorf("event = %s, want %s", got, tt.wantSingle)
+				}
+				return
+			}
+			var got []string
+			for _, e := range honeycomb.lastBatch(t) {
+				got = append(got, string(e.Data))
+			}
+			if !reflect.DeepEqual(got, tt.wantEvents) {
+				t.Errorf("batch events = %q, want %q", got, tt.wantEvents)
+			}
+		})
+	}
+}
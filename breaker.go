@@ -0,0 +1,121 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultCircuitBreakerCooldown = 30 * time.Second
+
+// errCircuitOpen is returned without calling Honeycomb while the circuit breaker is open.
+var errCircuitOpen = withCategory(errors.New("error circuit breaker open, honeycomb request not sent"), ErrTransient)
+
+// Circuit breaker states.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// circuitBreaker stops calling Honeycomb during sustained outages: after threshold consecutive failures
+// within window, the circuit opens and requests fail fast for cooldown. A single probe request is then let
+// through (half-open): its success closes the circuit, its failure opens it again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	clock     Clock
+
+	state        string
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+func newCircuitBreaker(threshold int, window time.Duration, cooldown time.Duration, clock Clock) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, window: window, cooldown: cooldown, state: circuitClosed,
+		clock: clock}
+}
+
+// allow reports whether a request can be sent.
+func (b *circuitBreaker) allow(ctx context.Context) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if since(b.clock, b.openedAt) < b.cooldown {
+			return false
+		}
+		b.transition(ctx, circuitHalfOpen)
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		// Only the probe is let through
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a request. Only transient failures count: a 4xx
+// response means Honeycomb is up.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := errors.Is(err, ErrTransient)
+	if b.state == circuitHalfOpen {
+		b.probing = false
+		if failed {
+			b.open(ctx)
+		} else {
+			b.transition(ctx, circuitClosed)
+			b.failures = 0
+		}
+		return
+	}
+	if b.state == circuitOpen {
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	if b.failures == 0 || (b.window > 0 && since(b.clock, b.firstFailure) > b.window) {
+		b.failures = 0
+		b.firstFailure = b.clock.Now()
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.open(ctx)
+	}
+}
+
+// open opens the circuit. b.mu must be held.
+func (b *circuitBreaker) open(ctx context.Context) {
+	b.transition(ctx, circuitOpen)
+	b.openedAt = b.clock.Now()
+	b.failures = 0
+}
+
+// transition logs and applies a state change. b.mu must be held.
+func (b *circuitBreaker) transition(ctx context.Context, state string) {
+	if state == b.state {
+		return
+	}
+	level := slog.LevelInfo
+	if state == circuitOpen {
+		level = slog.LevelWarn
+	}
+	loggerFrom(ctx).Log(ctx, level, "circuit breaker state changed",
+		"from", b.state, "to", state, "cooldown", b.cooldown.String())
+	b.state = state
+}
@@ -0,0 +1,72 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	transient := withCategory(errors.New("error honeycomb returned status 503"), ErrTransient)
+	permanent := withCategory(errors.New("error honeycomb returned status 400"), ErrPermanent)
+	clock := newFakeClock(testPublishTime)
+	b := newCircuitBreaker(2, time.Minute, 30*time.Second, clock)
+	ctx := quietContext()
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		record    []error
+		wantAllow []bool // the outcome of successive allow calls
+		wantState string
+	}{
+		{name: "a 4xx doesn't count", record: []error{transient, permanent, transient}, wantAllow: []bool{true},
+			wantState: circuitClosed},
+		{name: "failures outside the window don't add up", advance: 2 * time.Minute, record: []error{transient},
+			wantAllow: []bool{true}, wantState: circuitClosed},
+		{name: "consecutive failures open the circuit", record: []error{transient}, wantAllow: []bool{false},
+			wantState: circuitOpen},
+		{name: "open until the cooldown", advance: 29 * time.Second, wantAllow: []bool{false},
+			wantState: circuitOpen},
+		{name: "a single probe after the cooldown", advance: time.Second, wantAllow: []bool{true, false},
+			wantState: circuitHalfOpen},
+		{name: "a failed probe opens the circuit", record: []error{transient}, wantAllow: []bool{false},
+			wantState: circuitOpen},
+		{name: "a successful probe closes the circuit", advance: 30 * time.Second, wantAllow: []bool{true}},
+		{name: "closed", record: []error{nil}, wantAllow: []bool{true, true}, wantState: circuitClosed},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		for _, err := range step.record {
+			b.record(ctx, err)
+		}
+		for i, want := range step.wantAllow {
+			if got := b.allow(ctx); got != want {
+				t.Errorf("%s: allow() #%d = %v, want %v", step.name, i+1, got, want)
+			}
+		}
+		if step.wantState != "" && b.state != step.wantState {
+			t.Errorf("%s: state = %s, want %s", step.name, b.state, step.wantState)
+		}
+	}
+}
+
+func TestHandlerCircuitBreaker(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, respondWith(http.StatusServiceUnavailable, `{"error":"unavailable"}`))
+	cfg := testConfig(honeycomb)
+	cfg.CircuitBreakerThreshold = 1
+	cfg.Clock = newFakeClock(testPublishTime)
+	handler, _ := NewHandler(cfg)
+
+	// Once open, the sends fail fast with a transient error, without calling Honeycomb
+	for i := 0; i < 3; i++ {
+		err := handler(quietContext(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)}))
+		if !errors.Is(err, ErrTransient) || (i > 0) != errors.Is(err, errCircuitOpen) {
+			t.Errorf("send %d error = %v, want a transient error, the circuit being open after the first", i, err)
+		}
+	}
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("Honeycomb received %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,179 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// batcher buffers the events of concurrent invocations and sends them to the Honeycomb batch endpoint
+// once BatchMaxEvents events are pending for a dataset and API key, or BatchFlushInterval after the first
+// one.
+//
+// An invocation blocks until the batch holding its events is flushed and returns the outcome of its
+// own events, so a message is only ACKed once Honeycomb accepted it: delivery stays at least once, at
+// the cost of up to BatchFlushInterval of latency. When the invocation context is done before the
+// flush, the invocation fails (and the message is redelivered) while its events may still be sent,
+// which can duplicate them in Honeycomb.
+type batcher struct {
+	cfg Config
+
+	mu      sync.Mutex
+	pending map[batchKey]*pendingBatch
+}
+
+// batchKey identifies the batch an event goes to: a batch request targets a single dataset of a single
+// Honeycomb environment.
+type batchKey struct {
+	dataset string
+	apiKey  string
+}
+
+type pendingBatch struct {
+	batchKey
+	events    []batchEvent
+	waiters   []batchWaiter
+	stopTimer func() bool
+}
+
+// batchWaiter is an invocation waiting for the events [first, first+count) of a batch.
+type batchWaiter struct {
+	first  int
+	count  int
+	result chan error
+	logger *slog.Logger // the logger of the invocation, for its rejected events
+}
+
+func newBatcher(cfg Config) *batcher {
+	return &batcher{cfg: cfg, pending: make(map[batchKey]*pendingBatch)}
+}
+
+// add buffers the events of the message, stamped with its sample rate, and waits for the flush of their
+// batch.
+func (b *batcher) add(ctx context.Context, msg MessagePublishedData, dataset string, sampleRate int) error {
+	events, err := batchEvents(b.cfg, msg, sampleRate)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	waiter := batchWaiter{count: len(events), result: make(chan error, 1), logger: loggerFrom(ctx)}
+	key := batchKey{dataset: dataset, apiKey: apiKeyFor(b.cfg, dataset, msg.Message.Attributes)}
+
+	b.mu.Lock()
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &pendingBatch{batchKey: key}
+		batch.stopTimer = b.cfg.Clock.AfterFunc(b.cfg.BatchFlushInterval, func() { b.flush(batch) })
+		b.pending[key] = batch
+	}
+	waiter.first = len(batch.events)
+	batch.events = append(batch.events, events...)
+	batch.waiters = append(batch.waiters, waiter)
+	full := len(batch.events) >= b.cfg.BatchMaxEvents
+	b.mu.Unlock()
+
+	if full {
+		go b.flush(batch)
+	}
+
+	select {
+	case err := <-waiter.result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for the honeycomb batch flush %w", ctx.Err())
+	}
+}
+
+// flush sends a pending batch, unless it was already flushed, and notifies its waiters.
+func (b *batcher) flush(batch *pendingBatch) {
+	b.mu.Lock()
+	if b.pending[batch.batchKey] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, batch.batchKey)
+	batch.stopTimer()
+	b.mu.Unlock()
+
+	// The batch serves several invocations, it isn't bound to any of their contexts
+	ctx, cancel := context.WithTimeout(withLogger(context.Background(), b.cfg.Logger),
+		b.cfg.Timeout*time.Duration(b.cfg.MaxRetries+1))
+	defer cancel()
+	responses, err := b.send(ctx, batch)
+	for _, waiter := range batch.waiters {
+		if err != nil {
+			waiter.result <- err
+			continue
+		}
+		events := responses[waiter.first : waiter.first+waiter.count]
+		waiter.result <- checkBatchResponses(withLogger(ctx, waiter.logger), events, batch.dataset)
+	}
+}
+
+// flushAll sends all the pending batches, e.g: on shutdown.
+func (b *batcher) flushAll() {
+	b.mu.Lock()
+	batches := make([]*pendingBatch, 0, len(b.pending))
+	for _, batch := range b.pending {
+		batches = append(batches, batch)
+	}
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch *pendingBatch) {
+			defer wg.Done()
+			b.flush(batch)
+		}(batch)
+	}
+	wg.Wait()
+}
+
+func (b *batcher) send(ctx context.Context, batch *pendingBatch) ([]batchEventResponse, error) {
+	payload, err := json.Marshal(batch.events)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding honeycomb batch body %w", err)
+	}
+	headers := honeycombHeaders(b.cfg, batch.apiKey)
+	endpoint, err := sendEndpoint(b.cfg, batch.dataset, true, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.cfg.DryRun {
+		logDryRun(ctx, b.cfg, endpoint, headers, payload, batch.dataset)
+		responses := make([]batchEventResponse, len(batch.events))
+		for i := range responses {
+			responses[i].Status = http.StatusAccepted
+		}
+		return responses, nil
+	}
+	if b.cfg.Gzip {
+		if payload, err = gzipPayload(payload); err != nil {
+			return nil, err
+		}
+		headers.Set("Content-Encoding", "gzip")
+	}
+
+	if err := waitRateLimit(ctx, b.cfg.limiter, len(batch.events)); err != nil {
+		return nil, err
+	}
+	result, err := postWithFallback(ctx, b.cfg, endpoint, payload, headers, batch.dataset)
+	if err != nil {
+		return nil, err
+	}
+	loggerFrom(ctx).Info("Honeycomb batch flushed",
+		"dataset", batch.dataset,
+		"events", len(batch.events),
+		"messages", len(batch.waiters),
+		"status_code", result.StatusCode,
+		"latency_ms", result.Latency.Milliseconds())
+	return decodeBatchResponse(result.Body, len(batch.events))
+}
@@ -0,0 +1,158 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rejectBad answers the batch requests with a 400 for the events holding "bad", a 202 for the others.
+func rejectBad(w http.ResponseWriter, r *http.Request, body []byte) {
+	var events []batchEvent
+	_ = json.Unmarshal(body, &events)
+	statuses := make([]batchEventResponse, len(events))
+	for i, event := range events {
+		statuses[i] = batchEventResponse{Status: http.StatusAccepted}
+		if bytes.Contains(event.Data, []byte("bad")) {
+			statuses[i] = batchEventResponse{Status: http.StatusBadRequest, Error: "invalid event"}
+		}
+	}
+	_ = json.NewEncoder(w).Encode(statuses)
+}
+
+func TestBatcherFlushWhenFull(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, rejectBad)
+	cfg := testConfig(honeycomb)
+	cfg.Clock = newFakeClock(testPublishTime)
+	cfg.BatchMaxEvents = 3
+	cfg.BatchFlushInterval = time.Hour
+	handler, _ := NewHandler(cfg)
+
+	// The events of the three invocations are sent in a single batch, each invocation gets the outcome
+	// of its own events
+	payloads := []string{`{"n":1}`, `{"n":2,"bad":true}`, `{"n":3}`}
+	results := make([]error, len(payloads))
+	var wg sync.WaitGroup
+	for i, payload := range payloads {
+		wg.Add(1)
+		go func(i int, payload string) {
+			defer wg.Done()
+			e := pubSubEvent(t, PubSubMessage{Data: []byte(payload), MessageID: "message-" + strconv.Itoa(i)})
+			results[i] = handler(quietContext(), e)
+		}(i, payload)
+	}
+	wg.Wait()
+
+	requests := honeycomb.received()
+	if len(requests) != 1 || requests[0].Path != "/1/batch/test-dataset" {
+		t.Fatalf("requests = %+v, want a single batch request", requests)
+	}
+	var events []batchEvent
+	if err := json.Unmarshal(requests[0].Body, &events); err != nil || len(events) != 3 {
+		t.Fatalf("batch = %s (%v), want the 3 events", requests[0].Body, err)
+	}
+	var batchErr *batchError
+	if results[0] != nil || results[2] != nil {
+		t.Errorf("handler errors = %v, want the accepted messages to succeed", results)
+	}
+	if !errors.As(results[1], &batchErr) || batchErr.Rejected != 1 || batchErr.Total != 1 {
+		t.Errorf("handler error = %v, want the rejection of its only event", results[1])
+	}
+}
+
+func TestBatcherFlushInterval(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	clock := newFakeClock(testPublishTime)
+	cfg := testConfig(honeycomb)
+	cfg.Clock = clock
+	cfg.BatchMaxEvents = 100
+	cfg.BatchFlushInterval = 5 * time.Second
+	handler, _ := NewHandler(cfg)
+
+	done := make(chan error, 1)
+	go func() { done <- handler(quietContext(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})) }()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(4 * time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("handler returned %v before the flush interval", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("%d requests, want the batch flushed once", n)
+	}
+}
+
+func TestBatcherSeparateBatches(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	cfg.Clock = newFakeClock(testPublishTime)
+	cfg.BatchMaxEvents = 2
+	cfg.BatchFlushInterval = time.Hour
+	cfg.FanOutDatasets = []string{"team-a"}
+	cfg.APIKeys = map[string]string{"team-a": "team-a-key"}
+	handler, _ := NewHandler(cfg)
+
+	// A batch targets a single dataset and API key: the two messages fill one batch per dataset
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`), MessageID: "message-" + strconv.Itoa(i)})
+			if err := handler(quietContext(), e); err != nil {
+				t.Errorf("handler error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	keys := map[string]string{}
+	for _, r := range honeycomb.received() {
+		var events []batchEvent
+		if err := json.Unmarshal(r.Body, &events); err != nil || len(events) != 2 {
+			t.Errorf("batch %s = %s, want the events of both messages", r.Path, r.Body)
+		}
+		keys[r.Path] = r.Header.Get("X-Honeycomb-Team")
+	}
+	want := map[string]string{"/1/batch/test-dataset": "test-api-key", "/1/batch/team-a": "team-a-key"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("batches = %v, want one per dataset, with its API key", keys)
+	}
+}
+
+func TestBatcherFlushAll(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	clock := newFakeClock(testPublishTime)
+	cfg := testConfig(honeycomb)
+	cfg.Clock = clock
+	cfg.BatchMaxEvents = 100
+	cfg.BatchFlushInterval = time.Hour
+	handler, shutdown := NewHandler(cfg)
+
+	done := make(chan error, 1)
+	go func() { done <- handler(quietContext(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})) }()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("shutdown error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("handler error = %v, want the pending batch flushed on shutdown", err)
+	}
+}
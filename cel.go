@@ -0,0 +1,170 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// CELTransform maps events with a CEL expression, compiled once. The expression gets the decoded event
+// as `event` and the PubSub attributes as `attributes`, and returns the object to send (e.g:
+// `{"message": event.msg, "level": event.lvl, "team": attributes.team}`).
+// See the documentation for more details:
+// https://github.com/google/cel-spec/blob/master/doc/langdef.md
+type CELTransform struct {
+	expr    string
+	program cel.Program
+}
+
+// NewCELTransform compiles a CEL transform expression, which must return a map.
+func NewCELTransform(expr string) (*CELTransform, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("event", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("attributes", cel.MapType(cel.StringType, cel.StringType)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the CEL environment %w", err)
+	}
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("error compiling CEL expression %w", issues.Err())
+	}
+	// dyn results, e.g: of a field access, are checked when evaluated
+	if kind := ast.OutputType().Kind(); kind != types.MapKind && kind != types.DynKind {
+		return nil, fmt.Errorf("error CEL expression must return a map, it returns %s", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("error building CEL program %w", err)
+	}
+	return &CELTransform{expr: expr, program: program}, nil
+}
+
+// apply evaluates the expression on a decoded event and returns the resulting object. The result is
+// converted value by value, integers staying exact: a JSON round trip through structpb would turn them
+// into doubles, corrupting the IDs over 2^53.
+func (t *CELTransform) apply(fields map[string]any, attributes map[string]string) (map[string]any, error) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	out, _, err := t.program.Eval(map[string]any{"event": celNumbers(fields), "attributes": attributes})
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating CEL expression %w", err)
+	}
+	if _, ok := out.(traits.Mapper); !ok {
+		return nil, fmt.Errorf("error CEL expression must return a map with string keys, got %s", out.Type())
+	}
+	result, err := celNative(out)
+	if err != nil {
+		return nil, err
+	}
+	return result.(map[string]any), nil
+}
+
+// celNative converts a CEL value into the value decodeObject would decode from its JSON encoding:
+// numbers become json.Number, maps map[string]any and lists []any.
+func celNative(value ref.Val) (any, error) {
+	switch v := value.(type) {
+	case types.Null:
+		return nil, nil
+	case types.Bool:
+		return bool(v), nil
+	case types.String:
+		return string(v), nil
+	case types.Int:
+		return json.Number(strconv.FormatInt(int64(v), 10)), nil
+	case types.Uint:
+		return json.Number(strconv.FormatUint(uint64(v), 10)), nil
+	case types.Double:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("error CEL expression result holds the non finite number %v", f)
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case traits.Mapper:
+		object := make(map[string]any)
+		for it := v.Iterator(); it.HasNext() == types.True; {
+			key := it.Next()
+			name, ok := key.(types.String)
+			if !ok {
+				return nil, fmt.Errorf("error CEL expression must return a map with string keys, got a %s key",
+					key.Type())
+			}
+			elem, err := celNative(v.Get(key))
+			if err != nil {
+				return nil, err
+			}
+			object[string(name)] = elem
+		}
+		return object, nil
+	case traits.Lister:
+		var list []any
+		for it := v.Iterator(); it.HasNext() == types.True; {
+			elem, err := celNative(it.Next())
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		}
+		if list == nil {
+			list = []any{}
+		}
+		return list, nil
+	}
+	// Timestamps, durations, bytes: their JSON form, e.g: an RFC 3339 string for a timestamp
+	native, err := value.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, fmt.Errorf("error CEL expression result holds a %s, which has no JSON form", value.Type())
+	}
+	encoded, err := protojson.Marshal(native.(*structpb.Value))
+	if err != nil {
+		return nil, fmt.Errorf("error encoding CEL expression result %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("error decoding CEL expression result %w", err)
+	}
+	return decoded, nil
+}
+
+// celNumbers converts the json.Number values of a decoded event into int64, uint64 for the integers over
+// the int64 range, or float64, which CEL understands.
+func celNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		converted := make(map[string]any, len(v))
+		for key, elem := range v {
+			converted[key] = celNumbers(elem)
+		}
+		return converted
+	case []any:
+		converted := make([]any, len(v))
+		for i, elem := range v {
+			converted[i] = celNumbers(elem)
+		}
+		return converted
+	default:
+		return v
+	}
+}
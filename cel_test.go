@@ -0,0 +1,102 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewCELTransform(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: `{"message": event.msg}`},
+		{expr: `event`},
+		{expr: `event.nested`}, // dyn, checked when evaluated
+		{expr: `{"message": event.`, wantErr: "error compiling CEL expression"},
+		{expr: `1 + 1`, wantErr: "must return a map, it returns int"},
+		{expr: `attributes.team`, wantErr: "must return a map, it returns string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := NewCELTransform(tt.expr)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("NewCELTransform() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("NewCELTransform() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCELTransformApply(t *testing.T) {
+	event := `{"id":9007199254740993,"count":41,"ratio":0.25,"msg":"card declined","tags":["a","b"],` +
+		`"nested":{"deep":{"n":18446744073709551615}},"list":[1,2]}`
+	tests := []struct {
+		name    string
+		expr    string
+		want    string
+		wantErr string
+	}{
+		{name: "integers over 2^53 kept", expr: `{"id": event.id, "next": event.id + 2}`,
+			want: `{"id":9007199254740993,"next":9007199254740995}`},
+		{name: "numbers", expr: `{"count": event.count + 1, "ratio": event.ratio * 2.0, "unsigned": 3u}`,
+			want: `{"count":42,"ratio":0.5,"unsigned":3}`},
+		{name: "whole event", expr: `event`, want: `{"count":41,"id":9007199254740993,"list":[1,2],"msg":` +
+			`"card declined","nested":{"deep":{"n":18446744073709551615}},"ratio":0.25,"tags":["a","b"]}`},
+		{name: "attributes and nested values", expr: `{"team": attributes.team, "tags": event.tags, ` +
+			`"deep": event.nested.deep, "empty": [], "none": null, "ok": true}`,
+			want: `{"deep":{"n":18446744073709551615},"empty":[],"none":null,"ok":true,"tags":["a","b"],` +
+				`"team":"payments"}`},
+		{name: "timestamp", expr: `{"at": timestamp("2024-05-06T07:08:09Z")}`, want: `{"at":"2024-05-06T07:08:09Z"}`},
+		{name: "not a map", expr: `event.list`, wantErr: "must return a map with string keys"},
+		{name: "keys not strings", expr: `{1: "a"}`, wantErr: "with string keys, got a int key"},
+		{name: "non finite", expr: `{"x": event.ratio / 0.0}`, wantErr: "non finite number"},
+		{name: "missing field", expr: `{"x": event.missing}`, wantErr: "error evaluating CEL expression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform, err := NewCELTransform(tt.expr)
+			if err != nil {
+				t.Fatalf("NewCELTransform() error = %v", err)
+			}
+			fields, _ := decodeObject([]byte(event))
+			got, err := transform.apply(fields, map[string]string{"team": "payments"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("apply() = %v, %v, want the error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("apply() error = %v", err)
+			}
+			if encoded, _ := json.Marshal(got); string(encoded) != tt.want {
+				t.Errorf("apply() = %s, want %s", encoded, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerCELTransform(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	transform, err := NewCELTransform(`{"trace_id": event.trace, "message": event.msg, "team": attributes.team}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Transform = transform
+
+	data := `{"trace":1234567890123456789,"msg":"card declined","level":"error"}`
+	e := pubSubEvent(t, PubSubMessage{Data: []byte(data), Attributes: map[string]string{"team": "payments"}})
+	handler, _ := NewHandler(cfg)
+	if err := handler(quietContext(), e); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	want := `{"message":"card declined","team":"payments","trace_id":1234567890123456789}`
+	if got := string(honeycomb.received()[0].Body); got != want {
+		t.Errorf("event = %s, want %s, with the exact trace ID", got, want)
+	}
+}
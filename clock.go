@@ -0,0 +1,35 @@
+package HoneycombSinkHandler
+
+import "time"
+
+// Clock tells the time to the time-dependent features (event time, lag, timing annotations, retry
+// backoff, batch flushes, circuit breaker, periodic logs, metric exports, token and key caches), so they
+// can be driven by a fake clock. The wall clock is used when Config.Clock is nil.
+type Clock interface {
+	Now() time.Time
+	// After sends the current time on the returned channel once d elapsed.
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once d elapsed, unless stop is called before. stop reports
+	// whether it prevented the call.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+// realClock is the wall clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// since is time.Since for the clock.
+func since(clock Clock, t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
@@ -0,0 +1,158 @@
+package HoneycombSinkHandler
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock which only moves when told to. Timers fire when Advance or Set moves the clock
+// past their deadline. When skipWaits is set, After advances the clock by d and fires at once, so the
+// code under test sleeps without waiting.
+type fakeClock struct {
+	mu        sync.Mutex
+	now       time.Time
+	timers    []*fakeTimer
+	skipWaits bool
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	fire     func(now time.Time)
+	stopped  bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	if c.skipWaits {
+		c.Advance(d)
+		ch <- c.Now()
+		return ch
+	}
+	c.schedule(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	timer := c.schedule(d, func(time.Time) { go f() })
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		stopped := !timer.stopped
+		timer.stopped = true
+		return stopped
+	}
+}
+
+// Sleep advances the clock by d, like a sleep of the code under test would.
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance moves the clock forward by d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to now, firing the timers whose deadline passed.
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	var due []*fakeTimer
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		switch {
+		case timer.stopped:
+		case !timer.deadline.After(now):
+			timer.stopped = true
+			due = append(due, timer)
+		default:
+			pending = append(pending, timer)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+	for _, timer := range due {
+		timer.fire(now)
+	}
+}
+
+// Waiters returns the number of timers not fired nor stopped yet.
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, timer := range c.timers {
+		if !timer.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *fakeClock) schedule(d time.Duration, fire func(now time.Time)) *fakeTimer {
+	c.mu.Lock()
+	timer := &fakeTimer{deadline: c.now.Add(d), fire: fire}
+	if d > 0 {
+		c.timers = append(c.timers, timer)
+		c.mu.Unlock()
+		return timer
+	}
+	timer.stopped = true
+	now := c.now
+	c.mu.Unlock()
+	fire(now)
+	return timer
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := newFakeClock(start)
+
+	after := clock.After(time.Second)
+	called := make(chan struct{})
+	stop := clock.AfterFunc(2*time.Second, func() { close(called) })
+	stopped := clock.AfterFunc(2*time.Second, func() { t.Error("stopped timer fired") })
+	if !stopped() {
+		t.Fatal("stop of a pending timer reported false")
+	}
+	if got := clock.Waiters(); got != 2 {
+		t.Fatalf("Waiters() = %d, want 2", got)
+	}
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-after:
+		t.Fatal("After fired before its deadline")
+	default:
+	}
+	clock.Sleep(time.Millisecond)
+	if now := <-after; !now.Equal(start.Add(time.Second)) {
+		t.Errorf("After sent %v, want %v", now, start.Add(time.Second))
+	}
+
+	clock.Set(start.Add(time.Minute))
+	<-called
+	if stop() {
+		t.Error("stop of a fired timer reported true")
+	}
+	if got := since(clock, start); got != time.Minute {
+		t.Errorf("since() = %v, want %v", got, time.Minute)
+	}
+
+	clock.skipWaits = true
+	<-clock.After(time.Hour)
+	if got := since(clock, start); got != time.Hour+time.Minute {
+		t.Errorf("since() after a skipped wait = %v, want %v", got, time.Hour+time.Minute)
+	}
+}
@@ -0,0 +1,98 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Handling of the columns over MaxColumns, selected with COLUMN_OVERFLOW.
+const (
+	columnOverflowNest = "nest"
+	columnOverflowDrop = "drop"
+)
+
+// overflowField holds the columns over the limit with the nest handling.
+const overflowField = "_overflow"
+
+// limitColumns caps the top-level keys of a JSON object payload, or of each JSON object of an array
+// payload, to maxColumns. The keys are kept in sorted order, so an event always keeps the same columns;
+// the others are dropped, or moved under overflowField which then counts as a column. It returns the
+// number of events over the limit and the widest one's column count, other payloads are returned as is.
+func limitColumns(data []byte, maxColumns int, overflow string) ([]byte, int, int, error) {
+	if !isJSONArray(data) {
+		fields, ok := decodeObject(data)
+		if !ok || len(fields) <= maxColumns {
+			return data, 0, 0, nil
+		}
+		columns := len(fields)
+		limited, err := json.Marshal(limitFields(fields, maxColumns, overflow))
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("error encoding column limited payload %w", err)
+		}
+		return limited, 1, columns, nil
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, 0, 0, fmt.Errorf("error decoding json array payload %w", err)
+	}
+	count, widest := 0, 0
+	for i, event := range events {
+		fields, ok := decodeObject(event)
+		if !ok || len(fields) <= maxColumns {
+			continue
+		}
+		count++
+		widest = max(widest, len(fields))
+		limited, err := json.Marshal(limitFields(fields, maxColumns, overflow))
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("error encoding column limited payload %w", err)
+		}
+		events[i] = limited
+	}
+	if count == 0 {
+		return data, 0, 0, nil
+	}
+	limited, err := json.Marshal(events)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("error encoding column limited payload %w", err)
+	}
+	return limited, count, widest, nil
+}
+
+// columnOverflowAction describes the handling of the extra columns, for the logs.
+func columnOverflowAction(overflow string) string {
+	if overflow == columnOverflowDrop {
+		return "dropped"
+	}
+	return "moved under " + overflowField
+}
+
+// limitFields returns the first maxColumns keys of fields in sorted order, with the others under
+// overflowField unless overflow is drop.
+func limitFields(fields map[string]any, maxColumns int, overflow string) map[string]any {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	nest := overflow != columnOverflowDrop
+	kept := maxColumns
+	if nest {
+		kept = max(maxColumns-1, 0)
+	}
+
+	limited := make(map[string]any, maxColumns)
+	for _, key := range keys[:kept] {
+		limited[key] = fields[key]
+	}
+	if nest {
+		extra := make(map[string]any, len(keys)-kept)
+		for _, key := range keys[kept:] {
+			extra[key] = fields[key]
+		}
+		limited[overflowField] = extra
+	}
+	return limited
+}
@@ -0,0 +1,92 @@
+package HoneycombSinkHandler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLimitColumns(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		maxColumns  int
+		overflow    string
+		want        string
+		wantLimited int
+		wantWidest  int
+	}{
+		{name: "under the limit", data: `{"a":1,"b":2}`, maxColumns: 3, overflow: columnOverflowNest,
+			want: `{"a":1,"b":2}`},
+		{name: "at the limit", data: `{"a":1,"b":2,"c":3}`, maxColumns: 3, overflow: columnOverflowNest,
+			want: `{"a":1,"b":2,"c":3}`},
+		{name: "nested", data: `{"d":4,"a":1,"c":3,"b":2}`, maxColumns: 3, overflow: columnOverflowNest,
+			want: `{"_overflow":{"c":3,"d":4},"a":1,"b":2}`, wantLimited: 1, wantWidest: 4},
+		{name: "nested by default", data: `{"d":4,"a":1,"c":3,"b":2}`, maxColumns: 3,
+			want: `{"_overflow":{"c":3,"d":4},"a":1,"b":2}`, wantLimited: 1, wantWidest: 4},
+		{name: "dropped", data: `{"d":4,"a":1,"c":3,"b":2}`, maxColumns: 3, overflow: columnOverflowDrop,
+			want: `{"a":1,"b":2,"c":3}`, wantLimited: 1, wantWidest: 4},
+		{name: "nested values kept whole", data: `{"a":{"x":1,"y":2,"z":3},"b":[1,2,3]}`, maxColumns: 2,
+			overflow: columnOverflowDrop, want: `{"a":{"x":1,"y":2,"z":3},"b":[1,2,3]}`},
+		{name: "array", data: `[{"a":1},{"a":1,"b":2,"c":3},"text",{"a":1,"b":2,"c":3,"d":4,"e":5}]`,
+			maxColumns: 2, overflow: columnOverflowDrop, want: `[{"a":1},{"a":1,"b":2},"text",{"a":1,"b":2}]`,
+			wantLimited: 2, wantWidest: 5},
+		{name: "array under the limit", data: `[{"a":1}, {"b":2}]`, maxColumns: 2, overflow: columnOverflowNest,
+			want: `[{"a":1}, {"b":2}]`},
+		{name: "not an object", data: `"a,b,c"`, maxColumns: 1, overflow: columnOverflowNest, want: `"a,b,c"`},
+		{name: "large numbers kept", data: `{"id":12345678901234567890,"a":1,"z":3}`, maxColumns: 2,
+			overflow: columnOverflowDrop, want: `{"a":1,"id":12345678901234567890}`, wantLimited: 1, wantWidest: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, limited, widest, err := limitColumns([]byte(tt.data), tt.maxColumns, tt.overflow)
+			if err != nil {
+				t.Fatalf("limitColumns() error = %v", err)
+			}
+			if string(got) != tt.want || limited != tt.wantLimited || widest != tt.wantWidest {
+				t.Errorf("limitColumns() = %s, %d, %d, want %s, %d, %d",
+					got, limited, widest, tt.want, tt.wantLimited, tt.wantWidest)
+			}
+		})
+	}
+}
+
+func TestHandlerMaxColumns(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxColumns int
+		data       string
+		want       map[string]any
+		wantLog    bool
+	}{
+		{name: "no limit", data: `{"a":1,"b":2,"c":3}`, want: map[string]any{"a": 1.0, "b": 2.0, "c": 3.0}},
+		{name: "under the limit", maxColumns: 3, data: `{"a":1,"b":2}`, want: map[string]any{"a": 1.0, "b": 2.0}},
+		{name: "over the limit", maxColumns: 2, data: `{"a":1,"b":2,"c":3}`,
+			want: map[string]any{"a": 1.0, "_overflow": map[string]any{"b": 2.0, "c": 3.0}}, wantLog: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			var logs logRecorder
+			cfg := testConfig(honeycomb)
+			cfg.Logger = newRecordingLogger(&logs)
+			cfg.MaxColumns = tt.maxColumns
+
+			if err := handleData(t, cfg, tt.data); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if got := honeycomb.lastEvent(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("event = %v, want %v", got, tt.want)
+			}
+			records := logs.records(t, "events over the column limit, extra columns moved under _overflow")
+			if !tt.wantLog {
+				if len(records) != 0 {
+					t.Errorf("column limit logs = %v, want none", records)
+				}
+				return
+			}
+			if len(records) != 1 || records[0]["dataset"] != "test-dataset" || records[0]["columns"] != 3.0 {
+				t.Errorf("column limit logs = %v, want the dataset and the column count", records)
+			}
+		})
+	}
+}
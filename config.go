@@ -0,0 +1,989 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultTimeout    = 10 * time.Second
+	defaultAPIURL     = "https://api.honeycomb.io"
+	defaultAPIPath    = "/1/events"
+	defaultAuthHeader = "X-Honeycomb-Team"
+	// defaultMaxResponseBytes is enough for the status and the error message of a rejection.
+	defaultMaxResponseBytes = 4 << 10
+
+	defaultFlattenDelimiter = "."
+	defaultScrubMask        = "[REDACTED]"
+	defaultPubSubMetaPrefix = "pubsub"
+	defaultDedupTTL         = 10 * time.Minute
+	defaultDedupMaxEntries  = 10000
+
+	secretAccessTimeout = 10 * time.Second
+
+	defaultBatchFlushInterval = time.Second
+	defaultAuditFlushInterval = time.Second
+
+	serviceName                  = "gcp-sink-to-honeycomb"
+	defaultMetricsExportInterval = time.Minute
+	metricsExportTimeout         = 5 * time.Second
+)
+
+// Version is the version of the sink, sent in the User-Agent header. Release builds set it with
+// -ldflags "-X github.com/ValentinLvr/gcp-sink-to-honeycomb.Version=<version>".
+var Version = "dev"
+
+func defaultUserAgent() string {
+	return serviceName + "/" + Version
+}
+
+// Config holds the settings of the sink. The deployed function builds it from the environment with
+// ConfigFromEnv, tests can build it directly.
+type Config struct {
+	// SinkMode selects how events are shipped: "events" (the default) posts them to the Honeycomb events
+	// API, "otlp" sends them as OTLP log records to OTLPEndpoint and "webhook" posts them as is to
+	// WebhookURL. It is ignored when Sink is set.
+	SinkMode       string
+	OTLPEndpoint   string
+	OTLPHeaders    map[string]string
+	WebhookURL     string
+	WebhookHeaders map[string]string
+	// Sink forwards the events. The one selected by SinkMode is used when nil.
+	Sink Sink
+	// ExpectedEventTypes are the CloudEvent types processed, the events of other types are acknowledged and
+	// skipped. All types are processed when empty.
+	ExpectedEventTypes []string
+	// Dataset is the default Honeycomb dataset the events are sent to.
+	Dataset string
+	// APIKey is the Honeycomb API key, sent in the AuthHeader header.
+	APIKey string
+	// CheckDataset checks at cold start that the configured datasets exist, CreateDataset creates the
+	// missing ones with ManagementKey, a configuration key with the "Create Datasets" permission. The check
+	// uses ManagementKey too when set, the API key of the dataset otherwise.
+	CheckDataset  bool
+	CreateDataset bool
+	ManagementKey string
+	// APIKeys maps datasets, or "<attribute>=<value>" PubSub attribute selectors, to the API key of
+	// their Honeycomb environment. Attribute selectors win over datasets, APIKey is the fallback.
+	APIKeys    map[string]string
+	AuthHeader string
+	// APIURL is the Honeycomb API base URL, without trailing slash.
+	APIURL string
+	// APIPath is the path of the events API, the dataset is appended to it.
+	APIPath string
+	// FallbackURL is the base URL of a secondary ingest endpoint (e.g: another region or a Refinery) the
+	// sends are posted to when APIURL still fails after the retries. No fallback when empty.
+	FallbackURL string
+	// SubscriptionAllowlist maps datasets to the patterns of the PubSub subscriptions allowed to write to
+	// them. Messages of other subscriptions are refused, datasets which aren't listed accept all of them.
+	// Messages without a subscription are refused once it is set.
+	SubscriptionAllowlist map[string][]string
+	// FanOutDatasets also receive every event, on top of its resolved dataset. A failed dataset only
+	// fails the message when all of them failed, or when FanOutRequireAll is set.
+	FanOutDatasets   []string
+	FanOutRequireAll bool
+	// DatasetAttribute is the PubSub attribute overriding the dataset of a message, if any.
+	DatasetAttribute string
+	// RoutingRules route events to a dataset based on their content. The first matching rule wins.
+	RoutingRules []RoutingRule
+	// DatasetInHeader sends the dataset of the events and batch requests in the X-Honeycomb-Dataset
+	// header, to a URL without dataset, instead of in the URL path.
+	DatasetInHeader bool
+	// UserAgent is the User-Agent header of the requests, gcp-sink-to-honeycomb/<Version> when empty.
+	UserAgent string
+	// ContentType replaces the application/json content type of the requests to Honeycomb, if set.
+	ContentType string
+	// ExtraHeaders are added to every request to Honeycomb, e.g: for a proxy or an API gateway. They can't
+	// replace the API key header, unless ExtraHeadersOverrideKey is set.
+	ExtraHeaders            map[string]string
+	ExtraHeadersOverrideKey bool
+	// MaxRetries is the number of retries on network errors, 429 and 5xx responses.
+	MaxRetries int
+	// MaxConcurrency caps the number of requests in flight to Honeycomb across the concurrent invocations
+	// of the instance, no limit is enforced when 0. Requests over the limit wait for a slot.
+	MaxConcurrency int
+	// MaxInFlightBytes caps the total size of the PubSub data processed by the concurrent invocations of
+	// the instance, once decompressed, no limit is enforced when 0. Invocations over the cap wait for the
+	// ones in flight, which slows down PubSub; a message larger than the cap is processed alone. The
+	// messages waiting for their ordering key aren't counted.
+	MaxInFlightBytes int
+	// RateLimit caps the number of events sent to Honeycomb per second across the concurrent invocations,
+	// with bursts of up to RateBurst events (RateLimit, rounded up, when 0). No limit is enforced when 0.
+	RateLimit float64
+	RateBurst int
+	// CircuitBreakerThreshold opens the circuit breaker after this many consecutive transient failures
+	// within CircuitBreakerWindow (no window when 0): requests then fail fast for CircuitBreakerCooldown,
+	// before a probe request is let through. The breaker is disabled when 0.
+	CircuitBreakerThreshold int
+	CircuitBreakerWindow    time.Duration
+	CircuitBreakerCooldown  time.Duration
+	// AlertWebhookURL receives a summary (dataset, last error, failure count) once AlertThreshold
+	// consecutive sends failed permanently, at most once per AlertCooldown. No alert when empty.
+	AlertWebhookURL string
+	AlertThreshold  int
+	AlertCooldown   time.Duration
+	// Timeout bounds each request to Honeycomb.
+	Timeout time.Duration
+	// MaxResponseBytes caps the bytes read from the body of an error response of Honeycomb, the rest is
+	// dropped. The successful responses, which hold the per-event statuses of a batch, are capped at 1MB.
+	MaxResponseBytes int
+	// Batch sends every message through the batch endpoint.
+	Batch bool
+	// ReplayRateLimit is the maximum number of events per second forwarded by a replay, unlimited when 0,
+	// and ReplayConcurrency the number of events it sends in parallel, 1 when 0.
+	ReplayRateLimit   float64
+	ReplayConcurrency int
+	// BatchMaxEvents enables buffering: the events of concurrent invocations are sent together to the batch
+	// endpoint once BatchMaxEvents are pending for a dataset, or BatchFlushInterval after the first one.
+	BatchMaxEvents     int
+	BatchFlushInterval time.Duration
+	// AsyncWorkers enables asynchronous sends: the handler returns once the event is queued, and this many
+	// workers send the queued events. At most AsyncQueueSize events are queued, the handler waits for room
+	// beyond. The message is acknowledged before its event is sent, a failed send loses the event.
+	AsyncWorkers   int
+	AsyncQueueSize int
+	// AuditSink keeps an audit copy of every forwarded event: "stdout" as structured log entries, "gcs" as
+	// NDJSON objects of AuditBucket, under AuditPrefix, each holding the records of AuditFlushInterval. A
+	// failed audit write only fails the message when AuditRequired is set.
+	AuditSink          string
+	AuditBucket        string
+	AuditPrefix        string
+	AuditFlushInterval time.Duration
+	AuditRequired      bool
+	// Gzip compresses the request body.
+	Gzip bool
+	// SampleRate keeps 1 in SampleRate messages. Values below 1 are treated as 1.
+	SampleRate int
+	// SampleKey is the dotted path of the payload field (e.g: trace_id) sampling is deterministic on, if any.
+	SampleKey string
+	// Flatten expands nested JSON objects into top-level keys joined by FlattenDelimiter.
+	Flatten          bool
+	FlattenDelimiter string
+	// Transform maps JSON object events with a CEL expression, before the other transforms. A failed
+	// evaluation fails the message, or drops it when TransformSkipOnError is set.
+	Transform            *CELTransform
+	TransformSkipOnError bool
+	// FieldMap renames the top-level fields of JSON object events (old name -> new name). When the new
+	// name is already taken the event fails, unless FieldMapOverwrite is set.
+	FieldMap          map[string]string
+	FieldMapOverwrite bool
+	// DropFields are the dotted paths of the fields removed from JSON object events. When AllowFields is
+	// set, only the fields at those dotted paths are kept.
+	DropFields  []string
+	AllowFields []string
+	// ScrubPattern masks the matching parts of the string values of JSON object events with ScrubMask,
+	// whatever their field. Scrubbing is disabled when nil.
+	ScrubPattern *regexp.Regexp
+	ScrubMask    string
+	// StaticFields are added to every JSON object event. The producer's value wins on a key conflict,
+	// unless StaticFieldsOverride is set and MergeStrategy unset.
+	StaticFields         map[string]any
+	StaticFieldsOverride bool
+	// IncludePubSubMeta adds the PubSub message ID, subscription, ordering key, publish time and
+	// attributes to JSON object events, under PubSubMetaPrefix.
+	IncludePubSubMeta bool
+	PubSubMetaPrefix  string
+	// IncludeAttrs adds the PubSub attributes with these names ("*" for all) to JSON object events, as
+	// top-level fields whose keys are converted to AttrCase: "snake" (the default), "camel" or "preserve".
+	// Conflicts with the producer's fields follow MergeStrategy.
+	IncludeAttrs []string
+	AttrCase     string
+	// MergeStrategy resolves the key conflicts between the producer's fields and the ones added by the
+	// sink: "producer_wins" (the default) keeps the producer's value, "sink_wins" replaces it, and
+	// "prefix" adds the sink's value under MergePrefix + key. FieldMap renames are not concerned.
+	MergeStrategy string
+	MergePrefix   string
+	// MaxColumns caps the top-level keys of JSON object events, the extra keys are moved under "_overflow"
+	// or dropped when ColumnOverflow is "drop". No limit is enforced when 0.
+	MaxColumns     int
+	ColumnOverflow string
+	// TimeField is the dotted path of the field holding the event timestamp (epoch seconds, milliseconds,
+	// microseconds or nanoseconds, or an RFC 3339 like string). With TimeFieldMode "event_time" (the
+	// default) it sets the event time, with "rewrite" the field is rewritten as RFC 3339 in the event.
+	// The publish time is the event time when the field is missing or can't be parsed.
+	TimeField     string
+	TimeFieldMode string
+	// SkipIfAttr acknowledges without forwarding the messages carrying one of these attributes with the
+	// given value, or with any value for "*".
+	SkipIfAttr map[string]string
+	// ForwardIf forwards only the events of the decoded payload matching the condition, the other
+	// messages are acknowledged without forwarding. Elements of a JSON array payload are filtered one by one.
+	ForwardIf *ForwardCondition
+	// IncludeCESource adds the source and subject of the CloudEvent, which identify the resource that
+	// produced it, to JSON object events as ce.source and ce.subject.
+	IncludeCESource bool
+	// RespectOrdering forwards the messages sharing a PubSub ordering key one at a time, and adds the
+	// ordering key to JSON object events under PubSubMetaPrefix.
+	RespectOrdering bool
+	// DecodeDoubleBase64 decodes payloads base64-encoded a second time by the producer, when the result
+	// is a JSON object or array.
+	DecodeDoubleBase64 bool
+	// SkipEmpty acknowledges the messages without data instead of failing them.
+	SkipEmpty bool
+	// ExtractPath is the dotted path of the event in JSON object payloads wrapping it, only that object is
+	// forwarded. A payload without it fails, or is skipped when ExtractSkipMissing is set.
+	ExtractPath        string
+	ExtractSkipMissing bool
+	// PayloadFormat is the format of the PubSub data: "json" (the default) or "ndjson", newline-delimited
+	// JSON events sent through the batch endpoint.
+	PayloadFormat string
+	// WrapArrays sends a JSON array payload as a single event, under the "items" field. Otherwise the
+	// array is sent as one event per element, elements which aren't objects being dropped.
+	WrapArrays bool
+	// WrapInvalidJSON wraps payloads which aren't valid JSON as {"raw_message": "..."} instead of
+	// failing.
+	WrapInvalidJSON bool
+	// WrapNonJSON wraps payloads which aren't valid JSON as {"message": "...", "content_type": "..."},
+	// with their detected content type. It takes precedence over WrapInvalidJSON.
+	WrapNonJSON bool
+	// MaxEventBytes is the maximum size of an event, no limit is enforced when 0. Oversized events are
+	// rejected, unless TruncateOversized is set: their largest string fields are then trimmed.
+	MaxEventBytes     int
+	TruncateOversized bool
+	// DLQTopic is the PubSub topic (projects/<project>/topics/<topic>) messages permanently rejected by
+	// Honeycomb are published to, if any.
+	DLQTopic string
+	// Dedup skips the messages whose ID was forwarded less than DedupTTL ago. Deduplication is
+	// disabled when nil.
+	Dedup    DedupStore
+	DedupTTL time.Duration
+	// DryRun logs the assembled requests instead of sending them to Honeycomb.
+	DryRun bool
+	// Prewarm opens a connection to the sink's host at cold start, so the first event reuses it.
+	Prewarm bool
+	// PushVerifyJWT makes the push target check the OIDC token of the push subscription: signed by Google,
+	// for PushAudience (the URL of the push endpoint when empty) and, when set, issued to
+	// PushServiceAccount.
+	PushVerifyJWT      bool
+	PushAudience       string
+	PushServiceAccount string
+	// MetricsEndpoint is the OTLP/HTTP metrics endpoint (e.g: http://collector:4318/v1/metrics) the
+	// forwarded/failed counters and the Honeycomb latency histogram are pushed to, every
+	// MetricsExportInterval. Metrics are disabled when empty.
+	MetricsEndpoint       string
+	MetricsHeaders        map[string]string
+	MetricsExportInterval time.Duration
+	// LagWarnThreshold logs a warning and counts a lagging message in the metrics when a message is
+	// delivered more than LagWarnThreshold after its publication, disabled when 0. LagField, if set, is
+	// the field the delay since the publication is added as to JSON object events, in milliseconds.
+	LagWarnThreshold time.Duration
+	LagField         string
+	// AnnotateTiming adds the pipeline latency of a message, the time the sink spent on it before the send,
+	// in milliseconds, to its JSON object events as _sink_pipeline_ms. A producer field with the same
+	// name is kept.
+	AnnotateTiming bool
+	// TraceContext joins the trace of the producer, read from the traceparent CloudEvent extension: JSON
+	// object events get trace.trace_id and trace.parent_id fields. With TraceSpans, the sink also sends
+	// its own span, an extra event per message stamped with its sample rate, which the events are
+	// children of.
+	TraceContext bool
+	TraceSpans   bool
+	// ErrorReporting logs the sends which failed permanently in the format picked up by Error Reporting.
+	ErrorReporting bool
+	// ShutdownTimeout bounds the drain of the shutdown function returned by NewHandler, run on SIGTERM by
+	// the deployed function: flushing the buffered and queued events and waiting for the in-flight
+	// invocations. The default fits in the Cloud Run grace period.
+	ShutdownTimeout time.Duration
+	// DebugPayload logs the PubSub data at info level, so it shows with the default log level. It is off
+	// by default as payloads may hold sensitive data.
+	DebugPayload bool
+	// LogSampleRate only logs the data of 1 in LogSampleRate messages with DebugPayload, every message's
+	// when 1 or less. LogPayloadOnError logs the data of the messages which failed, at warning level.
+	LogSampleRate     int
+	LogPayloadOnError bool
+	// LogInvocations logs the outcome and the duration of every invocation.
+	LogInvocations bool
+	// Middlewares wrap the handler built by NewHandler, the first one being the outermost. They run inside
+	// the built-in logging and metrics middlewares.
+	Middlewares []Middleware
+	// Logger receives the logs of the handler. NewHandler builds a Cloud Logging JSON logger, configured
+	// with LOG_LEVEL, when nil; SendEvent uses the default logger.
+	Logger *slog.Logger
+	// HTTPClient sends the requests to Honeycomb. The shared pooled client is used when nil.
+	HTTPClient *http.Client
+	// ServicesHTTPClient sends the requests to the other services: the Google APIs (Secret Manager, PubSub,
+	// Cloud Storage, the push JWT keys), the metrics collector and the alert webhook. HTTPClient is used
+	// when nil. It must not pin the Honeycomb certificates.
+	ServicesHTTPClient *http.Client
+	// Clock tells the time, the wall clock is used when nil.
+	Clock Clock
+
+	// staticHeaders are the headers shared by the requests to Honeycomb, computed by withDefaults.
+	staticHeaders http.Header
+	// metrics, batcher, concurrency, inFlightBytes, limiter, skipped, unmatched, breaker, alerts and
+	// ordering are built by NewHandler from the metrics, buffering, concurrency, in-flight bytes, rate
+	// limit, filter, circuit breaker, alert and ordering settings.
+	metrics       *metricsRegistry
+	batcher       *batcher
+	concurrency   *semaphore.Weighted
+	inFlightBytes *semaphore.Weighted
+	limiter       *rate.Limiter
+	skipped       *skipCounter
+	unmatched     *skipCounter
+	breaker       *circuitBreaker
+	alerts        *alertNotifier
+	ordering      *keyedMutex
+}
+
+// ConfigFromEnv reads and validates the configuration from the environment variables. Errors match
+// ErrConfig.
+func ConfigFromEnv() (Config, error) {
+	c, err := configFromEnv()
+	return c, withCategory(err, ErrConfig)
+}
+
+func configFromEnv() (Config, error) {
+	var c Config
+	var err error
+	// The clients are built first, the API key may be fetched through the corporate proxy or CA too. The
+	// pins only apply to Honeycomb, the Google APIs have their own certificates.
+	tlsConfig, err := tlsConfigFromEnv()
+	if err != nil {
+		return c, err
+	}
+	if tlsConfig != nil {
+		c.HTTPClient = newTLSHTTPClient(tlsConfig)
+		c.ServicesHTTPClient = newServicesHTTPClient(tlsConfig)
+	}
+	// The dataset is optional outside of events mode, unless read from a file
+	if c.Dataset, err = getFileEnvVar("HONEYCOMB_DATASET"); err != nil && os.Getenv("HONEYCOMB_DATASET_FILE") != "" {
+		return c, err
+	}
+	switch c.SinkMode = getEnvVarOrDefault("SINK_MODE", sinkModeEvents); c.SinkMode {
+	case sinkModeEvents:
+		if c.Dataset, err = getFileEnvVar("HONEYCOMB_DATASET"); err != nil {
+			return c, err
+		}
+		if c.APIKey, err = getAPIKey(c.ServicesHTTPClient); err != nil {
+			return c, err
+		}
+		if c.APIKeys, err = getAPIKeysEnvVar(); err != nil {
+			return c, err
+		}
+		if c.CheckDataset, err = getBoolEnvVar("HONEYCOMB_CHECK_DATASET", false); err != nil {
+			return c, err
+		}
+		if c.CreateDataset, err = getBoolEnvVar("HONEYCOMB_CREATE_DATASET", false); err != nil {
+			return c, err
+		}
+		c.ManagementKey, err = getFileEnvVar("HONEYCOMB_MANAGEMENT_KEY")
+		if err != nil && os.Getenv("HONEYCOMB_MANAGEMENT_KEY_FILE") != "" {
+			return c, err
+		}
+		if c.CreateDataset && c.ManagementKey == "" {
+			return c, fmt.Errorf("error, HONEYCOMB_CREATE_DATASET requires a configuration key with the " +
+				"\"Create Datasets\" permission in HONEYCOMB_MANAGEMENT_KEY")
+		}
+	case sinkModeOTLP:
+		if c.OTLPEndpoint, err = getEnvVar("OTLP_ENDPOINT"); err != nil {
+			return c, err
+		}
+		if c.OTLPHeaders, err = getKeyValuesEnvVar("OTLP_HEADERS"); err != nil {
+			return c, err
+		}
+	case sinkModeWebhook:
+		if c.WebhookURL, err = getEnvVar("WEBHOOK_URL"); err != nil {
+			return c, err
+		}
+		if c.WebhookHeaders, err = getKeyValuesEnvVar("WEBHOOK_HEADERS"); err != nil {
+			return c, err
+		}
+	default:
+		return c, fmt.Errorf("error, SINK_MODE environment variable must be %s, %s or %s, got %q",
+			sinkModeEvents, sinkModeOTLP, sinkModeWebhook, c.SinkMode)
+	}
+	// The dataset is optional outside of events mode
+	if c.Dataset != "" || c.SinkMode == sinkModeEvents {
+		if err = validateDataset(c.Dataset); err != nil {
+			return c, fmt.Errorf("error, invalid HONEYCOMB_DATASET environment variable: %w", err)
+		}
+	}
+	if err = checkDatasetForKey(c); err != nil {
+		return c, err
+	}
+	if c.APIURL, err = getAPIURL("HONEYCOMB_API_URL", defaultAPIURL); err != nil {
+		return c, err
+	}
+	if c.APIPath, err = getAPIPath(c.APIURL); err != nil {
+		return c, err
+	}
+	if c.FallbackURL, err = getAPIURL("HONEYCOMB_FALLBACK_URL", ""); err != nil {
+		return c, err
+	}
+	c.AuthHeader = getEnvVarOrDefault("HONEYCOMB_AUTH_HEADER", defaultAuthHeader)
+	if c.AuthHeader == "" || strings.ContainsAny(c.AuthHeader, " :\t\r\n") {
+		return c, fmt.Errorf("error, HONEYCOMB_AUTH_HEADER environment variable must be a header name, got %q",
+			c.AuthHeader)
+	}
+	if c.DatasetInHeader, err = getBoolEnvVar("DATASET_IN_HEADER", false); err != nil {
+		return c, err
+	}
+	c.UserAgent = getEnvVarOrDefault("HONEYCOMB_USER_AGENT", defaultUserAgent())
+	c.ContentType = os.Getenv("HONEYCOMB_CONTENT_TYPE")
+	if c.ExtraHeaders, err = getStringMapEnvVar("HONEYCOMB_EXTRA_HEADERS"); err != nil {
+		return c, err
+	}
+	if c.ExtraHeadersOverrideKey, err = getBoolEnvVar("HONEYCOMB_EXTRA_HEADERS_OVERRIDE_KEY", false); err != nil {
+		return c, err
+	}
+	c.FanOutDatasets = getListEnvVar("HONEYCOMB_DATASETS")
+	if c.SubscriptionAllowlist, err = getSubscriptionAllowlistEnvVar(); err != nil {
+		return c, err
+	}
+	for _, dataset := range c.FanOutDatasets {
+		if err = validateDataset(dataset); err != nil {
+			return c, fmt.Errorf("error, invalid HONEYCOMB_DATASETS environment variable: %w", err)
+		}
+	}
+	if c.FanOutRequireAll, err = getBoolEnvVar("HONEYCOMB_DATASETS_REQUIRE_ALL", false); err != nil {
+		return c, err
+	}
+	c.ExpectedEventTypes = getListEnvVar("EXPECTED_EVENT_TYPES")
+	c.DatasetAttribute = os.Getenv("HONEYCOMB_DATASET_ATTRIBUTE")
+	if rules := os.Getenv("HONEYCOMB_ROUTING_RULES"); rules != "" {
+		if c.RoutingRules, err = parseRoutingRules(rules); err != nil {
+			return c, fmt.Errorf("error, invalid HONEYCOMB_ROUTING_RULES environment variable: %w", err)
+		}
+	}
+	if c.MaxRetries, err = getIntEnvVar("HONEYCOMB_MAX_RETRIES", defaultMaxRetries); err != nil {
+		return c, err
+	}
+	if c.MaxConcurrency, err = getIntEnvVar("HONEYCOMB_MAX_CONCURRENCY", 0); err != nil {
+		return c, err
+	}
+	if c.MaxInFlightBytes, err = getIntEnvVar("MAX_INFLIGHT_BYTES", 0); err != nil {
+		return c, err
+	}
+	if c.RateLimit, err = getFloatEnvVar("HONEYCOMB_RATE_LIMIT", 0); err != nil {
+		return c, err
+	}
+	if c.RateBurst, err = getIntEnvVar("HONEYCOMB_RATE_BURST", 0); err != nil {
+		return c, err
+	}
+	if c.CircuitBreakerThreshold, err = getIntEnvVar("CIRCUIT_BREAKER_THRESHOLD", 0); err != nil {
+		return c, err
+	}
+	if c.CircuitBreakerWindow, err = getDurationEnvVar("CIRCUIT_BREAKER_WINDOW", 0); err != nil {
+		return c, err
+	}
+	if c.CircuitBreakerCooldown, err = getDurationEnvVar("CIRCUIT_BREAKER_COOLDOWN",
+		defaultCircuitBreakerCooldown); err != nil {
+		return c, err
+	}
+	c.AlertWebhookURL = os.Getenv("ALERT_WEBHOOK_URL")
+	if c.AlertThreshold, err = getIntEnvVar("ALERT_THRESHOLD", defaultAlertThreshold); err != nil {
+		return c, err
+	}
+	if c.AlertCooldown, err = getDurationEnvVar("ALERT_COOLDOWN", defaultAlertCooldown); err != nil {
+		return c, err
+	}
+	if c.Timeout, err = getDurationEnvVar("HONEYCOMB_TIMEOUT", defaultTimeout); err != nil {
+		return c, err
+	}
+	if c.MaxResponseBytes, err = getIntEnvVar("MAX_RESPONSE_BYTES", defaultMaxResponseBytes); err != nil {
+		return c, err
+	}
+	if c.Batch, err = getBoolEnvVar("HONEYCOMB_BATCH", false); err != nil {
+		return c, err
+	}
+	if c.ReplayRateLimit, err = getFloatEnvVar("REPLAY_RATE_LIMIT", 0); err != nil {
+		return c, err
+	}
+	if c.ReplayConcurrency, err = getIntEnvVar("REPLAY_CONCURRENCY", 1); err != nil {
+		return c, err
+	}
+	if c.BatchMaxEvents, err = getIntEnvVar("BATCH_MAX_EVENTS", 0); err != nil {
+		return c, err
+	}
+	if c.BatchFlushInterval, err = getMillisecondsEnvVar("BATCH_FLUSH_MS", defaultBatchFlushInterval); err != nil {
+		return c, err
+	}
+	if c.AsyncWorkers, err = getIntEnvVar("ASYNC_WORKERS", 0); err != nil {
+		return c, err
+	}
+	if c.AsyncQueueSize, err = getIntEnvVar("ASYNC_QUEUE_SIZE", defaultAsyncQueueSize); err != nil {
+		return c, err
+	}
+	switch c.AuditSink = os.Getenv("AUDIT_SINK"); c.AuditSink {
+	case "", auditSinkStdout:
+	case auditSinkGCS:
+		if c.AuditBucket, err = getEnvVar("AUDIT_BUCKET"); err != nil {
+			return c, err
+		}
+		if c.AuditPrefix = strings.Trim(os.Getenv("AUDIT_PREFIX"), "/"); c.AuditPrefix != "" {
+			c.AuditPrefix += "/"
+		}
+		if c.AuditFlushInterval, err = getMillisecondsEnvVar("AUDIT_FLUSH_MS", defaultAuditFlushInterval); err != nil {
+			return c, err
+		}
+	default:
+		return c, fmt.Errorf("error, AUDIT_SINK environment variable must be %s or %s, got %q",
+			auditSinkStdout, auditSinkGCS, c.AuditSink)
+	}
+	if c.AuditRequired, err = getBoolEnvVar("AUDIT_REQUIRED", false); err != nil {
+		return c, err
+	}
+	if c.Gzip, err = getBoolEnvVar("HONEYCOMB_GZIP", false); err != nil {
+		return c, err
+	}
+	if c.SampleRate, err = getIntEnvVar("HONEYCOMB_SAMPLE_RATE", 1); err != nil {
+		return c, err
+	}
+	c.SampleKey = os.Getenv("HONEYCOMB_SAMPLE_KEY")
+	if c.Flatten, err = getBoolEnvVar("HONEYCOMB_FLATTEN", false); err != nil {
+		return c, err
+	}
+	c.FlattenDelimiter = getEnvVarOrDefault("HONEYCOMB_FLATTEN_DELIMITER", defaultFlattenDelimiter)
+	if expr := os.Getenv("HONEYCOMB_TRANSFORM_EXPR"); expr != "" {
+		if c.Transform, err = NewCELTransform(expr); err != nil {
+			return c, fmt.Errorf("error, invalid HONEYCOMB_TRANSFORM_EXPR environment variable: %w", err)
+		}
+	}
+	if c.TransformSkipOnError, err = getBoolEnvVar("HONEYCOMB_TRANSFORM_SKIP_ON_ERROR", false); err != nil {
+		return c, err
+	}
+	if c.FieldMap, err = getStringMapEnvVar("HONEYCOMB_FIELD_MAP"); err != nil {
+		return c, err
+	}
+	if c.FieldMapOverwrite, err = getBoolEnvVar("HONEYCOMB_FIELD_MAP_OVERWRITE", false); err != nil {
+		return c, err
+	}
+	c.DropFields = getListEnvVar("HONEYCOMB_DROP_FIELDS")
+	c.AllowFields = getListEnvVar("HONEYCOMB_ALLOW_FIELDS")
+	if c.ScrubPattern, err = getScrubPatternEnvVar("HONEYCOMB_SCRUB_PATTERNS"); err != nil {
+		return c, err
+	}
+	c.ScrubMask = getEnvVarOrDefault("HONEYCOMB_SCRUB_MASK", defaultScrubMask)
+	if c.StaticFields, err = getJSONObjectEnvVar("HONEYCOMB_STATIC_FIELDS"); err != nil {
+		return c, err
+	}
+	if c.StaticFieldsOverride, err = getBoolEnvVar("HONEYCOMB_STATIC_FIELDS_OVERRIDE", false); err != nil {
+		return c, err
+	}
+	if c.IncludePubSubMeta, err = getBoolEnvVar("HONEYCOMB_INCLUDE_PUBSUB_META", false); err != nil {
+		return c, err
+	}
+	c.PubSubMetaPrefix = getEnvVarOrDefault("HONEYCOMB_PUBSUB_META_PREFIX", defaultPubSubMetaPrefix)
+	c.IncludeAttrs = getListEnvVar("HONEYCOMB_INCLUDE_ATTRS")
+	c.AttrCase = getEnvVarOrDefault("HONEYCOMB_ATTR_CASE", attrCaseSnake)
+	if err = validateAttrCase(c.AttrCase); err != nil {
+		return c, fmt.Errorf("error, invalid HONEYCOMB_ATTR_CASE environment variable: %w", err)
+	}
+	if c.MergeStrategy = os.Getenv("MERGE_STRATEGY"); c.MergeStrategy != "" {
+		if err = validateMergeStrategy(c.MergeStrategy); err != nil {
+			return c, fmt.Errorf("error, invalid MERGE_STRATEGY environment variable: %w", err)
+		}
+	}
+	c.MergePrefix = getEnvVarOrDefault("MERGE_PREFIX", defaultMergePrefix)
+	if c.MaxColumns, err = getIntEnvVar("MAX_COLUMNS", 0); err != nil {
+		return c, err
+	}
+	c.ColumnOverflow = getEnvVarOrDefault("COLUMN_OVERFLOW", columnOverflowNest)
+	if c.ColumnOverflow != columnOverflowNest && c.ColumnOverflow != columnOverflowDrop {
+		return c, fmt.Errorf("error, invalid COLUMN_OVERFLOW environment variable %q, expected %s or %s",
+			c.ColumnOverflow, columnOverflowNest, columnOverflowDrop)
+	}
+	c.TimeField = os.Getenv("HONEYCOMB_TIME_FIELD")
+	c.TimeFieldMode = getEnvVarOrDefault("HONEYCOMB_TIME_FIELD_MODE", timeFieldEventTime)
+	if c.TimeFieldMode != timeFieldEventTime && c.TimeFieldMode != timeFieldRewrite {
+		return c, fmt.Errorf("error, invalid HONEYCOMB_TIME_FIELD_MODE environment variable %q, expected %s or %s",
+			c.TimeFieldMode, timeFieldEventTime, timeFieldRewrite)
+	}
+	if c.SkipIfAttr, err = getKeyValuesEnvVar("HONEYCOMB_SKIP_IF_ATTR"); err != nil {
+		return c, err
+	}
+	if cond := os.Getenv("HONEYCOMB_FORWARD_IF"); cond != "" {
+		if c.ForwardIf, err = parseForwardCondition(cond); err != nil {
+			return c, fmt.Errorf("error, invalid HONEYCOMB_FORWARD_IF environment variable: %w", err)
+		}
+	}
+	if c.IncludeCESource, err = getBoolEnvVar("INCLUDE_CE_SOURCE", false); err != nil {
+		return c, err
+	}
+	if c.RespectOrdering, err = getBoolEnvVar("HONEYCOMB_RESPECT_ORDERING", false); err != nil {
+		return c, err
+	}
+	if c.DecodeDoubleBase64, err = getBoolEnvVar("DECODE_DOUBLE_BASE64", false); err != nil {
+		return c, err
+	}
+	if c.SkipEmpty, err = getBoolEnvVar("SKIP_EMPTY", true); err != nil {
+		return c, err
+	}
+	c.ExtractPath = os.Getenv("HONEYCOMB_EXTRACT_PATH")
+	if c.ExtractSkipMissing, err = getBoolEnvVar("HONEYCOMB_EXTRACT_SKIP_MISSING", false); err != nil {
+		return c, err
+	}
+	switch c.PayloadFormat = getEnvVarOrDefault("PAYLOAD_FORMAT", payloadFormatJSON); c.PayloadFormat {
+	case payloadFormatJSON, payloadFormatNDJSON:
+	default:
+		return c, fmt.Errorf("error, PAYLOAD_FORMAT environment variable must be %s or %s, got %q",
+			payloadFormatJSON, payloadFormatNDJSON, c.PayloadFormat)
+	}
+	explodeArrays, err := getBoolEnvVar("EXPLODE_ARRAYS", true)
+	if err != nil {
+		return c, err
+	}
+	c.WrapArrays = !explodeArrays
+	if c.WrapInvalidJSON, err = getBoolEnvVar("WRAP_INVALID_JSON", false); err != nil {
+		return c, err
+	}
+	if c.WrapNonJSON, err = getBoolEnvVar("WRAP_NON_JSON", false); err != nil {
+		return c, err
+	}
+	if c.MaxEventBytes, err = getIntEnvVar("MAX_EVENT_BYTES", defaultMaxEventBytes); err != nil {
+		return c, err
+	}
+	if c.TruncateOversized, err = getBoolEnvVar("TRUNCATE_OVERSIZED", false); err != nil {
+		return c, err
+	}
+	c.DLQTopic = os.Getenv("DLQ_TOPIC")
+	if c.DLQTopic != "" && !topicPattern.MatchString(c.DLQTopic) {
+		return c, fmt.Errorf("error, DLQ_TOPIC environment variable must be a topic name "+
+			"(projects/<project>/topics/<topic>), got %q", c.DLQTopic)
+	}
+	if c.Dedup, err = dedupStoreFromEnv(); err != nil {
+		return c, err
+	}
+	if c.DedupTTL, err = getDurationEnvVar("DEDUP_TTL", defaultDedupTTL); err != nil {
+		return c, err
+	}
+	if c.DryRun, err = getBoolEnvVar("DRY_RUN", false); err != nil {
+		return c, err
+	}
+	if c.Prewarm, err = getBoolEnvVar("PREWARM", false); err != nil {
+		return c, err
+	}
+	if c.PushVerifyJWT, err = getBoolEnvVar("PUSH_VERIFY_JWT", false); err != nil {
+		return c, err
+	}
+	c.PushAudience = os.Getenv("PUSH_JWT_AUDIENCE")
+	c.PushServiceAccount = os.Getenv("PUSH_SERVICE_ACCOUNT")
+	enableMetrics, err := getBoolEnvVar("ENABLE_METRICS", false)
+	if err != nil {
+		return c, err
+	}
+	if enableMetrics {
+		if c.MetricsEndpoint, err = getEnvVar("METRICS_ENDPOINT"); err != nil {
+			return c, err
+		}
+		if c.MetricsHeaders, err = getKeyValuesEnvVar("METRICS_HEADERS"); err != nil {
+			return c, err
+		}
+		if c.MetricsExportInterval, err = getDurationEnvVar("METRICS_EXPORT_INTERVAL",
+			defaultMetricsExportInterval); err != nil {
+			return c, err
+		}
+	}
+	if c.ErrorReporting, err = getBoolEnvVar("ENABLE_ERROR_REPORTING", false); err != nil {
+		return c, err
+	}
+	if c.ShutdownTimeout, err = getDurationEnvVar("SHUTDOWN_TIMEOUT", defaultShutdownTimeout); err != nil {
+		return c, err
+	}
+	if c.DebugPayload, err = getBoolEnvVar("DEBUG_PAYLOAD", false); err != nil {
+		return c, err
+	}
+	if c.LogInvocations, err = getBoolEnvVar("LOG_INVOCATIONS", false); err != nil {
+		return c, err
+	}
+	if c.LogSampleRate, err = getIntEnvVar("LOG_SAMPLE_RATE", 1); err != nil {
+		return c, err
+	}
+	if c.LogPayloadOnError, err = getBoolEnvVar("LOG_PAYLOAD_ON_ERROR", false); err != nil {
+		return c, err
+	}
+	lagWarnSeconds, err := getIntEnvVar("LAG_WARN_SECONDS", 0)
+	if err != nil {
+		return c, err
+	}
+	c.LagWarnThreshold = time.Duration(lagWarnSeconds) * time.Second
+	c.LagField = os.Getenv("LAG_FIELD")
+	if c.AnnotateTiming, err = getBoolEnvVar("ANNOTATE_TIMING", false); err != nil {
+		return c, err
+	}
+	if c.TraceContext, err = getBoolEnvVar("TRACE_CONTEXT", false); err != nil {
+		return c, err
+	}
+	if c.TraceSpans, err = getBoolEnvVar("TRACE_SPANS", false); err != nil {
+		return c, err
+	}
+	if c.SampleRate < 1 {
+		return c, fmt.Errorf("error, HONEYCOMB_SAMPLE_RATE environment variable must be at least 1, got %d", c.SampleRate)
+	}
+	return c, nil
+}
+
+func getEnvVar(key string) (string, error) {
+	value, isPresent := os.LookupEnv(key)
+	if !isPresent {
+		return "", fmt.Errorf("error, %s environment variable is missing", key)
+	}
+	return value, nil
+}
+
+// getFileEnvVar reads a value from the file named by the <key>_FILE environment variable when it is set
+// (e.g: a secret mounted by an injector), from the key environment variable otherwise. Leading and
+// trailing whitespaces of the file are trimmed.
+func getFileEnvVar(key string) (string, error) {
+	path, isPresent := os.LookupEnv(key + "_FILE")
+	if !isPresent || path == "" {
+		return getEnvVar(key)
+	}
+	value, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading the %s_FILE file %w", key, err)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// getAPIKey reads the Honeycomb API key from the Secret Manager secret version named by
+// HONEYCOMB_API_KEY_SECRET when set, from the HONEYCOMB_API_KEY_FILE file or HONEYCOMB_API_KEY otherwise.
+// The secret is fetched once, at cold start, with client (the shared client when nil), and the function
+// service account needs roles/secretmanager.secretAccessor on it.
+func getAPIKey(client *http.Client) (string, error) {
+	name, isPresent := os.LookupEnv("HONEYCOMB_API_KEY_SECRET")
+	if !isPresent || name == "" {
+		return getFileEnvVar("HONEYCOMB_API_KEY")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretAccessTimeout)
+	defer cancel()
+	if client == nil {
+		client = httpClient
+	}
+	key, err := accessSecret(ctx, client, realClock{}, name)
+	if err != nil {
+		return "", fmt.Errorf("error reading the Honeycomb API key from HONEYCOMB_API_KEY_SECRET %w", err)
+	}
+	if key == "" {
+		return "", fmt.Errorf("error, secret %s holding the Honeycomb API key is empty", name)
+	}
+	return key, nil
+}
+
+// dedupStoreFromEnv builds the dedup store selected by DEDUP_STORE: "memory", "redis" (at
+// DEDUP_REDIS_ADDR), or none when unset.
+func dedupStoreFromEnv() (DedupStore, error) {
+	switch store := os.Getenv("DEDUP_STORE"); store {
+	case "":
+		return nil, nil
+	case "memory":
+		maxEntries, err := getIntEnvVar("DEDUP_MAX_ENTRIES", defaultDedupMaxEntries)
+		if err != nil {
+			return nil, err
+		}
+		return NewMemoryDedupStore(maxEntries, nil), nil
+	case "redis":
+		addr, err := getEnvVar("DEDUP_REDIS_ADDR")
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisDedupStore(addr), nil
+	default:
+		return nil, fmt.Errorf("error, DEDUP_STORE environment variable must be memory or redis, got %q", store)
+	}
+}
+
+// getEnvVarOrDefault reads an optional environment variable, returning def when it isn't set.
+func getEnvVarOrDefault(key string, def string) string {
+	value, isPresent := os.LookupEnv(key)
+	if !isPresent {
+		return def
+	}
+	return value
+}
+
+// getListEnvVar reads an optional comma-separated environment variable, ignoring blank items.
+func getListEnvVar(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getIntEnvVar reads an integer environment variable, returning def when it isn't set.
+func getIntEnvVar(key string, def int) (int, error) {
+	value, isPresent := os.LookupEnv(key)
+	if !isPresent {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("error, %s environment variable must be a non-negative integer, got %q", key, value)
+	}
+	return i, nil
+}
+
+// getFloatEnvVar reads a non-negative number environment variable, returning def when it isn't set.
+func getFloatEnvVar(key string, def float64) (float64, error) {
+	value, isPresent := os.LookupEnv(key)
+	if !isPresent {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("error, %s environment variable must be a non-negative number, got %q", key, value)
+	}
+	return f, nil
+}
+
+// getDurationEnvVar reads a duration environment variable (e.g: "10s", "500ms"), returning def when
+// it isn't set.
+func getDurationEnvVar(key string, def time.Duration) (time.Duration, error) {
+	value, isPresent := os.LookupEnv(key)
+	if !isPresent {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("error, %s environment variable must be a positive duration (e.g: 10s), got %q", key, value)
+	}
+	return d, nil
+}
+
+// getMillisecondsEnvVar reads a positive number of milliseconds from an environment variable, returning
+// def when it isn't set.
+func getMillisecondsEnvVar(key string, def time.Duration) (time.Duration, error) {
+	value, isPresent := os.LookupEnv(key)
+	if !isPresent {
+		return def, nil
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms <= 0 {
+		return 0, fmt.Errorf("error, %s environment variable must be a positive number of milliseconds, got %q",
+			key, value)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// getBoolEnvVar reads a boolean environment variable (e.g: "true", "false", "1"), returning def when
+// it isn't set.
+func getBoolEnvVar(key string, def bool) (bool, error) {
+	value, isPresent := os.LookupEnv(key)
+	if !isPresent {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("error, %s environment variable must be a boolean, got %q", key, value)
+	}
+	return b, nil
+}
+
+// getAPIURL reads a Honeycomb API base URL (e.g: https://api.eu1.honeycomb.io for the EU region, or
+// a Refinery proxy) and checks it is an absolute http(s) URL. The trailing slash is removed.
+func getAPIURL(key string, defaultValue string) (string, error) {
+	value, isPresent := os.LookupEnv(key)
+	if !isPresent {
+		return defaultValue, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || !u.IsAbs() || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("error, %s environment variable must be an absolute http(s) URL "+
+			"(e.g: https://api.eu1.honeycomb.io), got %q", key, value)
+	}
+	return strings.TrimRight(value, "/"), nil
+}
+
+// getAPIPath reads the path of the Honeycomb events API (e.g: /1/events) and checks the endpoint it
+// makes with the base URL is valid. The dataset is appended to it.
+func getAPIPath(apiURL string) (string, error) {
+	value := getEnvVarOrDefault("HONEYCOMB_API_PATH", defaultAPIPath)
+	if _, err := BuildEventsURL(apiURL, value, "dataset"); err != nil || strings.Contains(value, " ") {
+		return "", fmt.Errorf("error, HONEYCOMB_API_PATH environment variable must be a URL path (e.g: %s), got %q",
+			defaultAPIPath, value)
+	}
+	return "/" + strings.Trim(value, "/"), nil
+}
+
+// getJSONObjectEnvVar reads an optional environment variable holding a JSON object (e.g:
+// {"service":"checkout"}), returning nil when it isn't set.
+func getJSONObjectEnvVar(key string) (map[string]any, error) {
+	value, isPresent := os.LookupEnv(key)
+	if !isPresent || value == "" {
+		return nil, nil
+	}
+	fields, ok := decodeObject([]byte(value))
+	if !ok {
+		// The value may hold secrets, e.g: the API key in a header
+		return nil, fmt.Errorf("error, %s environment variable must be a JSON object", key)
+	}
+	return fields, nil
+}
+
+// getStringMapEnvVar reads an optional environment variable holding a JSON object of strings (e.g:
+// {"msg":"message"}), returning nil when it isn't set.
+func getStringMapEnvVar(key string) (map[string]string, error) {
+	fields, err := getJSONObjectEnvVar(key)
+	if err != nil || fields == nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(fields))
+	for name, value := range fields {
+		s, ok := value.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("error, %s environment variable must be a JSON object of non-empty strings, "+
+				"the value of %q isn't", key, name)
+		}
+		values[name] = s
+	}
+	return values, nil
+}
+
+// getScrubPatternEnvVar reads an optional environment variable holding a JSON array of regular
+// expressions (e.g: ["[\\w.+-]+@[\\w-]+\\.[\\w.]+"]) and compiles them, returning nil when it isn't set.
+func getScrubPatternEnvVar(key string) (*regexp.Regexp, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+	var patterns []string
+	if err := json.Unmarshal([]byte(value), &patterns); err != nil {
+		return nil, fmt.Errorf("error, %s environment variable must be a JSON array of regular expressions, got %q",
+			key, value)
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	pattern, err := compileScrubPatterns(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("error, invalid %s environment variable: %w", key, err)
+	}
+	return pattern, nil
+}
+
+// getKeyValuesEnvVar reads an optional environment variable holding comma-separated key=value pairs
+// (e.g: "x-honeycomb-team=abc,x-other=def"), returning nil when it isn't set.
+func getKeyValuesEnvVar(key string) (map[string]string, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, nil
+	}
+	pairs := make(map[string]string)
+	for i, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			// The pairs may hold secrets, e.g: the API key in a header, only the position is reported
+			return nil, fmt.Errorf("error, %s environment variable must be comma-separated key=value pairs, "+
+				"entry %d isn't", key, i+1)
+		}
+		pairs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return pairs, nil
+}
@@ -0,0 +1,61 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConfigFromEnvHidesSecrets(t *testing.T) {
+	const secret = "hcaik_01hs3ret"
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantName string
+	}{
+		{name: "OTLP headers", env: map[string]string{"SINK_MODE": "otlp",
+			"OTLP_ENDPOINT": "https://otlp.example.com", "OTLP_HEADERS": "x-honeycomb-dataset=logs," + secret},
+			wantName: "OTLP_HEADERS environment variable"},
+		{name: "webhook headers", env: map[string]string{"SINK_MODE": "webhook", "WEBHOOK_URL": "https://example.com",
+			"WEBHOOK_HEADERS": "authorization " + secret}, wantName: "WEBHOOK_HEADERS environment variable"},
+		{name: "metrics headers", env: map[string]string{"ENABLE_METRICS": "true",
+			"METRICS_ENDPOINT": "https://metrics.example.com", "METRICS_HEADERS": "=" + secret},
+			wantName: "METRICS_HEADERS environment variable"},
+		{name: "extra headers not an object", env: map[string]string{
+			"HONEYCOMB_EXTRA_HEADERS": `{"x-honeycomb-team":"` + secret + `"`},
+			wantName: "HONEYCOMB_EXTRA_HEADERS environment variable"},
+		{name: "extra headers empty value", env: map[string]string{
+			"HONEYCOMB_EXTRA_HEADERS": `{"x-honeycomb-team":"` + secret + `","x-other":""}`},
+			wantName: `HONEYCOMB_EXTRA_HEADERS environment variable must be a JSON object of non-empty strings, ` +
+				`the value of "x-other" isn't`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HONEYCOMB_DATASET", "test-dataset")
+			t.Setenv("HONEYCOMB_API_KEY", "test-api-key")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := ConfigFromEnv()
+			if !errors.Is(err, ErrConfig) || !strings.Contains(err.Error(), tt.wantName) {
+				t.Fatalf("ConfigFromEnv() error = %v, want a configuration error naming %s", err, tt.wantName)
+			}
+			if strings.Contains(err.Error(), secret) {
+				t.Errorf("ConfigFromEnv() error = %v, holds the secret", err)
+			}
+		})
+	}
+}
+
+func TestGetKeyValuesEnvVar(t *testing.T) {
+	t.Setenv("OTLP_HEADERS", " x-honeycomb-team = abc ,x-honeycomb-dataset=logs")
+	got, err := getKeyValuesEnvVar("OTLP_HEADERS")
+	if err != nil || len(got) != 2 || got["x-honeycomb-team"] != "abc" || got["x-honeycomb-dataset"] != "logs" {
+		t.Errorf("getKeyValuesEnvVar() = %v, %v, want the two trimmed pairs", got, err)
+	}
+
+	t.Setenv("OTLP_HEADERS", "a=1,b=2,secret")
+	if _, err := getKeyValuesEnvVar("OTLP_HEADERS"); err == nil || !strings.Contains(err.Error(), "entry 3") {
+		t.Errorf("getKeyValuesEnvVar() error = %v, want the position of the malformed entry", err)
+	}
+}
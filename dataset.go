@@ -0,0 +1,130 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// RoutingRule sends the events whose Field (a dotted JSON path, e.g: "event.type") equals Value to
+// Dataset.
+type RoutingRule struct {
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	Dataset string `json:"dataset"`
+}
+
+// resolveDataset returns the dataset the message is sent to: the value of the DatasetAttribute PubSub
+// attribute when the message carries it, else the dataset of the first routing rule matching the
+// payload, else the default dataset.
+func resolveDataset(msg MessagePublishedData, cfg Config) (string, error) {
+	if cfg.DatasetAttribute != "" {
+		if dataset, ok := msg.Message.Attributes[cfg.DatasetAttribute]; ok {
+			if err := validateDataset(dataset); err != nil {
+				return "", withCategory(
+					fmt.Errorf("error invalid dataset in PubSub attribute %q: %w", cfg.DatasetAttribute, err), ErrPermanent)
+			}
+			return dataset, nil
+		}
+	}
+	if len(cfg.RoutingRules) > 0 {
+		if fields, ok := decodeObject(msg.Message.Data); ok {
+			for _, rule := range cfg.RoutingRules {
+				if value, ok := lookupPath(fields, rule.Field); ok && fmt.Sprint(value) == rule.Value {
+					return rule.Dataset, nil
+				}
+			}
+		}
+	}
+	return cfg.Dataset, nil
+}
+
+// parseRoutingRules decodes and validates a JSON array of routing rules.
+func parseRoutingRules(value string) ([]RoutingRule, error) {
+	var rules []RoutingRule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("error decoding routing rules %w", err)
+	}
+	for i, rule := range rules {
+		if rule.Field == "" {
+			return nil, fmt.Errorf("error routing rule %d has no field", i)
+		}
+		if err := validateDataset(rule.Dataset); err != nil {
+			return nil, fmt.Errorf("error routing rule %d: %w", i, err)
+		}
+	}
+	return rules, nil
+}
+
+// datasetEndpoint returns the URL of a Honeycomb API (events, batch or markers) for a dataset. The
+// dataset is escaped since names may hold spaces or unicode characters.
+func datasetEndpoint(apiURL string, api string, dataset string) string {
+	return apiURL + "/1/" + api + "/" + url.PathEscape(dataset)
+}
+
+// datasetHeader carries the dataset of the events and batch requests when DatasetInHeader is set.
+const datasetHeader = "X-Honeycomb-Dataset"
+
+// sendEndpoint returns the URL of the events API (or of the batch API when batch is set) for a dataset.
+// When cfg.DatasetInHeader is set, the URL has no dataset and headers get the dataset header instead.
+func sendEndpoint(cfg Config, dataset string, batch bool, headers http.Header) (string, error) {
+	if !cfg.DatasetInHeader {
+		if batch {
+			return datasetEndpoint(cfg.APIURL, "batch", dataset), nil
+		}
+		return BuildEventsURL(cfg.APIURL, cfg.APIPath, dataset)
+	}
+	// The dataset becomes a header value, which mustn't hold control characters either
+	if err := validateDataset(dataset); err != nil {
+		return "", withCategory(fmt.Errorf("error invalid dataset: %w", err), ErrPermanent)
+	}
+	headers.Set(datasetHeader, dataset)
+	if batch {
+		return cfg.APIURL + "/1/batch", nil
+	}
+	return cfg.APIURL + cfg.APIPath, nil
+}
+
+// BuildEventsURL returns the URL of the Honeycomb events API for a dataset (e.g:
+// https://api.honeycomb.io/1/events/my%20dataset), from the API base URL and the events path (defaults to
+// https://api.honeycomb.io and /1/events when empty). Extra slashes are removed and the dataset is
+// escaped. An error is returned for a base URL which isn't absolute http(s) or an invalid dataset name.
+func BuildEventsURL(baseURL string, path string, dataset string) (string, error) {
+	if baseURL == "" {
+		baseURL = defaultAPIURL
+	}
+	if path == "" {
+		path = defaultAPIPath
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || !u.IsAbs() || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("error, honeycomb API URL must be an absolute http(s) URL, got %q", baseURL)
+	}
+	if strings.ContainsAny(path, "?#") {
+		return "", fmt.Errorf("error, honeycomb API path must be a URL path, got %q", path)
+	}
+	if err := validateDataset(dataset); err != nil {
+		return "", withCategory(fmt.Errorf("error invalid dataset: %w", err), ErrPermanent)
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + strings.Trim(path, "/") + "/" + url.PathEscape(dataset), nil
+}
+
+// validateDataset rejects dataset names which could inject path segments or query parameters in the
+// Honeycomb URL.
+func validateDataset(dataset string) error {
+	if strings.TrimSpace(dataset) == "" {
+		return fmt.Errorf("dataset name is empty")
+	}
+	if dataset == "." || dataset == ".." || strings.ContainsAny(dataset, `/\?#%`) {
+		return fmt.Errorf("dataset name %q contains forbidden characters", dataset)
+	}
+	for _, r := range dataset {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("dataset name %q contains control characters", dataset)
+		}
+	}
+	return nil
+}
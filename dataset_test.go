@@ -0,0 +1,254 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateDataset(t *testing.T) {
+	tests := []struct {
+		dataset string
+		wantErr bool
+	}{
+		{dataset: "production"},
+		{dataset: "my dataset"},
+		{dataset: "données-été"},
+		{dataset: "日本語"},
+		{dataset: "team.api_v2"},
+		{dataset: "", wantErr: true},
+		{dataset: "   ", wantErr: true},
+		{dataset: "a/b", wantErr: true},
+		{dataset: `a\b`, wantErr: true},
+		{dataset: "a?b=c", wantErr: true},
+		{dataset: "a#b", wantErr: true},
+		{dataset: "a%2Fb", wantErr: true},
+		{dataset: "..", wantErr: true},
+		{dataset: "line\nbreak", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dataset, func(t *testing.T) {
+			if err := validateDataset(tt.dataset); (err != nil) != tt.wantErr {
+				t.Errorf("validateDataset(%q) error = %v, want error: %v", tt.dataset, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDatasetEscaping(t *testing.T) {
+	tests := []struct {
+		dataset   string
+		wantPath  string
+		wantBatch string
+	}{
+		{dataset: "my dataset", wantPath: "/1/events/my%20dataset", wantBatch: "/1/batch/my%20dataset"},
+		{dataset: "données", wantPath: "/1/events/donn%C3%A9es", wantBatch: "/1/batch/donn%C3%A9es"},
+		{dataset: "日本", wantPath: "/1/events/%E6%97%A5%E6%9C%AC", wantBatch: "/1/batch/%E6%97%A5%E6%9C%AC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dataset, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.Dataset = tt.dataset
+			if err := handleData(t, cfg, `{"a":1}`); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if err := handleData(t, cfg, `[{"a":1}]`); err != nil {
+				t.Fatalf("handler error for a batch = %v", err)
+			}
+			requests := honeycomb.received()
+			if requests[0].Path != tt.wantPath {
+				t.Errorf("events path = %s, want %s", requests[0].Path, tt.wantPath)
+			}
+			if requests[1].Path != tt.wantBatch {
+				t.Errorf("batch path = %s, want %s", requests[1].Path, tt.wantBatch)
+			}
+		})
+	}
+}
+
+func TestDatasetValidatedAtLoad(t *testing.T) {
+	for _, dataset := range []string{"a/b", " "} {
+		t.Run(dataset, func(t *testing.T) {
+			t.Setenv("HONEYCOMB_DATASET", dataset)
+			t.Setenv("HONEYCOMB_API_KEY", "test-api-key")
+			if _, err := ConfigFromEnv(); !errors.Is(err, ErrConfig) {
+				t.Errorf("ConfigFromEnv() error = %v, want a configuration error", err)
+			}
+		})
+	}
+}
+
+func TestResolveDatasetFromAttribute(t *testing.T) {
+	cfg := Config{Dataset: "default", DatasetAttribute: "dataset"}
+	tests := []struct {
+		name    string
+		attrs   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "no attribute", want: "default"},
+		{name: "attribute", attrs: map[string]string{"dataset": "team a"}, want: "team a"},
+		{name: "unicode attribute", attrs: map[string]string{"dataset": "équipe"}, want: "équipe"},
+		{name: "slash in attribute", attrs: map[string]string{"dataset": "../admin"}, wantErr: true},
+		{name: "empty attribute", attrs: map[string]string{"dataset": ""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := MessagePublishedData{Message: PubSubMessage{Data: []byte(`{}`), Attributes: tt.attrs}}
+			got, err := resolveDataset(msg, cfg)
+			if tt.wantErr {
+				if !errors.Is(err, ErrPermanent) {
+					t.Errorf("resolveDataset() error = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("resolveDataset() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRoutingRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []RoutingRule
+		wantErr string
+	}{
+		{name: "rules", value: `[{"field":"severity","value":"ERROR","dataset":"errors"},` +
+			`{"field":"resource.type","value":"gce_instance","dataset":"vm logs"}]`,
+			want: []RoutingRule{{Field: "severity", Value: "ERROR", Dataset: "errors"},
+				{Field: "resource.type", Value: "gce_instance", Dataset: "vm logs"}}},
+		{name: "not JSON", value: `severity=ERROR`, wantErr: "error decoding routing rules"},
+		{name: "no field", value: `[{"value":"ERROR","dataset":"errors"}]`,
+			wantErr: "error routing rule 0 has no field"},
+		{name: "invalid dataset", value: `[{"field":"a","dataset":"errors"},{"field":"b","dataset":"a/b"}]`,
+			wantErr: "error routing rule 1:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRoutingRules(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseRoutingRules() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRoutingRules() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDatasetFromRoutingRules(t *testing.T) {
+	cfg := Config{Dataset: "default", RoutingRules: []RoutingRule{
+		{Field: "severity", Value: "ERROR", Dataset: "errors"},
+		{Field: "resource.labels.code", Value: "42", Dataset: "answers"},
+		{Field: "severity", Value: "ERROR", Dataset: "shadowed"},
+	}}
+	tests := map[string]string{
+		`{"severity":"ERROR"}`:                       "errors",
+		`{"resource":{"labels":{"code":42}}}`:        "answers",
+		`{"severity":"INFO"}`:                        "default",
+		`[{"severity":"ERROR"}]`:                     "default",
+		`{"severity":"ERROR","resource":{"x":true}}`: "errors",
+	}
+	for data, want := range tests {
+		msg := MessagePublishedData{Message: PubSubMessage{Data: []byte(data)}}
+		if got, err := resolveDataset(msg, cfg); err != nil || got != want {
+			t.Errorf("resolveDataset(%s) = %q, %v, want %q", data, got, err, want)
+		}
+	}
+}
+
+func TestBuildEventsURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		path    string
+		dataset string
+		want    string
+		wantErr bool
+	}{
+		{name: "default host", dataset: "prod", want: "https://api.honeycomb.io/1/events/prod"},
+		{name: "EU host", baseURL: "https://api.eu1.honeycomb.io", dataset: "prod",
+			want: "https://api.eu1.honeycomb.io/1/events/prod"},
+		{name: "trailing slashes", baseURL: "https://api.honeycomb.io//", path: "/1/events/", dataset: "prod",
+			want: "https://api.honeycomb.io/1/events/prod"},
+		{name: "custom path", baseURL: "http://refinery:8080", path: "v1/events", dataset: "prod",
+			want: "http://refinery:8080/v1/events/prod"},
+		{name: "escaped dataset", dataset: "my dataset", want: "https://api.honeycomb.io/1/events/my%20dataset"},
+		{name: "unicode dataset", dataset: "été", want: "https://api.honeycomb.io/1/events/%C3%A9t%C3%A9"},
+		{name: "slash in dataset", dataset: "a/b", wantErr: true},
+		{name: "empty dataset", dataset: "", wantErr: true},
+		{name: "query in dataset", dataset: "a?b", wantErr: true},
+		{name: "relative base URL", baseURL: "api.honeycomb.io", dataset: "prod", wantErr: true},
+		{name: "non-http base URL", baseURL: "ftp://api.honeycomb.io", dataset: "prod", wantErr: true},
+		{name: "query in path", path: "/1/events?x=1", dataset: "prod", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BuildEventsURL(tt.baseURL, tt.path, tt.dataset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildEventsURL() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("BuildEventsURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerDatasetInHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		inHeader   bool
+		dataset    string
+		data       string
+		wantPath   string
+		wantHeader string
+	}{
+		{name: "path mode event", dataset: "my dataset", data: `{"a":1}`, wantPath: "/1/events/my%20dataset"},
+		{name: "path mode batch", dataset: "my dataset", data: `[{"a":1}]`, wantPath: "/1/batch/my%20dataset"},
+		{name: "header mode event", inHeader: true, dataset: "my dataset", data: `{"a":1}`, wantPath: "/1/events",
+			wantHeader: "my dataset"},
+		{name: "header mode batch", inHeader: true, dataset: "équipe", data: `[{"a":1}]`, wantPath: "/1/batch",
+			wantHeader: "équipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.APIPath = defaultAPIPath
+			cfg.Dataset = tt.dataset
+			cfg.DatasetInHeader = tt.inHeader
+
+			if err := handleData(t, cfg, tt.data); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			request := honeycomb.received()[0]
+			if request.Path != tt.wantPath {
+				t.Errorf("path = %s, want %s", request.Path, tt.wantPath)
+			}
+			if got := request.Header.Get(datasetHeader); got != tt.wantHeader {
+				t.Errorf("%s header = %q, want %q", datasetHeader, got, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestSendEndpointInvalidDatasetInHeader(t *testing.T) {
+	cfg := Config{APIURL: "https://api.honeycomb.io", APIPath: defaultAPIPath, DatasetInHeader: true}
+	headers := http.Header{}
+	if _, err := sendEndpoint(cfg, "line\nbreak", false, headers); !errors.Is(err, ErrPermanent) {
+		t.Errorf("sendEndpoint() error = %v, want a permanent error", err)
+	}
+	if got := headers.Get(datasetHeader); got != "" {
+		t.Errorf("%s header = %q, want it unset for an invalid dataset", datasetHeader, got)
+	}
+}
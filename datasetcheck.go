@@ -0,0 +1,128 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const datasetCheckTimeout = 10 * time.Second
+
+var datasetSlugSeparators = regexp.MustCompile(`[^a-z0-9_~.-]+`)
+
+// datasetSlug approximates the slug Honeycomb derives from a dataset name to address it in the
+// datasets API: lower cased, with runs of other characters replaced by a dash.
+func datasetSlug(dataset string) string {
+	return strings.Trim(datasetSlugSeparators.ReplaceAllString(strings.ToLower(dataset), "-"), "-")
+}
+
+// configuredDatasets returns the datasets known from the configuration: the default one, the fan out
+// ones and the ones of the routing rules. Datasets from PubSub attributes are only known at runtime.
+func configuredDatasets(cfg Config) []string {
+	seen := map[string]bool{}
+	var datasets []string
+	add := func(dataset string) {
+		if dataset != "" && !seen[dataset] {
+			seen[dataset] = true
+			datasets = append(datasets, dataset)
+		}
+	}
+	add(cfg.Dataset)
+	for _, dataset := range cfg.FanOutDatasets {
+		add(dataset)
+	}
+	for _, rule := range cfg.RoutingRules {
+		add(rule.Dataset)
+	}
+	return datasets
+}
+
+// checkDatasets checks the configured datasets exist in Honeycomb, and creates the missing ones when
+// cfg.CreateDataset is set. The outcome is only logged: a failed check doesn't prevent the events from
+// being sent, Honeycomb may still create the dataset on the first event.
+// See the documentation for more details:
+// https://docs.honeycomb.io/api/tag/Datasets
+func checkDatasets(ctx context.Context, cfg Config) {
+	ctx, cancel := context.WithTimeout(ctx, datasetCheckTimeout)
+	defer cancel()
+	for _, dataset := range configuredDatasets(cfg) {
+		key := cfg.ManagementKey
+		if key == "" {
+			key = apiKeyFor(cfg, dataset, nil)
+		}
+		exists, err := datasetExists(ctx, cfg, key, dataset)
+		switch {
+		case err != nil:
+			cfg.Logger.Warn("error checking the Honeycomb dataset exists", "dataset", dataset, "error", err.Error())
+		case exists:
+			cfg.Logger.Info("Honeycomb dataset exists", "dataset", dataset)
+		case !cfg.CreateDataset:
+			cfg.Logger.Warn("Honeycomb dataset doesn't exist, events may be rejected until it is created "+
+				"(set HONEYCOMB_CREATE_DATASET to create it at startup)", "dataset", dataset)
+		default:
+			if err := createDataset(ctx, cfg, dataset); err != nil {
+				cfg.Logger.Error("error creating the Honeycomb dataset", "dataset", dataset, "error", err.Error())
+				continue
+			}
+			cfg.Logger.Info("Honeycomb dataset created", "dataset", dataset)
+		}
+	}
+}
+
+// datasetExists reports whether the dataset exists, Honeycomb answers 404 for an unknown one.
+func datasetExists(ctx context.Context, cfg Config, key string, dataset string) (bool, error) {
+	status, body, err := callDatasetsAPI(ctx, cfg, key, "GET", datasetEndpoint(cfg.APIURL, "datasets", datasetSlug(dataset)), nil)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case status == http.StatusNotFound:
+		return false, nil
+	case status < 200 || status > 299:
+		return false, &honeycombStatusError{StatusCode: status, Dataset: dataset, Body: body}
+	}
+	return true, nil
+}
+
+// createDataset creates the dataset with the management key, which needs the "Create Datasets"
+// permission.
+func createDataset(ctx context.Context, cfg Config, dataset string) error {
+	payload, err := json.Marshal(map[string]string{"name": dataset})
+	if err != nil {
+		return fmt.Errorf("error encoding honeycomb dataset %w", err)
+	}
+	status, body, err := callDatasetsAPI(ctx, cfg, cfg.ManagementKey, "POST", cfg.APIURL+"/1/datasets", payload)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return &honeycombStatusError{StatusCode: status, Dataset: dataset, Body: body}
+	}
+	return nil
+}
+
+// callDatasetsAPI sends a single request to the Honeycomb datasets API and returns the response status
+// and a summary of its body, for the errors.
+func callDatasetsAPI(ctx context.Context, cfg Config, key string, method string, endpoint string,
+	payload []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", fmt.Errorf("error initializing honeycomb datasets request %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", cfg.UserAgent)
+	req.Header.Set(cfg.AuthHeader, key)
+	resp, err := cfg.HTTPClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("error sending honeycomb datasets request %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, summarizeBody(resp.Header.Get("Content-Type"), body), nil
+}
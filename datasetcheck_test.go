@@ -0,0 +1,96 @@
+package HoneycombSinkHandler
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDatasetSlug(t *testing.T) {
+	tests := map[string]string{
+		"payments":         "payments",
+		"My Service Logs":  "my-service-logs",
+		"  prod / api!! ":  "prod-api",
+		"team_a.v2~canary": "team_a.v2~canary",
+	}
+	for dataset, want := range tests {
+		if got := datasetSlug(dataset); got != want {
+			t.Errorf("datasetSlug(%q) = %q, want %q", dataset, got, want)
+		}
+	}
+}
+
+func TestConfiguredDatasets(t *testing.T) {
+	cfg := Config{Dataset: "default", FanOutDatasets: []string{"audit", "default"},
+		RoutingRules: []RoutingRule{{Dataset: "errors"}, {Dataset: "audit"}}}
+	want := []string{"default", "audit", "errors"}
+	if got := configuredDatasets(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("configuredDatasets() = %v, want %v", got, want)
+	}
+}
+
+func TestCheckDatasets(t *testing.T) {
+	tests := []struct {
+		name       string
+		create     bool
+		exists     bool
+		wantCreate bool
+		wantLog    string
+	}{
+		{name: "exists", exists: true, wantLog: "Honeycomb dataset exists"},
+		{name: "missing", wantLog: "Honeycomb dataset doesn't exist, events may be rejected until it is created " +
+			"(set HONEYCOMB_CREATE_DATASET to create it at startup)"},
+		{name: "created", create: true, wantCreate: true, wantLog: "Honeycomb dataset created"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+				switch {
+				case r.Method == http.MethodPost:
+					respondWith(http.StatusCreated, `{"name":"My Logs"}`)(w, r, body)
+				case tt.exists:
+					respondWith(http.StatusOK, `{"name":"My Logs"}`)(w, r, body)
+				default:
+					respondWith(http.StatusNotFound, `{"error":"dataset not found"}`)(w, r, body)
+				}
+			})
+			var logs logRecorder
+			cfg := testConfig(honeycomb)
+			cfg.Dataset = "My Logs"
+			cfg.CreateDataset = tt.create
+			cfg.ManagementKey = "management-key"
+			cfg.Logger = newRecordingLogger(&logs)
+
+			checkDatasets(quietContext(), withDefaults(cfg))
+			requests := honeycomb.received()
+			if requests[0].Method != http.MethodGet || requests[0].Path != "/1/datasets/my-logs" ||
+				requests[0].Header.Get("X-Honeycomb-Team") != "management-key" {
+				t.Errorf("request = %+v, want the dataset read with the management key", requests[0])
+			}
+			if created := len(requests) == 2 && requests[1].Method == http.MethodPost &&
+				string(requests[1].Body) == `{"name":"My Logs"}`; created != tt.wantCreate {
+				t.Errorf("requests = %+v, want the dataset created: %v", requests, tt.wantCreate)
+			}
+			if records := logs.records(t, tt.wantLog); len(records) != 1 || records[0]["dataset"] != "My Logs" {
+				t.Errorf("log records = %v, want %q", records, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestCheckDatasetsFailure(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, respondWith(http.StatusUnauthorized, `{"error":"unknown API key"}`))
+	var logs logRecorder
+	cfg := testConfig(honeycomb)
+	cfg.CreateDataset = true
+	cfg.Logger = newRecordingLogger(&logs)
+
+	// A failed check is only logged, nothing is created
+	checkDatasets(quietContext(), withDefaults(cfg))
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("%d requests, want only the check", n)
+	}
+	if records := logs.records(t, "error checking the Honeycomb dataset exists"); len(records) != 1 {
+		t.Errorf("log records = %v, want the failed check logged", records)
+	}
+}
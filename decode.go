@@ -0,0 +1,96 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// decodeDoubleBase64 undoes an application-level base64 encoding of the payload, on top of the PubSub
+// one already decoded by the CloudEvents SDK. The decoded bytes are only used when they are valid JSON,
+// so plain payloads (including ones that happen to be valid base64) are left untouched.
+func decodeDoubleBase64(data []byte) []byte {
+	candidate := bytes.TrimSpace(data)
+	// The encoded payload may have been published as a JSON string
+	var quoted string
+	if len(candidate) > 0 && candidate[0] == '"' && json.Unmarshal(candidate, &quoted) == nil {
+		candidate = []byte(quoted)
+	}
+	if len(candidate) == 0 {
+		return data
+	}
+
+	for _, encoding := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		decoded := make([]byte, encoding.DecodedLen(len(candidate)))
+		n, err := encoding.Decode(decoded, candidate)
+		if err != nil {
+			continue
+		}
+		decoded = bytes.TrimSpace(decoded[:n])
+		if len(decoded) > 0 && (decoded[0] == '{' || decoded[0] == '[') && json.Valid(decoded) {
+			return decoded
+		}
+	}
+	return data
+}
+
+// decodePubSubLoose decodes a PubSub envelope field by field, for the envelopes the strict decoding
+// rejected: malformed or unexpected fields (e.g: a numeric messageId, a garbled publishTime) are logged
+// and left empty rather than failing the whole message. Only a missing or undecodable message data fails.
+func decodePubSubLoose(ctx context.Context, data []byte) (MessagePublishedData, error) {
+	var msg MessagePublishedData
+	var envelope struct {
+		Message      map[string]json.RawMessage `json:"message"`
+		Subscription json.RawMessage            `json:"subscription"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return msg, fmt.Errorf("error decoding the PubSub envelope %w", err)
+	}
+	raw, ok := looseField(envelope.Message, "data")
+	if !ok {
+		return msg, errors.New("error the PubSub envelope has no message data")
+	}
+	if err := json.Unmarshal(raw, &msg.Message.Data); err != nil {
+		return msg, fmt.Errorf("error decoding the PubSub message data %w", err)
+	}
+
+	var malformed []string
+	decodeField := func(raw json.RawMessage, ok bool, name string, target any) {
+		if ok && json.Unmarshal(raw, target) != nil {
+			malformed = append(malformed, name)
+		}
+	}
+	raw, ok = looseField(envelope.Message, "messageId", "message_id")
+	decodeField(raw, ok, "messageId", &msg.Message.MessageID)
+	raw, ok = looseField(envelope.Message, "attributes")
+	decodeField(raw, ok, "attributes", &msg.Message.Attributes)
+	var publishTime time.Time
+	raw, ok = looseField(envelope.Message, "publishTime", "publish_time")
+	decodeField(raw, ok, "publishTime", &publishTime)
+	msg.Message.PublishTime = publishTime
+	raw, ok = looseField(envelope.Message, "orderingKey", "ordering_key")
+	decodeField(raw, ok, "orderingKey", &msg.Message.OrderingKey)
+	decodeField(envelope.Subscription, len(envelope.Subscription) > 0, "subscription", &msg.Subscription)
+
+	if len(malformed) > 0 {
+		loggerFrom(ctx).Warn("malformed PubSub envelope fields ignored",
+			"message_id", msg.Message.MessageID, "fields", malformed)
+	}
+	return msg, nil
+}
+
+// looseField returns the first of the named fields present in the object, null fields being absent.
+func looseField(object map[string]json.RawMessage, names ...string) (json.RawMessage, bool) {
+	for _, name := range names {
+		if raw, ok := object[name]; ok && !bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			return raw, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,206 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+func TestDecodeDoubleBase64(t *testing.T) {
+	const object = `{"user":"alice","count":2}`
+	encoded := base64.StdEncoding.EncodeToString([]byte(object))
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "single-encoded JSON object", data: object, want: object},
+		{name: "single-encoded JSON array", data: `[1,2]`, want: `[1,2]`},
+		{name: "double-encoded JSON object", data: encoded, want: object},
+		{name: "double-encoded JSON array", data: base64.StdEncoding.EncodeToString([]byte(`[{"a":1}]`)),
+			want: `[{"a":1}]`},
+		{name: "double-encoded as a JSON string", data: `"` + encoded + `"`, want: object},
+		{name: "double-encoded without padding", data: base64.RawStdEncoding.EncodeToString([]byte(object)),
+			want: object},
+		{name: "double-encoded URL alphabet", data: base64.URLEncoding.EncodeToString([]byte(`{"q":"a?b>c"}`)),
+			want: `{"q":"a?b>c"}`},
+		{name: "surrounding whitespace", data: "  " + encoded + "\n", want: object},
+		{name: "plain string", data: "hello world", want: "hello world"},
+		{name: "plain string which is valid base64", data: "dGVzdA==", want: "dGVzdA=="},
+		{name: "base64 of a JSON scalar", data: base64.StdEncoding.EncodeToString([]byte(`42`)),
+			want: base64.StdEncoding.EncodeToString([]byte(`42`))},
+		{name: "JSON string", data: `"hello"`, want: `"hello"`},
+		{name: "empty", data: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeDoubleBase64([]byte(tt.data)); string(got) != tt.want {
+				t.Errorf("decodeDoubleBase64(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerDecodeDoubleBase64(t *testing.T) {
+	const object = `{"user":"alice"}`
+	encoded := base64.StdEncoding.EncodeToString([]byte(object))
+	tests := []struct {
+		name    string
+		enabled bool
+		want    string
+	}{
+		{name: "enabled", enabled: true, want: object},
+		{name: "disabled", want: `{"raw_message":"` + encoded + `"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.DecodeDoubleBase64 = tt.enabled
+			cfg.WrapInvalidJSON = true
+
+			if err := handleData(t, cfg, encoded); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if got := string(honeycomb.received()[0].Body); got != tt.want {
+				t.Errorf("Honeycomb received %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePubSubLoose(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString([]byte(`{"a":1}`))
+	tests := []struct {
+		name             string
+		envelope         string
+		wantErr          bool
+		wantMessageID    string
+		wantSubscription string
+		wantPublishTime  time.Time
+		wantAttributes   map[string]string
+	}{
+		{
+			name: "numeric messageId",
+			envelope: `{"message":{"data":"` + data + `","messageId":123,"publishTime":"2024-05-06T07:08:09Z"},` +
+				`"subscription":"projects/p/subscriptions/s"}`,
+			wantSubscription: "projects/p/subscriptions/s",
+			wantPublishTime:  testPublishTime,
+		},
+		{
+			name: "garbled publishTime and attributes",
+			envelope: `{"message":{"data":"` + data + `","message_id":"m-1","publishTime":"yesterday",` +
+				`"attributes":["not","a","map"]},"subscription":"projects/p/subscriptions/s"}`,
+			wantMessageID:    "m-1",
+			wantSubscription: "projects/p/subscriptions/s",
+		},
+		{
+			name: "unknown and null fields",
+			envelope: `{"message":{"data":"` + data + `","messageId":"m-1","attributes":{"k":"v"},` +
+				`"orderingKey":null,"extra":{"deep":[1,2]}},"subscription":42,"deliveryAttempt":"x"}`,
+			wantMessageID:  "m-1",
+			wantAttributes: map[string]string{"k": "v"},
+		},
+		{
+			name:     "missing data",
+			envelope: `{"message":{"messageId":"m-1"}}`,
+			wantErr:  true,
+		},
+		{
+			name:     "null data",
+			envelope: `{"message":{"data":null}}`,
+			wantErr:  true,
+		},
+		{
+			name:     "data isn't base64",
+			envelope: `{"message":{"data":"%%%"}}`,
+			wantErr:  true,
+		},
+		{
+			name:     "envelope isn't JSON",
+			envelope: `{"message":`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := decodePubSubLoose(quietContext(), []byte(tt.envelope))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodePubSubLoose() = %+v, want an error", msg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodePubSubLoose() error = %v", err)
+			}
+			if string(msg.Message.Data) != `{"a":1}` {
+				t.Errorf("data = %s, want {\"a\":1}", msg.Message.Data)
+			}
+			if msg.Message.MessageID != tt.wantMessageID {
+				t.Errorf("message ID = %q, want %q", msg.Message.MessageID, tt.wantMessageID)
+			}
+			if msg.Subscription != tt.wantSubscription {
+				t.Errorf("subscription = %q, want %q", msg.Subscription, tt.wantSubscription)
+			}
+			if !msg.Message.PublishTime.Equal(tt.wantPublishTime) {
+				t.Errorf("publish time = %v, want %v", msg.Message.PublishTime, tt.wantPublishTime)
+			}
+			if !reflect.DeepEqual(msg.Message.Attributes, tt.wantAttributes) {
+				t.Errorf("attributes = %v, want %v", msg.Message.Attributes, tt.wantAttributes)
+			}
+		})
+	}
+}
+
+func TestHandlerMalformedEnvelope(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString([]byte(`{"a":1}`))
+	tests := []struct {
+		name          string
+		envelope      string
+		wantPermanent bool
+	}{
+		{
+			name: "garbled sibling fields",
+			envelope: `{"message":{"data":"` + data + `","messageId":123,"publishTime":false,"attributes":"x"},` +
+				`"subscription":["s"]}`,
+		},
+		{
+			name:          "no data",
+			envelope:      `{"message":{"messageId":123}}`,
+			wantPermanent: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			e := event.New()
+			e.SetID("ce-id")
+			e.SetType(pubSubEventType)
+			e.SetSource("//pubsub.googleapis.com/projects/my-project/topics/my-topic")
+			if err := e.SetData(event.ApplicationJSON, []byte(tt.envelope)); err != nil {
+				t.Fatal(err)
+			}
+
+			handler, _ := NewHandler(testConfig(honeycomb))
+			err := handler(context.Background(), e)
+			if tt.wantPermanent {
+				if !errors.Is(err, ErrPermanent) {
+					t.Fatalf("handler error = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handler error = %v, want the message forwarded", err)
+			}
+			if got := honeycomb.lastEvent(t)["a"]; got != 1.0 {
+				t.Errorf("forwarded a = %v, want 1", got)
+			}
+		})
+	}
+}
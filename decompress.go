@@ -0,0 +1,56 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// contentEncodingAttribute is the PubSub attribute producers set when they compress the data.
+const contentEncodingAttribute = "content-encoding"
+
+// maxDecompressedBytes bounds the size of a decompressed payload, so a compression bomb can't exhaust
+// the instance memory.
+const maxDecompressedBytes = 10 * 1024 * 1024
+
+// decompressPayload decompresses the PubSub data according to its content-encoding attribute: gzip
+// or zstd. Data without the attribute, or with the identity encoding, is returned as is.
+func decompressPayload(msg MessagePublishedData) ([]byte, error) {
+	data := msg.Message.Data
+	encoding := strings.ToLower(strings.TrimSpace(msg.Message.Attributes[contentEncodingAttribute]))
+	var reader io.Reader
+	switch encoding {
+	case "", "identity":
+		return data, nil
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, withCategory(fmt.Errorf("error decompressing gzip payload %w", err), ErrPermanent)
+		}
+		defer zr.Close()
+		reader = zr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, withCategory(fmt.Errorf("error decompressing zstd payload %w", err), ErrPermanent)
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return nil, withCategory(fmt.Errorf("error unsupported content-encoding attribute %q", encoding), ErrPermanent)
+	}
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxDecompressedBytes+1))
+	if err != nil {
+		return nil, withCategory(fmt.Errorf("error decompressing %s payload %w", encoding, err), ErrPermanent)
+	}
+	if len(decompressed) > maxDecompressedBytes {
+		return nil, withCategory(fmt.Errorf("error decompressed %s payload is over %d bytes", encoding,
+			maxDecompressedBytes), ErrPermanent)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,114 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zw.Close()
+	return zw.EncodeAll(data, nil)
+}
+
+func TestDecompressPayload(t *testing.T) {
+	const object = `{"level":"info","message":"compressed"}`
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+		want     string
+		wantErr  bool
+	}{
+		{name: "plain", data: []byte(object), want: object},
+		{name: "identity", data: []byte(object), encoding: "identity", want: object},
+		{name: "gzip", data: gzipData(t, []byte(object)), encoding: "gzip", want: object},
+		{name: "gzip in uppercase", data: gzipData(t, []byte(object)), encoding: " GZIP ", want: object},
+		{name: "zstd", data: zstdData(t, []byte(object)), encoding: "zstd", want: object},
+		{name: "corrupted gzip", data: []byte(object), encoding: "gzip", wantErr: true},
+		{name: "truncated gzip", data: gzipData(t, []byte(object))[:20], encoding: "gzip", wantErr: true},
+		{name: "corrupted zstd", data: []byte(object), encoding: "zstd", wantErr: true},
+		{name: "unsupported encoding", data: []byte(object), encoding: "br", wantErr: true},
+		{name: "compression bomb", data: gzipData(t, make([]byte, maxDecompressedBytes+1)), encoding: "gzip",
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := MessagePublishedData{Message: PubSubMessage{Data: tt.data}}
+			if tt.encoding != "" {
+				msg.Message.Attributes = map[string]string{contentEncodingAttribute: tt.encoding}
+			}
+			got, err := decompressPayload(msg)
+			if tt.wantErr {
+				if !errors.Is(err, ErrPermanent) {
+					t.Errorf("decompressPayload() error = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("decompressPayload() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerCompressedPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+		wantErr  bool
+	}{
+		{name: "gzip JSON", data: gzipData(t, []byte(`{"a":1}`)), encoding: "gzip"},
+		{name: "zstd JSON", data: zstdData(t, []byte(`{"a":1}`)), encoding: "zstd"},
+		{name: "plain JSON", data: []byte(`{"a":1}`)},
+		{name: "gzip of invalid JSON", data: gzipData(t, []byte(`not json`)), encoding: "gzip", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			msg := PubSubMessage{Data: tt.data}
+			if tt.encoding != "" {
+				msg.Attributes = map[string]string{contentEncodingAttribute: tt.encoding}
+			}
+
+			handler, _ := NewHandler(testConfig(honeycomb))
+			err := handler(quietContext(), pubSubEvent(t, msg))
+			if tt.wantErr {
+				if !errors.Is(err, ErrPermanent) || len(honeycomb.received()) != 0 {
+					t.Errorf("handler error = %v with %d requests, want a permanent error and no request", err,
+						len(honeycomb.received()))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if got := string(honeycomb.received()[0].Body); !strings.Contains(got, `"a":1`) {
+				t.Errorf("Honeycomb received %s, want the decompressed JSON", got)
+			}
+		})
+	}
+}
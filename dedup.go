@@ -0,0 +1,201 @@
+package HoneycombSinkHandler
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupStore remembers the PubSub message IDs already forwarded to Honeycomb, so redeliveries of a
+// message aren't counted twice.
+type DedupStore interface {
+	// Contains reports whether the message ID was recorded less than a TTL ago.
+	Contains(ctx context.Context, id string) (bool, error)
+	// Add records the message ID for ttl.
+	Add(ctx context.Context, id string, ttl time.Duration) error
+}
+
+// memoryDedupStore is an in-memory LRU of message IDs. It only deduplicates the messages handled by
+// the same instance.
+type memoryDedupStore struct {
+	mu         sync.Mutex
+	clock      Clock
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // front is the most recently added
+}
+
+type memoryDedupEntry struct {
+	id      string
+	expires time.Time
+}
+
+// NewMemoryDedupStore returns an in-memory DedupStore holding up to maxEntries message IDs, evicting
+// the least recently added ones. The TTLs are measured with clock, the wall clock when nil.
+func NewMemoryDedupStore(maxEntries int, clock Clock) DedupStore {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &memoryDedupStore{
+		clock:      clock,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (s *memoryDedupStore) Contains(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	if s.clock.Now().After(elem.Value.(*memoryDedupEntry).expires) {
+		s.order.Remove(elem)
+		delete(s.entries, id)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *memoryDedupStore) Add(_ context.Context, id string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[id]; ok {
+		elem.Value.(*memoryDedupEntry).expires = s.clock.Now().Add(ttl)
+		s.order.MoveToFront(elem)
+		return nil
+	}
+	s.entries[id] = s.order.PushFront(&memoryDedupEntry{id: id, expires: s.clock.Now().Add(ttl)})
+	for s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryDedupEntry).id)
+	}
+	return nil
+}
+
+// redisDedupStore keeps the message IDs in Redis (e.g: Memorystore), so they are shared by all the
+// instances. It speaks just enough of the RESP protocol for EXISTS and SET.
+type redisDedupStore struct {
+	addr string
+	mu   sync.Mutex
+	idle []*redisConn // connections ready for the next command, the mutex only guards the checkout
+}
+
+// redisConn is a connection to Redis, used by one command at a time.
+type redisConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+const (
+	redisKeyPrefix = "gcp-sink-to-honeycomb:dedup:"
+	// redisMaxIdleConns bounds the connections kept open between the commands.
+	redisMaxIdleConns = 8
+)
+
+// NewRedisDedupStore returns a DedupStore backed by the Redis server at addr (host:port).
+func NewRedisDedupStore(addr string) DedupStore {
+	return &redisDedupStore{addr: addr}
+}
+
+func (s *redisDedupStore) Contains(ctx context.Context, id string) (bool, error) {
+	reply, err := s.do(ctx, "EXISTS", redisKeyPrefix+id)
+	if err != nil {
+		return false, err
+	}
+	return reply == "1", nil
+}
+
+func (s *redisDedupStore) Add(ctx context.Context, id string, ttl time.Duration) error {
+	_, err := s.do(ctx, "SET", redisKeyPrefix+id, "1", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// do sends a command on an idle connection, or a new one, and returns its integer or simple string
+// reply. The connection is dropped on any error.
+func (s *redisDedupStore) do(ctx context.Context, args ...string) (string, error) {
+	c, err := s.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+	} else {
+		_ = c.conn.SetDeadline(time.Time{})
+	}
+
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		c.conn.Close()
+		return "", err
+	}
+	s.put(c)
+	return reply, nil
+}
+
+// get checks out an idle connection, or dials a new one.
+func (s *redisDedupStore) get(ctx context.Context) (*redisConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to redis %s %w", s.addr, err)
+	}
+	return &redisConn{conn: conn, rd: bufio.NewReader(conn)}, nil
+}
+
+// put returns a connection to the idle ones, or closes it when there are enough.
+func (s *redisDedupStore) put(c *redisConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= redisMaxIdleConns {
+		c.conn.Close()
+		return
+	}
+	s.idle = append(s.idle, c)
+}
+
+func (c *redisConn) roundTrip(args []string) (string, error) {
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(cmd.String())); err != nil {
+		return "", fmt.Errorf("error sending redis command %s %w", args[0], err)
+	}
+
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("error reading redis reply to %s %w", args[0], err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("error empty redis reply to %s", args[0])
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("error redis replied to %s: %s", args[0], line[1:])
+	default:
+		return "", fmt.Errorf("error unexpected redis reply to %s: %q", args[0], line)
+	}
+}
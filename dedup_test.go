@@ -0,0 +1,308 @@
+package HoneycombSinkHandler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock(testPublishTime)
+	store := NewMemoryDedupStore(10, clock)
+
+	if err := store.Add(ctx, "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		advance time.Duration
+		want    bool
+	}{
+		{advance: 0, want: true},
+		{advance: 59 * time.Second, want: true},
+		{advance: time.Second, want: true}, // expires after the TTL, not at it
+		{advance: time.Millisecond, want: false},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		if got, err := store.Contains(ctx, "a"); err != nil || got != step.want {
+			t.Errorf("Contains() %v after the Add = %v, %v, want %v", since(clock, testPublishTime), got, err, step.want)
+		}
+	}
+
+	// A redelivered message is recorded again, for a new TTL
+	if err := store.Add(ctx, "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Second)
+	if err := store.Add(ctx, "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(45 * time.Second)
+	if got, _ := store.Contains(ctx, "a"); !got {
+		t.Error("Contains() = false within the TTL of the last Add")
+	}
+}
+
+func TestMemoryDedupStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(3, newFakeClock(testPublishTime))
+	for _, id := range []string{"a", "b", "c"} {
+		if err := store.Add(ctx, id, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Adding "a" again makes it the most recent, "b" is the least recently added
+	if err := store.Add(ctx, "a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(ctx, "d", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if got, _ := store.Contains(ctx, id); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", id, got, want)
+		}
+	}
+	if n := len(store.(*memoryDedupStore).entries); n != 3 {
+		t.Errorf("store holds %d entries, want 3", n)
+	}
+}
+
+// fakeRedis is a TCP server speaking enough RESP for the dedup store: it records the commands, answers
+// SET with +OK and EXISTS with whether the key was set. Commands of the failing key get an error reply.
+type fakeRedis struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	commands [][]string
+	keys     map[string]bool
+	conns    int
+}
+
+const fakeRedisFailingKey = redisKeyPrefix + "failing"
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{listener: listener, keys: map[string]bool{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			r.conns++
+			r.mu.Unlock()
+			go r.serve(t, conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readRESPArray(rd)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Errorf("fake redis: invalid command: %v", err)
+			return
+		}
+		r.mu.Lock()
+		r.commands = append(r.commands, args)
+		var reply string
+		switch {
+		case len(args) > 1 && args[1] == fakeRedisFailingKey:
+			reply = "-ERR test failure\r\n"
+		case args[0] == "SET":
+			r.keys[args[1]] = true
+			reply = "+OK\r\n"
+		case args[0] == "EXISTS" && r.keys[args[1]]:
+			reply = ":1\r\n"
+		case args[0] == "EXISTS":
+			reply = ":0\r\n"
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		r.mu.Unlock()
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readRESPArray reads a command, an array of bulk strings, checking its encoding.
+func readRESPArray(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("array header %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	if err != nil {
+		return nil, fmt.Errorf("array header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(header, "$") || !strings.HasSuffix(header, "\r\n") {
+			return nil, fmt.Errorf("bulk string header %q", header)
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(header[1:], "\r\n"))
+		if err != nil {
+			return nil, fmt.Errorf("bulk string header %q", header)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		if string(data[size:]) != "\r\n" {
+			return nil, fmt.Errorf("bulk string %q isn't terminated by CRLF", data)
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func (r *fakeRedis) received() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string(nil), r.commands...)
+}
+
+func TestRedisDedupStore(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis(t)
+	store := NewRedisDedupStore(redis.listener.Addr().String())
+
+	if seen, err := store.Contains(ctx, "message-1"); err != nil || seen {
+		t.Fatalf("Contains() before Add = %v, %v, want false", seen, err)
+	}
+	if err := store.Add(ctx, "message-1", 90*time.Second); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if seen, err := store.Contains(ctx, "message-1"); err != nil || !seen {
+		t.Fatalf("Contains() after Add = %v, %v, want true", seen, err)
+	}
+	// An id with spaces and CRLF is sent as a single bulk string
+	if err := store.Add(ctx, "id with\r\nCRLF", time.Second); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := store.Contains(ctx, "failing"); err == nil || !strings.Contains(err.Error(), "test failure") {
+		t.Errorf("Contains() of an error reply = %v, want the redis error", err)
+	}
+	// The connection is dropped on the error, the next command dials again
+	if seen, err := store.Contains(ctx, "message-1"); err != nil || !seen {
+		t.Errorf("Contains() after an error = %v, %v, want true", seen, err)
+	}
+
+	want := [][]string{
+		{"EXISTS", redisKeyPrefix + "message-1"},
+		{"SET", redisKeyPrefix + "message-1", "1", "PX", "90000"},
+		{"EXISTS", redisKeyPrefix + "message-1"},
+		{"SET", redisKeyPrefix + "id with\r\nCRLF", "1", "PX", "1000"},
+		{"EXISTS", fakeRedisFailingKey},
+		{"EXISTS", redisKeyPrefix + "message-1"},
+	}
+	if got := redis.received(); !reflect.DeepEqual(got, want) {
+		t.Errorf("redis received %q, want %q", got, want)
+	}
+	redis.mu.Lock()
+	defer redis.mu.Unlock()
+	if redis.conns != 2 {
+		t.Errorf("store opened %d connections, want 2: one reused until the error, one after", redis.conns)
+	}
+}
+
+func TestRedisDedupStoreConcurrent(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis(t)
+	store := NewRedisDedupStore(redis.listener.Addr().String())
+
+	const messages = 50
+	var wg sync.WaitGroup
+	for i := 0; i < messages; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := "message-" + strconv.Itoa(i)
+			if err := store.Add(ctx, id, time.Minute); err != nil {
+				t.Errorf("Add(%s) error = %v", id, err)
+			}
+			if seen, err := store.Contains(ctx, id); err != nil || !seen {
+				t.Errorf("Contains(%s) = %v, %v, want true", id, seen, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := len(redis.received()); n != 2*messages {
+		t.Errorf("redis received %d commands, want %d", n, 2*messages)
+	}
+	if idle := len(store.(*redisDedupStore).idle); idle > redisMaxIdleConns {
+		t.Errorf("store keeps %d idle connections, want at most %d", idle, redisMaxIdleConns)
+	}
+}
+
+func TestRedisDedupStoreUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	store := NewRedisDedupStore(addr)
+	if _, err := store.Contains(context.Background(), "message-1"); err == nil {
+		t.Error("Contains() on an unreachable server returned no error")
+	}
+}
+
+func TestHandlerDedup(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	clock := newFakeClock(testPublishTime)
+	cfg := testConfig(honeycomb)
+	cfg.Clock = clock
+	cfg.Dedup = NewMemoryDedupStore(10, clock)
+	cfg.DedupTTL = time.Minute
+	handler, _ := NewHandler(cfg)
+
+	deliver := func() {
+		t.Helper()
+		if err := handler(context.Background(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})); err != nil {
+			t.Fatalf("handler error = %v", err)
+		}
+	}
+	deliver()
+	deliver() // redelivery within the TTL
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("Honeycomb received %d requests for a redelivered message, want 1", n)
+	}
+	clock.Advance(2 * time.Minute)
+	deliver()
+	if n := len(honeycomb.received()); n != 2 {
+		t.Errorf("Honeycomb received %d requests after the TTL, want 2", n)
+	}
+}
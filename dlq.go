@@ -0,0 +1,93 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+const pubSubAPIURL = "https://pubsub.googleapis.com/v1/"
+
+var topicPattern = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// maxAttributeValueBytes is the PubSub limit on the size of an attribute value: a longer one fails the
+// publish, and the message would be redelivered forever.
+const maxAttributeValueBytes = 1024
+
+// isPermanent reports whether the message failed for good (malformed, too large, a 4xx other than 429),
+// in which case redelivering it would fail again.
+func isPermanent(err error) bool {
+	return errors.Is(err, ErrPermanent)
+}
+
+// failedDataset returns the dataset a failed message was meant for: the one of the Honeycomb error, the
+// one it resolves to otherwise, or the configured dataset when it can't be resolved.
+func failedDataset(err error, msg MessagePublishedData, cfg Config) string {
+	var statusErr *honeycombStatusError
+	if errors.As(err, &statusErr) && statusErr.Dataset != "" {
+		return statusErr.Dataset
+	}
+	var batchErr *batchError
+	if errors.As(err, &batchErr) && batchErr.Dataset != "" {
+		return batchErr.Dataset
+	}
+	if dataset, resolveErr := resolveDataset(msg, cfg); resolveErr == nil {
+		return dataset
+	}
+	return cfg.Dataset
+}
+
+// publishToDLQ publishes the original PubSub message to the dead-letter topic, with its attributes and
+// the rejection reason.
+// See the documentation for more details:
+// https://cloud.google.com/pubsub/docs/reference/rest/v1/projects.topics/publish
+func publishToDLQ(ctx context.Context, cfg Config, msg MessagePublishedData, dataset string, cause error) error {
+	attributes := make(map[string]string, len(msg.Message.Attributes)+4)
+	for key, value := range msg.Message.Attributes {
+		attributes[key] = value
+	}
+	attributes["dlq-reason"] = truncateAttribute(cause.Error())
+	attributes["dlq-dataset"] = dataset
+	attributes["dlq-original-message-id"] = msg.Message.MessageID
+	var statusErr *honeycombStatusError
+	if errors.As(cause, &statusErr) {
+		attributes["dlq-status-code"] = strconv.Itoa(statusErr.StatusCode)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"messages": []map[string]any{{
+			// []byte is encoded in base64, as expected by the PubSub API
+			"data":       msg.Message.Data,
+			"attributes": attributes,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding dead-letter message %w", err)
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	endpoint := pubSubAPIURL + cfg.DLQTopic + ":publish"
+	if err := callGoogleAPI(reqCtx, cfg.ServicesHTTPClient, cfg.Clock, "POST", endpoint, bytes.NewReader(body), nil); err != nil {
+		return fmt.Errorf("error publishing to dead-letter topic %s %w", cfg.DLQTopic, err)
+	}
+	return nil
+}
+
+// truncateAttribute cuts value to the PubSub attribute size limit, on a character boundary, marking the
+// cut.
+func truncateAttribute(value string) string {
+	if len(value) <= maxAttributeValueBytes {
+		return value
+	}
+	keep := maxAttributeValueBytes - len("...")
+	for keep > 0 && !utf8.RuneStart(value[keep]) {
+		keep--
+	}
+	return value[:keep] + "..."
+}
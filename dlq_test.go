@@ -0,0 +1,131 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// fakePubSub is an httptest server standing for the metadata server and the PubSub API, recording the
+// published messages.
+type fakePubSub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	messages []map[string]any
+}
+
+func newFakePubSub(t *testing.T) *fakePubSub {
+	t.Helper()
+	f := &fakePubSub{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/computeMetadata/") {
+			_, _ = w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+			return
+		}
+		var body struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("error decoding the publish request: %v", err)
+		}
+		f.mu.Lock()
+		f.messages = append(f.messages, body.Messages...)
+		f.mu.Unlock()
+		_, _ = w.Write([]byte(`{"messageIds":["1"]}`))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// client returns a client sending all the Google API requests to the fake.
+func (f *fakePubSub) client() *http.Client {
+	target, _ := url.Parse(f.URL)
+	return &http.Client{Transport: redirectAllTransport{target: target, base: http.DefaultTransport}}
+}
+
+func (f *fakePubSub) published() []map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]map[string]any(nil), f.messages...)
+}
+
+func TestTruncateAttribute(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "short", value: "error honeycomb returned status 400", want: "error honeycomb returned status 400"},
+		{name: "at the limit", value: strings.Repeat("a", maxAttributeValueBytes),
+			want: strings.Repeat("a", maxAttributeValueBytes)},
+		{name: "over the limit", value: strings.Repeat("a", 5000),
+			want: strings.Repeat("a", maxAttributeValueBytes-3) + "..."},
+		{name: "multi-byte characters", value: strings.Repeat("é", 1000),
+			want: strings.Repeat("é", (maxAttributeValueBytes-3)/2) + "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateAttribute(tt.value)
+			if got != tt.want || len(got) > maxAttributeValueBytes || !utf8.ValidString(got) {
+				t.Errorf("truncateAttribute() = %q (%d bytes), want %q", got, len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerDLQLongReason(t *testing.T) {
+	// Honeycomb rejects every event of a large batch, with a long reason
+	reason := strings.Repeat("événement invalide ", 100)
+	statuses := make([]string, 200)
+	events := make([]string, len(statuses))
+	for i := range statuses {
+		statuses[i] = `{"status":400,"error":"` + reason + `"}`
+		events[i] = `{"a":1}`
+	}
+	honeycomb := newFakeHoneycomb(t, respondWith(http.StatusOK, "["+strings.Join(statuses, ",")+"]"))
+	pubsub := newFakePubSub(t)
+	cfg := testConfig(honeycomb)
+	cfg.DLQTopic = "projects/my-project/topics/dead-letters"
+	cfg.ServicesHTTPClient = pubsub.client()
+	cfg.MaxResponseBytes = 1 << 20
+
+	err := handleData(t, cfg, "["+strings.Join(events, ",")+"]")
+	if err != nil {
+		t.Fatalf("handler error = %v, want the message ACKed once dead-lettered", err)
+	}
+	messages := pubsub.published()
+	if len(messages) != 1 {
+		t.Fatalf("%d messages dead-lettered, want 1", len(messages))
+	}
+	attributes, _ := messages[0]["attributes"].(map[string]any)
+	got, _ := attributes["dlq-reason"].(string)
+	if len(got) > maxAttributeValueBytes || !utf8.ValidString(got) ||
+		!strings.HasPrefix(got, "error honeycomb rejected 200/200 events") {
+		t.Errorf("dlq-reason = %q (%d bytes), want the truncated batch error", got, len(got))
+	}
+	if attributes["dlq-dataset"] != "test-dataset" || attributes["dlq-original-message-id"] != "message-1" {
+		t.Errorf("attributes = %v, want the dataset and the original message ID", attributes)
+	}
+}
+
+func TestHandlerDLQTransient(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, respondWith(http.StatusServiceUnavailable, `{"error":"unavailable"}`))
+	pubsub := newFakePubSub(t)
+	cfg := testConfig(honeycomb)
+	cfg.DLQTopic = "projects/my-project/topics/dead-letters"
+	cfg.ServicesHTTPClient = pubsub.client()
+
+	if err := handleData(t, cfg, `{"a":1}`); !errors.Is(err, ErrTransient) {
+		t.Errorf("handler error = %v, want the transient error, for a redelivery", err)
+	}
+	if n := len(pubsub.published()); n != 0 {
+		t.Errorf("%d messages dead-lettered, want none", n)
+	}
+}
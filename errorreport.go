@@ -0,0 +1,42 @@
+package HoneycombSinkHandler
+
+import (
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// reportedErrorEventType makes Error Reporting pick up a log entry.
+// See the documentation for more details:
+// https://cloud.google.com/error-reporting/docs/formatting-error-messages
+const reportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// reportError logs a failed send in the format Error Reporting recognizes: the message holds the error
+// and the stack trace, the service context tells the deployments apart and the dataset and the message
+// ID are attached as context.
+func reportError(logger *slog.Logger, err error, messageID string, dataset string) {
+	service := os.Getenv("K_SERVICE")
+	if service == "" {
+		service = serviceName
+	}
+	serviceContext := []any{slog.String("service", service)}
+	if revision := os.Getenv("K_REVISION"); revision != "" {
+		serviceContext = append(serviceContext, slog.String("version", revision))
+	}
+	location := []any{}
+	if pc, file, line, ok := runtime.Caller(1); ok {
+		location = append(location, slog.String("filePath", file), slog.Int("lineNumber", line))
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			location = append(location, slog.String("functionName", fn.Name()))
+		}
+	}
+
+	logger.Error(err.Error()+"\n\n"+string(debug.Stack()),
+		"@type", reportedErrorEventType,
+		slog.Group("serviceContext", serviceContext...),
+		slog.Group("context", slog.Group("reportLocation", location...)),
+		"message_id", messageID,
+		"dataset", dataset,
+	)
+}
@@ -0,0 +1,85 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// reportedErrors decodes the log records picked up by Error Reporting.
+func reportedErrors(t *testing.T, r *logRecorder) []map[string]any {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records []map[string]any
+	for _, line := range bytes.Split(r.buf.Bytes(), []byte("\n")) {
+		var record map[string]any
+		if len(line) > 0 && json.Unmarshal(line, &record) == nil && record["@type"] == reportedErrorEventType {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestReportError(t *testing.T) {
+	t.Setenv("K_SERVICE", "honeycomb-sink")
+	t.Setenv("K_REVISION", "honeycomb-sink-00042")
+	var logs logRecorder
+
+	reportError(newRecordingLogger(&logs), errors.New("error honeycomb returned status 400"), "message-1",
+		"test-dataset")
+	records := reportedErrors(t, &logs)
+	if len(records) != 1 {
+		t.Fatalf("%d reported errors, want 1", len(records))
+	}
+	record := records[0]
+	if message, _ := record[slog.MessageKey].(string); !strings.HasPrefix(message,
+		"error honeycomb returned status 400\n\ngoroutine ") {
+		t.Errorf("message = %q, want the error followed by the stack trace", message)
+	}
+	service, _ := record["serviceContext"].(map[string]any)
+	if service["service"] != "honeycomb-sink" || service["version"] != "honeycomb-sink-00042" {
+		t.Errorf("serviceContext = %v, want the Cloud Run service and revision", service)
+	}
+	location, _ := record["context"].(map[string]any)["reportLocation"].(map[string]any)
+	if function, _ := location["functionName"].(string); !strings.HasSuffix(function, "TestReportError") {
+		t.Errorf("reportLocation = %v, want the caller of reportError", location)
+	}
+	if record["message_id"] != "message-1" || record["dataset"] != "test-dataset" {
+		t.Errorf("record = %v, want the message ID and the dataset attached", record)
+	}
+}
+
+func TestHandlerErrorReporting(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		enabled      bool
+		wantReported int
+	}{
+		{name: "permanent failure", status: http.StatusBadRequest, enabled: true, wantReported: 1},
+		{name: "transient failure", status: http.StatusServiceUnavailable, enabled: true},
+		{name: "disabled", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, respondWith(tt.status, `{"error":"invalid event"}`))
+			var logs logRecorder
+			cfg := testConfig(honeycomb)
+			cfg.ErrorReporting = tt.enabled
+			cfg.Logger = newRecordingLogger(&logs)
+			handler, _ := NewHandler(cfg)
+
+			if err := handler(quietContext(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})); err == nil {
+				t.Fatal("handler error = nil, want the send failure")
+			}
+			if n := len(reportedErrors(t, &logs)); n != tt.wantReported {
+				t.Errorf("%d reported errors, want %d", n, tt.wantReported)
+			}
+		})
+	}
+}
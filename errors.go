@@ -0,0 +1,96 @@
+package HoneycombSinkHandler
+
+import "errors"
+
+// Failure categories of the errors returned by the handler and ConfigFromEnv, to be tested with
+// errors.Is: a wrapper can NACK (retry) transient and rate limited failures, and ACK or dead-letter
+// permanent ones.
+var (
+	// ErrConfig is returned by ConfigFromEnv for a missing or invalid setting.
+	ErrConfig = errors.New("configuration error")
+	// ErrTransient marks failures which may succeed on redelivery: network errors, timeouts, 5xx.
+	ErrTransient = errors.New("transient error")
+	// ErrPermanent marks failures which won't succeed on redelivery: malformed events, 4xx responses.
+	ErrPermanent = errors.New("permanent error")
+	// ErrRateLimited marks the 429 responses of Honeycomb. It is a transient failure too.
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// categorizedError tags an error with its failure category, keeping its message.
+type categorizedError struct {
+	err      error
+	category error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() []error {
+	return []error{e.err, e.category}
+}
+
+// joinedError is the failure of several operations, classified by the transient ones when there are
+// any: it only unwraps them, so errors.Is doesn't find the permanent failures.
+type joinedError struct {
+	err       error
+	transient []error
+}
+
+func (e *joinedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *joinedError) Unwrap() []error {
+	return e.transient
+}
+
+// joinErrors joins the errors like errors.Join. A failure mixing permanent and transient errors is
+// transient: the message is redelivered rather than dead-lettered, as the transient parts may succeed.
+func joinErrors(errs ...error) error {
+	err := errors.Join(errs...)
+	var transient []error
+	for _, e := range errs {
+		if errors.Is(e, ErrTransient) {
+			transient = append(transient, e)
+		}
+	}
+	if err == nil || len(transient) == 0 {
+		return err
+	}
+	return &joinedError{err: err, transient: transient}
+}
+
+// withCategory tags err with a failure category, nil stays nil.
+func withCategory(err error, category error) error {
+	if err == nil {
+		return nil
+	}
+	return &categorizedError{err: err, category: category}
+}
+
+// Is makes a status error match the category of its status code.
+func (e *honeycombStatusError) Is(target error) bool {
+	switch {
+	case e.StatusCode == 429:
+		return target == ErrRateLimited || target == ErrTransient
+	case e.StatusCode >= 500:
+		return target == ErrTransient
+	default:
+		return target == ErrPermanent
+	}
+}
+
+// Is makes the rejection of events of a batch a permanent failure, unless some events were rejected with
+// a 429 or a 5xx: redelivering the message may then get them accepted.
+func (e *batchError) Is(target error) bool {
+	if e.Transient > 0 {
+		return target == ErrTransient
+	}
+	return target == ErrPermanent
+}
+
+// Is makes an oversized event a permanent failure.
+func (e *errEventTooLarge) Is(target error) bool {
+	return target == ErrPermanent
+}
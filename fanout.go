@@ -0,0 +1,59 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// fanOutDatasets returns the datasets an event is sent to: its resolved dataset followed by the
+// fan-out datasets, without duplicates.
+func fanOutDatasets(dataset string, extra []string) []string {
+	datasets := []string{dataset}
+	for _, d := range extra {
+		if d != dataset {
+			datasets = append(datasets, d)
+		}
+	}
+	return datasets
+}
+
+// sendFanOut sends the event to each dataset concurrently. A failed dataset doesn't prevent the others
+// from getting the event: an error is only returned when every send failed, or any when requireAll is set.
+func sendFanOut(ctx context.Context, sink Sink, event Event, datasets []string, requireAll bool) error {
+	if len(datasets) == 1 {
+		event.Dataset = datasets[0]
+		return sink.Send(ctx, event)
+	}
+
+	errs := make([]error, len(datasets))
+	var wg sync.WaitGroup
+	for i, dataset := range datasets {
+		wg.Add(1)
+		go func(i int, dataset string) {
+			defer wg.Done()
+			e := event
+			e.Dataset = dataset
+			errs[i] = sink.Send(ctx, e)
+		}(i, dataset)
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("dataset %q: %w", datasets[i], err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	err := joinErrors(failed...)
+	if len(failed) == len(datasets) || requireAll {
+		return fmt.Errorf("error sending to %d/%d datasets %w", len(failed), len(datasets), err)
+	}
+	loggerFrom(ctx).Warn("event not sent to some datasets",
+		"message_id", event.PubSub.Message.MessageID, "failed", len(failed), "datasets", len(datasets),
+		"error", err.Error())
+	return nil
+}
@@ -0,0 +1,144 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFanOutDatasets(t *testing.T) {
+	got := fanOutDatasets("firehose", []string{"team-a", "firehose", "team-b"})
+	if want := []string{"firehose", "team-a", "team-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fanOutDatasets() = %v, want %v", got, want)
+	}
+}
+
+func TestHandlerFanOut(t *testing.T) {
+	tests := []struct {
+		name       string
+		failing    []string
+		requireAll bool
+		wantErr    bool
+	}{
+		{name: "all succeed"},
+		{name: "partial failure", failing: []string{"team-a"}},
+		{name: "partial failure with require all", failing: []string{"team-a"}, requireAll: true, wantErr: true},
+		{name: "all fail", failing: []string{"firehose", "team-a", "team-b"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+				for _, dataset := range tt.failing {
+					if strings.HasSuffix(r.URL.Path, "/"+dataset) {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+				}
+				acceptAll(w, r, body)
+			})
+			cfg := testConfig(honeycomb)
+			cfg.Dataset = "firehose"
+			cfg.FanOutDatasets = []string{"team-a", "team-b"}
+			cfg.FanOutRequireAll = tt.requireAll
+
+			err := handleData(t, cfg, `{"a":1}`)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handler error = %v, want error: %v", err, tt.wantErr)
+			}
+			for _, dataset := range tt.failing {
+				if tt.wantErr && !strings.Contains(err.Error(), dataset) {
+					t.Errorf("error %q doesn't name the failed dataset %s", err, dataset)
+				}
+			}
+			// Every dataset got the event, whatever the failures of the others
+			var paths []string
+			for _, r := range honeycomb.received() {
+				paths = append(paths, r.Path)
+				if string(r.Body) != `{"a":1}` {
+					t.Errorf("%s received %s, want the event", r.Path, r.Body)
+				}
+			}
+			sort.Strings(paths)
+			want := []string{"/1/events/firehose", "/1/events/team-a", "/1/events/team-b"}
+			if !reflect.DeepEqual(paths, want) {
+				t.Errorf("Honeycomb received %v, want %v", paths, want)
+			}
+		})
+	}
+}
+
+func TestHandlerFanOutMixedFailures(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      map[string]int
+		wantTransient bool
+	}{
+		{name: "permanent", statuses: map[string]int{"firehose": 400, "team-a": 403}},
+		{name: "permanent and transient", statuses: map[string]int{"firehose": 400, "team-a": 503},
+			wantTransient: true},
+		{name: "transient", statuses: map[string]int{"firehose": 503, "team-a": 429}, wantTransient: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+				dataset := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+				w.WriteHeader(tt.statuses[dataset])
+				_, _ = w.Write([]byte(`{"error":"failed"}`))
+			})
+			pubsub := newFakePubSub(t)
+			cfg := testConfig(honeycomb)
+			cfg.Dataset = "firehose"
+			cfg.FanOutDatasets = []string{"team-a"}
+			cfg.DLQTopic = "projects/my-project/topics/dead-letters"
+			cfg.ServicesHTTPClient = pubsub.client()
+
+			// A message which may still reach a dataset on redelivery isn't dead-lettered
+			err := handleData(t, cfg, `{"a":1}`)
+			dead := len(pubsub.published())
+			if tt.wantTransient {
+				if !errors.Is(err, ErrTransient) || errors.Is(err, ErrPermanent) || dead != 0 {
+					t.Errorf("handler error = %v with %d messages dead-lettered, want a transient error", err, dead)
+				}
+			} else if err != nil || dead != 1 {
+				t.Errorf("handler error = %v with %d messages dead-lettered, want it dead-lettered", err, dead)
+			}
+		})
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	permanent := withCategory(errors.New("error malformed"), ErrPermanent)
+	transient := withCategory(errors.New("error unavailable"), ErrTransient)
+	batch := &batchError{Dataset: "test-dataset", Rejected: 1, Total: 2, Indexes: []int{1}, Transient: 1}
+	tests := []struct {
+		name          string
+		errs          []error
+		wantTransient bool
+		wantPermanent bool
+	}{
+		{name: "none"},
+		{name: "permanent", errs: []error{permanent, permanent}, wantPermanent: true},
+		{name: "mixed", errs: []error{permanent, transient}, wantTransient: true},
+		{name: "transient batch rejection", errs: []error{permanent, batch}, wantTransient: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := joinErrors(tt.errs...)
+			if (err == nil) != (len(tt.errs) == 0) {
+				t.Fatalf("joinErrors() = %v", err)
+			}
+			if errors.Is(err, ErrTransient) != tt.wantTransient || errors.Is(err, ErrPermanent) != tt.wantPermanent {
+				t.Errorf("joinErrors() = %v, transient: %v, permanent: %v, want %v, %v", err,
+					errors.Is(err, ErrTransient), errors.Is(err, ErrPermanent), tt.wantTransient, tt.wantPermanent)
+			}
+			for _, e := range tt.errs {
+				if !strings.Contains(err.Error(), e.Error()) {
+					t.Errorf("joinErrors() = %q, want it to hold %q", err, e)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Operators of a forward condition.
+const (
+	forwardOpEqual        = "=="
+	forwardOpNotEqual     = "!="
+	forwardOpIn           = "in"
+	forwardOpNotIn        = "not in"
+	forwardOpGreater      = ">"
+	forwardOpGreaterEqual = ">="
+	forwardOpLess         = "<"
+	forwardOpLessEqual    = "<="
+)
+
+var forwardConditionPattern = regexp.MustCompile(`^\s*(\S+)\s+(==|!=|>=|<=|>|<|not\s+in|in)\s+(.+?)\s*$`)
+
+// ForwardCondition forwards only the events whose Field (a dotted JSON path, e.g: "log.level") matches
+// Values with Operator: "==", "!=", "in", "not in", or the numeric ">", ">=", "<" and "<=". An event
+// without the field doesn't match.
+type ForwardCondition struct {
+	Field    string
+	Operator string
+	Values   []string
+}
+
+// parseForwardCondition parses a condition written as "<field> <operator> <value>", e.g:
+// "level in [error,fatal]" or "status >= 500". Values may be quoted.
+func parseForwardCondition(value string) (*ForwardCondition, error) {
+	parts := forwardConditionPattern.FindStringSubmatch(value)
+	if parts == nil {
+		return nil, fmt.Errorf("error forward condition %q isn't written as <field> <operator> <value>", value)
+	}
+	cond := &ForwardCondition{Field: parts[1], Operator: strings.Join(strings.Fields(parts[2]), " ")}
+	operand := parts[3]
+	switch cond.Operator {
+	case forwardOpIn, forwardOpNotIn:
+		if !strings.HasPrefix(operand, "[") || !strings.HasSuffix(operand, "]") {
+			return nil, fmt.Errorf("error forward condition %q needs a [a,b] list after %q", value, cond.Operator)
+		}
+		for _, item := range strings.Split(operand[1:len(operand)-1], ",") {
+			if item = unquote(strings.TrimSpace(item)); item != "" {
+				cond.Values = append(cond.Values, item)
+			}
+		}
+		if len(cond.Values) == 0 {
+			return nil, fmt.Errorf("error forward condition %q has an empty list", value)
+		}
+	case forwardOpGreater, forwardOpGreaterEqual, forwardOpLess, forwardOpLessEqual:
+		if _, err := strconv.ParseFloat(operand, 64); err != nil {
+			return nil, fmt.Errorf("error forward condition %q needs a number after %q", value, cond.Operator)
+		}
+		cond.Values = []string{operand}
+	default:
+		cond.Values = []string{unquote(operand)}
+	}
+	return cond, nil
+}
+
+// unquote removes the double or single quotes around a value.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// String returns the condition as written in HONEYCOMB_FORWARD_IF.
+func (c *ForwardCondition) String() string {
+	if c.Operator == forwardOpIn || c.Operator == forwardOpNotIn {
+		return fmt.Sprintf("%s %s [%s]", c.Field, c.Operator, strings.Join(c.Values, ","))
+	}
+	return fmt.Sprintf("%s %s %s", c.Field, c.Operator, strings.Join(c.Values, ""))
+}
+
+// matches reports whether the JSON object event matches the condition.
+func (c *ForwardCondition) matches(fields map[string]any) bool {
+	value, ok := lookupPath(fields, c.Field)
+	if !ok || value == nil {
+		return false
+	}
+	switch c.Operator {
+	case forwardOpEqual:
+		return fmt.Sprint(value) == c.Values[0]
+	case forwardOpNotEqual:
+		return fmt.Sprint(value) != c.Values[0]
+	case forwardOpIn, forwardOpNotIn:
+		found := false
+		for _, want := range c.Values {
+			if fmt.Sprint(value) == want {
+				found = true
+				break
+			}
+		}
+		return found == (c.Operator == forwardOpIn)
+	}
+
+	// Numeric comparison, the field may hold a number or a numeric string
+	got, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+	if err != nil {
+		return false
+	}
+	want, _ := strconv.ParseFloat(c.Values[0], 64)
+	switch c.Operator {
+	case forwardOpGreater:
+		return got > want
+	case forwardOpGreaterEqual:
+		return got >= want
+	case forwardOpLess:
+		return got < want
+	default:
+		return got <= want
+	}
+}
+
+// filterPayload keeps the events of the payload matching the condition: a JSON object is kept or not,
+// the elements of a JSON array are filtered one by one. It returns nil when no event matches, payloads
+// which aren't JSON never match.
+func filterPayload(data []byte, cond *ForwardCondition) ([]byte, error) {
+	if !isJSONArray(data) {
+		fields, ok := decodeObject(data)
+		if !ok || !cond.matches(fields) {
+			return nil, nil
+		}
+		return data, nil
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return nil, nil
+	}
+	kept := elements[:0]
+	for _, element := range elements {
+		if fields, ok := decodeObject(element); ok && cond.matches(fields) {
+			kept = append(kept, element)
+		}
+	}
+	switch len(kept) {
+	case 0:
+		return nil, nil
+	case len(elements):
+		return data, nil
+	}
+	filtered, err := json.Marshal(kept)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding filtered json array payload %w", err)
+	}
+	return filtered, nil
+}
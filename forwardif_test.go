@@ -0,0 +1,160 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseForwardCondition(t *testing.T) {
+	tests := []struct {
+		value    string
+		want     string // the condition written back, empty for an invalid condition
+		operator string
+	}{
+		{value: "level == error", want: "level == error", operator: forwardOpEqual},
+		{value: `  level != "debug" `, want: "level != debug", operator: forwardOpNotEqual},
+		{value: "level in [error, 'fatal']", want: "level in [error,fatal]", operator: forwardOpIn},
+		{value: "level not   in [debug,info]", want: "level not in [debug,info]", operator: forwardOpNotIn},
+		{value: "http.status >= 500", want: "http.status >= 500", operator: forwardOpGreaterEqual},
+		{value: "latency_ms < 0.5", want: "latency_ms < 0.5", operator: forwardOpLess},
+		{value: "level"},
+		{value: "level ~= error"},
+		{value: "level in error,fatal"},
+		{value: "level in [ , ]"},
+		{value: "status > high"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cond, err := parseForwardCondition(tt.value)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("parseForwardCondition() = %v, want an error", cond)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseForwardCondition() error = %v", err)
+			}
+			if cond.Operator != tt.operator || cond.String() != tt.want {
+				t.Errorf("parseForwardCondition() = %q (%s), want %q (%s)", cond, cond.Operator, tt.want, tt.operator)
+			}
+		})
+	}
+}
+
+func TestForwardConditionMatches(t *testing.T) {
+	tests := []struct {
+		condition string
+		payload   string
+		want      bool
+	}{
+		{condition: "level == error", payload: `{"level":"error"}`, want: true},
+		{condition: "level == error", payload: `{"level":"info"}`},
+		{condition: "level == error", payload: `{"other":"error"}`},
+		{condition: "level == error", payload: `{"level":null}`},
+		{condition: "level != debug", payload: `{"level":"info"}`, want: true},
+		{condition: "level != debug", payload: `{}`},
+		{condition: "level in [error,fatal]", payload: `{"level":"fatal"}`, want: true},
+		{condition: "level in [error,fatal]", payload: `{"level":"warn"}`},
+		{condition: "level not in [debug,info]", payload: `{"level":"error"}`, want: true},
+		{condition: "level not in [debug,info]", payload: `{"level":"debug"}`},
+		{condition: "log.level == error", payload: `{"log":{"level":"error"}}`, want: true},
+		{condition: "status >= 500", payload: `{"status":503}`, want: true},
+		{condition: "status >= 500", payload: `{"status":"500"}`, want: true},
+		{condition: "status >= 500", payload: `{"status":404}`},
+		{condition: "status >= 500", payload: `{"status":"n/a"}`},
+		{condition: "latency > 1.5", payload: `{"latency":1.6}`, want: true},
+		{condition: "latency <= 1.5", payload: `{"latency":1.5}`, want: true},
+		{condition: "count == 3", payload: `{"count":3}`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.condition+" "+tt.payload, func(t *testing.T) {
+			cond, err := parseForwardCondition(tt.condition)
+			if err != nil {
+				t.Fatalf("parseForwardCondition() error = %v", err)
+			}
+			fields, ok := decodeObject([]byte(tt.payload))
+			if !ok {
+				t.Fatalf("payload %s isn't an object", tt.payload)
+			}
+			if got := cond.matches(fields); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterPayload(t *testing.T) {
+	cond, err := parseForwardCondition("level in [error,fatal]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		payload string
+		want    string // empty when nothing matches
+	}{
+		{name: "matching object", payload: `{"level":"error"}`, want: `{"level":"error"}`},
+		{name: "non-matching object", payload: `{"level":"info"}`},
+		{name: "not JSON", payload: `level=error`},
+		{name: "array filtered", payload: `[{"level":"info"},{"level":"error"},3,{"level":"fatal"}]`,
+			want: `[{"level":"error"},{"level":"fatal"}]`},
+		{name: "array all matching", payload: `[{"level":"error"}, {"level":"fatal"}]`,
+			want: `[{"level":"error"}, {"level":"fatal"}]`},
+		{name: "array none matching", payload: `[{"level":"info"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filterPayload([]byte(tt.payload), cond)
+			if err != nil {
+				t.Fatalf("filterPayload() error = %v", err)
+			}
+			if string(got) != tt.want || (tt.want == "" && got != nil) {
+				t.Errorf("filterPayload() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerForwardIf(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	var logs logRecorder
+	clock := newFakeClock(testPublishTime)
+	cfg := testConfig(honeycomb)
+	cfg.Logger = newRecordingLogger(&logs)
+	cfg.Clock = clock
+	cfg.ForwardIf, _ = parseForwardCondition("level in [error,fatal]")
+	handler, _ := NewHandler(cfg)
+	handle := func(data string) {
+		t.Helper()
+		if err := handler(context.Background(), pubSubEvent(t, PubSubMessage{Data: []byte(data)})); err != nil {
+			t.Fatalf("handler error = %v, want the message acknowledged", err)
+		}
+	}
+
+	handle(`{"level":"error","msg":"boom"}`)
+	handle(`{"level":"info"}`)
+	handle(`{"msg":"no level"}`)
+	handle(`not json`)
+	handle(`[{"level":"debug"},{"level":"fatal"}]`)
+
+	requests := honeycomb.received()
+	if len(requests) != 2 {
+		t.Fatalf("%d requests sent, want the 2 matching messages", len(requests))
+	}
+	if got := honeycomb.lastBatch(t); len(got) != 1 || string(got[0].Data) != `{"level":"fatal"}` {
+		t.Errorf("batch = %+v, want the matching element only", got)
+	}
+
+	// The drop count is logged at most once per interval
+	const message = "PubSub messages skipped by the forward condition"
+	if got := logs.records(t, message); len(got) != 1 || got[0]["count"] != 1.0 {
+		t.Fatalf("skip logs = %v, want the first drop logged", got)
+	}
+	clock.Advance(skipLogInterval + time.Second)
+	handle(`{"level":"warn"}`)
+	if got := logs.records(t, message); len(got) != 2 || got[1]["count"] != 3.0 {
+		t.Errorf("skip logs = %v, want the 3 drops since the last log counted", got)
+	}
+}
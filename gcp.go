@@ -0,0 +1,152 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataTokenURL returns an access token for the function's service account.
+// See the documentation for more details:
+// https://cloud.google.com/functions/docs/securing/function-identity#access_tokens
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// accessToken caches the service account access token between invocations.
+var accessToken struct {
+	sync.Mutex
+	value   string
+	expires time.Time
+}
+
+// gcpAccessToken returns an OAuth2 access token to call Google APIs, fetched from the metadata server
+// and cached until shortly before it expires.
+func gcpAccessToken(ctx context.Context, client *http.Client, clock Clock) (string, error) {
+	accessToken.Lock()
+	defer accessToken.Unlock()
+	if accessToken.value != "" && clock.Now().Before(accessToken.expires) {
+		return accessToken.value, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", metadataTokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error initializing metadata token request %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching access token from the metadata server %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _, _ := readResponseBody(resp.Body, defaultMaxResponseBytes)
+		return "", fmt.Errorf("error metadata server returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error decoding metadata server token %w", err)
+	}
+	accessToken.value = token.AccessToken
+	accessToken.expires = clock.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return accessToken.value, nil
+}
+
+// callGoogleAPI sends a request authenticated with the service account to a Google REST API and decodes
+// the JSON response into out, when not nil. The body of an error response is read up to the
+// defaultMaxResponseBytes cap of the Honeycomb error responses.
+func callGoogleAPI(ctx context.Context, client *http.Client, clock Clock, method string, endpoint string,
+	body io.Reader, out any) error {
+	token, err := gcpAccessToken(ctx, client, clock)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("error initializing request to %s %w", endpoint, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request to %s %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _, _ := readResponseBody(resp.Body, defaultMaxResponseBytes)
+		return fmt.Errorf("error %s returned status %d: %s", endpoint, resp.StatusCode, respBody)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response from %s %w", endpoint, err)
+	}
+	return nil
+}
+
+const secretManagerAPIURL = "https://secretmanager.googleapis.com/v1/"
+
+var secretVersionPattern = regexp.MustCompile(`^projects/[^/]+/secrets/[^/]+/versions/[^/]+$`)
+
+// accessSecret returns the value of a Secret Manager secret version
+// (projects/<project>/secrets/<secret>/versions/<version>).
+// See the documentation for more details:
+// https://cloud.google.com/secret-manager/docs/reference/rest/v1/projects.secrets.versions/access
+func accessSecret(ctx context.Context, client *http.Client, clock Clock, name string) (string, error) {
+	if !secretVersionPattern.MatchString(name) {
+		return "", fmt.Errorf("error, %q is not a secret version name "+
+			"(projects/<project>/secrets/<secret>/versions/<version>)", name)
+	}
+	var secret struct {
+		Payload struct {
+			Data []byte `json:"data"` // Automatically decoded from base64.
+		} `json:"payload"`
+	}
+	if err := callGoogleAPI(ctx, client, clock, "GET", secretManagerAPIURL+name+":access", nil, &secret); err != nil {
+		return "", fmt.Errorf("error accessing secret %s %w", name, err)
+	}
+	return strings.TrimSpace(string(secret.Payload.Data)), nil
+}
+
+const storageAPIURL = "https://storage.googleapis.com/storage/v1/"
+
+// openGCSObject streams the content of a Cloud Storage object (gs://<bucket>/<object>).
+// See the documentation for more details:
+// https://cloud.google.com/storage/docs/json_api/v1/objects/get
+func openGCSObject(ctx context.Context, client *http.Client, clock Clock, uri string) (io.ReadCloser, error) {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(uri, "gs://"), "/")
+	if !strings.HasPrefix(uri, "gs://") || !ok || bucket == "" || object == "" {
+		return nil, fmt.Errorf("error, %q is not a Cloud Storage object URI (gs://<bucket>/<object>)", uri)
+	}
+	token, err := gcpAccessToken(ctx, client, clock)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := storageAPIURL + "b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(object) + "?alt=media"
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing request to %s %w", endpoint, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s %w", uri, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _, _ := readResponseBody(resp.Body, defaultMaxResponseBytes)
+		return nil, fmt.Errorf("error reading %s, Cloud Storage returned status %d: %s", uri, resp.StatusCode, body)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,111 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const healthProbeTimeout = 5 * time.Second
+
+// envVarPattern matches the name of an environment variable, e.g: HONEYCOMB_API_KEY.
+var envVarPattern = regexp.MustCompile(`\b[A-Z][A-Z0-9]*(?:_[A-Z0-9]+)+\b`)
+
+// configVariable returns the environment variable a configuration error is about, empty when it names
+// none.
+func configVariable(err error) string {
+	return envVarPattern.FindString(err.Error())
+}
+
+// healthReport is the body of the health check response.
+type healthReport struct {
+	Status      string `json:"status"`
+	ConfigValid bool   `json:"config_valid"`
+	Error       string `json:"error,omitempty"`
+	// ConfigVariable names the environment variable of the configuration error, the details of which
+	// are only logged: the endpoint is unauthenticated.
+	ConfigVariable string `json:"config_variable,omitempty"`
+	SinkMode       string `json:"sink_mode,omitempty"`
+	DatasetSet     bool   `json:"dataset_set"`
+	APIKeyPresent  bool   `json:"api_key_present"`
+	// APIReachable is only reported when the probe was requested.
+	APIReachable *bool  `json:"api_reachable,omitempty"`
+	APIError     string `json:"api_error,omitempty"`
+}
+
+// newHealthHandler returns the health check target: it answers 200 with a JSON report when the
+// configuration loaded at cold start is valid, 503 otherwise. With ?probe=api, it also checks the API key
+// against the Honeycomb auth endpoint, which doesn't count as an event.
+func newHealthHandler(cfg Config, cfgErr error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := healthReport{
+			Status:        "ok",
+			ConfigValid:   cfgErr == nil,
+			SinkMode:      cfg.SinkMode,
+			DatasetSet:    cfg.Dataset != "",
+			APIKeyPresent: cfg.APIKey != "",
+		}
+		if cfgErr != nil {
+			report.Error = "invalid configuration"
+			report.ConfigVariable = configVariable(cfgErr)
+			logger := cfg.Logger
+			if logger == nil {
+				logger = slog.Default()
+			}
+			logger.Error("invalid configuration", "error", cfgErr.Error())
+		}
+		if cfgErr == nil && r.URL.Query().Get("probe") == "api" && cfg.SinkMode == sinkModeEvents {
+			reachable := true
+			if err := probeHoneycomb(r.Context(), cfg); err != nil {
+				reachable = false
+				report.APIError = err.Error()
+			}
+			report.APIReachable = &reachable
+		}
+
+		status := http.StatusOK
+		if !report.ConfigValid || (report.APIReachable != nil && !*report.APIReachable) {
+			report.Status = "unhealthy"
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
+
+// probeHoneycomb checks the API is reachable and accepts the API key.
+// See the documentation for more details:
+// https://docs.honeycomb.io/api/tag/Auth
+func probeHoneycomb(ctx context.Context, cfg Config) error {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.APIURL+"/1/auth", nil)
+	if err != nil {
+		return fmt.Errorf("error initializing honeycomb auth request %w", err)
+	}
+	authHeader := cfg.AuthHeader
+	if authHeader == "" {
+		authHeader = defaultAuthHeader
+	}
+	req.Header.Set(authHeader, cfg.APIKey)
+	client := cfg.HTTPClient
+	if client == nil {
+		client = httpClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending auth request to honeycomb %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode != http.StatusOK {
+		return &honeycombStatusError{StatusCode: resp.StatusCode, Body: summarizeBody(resp.Header.Get("Content-Type"), body)}
+	}
+	return nil
+}
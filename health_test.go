@@ -0,0 +1,88 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	const secret = "hcaik_01hs3ret"
+	tests := []struct {
+		name         string
+		cfgErr       error
+		probe        bool
+		respond      func(w http.ResponseWriter, r *http.Request, body []byte)
+		wantStatus   int
+		wantVariable string
+		wantReach    bool // with the probe
+	}{
+		{name: "valid", wantStatus: http.StatusOK},
+		{name: "invalid", wantStatus: http.StatusServiceUnavailable, wantVariable: "OTLP_HEADERS",
+			cfgErr: fmt.Errorf("error, OTLP_HEADERS environment variable must be key=value pairs, got %q", secret)},
+		{name: "probe", probe: true, wantStatus: http.StatusOK, wantReach: true},
+		{name: "probe rejected", probe: true, wantStatus: http.StatusServiceUnavailable,
+			respond: respondWith(http.StatusUnauthorized, `{"error":"unknown API key"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, tt.respond)
+			var logs logRecorder
+			cfg := testConfig(honeycomb)
+			cfg.SinkMode = sinkModeEvents
+			cfg.Logger = newRecordingLogger(&logs)
+
+			target := "/"
+			if tt.probe {
+				target = "/?probe=api"
+			}
+			rec := httptest.NewRecorder()
+			newHealthHandler(withDefaults(cfg), tt.cfgErr)(rec, httptest.NewRequest(http.MethodGet, target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var report healthReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("error decoding the report %s: %v", rec.Body, err)
+			}
+			if report.ConfigValid != (tt.cfgErr == nil) || report.ConfigVariable != tt.wantVariable {
+				t.Errorf("report = %+v, want config_variable %q", report, tt.wantVariable)
+			}
+			if (report.APIReachable != nil) != tt.probe || (tt.probe && *report.APIReachable != tt.wantReach) {
+				t.Errorf("api_reachable = %v, want %v when probed: %v", report.APIReachable, tt.wantReach, tt.probe)
+			}
+
+			// The details of the configuration error are logged, never answered
+			if strings.Contains(rec.Body.String(), secret) {
+				t.Errorf("body = %s, holds the secret", rec.Body)
+			}
+			if tt.cfgErr != nil {
+				records := logs.records(t, "invalid configuration")
+				if report.Error != "invalid configuration" || len(records) != 1 ||
+					records[0]["error"] != tt.cfgErr.Error() {
+					t.Errorf("error = %q, logs = %v, want the details logged", report.Error, records)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigVariable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: fmt.Errorf("error, HONEYCOMB_DATASET environment variable is missing"), want: "HONEYCOMB_DATASET"},
+		{err: fmt.Errorf("error reading the Honeycomb API key from HONEYCOMB_API_KEY_SECRET %w",
+			fmt.Errorf("error accessing secret")), want: "HONEYCOMB_API_KEY_SECRET"},
+		{err: fmt.Errorf("error, unknown sink mode"), want: ""},
+	}
+	for _, tt := range tests {
+		if got := configVariable(tt.err); got != tt.want {
+			t.Errorf("configVariable(%q) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// newLogger returns a JSON logger whose output is understood by Cloud Logging: the level is written as
+// `severity` and the message as `message`, so entries are parsed into structured log entries.
+// The minimum level is read from LOG_LEVEL (debug, info, warn or error), defaulting to info.
+func newLogger() *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(os.Getenv("LOG_LEVEL")))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.LevelKey:
+				a.Key = "severity"
+				if lvl, ok := a.Value.Any().(slog.Level); ok && lvl == slog.LevelWarn {
+					a.Value = slog.StringValue("WARNING")
+				}
+			case slog.MessageKey:
+				a.Key = "message"
+			}
+			return a
+		},
+	})
+	return slog.New(handler)
+}
+
+// redact masks a secret so it can be logged: only its last 4 characters are kept, which is enough to
+// tell keys apart.
+func redact(secret string) string {
+	const visible = 4
+	if len(secret) <= visible {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-visible) + secret[len(secret)-visible:]
+}
+
+// shouldLogPayload keeps the payload of 1 in sampleRate messages in the logs. The decision is
+// deterministic on the message ID, so the redeliveries of a logged message are logged too.
+func shouldLogPayload(sampleRate int, messageID string) bool {
+	if sampleRate <= 1 {
+		return true
+	}
+	if messageID == "" {
+		return rand.Intn(sampleRate) == 0
+	}
+	return sampleDeterministic(messageID, sampleRate)
+}
+
+// loggerKey is the context key of the invocation logger.
+type loggerKey struct{}
+
+// withLogger stores the logger of an invocation in its context, so the functions it calls log with the
+// attributes bound by the handler, e.g: the message ID.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger stored in the context, or the default logger outside of an invocation.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
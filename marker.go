@@ -0,0 +1,49 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// PubSub attributes turning a message into a Honeycomb marker.
+const (
+	markerTypeAttribute    = "honeycomb-marker-type"
+	markerMessageAttribute = "honeycomb-marker-message"
+	markerURLAttribute     = "honeycomb-marker-url"
+)
+
+// isMarker reports whether the message describes a marker rather than an event.
+func isMarker(msg MessagePublishedData) bool {
+	_, ok := msg.Message.Attributes[markerTypeAttribute]
+	return ok
+}
+
+// sendMarker creates a Honeycomb marker (e.g: a deploy or an incident) from the message attributes,
+// starting at the event time.
+// See the documentation for more details:
+// https://docs.honeycomb.io/api/tag/Markers#operation/createMarker
+func sendMarker(ctx context.Context, cfg Config, msg MessagePublishedData, dataset string) (sendResult, error) {
+	marker := map[string]any{
+		"type":       msg.Message.Attributes[markerTypeAttribute],
+		"start_time": eventTime(msg, cfg.Clock).Unix(),
+	}
+	if message, ok := msg.Message.Attributes[markerMessageAttribute]; ok {
+		marker["message"] = message
+	}
+	if url, ok := msg.Message.Attributes[markerURLAttribute]; ok {
+		marker["url"] = url
+	}
+	payload, err := json.Marshal(marker)
+	if err != nil {
+		return sendResult{}, fmt.Errorf("error encoding honeycomb marker %w", err)
+	}
+
+	endpoint := datasetEndpoint(cfg.APIURL, "markers", dataset)
+	headers := honeycombHeaders(cfg, apiKeyFor(cfg, dataset, msg.Message.Attributes))
+	if cfg.DryRun {
+		logDryRun(ctx, cfg, endpoint, headers, payload, dataset)
+		return sendResult{}, nil
+	}
+	return postWithRetries(ctx, cfg, endpoint, payload, headers, dataset)
+}
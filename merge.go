@@ -0,0 +1,68 @@
+package HoneycombSinkHandler
+
+import "fmt"
+
+// Strategies for the key conflicts between the producer's fields and the fields added by the sink
+// (static fields, PubSub metadata, lag, ordering key, trace and timing fields), selected with
+// MERGE_STRATEGY.
+const (
+	mergeProducerWins = "producer_wins"
+	mergeSinkWins     = "sink_wins"
+	mergePrefix       = "prefix"
+)
+
+const defaultMergePrefix = "sink."
+
+// fieldMerge merges the fields added by the sink into the producer's event.
+type fieldMerge struct {
+	strategy string
+	// prefix renames the conflicting sink fields with the prefix strategy.
+	prefix string
+}
+
+// fieldMerge returns the merge of the fields added by the sink. StaticFieldsOverride only applies to the
+// static fields, when MergeStrategy is unset.
+func (cfg Config) fieldMerge(static bool) fieldMerge {
+	merge := fieldMerge{strategy: cfg.MergeStrategy, prefix: cfg.MergePrefix}
+	if merge.strategy == "" {
+		merge.strategy = mergeProducerWins
+		if static && cfg.StaticFieldsOverride {
+			merge.strategy = mergeSinkWins
+		}
+	}
+	if merge.prefix == "" {
+		merge.prefix = defaultMergePrefix
+	}
+	return merge
+}
+
+// merge adds the extra fields to the event. On a key conflict the producer's value is kept with
+// producer_wins, replaced with sink_wins, and with prefix both are kept: the sink's value is added under
+// the prefixed key, prefixed again until the key is free.
+func (m fieldMerge) merge(fields map[string]any, extra map[string]any) {
+	for key, value := range extra {
+		if _, exists := fields[key]; exists {
+			switch m.strategy {
+			case mergeSinkWins:
+			case mergePrefix:
+				for exists {
+					key = m.prefix + key
+					_, exists = fields[key]
+				}
+			default:
+				continue
+			}
+		}
+		fields[key] = value
+	}
+}
+
+// validateMergeStrategy checks the strategy is one of producer_wins, sink_wins and prefix.
+func validateMergeStrategy(strategy string) error {
+	switch strategy {
+	case mergeProducerWins, mergeSinkWins, mergePrefix:
+		return nil
+	}
+	return fmt.Errorf("error unknown merge strategy %q, expected %s, %s or %s", strategy, mergeProducerWins,
+		mergeSinkWins, mergePrefix)
+}
@@ -0,0 +1,127 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFieldMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		fields   map[string]any
+		extra    map[string]any
+		want     map[string]any
+	}{
+		{
+			name:     "producer wins",
+			strategy: mergeProducerWins,
+			fields:   map[string]any{"env": "producer", "a": 1},
+			extra:    map[string]any{"env": "sink", "region": "eu"},
+			want:     map[string]any{"env": "producer", "a": 1, "region": "eu"},
+		},
+		{
+			name:     "sink wins",
+			strategy: mergeSinkWins,
+			fields:   map[string]any{"env": "producer", "a": 1},
+			extra:    map[string]any{"env": "sink", "region": "eu"},
+			want:     map[string]any{"env": "sink", "a": 1, "region": "eu"},
+		},
+		{
+			name:     "prefix",
+			strategy: mergePrefix,
+			fields:   map[string]any{"env": "producer", "a": 1},
+			extra:    map[string]any{"env": "sink", "region": "eu"},
+			want:     map[string]any{"env": "producer", "sink.env": "sink", "a": 1, "region": "eu"},
+		},
+		{
+			name:     "prefix taken",
+			strategy: mergePrefix,
+			fields:   map[string]any{"env": "producer", "sink.env": "also producer"},
+			extra:    map[string]any{"env": "sink"},
+			want:     map[string]any{"env": "producer", "sink.env": "also producer", "sink.sink.env": "sink"},
+		},
+		{
+			name:     "no conflict",
+			strategy: mergeProducerWins,
+			fields:   map[string]any{},
+			extra:    map[string]any{"env": "sink"},
+			want:     map[string]any{"env": "sink"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fieldMerge{strategy: tt.strategy, prefix: defaultMergePrefix}.merge(tt.fields, tt.extra)
+			if !reflect.DeepEqual(tt.fields, tt.want) {
+				t.Errorf("merged fields = %v, want %v", tt.fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFieldMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		static bool
+		want   fieldMerge
+	}{
+		{name: "default", want: fieldMerge{strategy: mergeProducerWins, prefix: defaultMergePrefix}},
+		{name: "static fields override", cfg: Config{StaticFieldsOverride: true}, static: true,
+			want: fieldMerge{strategy: mergeSinkWins, prefix: defaultMergePrefix}},
+		{name: "static fields override for other fields", cfg: Config{StaticFieldsOverride: true},
+			want: fieldMerge{strategy: mergeProducerWins, prefix: defaultMergePrefix}},
+		{name: "strategy over static fields override",
+			cfg:    Config{StaticFieldsOverride: true, MergeStrategy: mergePrefix, MergePrefix: "gcp_"},
+			static: true, want: fieldMerge{strategy: mergePrefix, prefix: "gcp_"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.fieldMerge(tt.static); got != tt.want {
+				t.Errorf("fieldMerge() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerMergeStrategy(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     map[string]any
+	}{
+		{strategy: "", want: map[string]any{"env": "producer", "team": "producer", "region": "eu"}},
+		{strategy: mergeProducerWins, want: map[string]any{"env": "producer", "team": "producer", "region": "eu"}},
+		{strategy: mergeSinkWins, want: map[string]any{"env": "static", "team": "attribute", "region": "eu"}},
+		{strategy: mergePrefix, want: map[string]any{"env": "producer", "team": "producer", "region": "eu",
+			"sink.env": "static", "sink.team": "attribute"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.MergeStrategy = tt.strategy
+			cfg.StaticFields = map[string]any{"env": "static", "region": "eu"}
+			cfg.IncludeAttrs = []string{"team"}
+			handler, _ := NewHandler(cfg)
+
+			e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"env":"producer","team":"producer"}`),
+				Attributes: map[string]string{"team": "attribute"}})
+			if err := handler(quietContext(), e); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if got := honeycomb.lastEvent(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("event = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeStrategyFromEnv(t *testing.T) {
+	t.Setenv("HONEYCOMB_DATASET", "test-dataset")
+	t.Setenv("HONEYCOMB_API_KEY", "test-api-key")
+	t.Setenv("MERGE_STRATEGY", "newest_wins")
+	if _, err := ConfigFromEnv(); !errors.Is(err, ErrConfig) {
+		t.Errorf("ConfigFromEnv() error = %v, want a configuration error", err)
+	}
+}
@@ -0,0 +1,345 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Names of the metrics exported by the sink.
+const (
+	metricForwarded   = "sink.events.forwarded"
+	metricFailed      = "sink.events.failed"
+	metricLatency     = "sink.honeycomb.latency"
+	metricLagging     = "sink.pubsub.lagging"
+	metricInvocations = "sink.invocations"
+	metricFailovers   = "sink.honeycomb.failovers"
+	// metricTooManyColumns counts the events rejected for their number of columns, by dataset, to spot
+	// the high-cardinality producers.
+	metricTooManyColumns = "sink.events.too_many_columns"
+)
+
+// latencyBounds are the upper bounds, in milliseconds, of the latency histogram buckets.
+var latencyBounds = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// metricsRegistry accumulates the sink metrics in memory and periodically pushes them to an
+// OpenTelemetry collector (or any OTLP/HTTP endpoint, Honeycomb included) with cumulative temporality.
+type metricsRegistry struct {
+	mu         sync.Mutex
+	endpoint   string
+	headers    map[string]string
+	interval   time.Duration
+	client     *http.Client
+	clock      Clock
+	start      time.Time
+	lastExport time.Time
+
+	forwarded    int64
+	lagging      int64
+	invocations  map[string]int64 // by outcome
+	failures     map[string]int64 // by reason
+	wideEvents   map[string]int64 // events with too many columns, by dataset
+	failovers    map[string]int64 // sends posted to the fallback endpoint, by outcome
+	latencyCount int64
+	latencySum   float64
+	latencyBins  []int64 // len(latencyBounds)+1 buckets
+}
+
+func newMetricsRegistry(endpoint string, headers map[string]string, interval time.Duration,
+	client *http.Client, clock Clock) *metricsRegistry {
+	now := clock.Now()
+	return &metricsRegistry{
+		endpoint:    endpoint,
+		headers:     headers,
+		interval:    interval,
+		client:      client,
+		clock:       clock,
+		start:       now,
+		lastExport:  now,
+		failures:    make(map[string]int64),
+		invocations: make(map[string]int64),
+		wideEvents:  make(map[string]int64),
+		failovers:   make(map[string]int64),
+		latencyBins: make([]int64, len(latencyBounds)+1),
+	}
+}
+
+// recordSend records the outcome and the latency of a send to Honeycomb.
+func (m *metricsRegistry) recordSend(latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.forwarded++
+	} else {
+		m.failures[failureReason(err)]++
+		m.recordRejections(err)
+	}
+
+	ms := float64(latency) / float64(time.Millisecond)
+	m.latencyCount++
+	m.latencySum += ms
+	bin := len(latencyBounds)
+	for i, bound := range latencyBounds {
+		if ms <= bound {
+			bin = i
+			break
+		}
+	}
+	m.latencyBins[bin]++
+}
+
+// failureReason classifies a send error for the failures counter.
+func failureReason(err error) string {
+	var statusErr *honeycombStatusError
+	var batchErr *batchError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, errCircuitOpen):
+		return "circuit_open"
+	case errors.As(err, &statusErr) && statusErr.StatusCode >= 500:
+		return "5xx"
+	case errors.As(err, &statusErr):
+		return "4xx"
+	case errors.As(err, &batchErr):
+		return "rejected"
+	default:
+		return "network"
+	}
+}
+
+// recordRejections counts the events of the error rejected for their number of columns. m.mu must be
+// held.
+func (m *metricsRegistry) recordRejections(err error) {
+	var statusErr *honeycombStatusError
+	if errors.As(err, &statusErr) && statusErr.Reason == rejectTooManyColumns {
+		m.wideEvents[statusErr.Dataset]++
+	}
+	var batchErr *batchError
+	if errors.As(err, &batchErr) && batchErr.Causes[rejectTooManyColumns] > 0 {
+		m.wideEvents[batchErr.Dataset] += int64(batchErr.Causes[rejectTooManyColumns])
+	}
+}
+
+// recordFailover records a send posted to the fallback endpoint and its outcome: "ok" when the fallback
+// accepted it, "error" otherwise.
+func (m *metricsRegistry) recordFailover(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	m.failovers[outcome]++
+}
+
+// recordLag records a message delivered later than the lag threshold after its publication.
+func (m *metricsRegistry) recordLag() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lagging++
+}
+
+// recordInvocation records the outcome of an invocation: "ok" when the message was ACKed, "error"
+// otherwise.
+func (m *metricsRegistry) recordInvocation(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	m.invocations[outcome]++
+}
+
+// exportIfDue pushes the metrics when the export interval has elapsed since the last export. It is
+// called inline by the metrics middleware since the instance CPU may be throttled between invocations.
+func (m *metricsRegistry) exportIfDue(ctx context.Context) {
+	m.mu.Lock()
+	if since(m.clock, m.lastExport) < m.interval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastExport = m.clock.Now()
+	body, err := json.Marshal(m.snapshot(m.lastExport))
+	m.mu.Unlock()
+	if err != nil {
+		loggerFrom(ctx).Warn("error encoding metrics", "error", err.Error())
+		return
+	}
+
+	if err := m.push(ctx, body); err != nil {
+		loggerFrom(ctx).Warn("error exporting metrics", "endpoint", m.endpoint, "error", err.Error())
+	}
+}
+
+func (m *metricsRegistry) push(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, metricsExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", m.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error initializing metrics export request %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range m.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending metrics export request %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error metrics endpoint returned status %d: %s", resp.StatusCode, respBody)
+	}
+	return nil
+}
+
+// snapshot builds an OTLP/JSON ExportMetricsServiceRequest. m.mu must be held.
+// See the documentation for more details:
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+func (m *metricsRegistry) snapshot(now time.Time) map[string]any {
+	const cumulative = 2
+	start := strconv.FormatInt(m.start.UnixNano(), 10)
+	end := strconv.FormatInt(now.UnixNano(), 10)
+
+	failures := make([]map[string]any, 0, len(m.failures))
+	for reason, count := range m.failures {
+		failures = append(failures, map[string]any{
+			"attributes":        []map[string]any{otlpAttribute("reason", reason)},
+			"asInt":             strconv.FormatInt(count, 10),
+			"startTimeUnixNano": start,
+			"timeUnixNano":      end,
+		})
+	}
+	invocations := make([]map[string]any, 0, len(m.invocations))
+	for outcome, count := range m.invocations {
+		invocations = append(invocations, map[string]any{
+			"attributes":        []map[string]any{otlpAttribute("outcome", outcome)},
+			"asInt":             strconv.FormatInt(count, 10),
+			"startTimeUnixNano": start,
+			"timeUnixNano":      end,
+		})
+	}
+	wideEvents := make([]map[string]any, 0, len(m.wideEvents))
+	for dataset, count := range m.wideEvents {
+		wideEvents = append(wideEvents, map[string]any{
+			"attributes":        []map[string]any{otlpAttribute("dataset", dataset)},
+			"asInt":             strconv.FormatInt(count, 10),
+			"startTimeUnixNano": start,
+			"timeUnixNano":      end,
+		})
+	}
+	failovers := make([]map[string]any, 0, len(m.failovers))
+	for outcome, count := range m.failovers {
+		failovers = append(failovers, map[string]any{
+			"attributes":        []map[string]any{otlpAttribute("outcome", outcome)},
+			"asInt":             strconv.FormatInt(count, 10),
+			"startTimeUnixNano": start,
+			"timeUnixNano":      end,
+		})
+	}
+	bins := make([]string, len(m.latencyBins))
+	for i, count := range m.latencyBins {
+		bins[i] = strconv.FormatInt(count, 10)
+	}
+
+	metrics := []map[string]any{
+		{
+			"name": metricForwarded,
+			"sum": map[string]any{
+				"aggregationTemporality": cumulative,
+				"isMonotonic":            true,
+				"dataPoints": []map[string]any{{
+					"asInt":             strconv.FormatInt(m.forwarded, 10),
+					"startTimeUnixNano": start,
+					"timeUnixNano":      end,
+				}},
+			},
+		},
+		{
+			"name": metricLagging,
+			"sum": map[string]any{
+				"aggregationTemporality": cumulative,
+				"isMonotonic":            true,
+				"dataPoints": []map[string]any{{
+					"asInt":             strconv.FormatInt(m.lagging, 10),
+					"startTimeUnixNano": start,
+					"timeUnixNano":      end,
+				}},
+			},
+		},
+		{
+			"name": metricFailed,
+			"sum": map[string]any{
+				"aggregationTemporality": cumulative,
+				"isMonotonic":            true,
+				"dataPoints":             failures,
+			},
+		},
+		{
+			"name": metricTooManyColumns,
+			"sum": map[string]any{
+				"aggregationTemporality": cumulative,
+				"isMonotonic":            true,
+				"dataPoints":             wideEvents,
+			},
+		},
+		{
+			"name": metricFailovers,
+			"sum": map[string]any{
+				"aggregationTemporality": cumulative,
+				"isMonotonic":            true,
+				"dataPoints":             failovers,
+			},
+		},
+		{
+			"name": metricInvocations,
+			"sum": map[string]any{
+				"aggregationTemporality": cumulative,
+				"isMonotonic":            true,
+				"dataPoints":             invocations,
+			},
+		},
+		{
+			"name": metricLatency,
+			"unit": "ms",
+			"histogram": map[string]any{
+				"aggregationTemporality": cumulative,
+				"dataPoints": []map[string]any{{
+					"count":             strconv.FormatInt(m.latencyCount, 10),
+					"sum":               m.latencySum,
+					"bucketCounts":      bins,
+					"explicitBounds":    latencyBounds,
+					"startTimeUnixNano": start,
+					"timeUnixNano":      end,
+				}},
+			},
+		},
+	}
+
+	return map[string]any{
+		"resourceMetrics": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": []map[string]any{otlpAttribute("service.name", serviceName)},
+			},
+			"scopeMetrics": []map[string]any{{
+				"scope":   map[string]any{"name": serviceName},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
+
+// otlpAttribute encodes a string key/value pair as an OTLP/JSON attribute.
+func otlpAttribute(key string, value string) map[string]any {
+	return map[string]any{"key": key, "value": map[string]any{"stringValue": value}}
+}
@@ -0,0 +1,34 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "timeout", err: fmt.Errorf("error honeycomb post request timed out %w", context.DeadlineExceeded),
+			want: "timeout"},
+		{name: "circuit open", err: fmt.Errorf("dataset %q: %w", "test-dataset", errCircuitOpen),
+			want: "circuit_open"},
+		{name: "5xx", err: &honeycombStatusError{StatusCode: http.StatusBadGateway}, want: "5xx"},
+		{name: "4xx", err: &honeycombStatusError{StatusCode: http.StatusForbidden}, want: "4xx"},
+		{name: "batch rejection", err: &batchError{Rejected: 1, Total: 2}, want: "rejected"},
+		{name: "network", err: withCategory(errors.New("error connection refused"), ErrTransient),
+			want: "network"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failureReason(tt.err); got != tt.want {
+				t.Errorf("failureReason(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
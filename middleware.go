@@ -0,0 +1,57 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// HandlerFunc processes a CloudEvent, like the function target.
+type HandlerFunc func(ctx context.Context, e event.Event) error
+
+// Middleware wraps a HandlerFunc with a cross-cutting concern (logging, metrics, authorization...). It
+// calls next to hand the event over to the rest of the chain, or returns without calling it to stop it.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// chain composes the middlewares around h, the first middleware being the outermost.
+func chain(h HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// LoggingMiddleware logs the outcome and the duration of each invocation.
+var LoggingMiddleware = loggingMiddleware(realClock{})
+
+// loggingMiddleware is LoggingMiddleware, timing the invocations with clock.
+func loggingMiddleware(clock Clock) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, e event.Event) error {
+			start := clock.Now()
+			err := next(ctx, e)
+			if err != nil {
+				loggerFrom(ctx).Warn("CloudEvent handling failed",
+					"event_id", e.ID(), "type", e.Type(), "duration_ms", since(clock, start).Milliseconds(),
+					"error", err.Error())
+				return err
+			}
+			loggerFrom(ctx).Info("CloudEvent handled",
+				"event_id", e.ID(), "type", e.Type(), "duration_ms", since(clock, start).Milliseconds())
+			return nil
+		}
+	}
+}
+
+// metricsMiddleware counts the invocations by outcome and exports the metrics once the invocation is
+// done, when the export interval has elapsed.
+func metricsMiddleware(m *metricsRegistry) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, e event.Event) error {
+			err := next(ctx, e)
+			m.recordInvocation(err)
+			m.exportIfDue(ctx)
+			return err
+		}
+	}
+}
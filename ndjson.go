@@ -0,0 +1,50 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Payload formats selected with PAYLOAD_FORMAT.
+const (
+	payloadFormatJSON   = "json"
+	payloadFormatNDJSON = "ndjson"
+)
+
+// splitNDJSON turns newline-delimited JSON events into a JSON array, so they are sent through the batch
+// endpoint. Blank lines are skipped. Lines which aren't valid JSON are wrapped when WrapNonJSON or
+// WrapInvalidJSON is set, otherwise they are logged and dropped: redelivering the message wouldn't fix
+// them. The message fails when none of its lines is valid.
+func splitNDJSON(ctx context.Context, data []byte, cfg Config, messageID string) ([]byte, error) {
+	events := []json.RawMessage{}
+	var lineErrs []error
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		event, err := validatePayload(line, cfg)
+		if err != nil {
+			loggerFrom(ctx).Warn("NDJSON line is not valid JSON, dropping it",
+				"message_id", messageID, "line", i+1, "error", err.Error())
+			lineErrs = append(lineErrs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 && len(lineErrs) > 0 {
+		return nil, withCategory(fmt.Errorf("error no valid line in the NDJSON payload: %w", errors.Join(lineErrs...)),
+			ErrPermanent)
+	}
+	if len(lineErrs) > 0 {
+		loggerFrom(ctx).Warn("NDJSON lines dropped",
+			"message_id", messageID, "forwarded", len(events), "dropped", len(lineErrs))
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(events)
+}
@@ -0,0 +1,94 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplitNDJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		wrap          bool
+		want          string
+		wantPermanent bool
+	}{
+		{
+			name: "valid lines",
+			data: "{\"a\":1}\n{\"b\":2}\n",
+			want: `[{"a":1},{"b":2}]`,
+		},
+		{
+			name: "blank lines and CRLF",
+			data: "\r\n{\"a\":1}\r\n\n   \n{\"b\":2}",
+			want: `[{"a":1},{"b":2}]`,
+		},
+		{
+			name: "invalid lines dropped",
+			data: "{\"a\":1}\nnot json\n{\"b\":\n{\"c\":3}",
+			want: `[{"a":1},{"c":3}]`,
+		},
+		{
+			name: "invalid lines wrapped",
+			data: "{\"a\":1}\nnot json",
+			wrap: true,
+			want: `[{"a":1},{"raw_message":"not json"}]`,
+		},
+		{
+			name:          "no valid line",
+			data:          "not json\n{broken",
+			wantPermanent: true,
+		},
+		{
+			name: "only blank lines",
+			data: "\n  \n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{WrapInvalidJSON: tt.wrap}
+			got, err := splitNDJSON(quietContext(), []byte(tt.data), cfg, "message-1")
+			if tt.wantPermanent {
+				if !errors.Is(err, ErrPermanent) {
+					t.Fatalf("splitNDJSON() error = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitNDJSON() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("splitNDJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerNDJSON(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	cfg.PayloadFormat = payloadFormatNDJSON
+
+	if err := handleData(t, cfg, "{\"a\":1}\ngarbage\n\n{\"b\":2}\n"); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	requests := honeycomb.received()
+	if len(requests) != 1 || requests[0].Path != "/1/batch/test-dataset" {
+		t.Fatalf("requests = %+v, want one batch request", requests)
+	}
+	var got []string
+	for _, e := range honeycomb.lastBatch(t) {
+		got = append(got, string(e.Data))
+	}
+	if want := []string{`{"a":1}`, `{"b":2}`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batch events = %q, want %q", got, want)
+	}
+
+	if err := handleData(t, cfg, "garbage\nmore garbage"); !errors.Is(err, ErrPermanent) {
+		t.Errorf("handler error = %v, want a permanent error when no line is valid", err)
+	}
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("%d requests sent, want the invalid message dropped before sending", n)
+	}
+}
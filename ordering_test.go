@@ -0,0 +1,34 @@
+package HoneycombSinkHandler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	k := newKeyedMutex()
+	unlock := k.lock("a")
+
+	// Another key isn't blocked
+	k.lock("b")()
+
+	// The same key waits for the release
+	locked := make(chan func())
+	go func() { locked <- k.lock("a") }()
+	select {
+	case <-locked:
+		t.Fatal("lock() returned while the key was held")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlock = <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock() still waiting after the release of the key")
+	}
+	unlock()
+
+	if n := len(k.locks); n != 0 {
+		t.Errorf("%d keys left, want the unused keys released", n)
+	}
+}
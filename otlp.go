@@ -0,0 +1,143 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Sink modes selected with SINK_MODE.
+const (
+	sinkModeEvents  = "events"
+	sinkModeOTLP    = "otlp"
+	sinkModeWebhook = "webhook"
+)
+
+// sendOTLPLogs maps the PubSub data into OTLP log records, one per event, and ships them to the OTLP/HTTP
+// logs endpoint (e.g: https://api.honeycomb.io/v1/logs or a collector). The payload went through the same
+// validation and transforms as in events mode.
+// See the documentation for more details:
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp
+func sendOTLPLogs(ctx context.Context, cfg Config, msg MessagePublishedData, dataset string) (sendResult, error) {
+	events := []json.RawMessage{msg.Message.Data}
+	if isJSONArray(msg.Message.Data) {
+		if err := json.Unmarshal(msg.Message.Data, &events); err != nil {
+			return sendResult{}, fmt.Errorf("error decoding json array payload %w", err)
+		}
+	}
+
+	records := make([]map[string]any, 0, len(events))
+	for _, event := range events {
+		timestamp := strconv.FormatInt(payloadEventTime(cfg, msg, event).UnixNano(), 10)
+		records = append(records, otlpLogRecord(event, timestamp))
+	}
+	service := dataset
+	if service == "" {
+		service = serviceName
+	}
+	payload, err := json.Marshal(map[string]any{
+		"resourceLogs": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": []map[string]any{otlpAttribute("service.name", service)},
+			},
+			"scopeLogs": []map[string]any{{
+				"scope":      map[string]any{"name": serviceName},
+				"logRecords": records,
+			}},
+		}},
+	})
+	if err != nil {
+		return sendResult{}, fmt.Errorf("error encoding OTLP logs %w", err)
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+	for name, value := range cfg.OTLPHeaders {
+		headers.Set(name, value)
+	}
+	// Honeycomb routes OTLP data by service.name, Classic environments need the dataset header
+	if dataset != "" {
+		headers.Set("X-Honeycomb-Dataset", dataset)
+	}
+
+	if cfg.DryRun {
+		logDryRun(ctx, cfg, cfg.OTLPEndpoint, headers, payload, dataset)
+		return sendResult{}, nil
+	}
+	if cfg.Gzip {
+		if payload, err = gzipPayload(payload); err != nil {
+			return sendResult{}, err
+		}
+		headers.Set("Content-Encoding", "gzip")
+	}
+	return postWithRetries(ctx, cfg, cfg.OTLPEndpoint, payload, headers, dataset)
+}
+
+// otlpLogRecord maps an event to an OTLP log record: the fields of a JSON object become attributes, its
+// "message"/"msg" field the body and its "level"/"severity" field the severity. Any other payload
+// becomes the body.
+func otlpLogRecord(event json.RawMessage, timestamp string) map[string]any {
+	record := map[string]any{
+		"timeUnixNano":         timestamp,
+		"observedTimeUnixNano": timestamp,
+	}
+	fields, ok := decodeObject(event)
+	if !ok {
+		record["body"] = map[string]any{"stringValue": string(bytes.TrimSpace(event))}
+		return record
+	}
+
+	for _, key := range []string{"message", "msg"} {
+		if body, ok := fields[key].(string); ok {
+			record["body"] = map[string]any{"stringValue": body}
+			delete(fields, key)
+			break
+		}
+	}
+	for _, key := range []string{"level", "severity"} {
+		if severity, ok := fields[key].(string); ok {
+			record["severityText"] = severity
+			break
+		}
+	}
+	attributes := make([]map[string]any, 0, len(fields))
+	for key, value := range fields {
+		attributes = append(attributes, map[string]any{"key": key, "value": otlpValue(value)})
+	}
+	record["attributes"] = attributes
+	return record
+}
+
+// otlpValue encodes a decoded JSON value as an OTLP/JSON AnyValue.
+func otlpValue(value any) map[string]any {
+	switch v := value.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return map[string]any{"intValue": strconv.FormatInt(i, 10)}
+		}
+		f, _ := v.Float64()
+		return map[string]any{"doubleValue": f}
+	case []any:
+		values := make([]map[string]any, 0, len(v))
+		for _, elem := range v {
+			values = append(values, otlpValue(elem))
+		}
+		return map[string]any{"arrayValue": map[string]any{"values": values}}
+	case map[string]any:
+		values := make([]map[string]any, 0, len(v))
+		for key, elem := range v {
+			values = append(values, map[string]any{"key": key, "value": otlpValue(elem)})
+		}
+		return map[string]any{"kvlistValue": map[string]any{"values": values}}
+	default:
+		// null
+		return map[string]any{}
+	}
+}
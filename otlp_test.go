@@ -0,0 +1,126 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestOTLPLogRecord(t *testing.T) {
+	const timestamp = "1714979289000000000"
+	tests := []struct {
+		name         string
+		event        string
+		wantBody     any
+		wantSeverity any
+		wantKeys     []string
+	}{
+		{name: "message and level", event: `{"message":"card declined","level":"error","amount":12}`,
+			wantBody: "card declined", wantSeverity: "error", wantKeys: []string{"amount", "level"}},
+		{name: "msg and severity", event: `{"msg":"started","severity":"INFO"}`,
+			wantBody: "started", wantSeverity: "INFO", wantKeys: []string{"severity"}},
+		{name: "no message", event: `{"a":1}`, wantKeys: []string{"a"}},
+		{name: "not an object", event: ` plain text `, wantBody: "plain text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := otlpLogRecord(json.RawMessage(tt.event), timestamp)
+			if record["timeUnixNano"] != timestamp || record["observedTimeUnixNano"] != timestamp {
+				t.Errorf("record = %v, want the event timestamp", record)
+			}
+			var body any
+			if b, ok := record["body"].(map[string]any); ok {
+				body = b["stringValue"]
+			}
+			if body != tt.wantBody || record["severityText"] != tt.wantSeverity {
+				t.Errorf("body, severity = %v, %v, want %v, %v", body, record["severityText"], tt.wantBody,
+					tt.wantSeverity)
+			}
+			attributes, _ := record["attributes"].([]map[string]any)
+			var keys []string
+			for _, attribute := range attributes {
+				keys = append(keys, attribute["key"].(string))
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("attributes = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestOTLPValue(t *testing.T) {
+	data := `{"s":"a","b":true,"i":9007199254740993,"f":0.5,"l":[1],"m":{"k":"v"},"n":null}`
+	fields, _ := decodeObject([]byte(data))
+	tests := map[string]string{
+		"s": `{"stringValue":"a"}`,
+		"b": `{"boolValue":true}`,
+		"i": `{"intValue":"9007199254740993"}`,
+		"f": `{"doubleValue":0.5}`,
+		"l": `{"arrayValue":{"values":[{"intValue":"1"}]}}`,
+		"m": `{"kvlistValue":{"values":[{"key":"k","value":{"stringValue":"v"}}]}}`,
+		"n": `{}`,
+	}
+	for key, want := range tests {
+		if got, _ := json.Marshal(otlpValue(fields[key])); string(got) != want {
+			t.Errorf("otlpValue(%v) = %s, want %s", fields[key], got, want)
+		}
+	}
+}
+
+func TestHandlerOTLPSink(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	cfg.SinkMode = sinkModeOTLP
+	cfg.OTLPEndpoint = honeycomb.URL + "/v1/logs"
+	cfg.OTLPHeaders = map[string]string{"X-Honeycomb-Team": "otlp-key"}
+	handler, _ := NewHandler(cfg)
+
+	// Each element of an array payload becomes a log record
+	data := `[{"message":"first"},{"message":"second"}]`
+	if err := handler(quietContext(), pubSubEvent(t, PubSubMessage{Data: []byte(data)})); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	requests := honeycomb.received()
+	if len(requests) != 1 || requests[0].Path != "/v1/logs" {
+		t.Fatalf("requests = %+v, want a single OTLP logs request", requests)
+	}
+	header := requests[0].Header
+	if header.Get("X-Honeycomb-Team") != "otlp-key" || header.Get("X-Honeycomb-Dataset") != "test-dataset" ||
+		header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v, want the OTLP headers and the dataset", header)
+	}
+	var payload struct {
+		ResourceLogs []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value struct {
+						StringValue string `json:"stringValue"`
+					} `json:"value"`
+				} `json:"attributes"`
+			} `json:"resource"`
+			ScopeLogs []struct {
+				LogRecords []struct {
+					TimeUnixNano string `json:"timeUnixNano"`
+					Body         struct {
+						StringValue string `json:"stringValue"`
+					} `json:"body"`
+				} `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	if err := json.Unmarshal(requests[0].Body, &payload); err != nil {
+		t.Fatalf("error decoding the OTLP payload %s: %v", requests[0].Body, err)
+	}
+	resource := payload.ResourceLogs[0].Resource.Attributes[0]
+	if resource.Key != "service.name" || resource.Value.StringValue != "test-dataset" {
+		t.Errorf("resource attribute = %+v, want the dataset as service.name", resource)
+	}
+	records := payload.ResourceLogs[0].ScopeLogs[0].LogRecords
+	if len(records) != 2 || records[0].Body.StringValue != "first" || records[1].Body.StringValue != "second" ||
+		records[0].TimeUnixNano != "1714979289000000000" {
+		t.Errorf("log records = %+v, want one per element, at the publish time", records)
+	}
+}
@@ -0,0 +1,58 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const prewarmTimeout = 3 * time.Second
+
+// prewarmURL returns the URL of the host the sink sends the events to.
+func prewarmURL(cfg Config) string {
+	switch cfg.SinkMode {
+	case sinkModeOTLP:
+		return cfg.OTLPEndpoint
+	case sinkModeWebhook:
+		return cfg.WebhookURL
+	default:
+		return cfg.APIURL
+	}
+}
+
+// prewarm opens a connection to the sink's host with a HEAD request, so the DNS lookup and TLS handshake
+// aren't paid by the first event after a cold start: the connection stays in the client's idle pool.
+// Any answer will do, the status is ignored. It gives up after prewarmTimeout, a failure is only logged.
+func prewarm(ctx context.Context, cfg Config) {
+	ctx, cancel := context.WithTimeout(ctx, prewarmTimeout)
+	defer cancel()
+	start := cfg.Clock.Now()
+	target := prewarmURL(cfg)
+	if err := headRequest(ctx, cfg, target); err != nil {
+		cfg.Logger.Warn("error prewarming the connection, the first event will open it", "error", err.Error())
+		return
+	}
+	host := target
+	if u, err := url.Parse(target); err == nil {
+		host = u.Host
+	}
+	cfg.Logger.Info("connection prewarmed", "host", host, "latency_ms", since(cfg.Clock, start).Milliseconds())
+}
+
+func headRequest(ctx context.Context, cfg Config, target string) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", target, nil)
+	if err != nil {
+		return fmt.Errorf("error initializing prewarm request %w", err)
+	}
+	req.Header.Set("User-Agent", cfg.UserAgent)
+	resp, err := cfg.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending prewarm request %w", err)
+	}
+	// The connection only returns to the pool once the body is drained and closed
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
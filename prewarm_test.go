@@ -0,0 +1,71 @@
+package HoneycombSinkHandler
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPrewarmURL(t *testing.T) {
+	cfg := Config{APIURL: "https://api.honeycomb.io", OTLPEndpoint: "https://otlp.example.com/v1/logs",
+		WebhookURL: "https://hooks.example.com"}
+	tests := map[string]string{
+		"":              cfg.APIURL,
+		sinkModeEvents:  cfg.APIURL,
+		sinkModeOTLP:    cfg.OTLPEndpoint,
+		sinkModeWebhook: cfg.WebhookURL,
+	}
+	for mode, want := range tests {
+		cfg.SinkMode = mode
+		if got := prewarmURL(cfg); got != want {
+			t.Errorf("prewarmURL(%q) = %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestPrewarm(t *testing.T) {
+	// Any answer will do
+	honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		acceptAll(w, r, body)
+	})
+	var logs logRecorder
+	cfg := testConfig(honeycomb)
+	cfg.Logger = newRecordingLogger(&logs)
+
+	prewarm(quietContext(), withDefaults(cfg))
+	requests := honeycomb.received()
+	if len(requests) != 1 || requests[0].Method != http.MethodHead || requests[0].Header.Get("User-Agent") == "" {
+		t.Errorf("requests = %+v, want a single HEAD request", requests)
+	}
+	if records := logs.records(t, "connection prewarmed"); len(records) != 1 ||
+		records[0]["host"] != honeycomb.Listener.Addr().String() {
+		t.Errorf("log records = %v, want the prewarmed host logged", records)
+	}
+
+	// The prewarmed connection is reused by the first event
+	handler, _ := NewHandler(cfg)
+	if err := handler(quietContext(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	if requests := honeycomb.received(); requests[1].RemoteAddr != requests[0].RemoteAddr {
+		t.Errorf("event sent from %s, want the connection prewarmed from %s", requests[1].RemoteAddr,
+			requests[0].RemoteAddr)
+	}
+}
+
+func TestPrewarmFailure(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	var logs logRecorder
+	cfg := testConfig(honeycomb)
+	cfg.Logger = newRecordingLogger(&logs)
+	honeycomb.Close()
+
+	prewarm(quietContext(), withDefaults(cfg))
+	records := logs.records(t, "error prewarming the connection, the first event will open it")
+	if len(records) != 1 {
+		t.Errorf("log records = %v, want the failure logged", records)
+	}
+}
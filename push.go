@@ -0,0 +1,116 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// maxPushBodyBytes bounds the push request body: a 10MB PubSub message, base64 encoded, and its envelope.
+const maxPushBodyBytes = 16 << 20
+
+// newPushHandler returns the push target, for the PubSub push subscriptions delivering their messages as
+// a plain POST of {"message": {...}, "subscription": "..."} rather than as an EventArc CloudEvent. The
+// envelope is handed to handler as the CloudEvent EventArc would have delivered, so both trigger styles go
+// through the same decoding, transforms and send path. It answers 204 to ACK the message, 500 to NACK it
+// when it failed transiently. A message failing permanently is logged and ACKed, as a redelivery would
+// fail again.
+// When PushVerifyJWT is set, the requests must carry the OIDC token of the subscription in their
+// Authorization header.
+func newPushHandler(cfg Config, handler func(ctx context.Context, e event.Event) error) http.HandlerFunc {
+	cfg = withDefaults(cfg)
+	var verifier *jwtVerifier
+	if cfg.PushVerifyJWT {
+		verifier = newJWTVerifier(cfg.ServicesHTTPClient, cfg.Clock)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "push messages must be delivered with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if verifier != nil {
+			if err := verifyPushToken(r, cfg, verifier); err != nil {
+				cfg.Logger.Warn("push request refused", "error", err.Error())
+				if errors.Is(err, errInvalidToken) {
+					http.Error(w, "invalid or missing push token", http.StatusUnauthorized)
+				} else {
+					http.Error(w, "push token can't be checked", http.StatusServiceUnavailable)
+				}
+				return
+			}
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPushBodyBytes))
+		if err != nil {
+			http.Error(w, "error reading push request "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		e, err := pushEvent(body, r.Header.Get("traceparent"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := handler(r.Context(), e); err != nil {
+			if !isPermanent(err) || errors.Is(err, ErrTransient) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// PubSub would redeliver the message forever, it is acknowledged like the handler acknowledges
+			// the messages it dead-lettered
+			cfg.Logger.Error("PubSub push message failed permanently, acknowledging it",
+				"message_id", e.ID(), "error", err.Error())
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// verifyPushToken checks the bearer token of a push request. The audience defaults to the URL of the
+// push endpoint, the default of PubSub.
+func verifyPushToken(r *http.Request, cfg Config, verifier *jwtVerifier) error {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return fmt.Errorf("%w: no bearer token", errInvalidToken)
+	}
+	audience := cfg.PushAudience
+	if audience == "" {
+		audience = "https://" + r.Host + r.URL.Path
+	}
+	return verifier.verify(r.Context(), token, audience, cfg.PushServiceAccount)
+}
+
+// pushEvent wraps a push envelope into the CloudEvent EventArc delivers for a PubSub message, the
+// envelopes share the same shape. The message ID is the CloudEvent ID, as with EventArc.
+// See the documentation for more details:
+// https://cloud.google.com/pubsub/docs/push#receive_push
+func pushEvent(body []byte, traceparent string) (event.Event, error) {
+	var envelope struct {
+		Message struct {
+			MessageID string `json:"messageId"`
+		} `json:"message"`
+		Subscription string `json:"subscription"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return event.Event{}, withCategory(fmt.Errorf("error decoding push envelope %w", err), ErrPermanent)
+	}
+	if envelope.Message.MessageID == "" {
+		return event.Event{}, withCategory(errors.New("error push envelope has no message ID"), ErrPermanent)
+	}
+
+	e := event.New()
+	e.SetID(envelope.Message.MessageID)
+	e.SetType(pubSubEventType)
+	e.SetSource("//pubsub.googleapis.com/" + envelope.Subscription)
+	if traceparent != "" {
+		e.SetExtension("traceparent", traceparent)
+	}
+	if err := e.SetData(event.ApplicationJSON, body); err != nil {
+		return event.Event{}, withCategory(fmt.Errorf("error wrapping push envelope %w", err), ErrPermanent)
+	}
+	return e, nil
+}
@@ -0,0 +1,166 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// samplePushBody is a push request body as delivered by a PubSub push subscription.
+var samplePushBody = `{
+  "message": {
+    "attributes": {"team": "payments"},
+    "data": "` + base64.StdEncoding.EncodeToString([]byte(`{"level":"error","msg":"card declined"}`)) + `",
+    "messageId": "2070443601311540",
+    "message_id": "2070443601311540",
+    "publishTime": "2024-05-06T07:08:09.123Z",
+    "publish_time": "2024-05-06T07:08:09.123Z"
+  },
+  "subscription": "projects/my-project/subscriptions/my-push-sub"
+}`
+
+func TestPushEvent(t *testing.T) {
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	e, err := pushEvent([]byte(samplePushBody), traceparent)
+	if err != nil {
+		t.Fatalf("pushEvent() error = %v", err)
+	}
+	if e.ID() != "2070443601311540" || e.Type() != pubSubEventType ||
+		e.Source() != "//pubsub.googleapis.com/projects/my-project/subscriptions/my-push-sub" {
+		t.Errorf("pushEvent() = %s %s %s, want the EventArc CloudEvent of the message", e.ID(), e.Type(), e.Source())
+	}
+	if got := e.Extensions()["traceparent"]; got != traceparent {
+		t.Errorf("traceparent extension = %v, want %s", got, traceparent)
+	}
+
+	msg, err := readPubSubEvent(quietContext(), e, Config{})
+	if err != nil {
+		t.Fatalf("readPubSubEvent() error = %v", err)
+	}
+	if string(msg.Message.Data) != `{"level":"error","msg":"card declined"}` ||
+		msg.Message.Attributes["team"] != "payments" ||
+		msg.Subscription != "projects/my-project/subscriptions/my-push-sub" ||
+		!msg.Message.PublishTime.Equal(time.Date(2024, 5, 6, 7, 8, 9, 123e6, time.UTC)) {
+		t.Errorf("decoded message = %+v, want the push message", msg)
+	}
+
+	for _, body := range []string{`{"message":{"data":"e30="}}`, `not json`} {
+		if _, err := pushEvent([]byte(body), ""); !errors.Is(err, ErrPermanent) {
+			t.Errorf("pushEvent(%s) error = %v, want a permanent error", body, err)
+		}
+	}
+}
+
+func TestPushHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		respond    func(w http.ResponseWriter, r *http.Request, body []byte)
+		wantStatus int
+		wantSent   bool
+	}{
+		{name: "acknowledged", method: http.MethodPost, body: samplePushBody, wantStatus: http.StatusNoContent,
+			wantSent: true},
+		{name: "failed permanently", method: http.MethodPost, body: samplePushBody,
+			respond:    respondWith(http.StatusForbidden, `{"error":"unknown API key"}`),
+			wantStatus: http.StatusNoContent, wantSent: true},
+		{name: "not acknowledged", method: http.MethodPost, body: samplePushBody,
+			respond:    respondWith(http.StatusServiceUnavailable, `{"error":"unavailable"}`),
+			wantStatus: http.StatusInternalServerError, wantSent: true},
+		{name: "malformed envelope", method: http.MethodPost, body: `{"message":`,
+			wantStatus: http.StatusBadRequest},
+		{name: "no message ID", method: http.MethodPost, body: `{"message":{"data":"e30="}}`,
+			wantStatus: http.StatusBadRequest},
+		{name: "GET", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, tt.respond)
+			cfg := testConfig(honeycomb)
+			handler, _ := NewHandler(cfg)
+			push := newPushHandler(cfg, handler)
+
+			rec := httptest.NewRecorder()
+			push(rec, httptest.NewRequest(tt.method, testPushAudience, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if sent := len(honeycomb.received()) > 0; sent != tt.wantSent {
+				t.Fatalf("event sent: %v, want %v", sent, tt.wantSent)
+			}
+			if tt.wantSent {
+				if got := honeycomb.lastEvent(t)["msg"]; got != "card declined" {
+					t.Errorf("forwarded msg = %v, want the push message data", got)
+				}
+			}
+		})
+	}
+}
+
+// redirectTransport sends the requests for host to the target server instead.
+type redirectTransport struct {
+	host   string
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Host == t.host {
+		r = r.Clone(r.Context())
+		r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestPushHandlerJWT(t *testing.T) {
+	certs := newFakeGoogleCerts(t)
+	certsURL, _ := url.Parse(certs.URL)
+	googleCerts, _ := url.Parse(googleCertsURL)
+	clock := newFakeClock(testPublishTime)
+	tests := []struct {
+		name          string
+		authorization string
+		certsDown     bool
+		wantStatus    int
+	}{
+		{name: "valid token", authorization: "Bearer " + certs.token(t, clock.Now()),
+			wantStatus: http.StatusNoContent},
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+		{name: "not a bearer token", authorization: "Basic dXNlcjpwYXNz", wantStatus: http.StatusUnauthorized},
+		{name: "expired token", authorization: "Bearer " + certs.token(t, clock.Now().Add(-2*time.Hour)),
+			wantStatus: http.StatusUnauthorized},
+		{name: "keys unavailable", authorization: "Bearer " + certs.token(t, clock.Now()), certsDown: true,
+			wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certs.failing.Store(tt.certsDown)
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.HTTPClient = &http.Client{Transport: redirectTransport{host: googleCerts.Host, target: certsURL}}
+			cfg.Clock = clock
+			cfg.PushVerifyJWT = true
+			cfg.PushServiceAccount = testPushAccount
+			handler, _ := NewHandler(cfg)
+			push := newPushHandler(cfg, handler)
+
+			r := httptest.NewRequest(http.MethodPost, testPushAudience, strings.NewReader(samplePushBody))
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			push(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d (%s), want %d", rec.Code, strings.TrimSpace(rec.Body.String()), tt.wantStatus)
+			}
+			if sent := len(honeycomb.received()) > 0; sent != (tt.wantStatus == http.StatusNoContent) {
+				t.Errorf("event sent: %v with status %d", sent, rec.Code)
+			}
+		})
+	}
+}
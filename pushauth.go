@@ -0,0 +1,199 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleCertsURL serves the keys Google signs its OIDC tokens with, as a JWK set.
+// See the documentation for more details:
+// https://cloud.google.com/pubsub/docs/authenticate-push-subscriptions
+const googleCertsURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+const (
+	// jwtLeeway tolerates the clock skew with Google when checking the token validity.
+	jwtLeeway = 30 * time.Second
+	// certsRefreshInterval bounds the refreshes of the keys triggered by unknown key IDs.
+	certsRefreshInterval = time.Minute
+	certsCacheDuration   = time.Hour
+)
+
+var errInvalidToken = errors.New("invalid push token")
+
+// jwtClaims are the claims of a Pub/Sub push token checked by the sink.
+type jwtClaims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Expiry        int64  `json:"exp"`
+	IssuedAt      int64  `json:"iat"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// jwtVerifier checks the OIDC tokens Pub/Sub signs the push requests with, caching Google's keys.
+type jwtVerifier struct {
+	client   *http.Client
+	clock    Clock
+	certsURL string
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+func newJWTVerifier(client *http.Client, clock Clock) *jwtVerifier {
+	return &jwtVerifier{client: client, clock: clock, certsURL: googleCertsURL}
+}
+
+// verify checks the token is signed by Google, valid now, for audience and, when email is set, issued to
+// the email service account. Errors match errInvalidToken unless the keys couldn't be fetched.
+func (v *jwtVerifier) verify(ctx context.Context, token string, audience string, email string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: not a JWT", errInvalidToken)
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Algorithm != "RS256" {
+		return fmt.Errorf("%w: unexpected algorithm %q", errInvalidToken, header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", errInvalidToken)
+	}
+	key, err := v.key(ctx, header.KeyID)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return fmt.Errorf("%w: bad signature", errInvalidToken)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return err
+	}
+	now := v.clock.Now()
+	switch {
+	case claims.Issuer != "accounts.google.com" && claims.Issuer != "https://accounts.google.com":
+		return fmt.Errorf("%w: unexpected issuer %q", errInvalidToken, claims.Issuer)
+	case claims.Audience != audience:
+		return fmt.Errorf("%w: unexpected audience %q", errInvalidToken, claims.Audience)
+	case now.After(time.Unix(claims.Expiry, 0).Add(jwtLeeway)):
+		return fmt.Errorf("%w: expired", errInvalidToken)
+	case now.Add(jwtLeeway).Before(time.Unix(claims.IssuedAt, 0)):
+		return fmt.Errorf("%w: issued in the future", errInvalidToken)
+	case email != "" && (claims.Email != email || !claims.EmailVerified):
+		return fmt.Errorf("%w: unexpected service account %q", errInvalidToken, claims.Email)
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, into any) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed part", errInvalidToken)
+	}
+	if err := json.Unmarshal(decoded, into); err != nil {
+		return fmt.Errorf("%w: malformed part", errInvalidToken)
+	}
+	return nil
+}
+
+// key returns the Google public key of the key ID, refreshing the keys when they are stale or the key ID
+// is unknown, as Google rotates them.
+func (v *jwtVerifier) key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.keys[keyID]
+	age := since(v.clock, v.fetchedAt)
+	if (ok && age < certsCacheDuration) || (!ok && age < certsRefreshInterval) {
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key %q", errInvalidToken, keyID)
+		}
+		return key, nil
+	}
+
+	keys, err := fetchGoogleKeys(ctx, v.client, v.certsURL)
+	if err != nil {
+		if ok {
+			// Better a key a little past its cache duration than no check at all
+			return key, nil
+		}
+		return nil, err
+	}
+	v.keys = keys
+	v.fetchedAt = v.clock.Now()
+	if key, ok = keys[keyID]; !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", errInvalidToken, keyID)
+	}
+	return key, nil
+}
+
+// fetchGoogleKeys downloads the JWK set of Google's RSA keys.
+func fetchGoogleKeys(ctx context.Context, client *http.Client, certsURL string) (map[string]*rsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", certsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing google certs request %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching google certs %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading google certs %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching google certs, status %d: %s", resp.StatusCode,
+			summarizeBody(resp.Header.Get("Content-Type"), body))
+	}
+	var set struct {
+		Keys []struct {
+			KeyType  string `json:"kty"`
+			KeyID    string `json:"kid"`
+			Modulus  string `json:"n"`
+			Exponent string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("error decoding google certs %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,217 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testPushAudience = "https://sink.example.com/push"
+	testPushAccount  = "push@my-project.iam.gserviceaccount.com"
+)
+
+// fakeGoogleCerts is an httptest server standing for Google's JWK set endpoint, serving the key the test
+// tokens are signed with. It answers 503 when failing is set.
+type fakeGoogleCerts struct {
+	*httptest.Server
+
+	key     *rsa.PrivateKey
+	keyID   string
+	fetches atomic.Int32
+	failing atomic.Bool
+}
+
+func newFakeGoogleCerts(t *testing.T) *fakeGoogleCerts {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating the signing key: %v", err)
+	}
+	f := &fakeGoogleCerts{key: key, keyID: "key-1"}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.fetches.Add(1)
+		if f.failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		jwk := map[string]string{
+			"kty": "RSA",
+			"kid": f.keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}
+		ecKey := map[string]string{"kty": "EC", "kid": "ec"}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []any{jwk, ecKey}})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// claims returns the claims of a valid push token, issued at now.
+func (f *fakeGoogleCerts) claims(now time.Time) jwtClaims {
+	return jwtClaims{
+		Issuer:        "https://accounts.google.com",
+		Audience:      testPushAudience,
+		Expiry:        now.Add(time.Hour).Unix(),
+		IssuedAt:      now.Unix(),
+		Email:         testPushAccount,
+		EmailVerified: true,
+	}
+}
+
+// sign returns the token of the claims, signed with key under the key ID.
+func (f *fakeGoogleCerts) sign(t *testing.T, claims jwtClaims, key *rsa.PrivateKey, keyID, alg string) string {
+	t.Helper()
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(map[string]string{"alg": alg, "kid": keyID, "typ": "JWT"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("error signing the token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// token returns a valid push token issued at now.
+func (f *fakeGoogleCerts) token(t *testing.T, now time.Time) string {
+	return f.sign(t, f.claims(now), f.key, f.keyID, "RS256")
+}
+
+// verifier returns a verifier fetching the keys from the fake server.
+func (f *fakeGoogleCerts) verifier(clock Clock) *jwtVerifier {
+	v := newJWTVerifier(f.Client(), clock)
+	v.certsURL = f.URL
+	return v
+}
+
+func TestJWTVerifier(t *testing.T) {
+	certs := newFakeGoogleCerts(t)
+	now := testPublishTime
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withClaims := func(change func(c *jwtClaims)) string {
+		claims := certs.claims(now)
+		change(&claims)
+		return certs.sign(t, claims, certs.key, certs.keyID, "RS256")
+	}
+	tests := []struct {
+		name    string
+		token   string
+		email   string
+		wantErr bool
+	}{
+		{name: "valid", token: certs.token(t, now), email: testPushAccount},
+		{name: "any service account", token: withClaims(func(c *jwtClaims) { c.Email = "other@example.com" })},
+		{name: "issuer without scheme", token: withClaims(func(c *jwtClaims) { c.Issuer = "accounts.google.com" })},
+		{name: "expiry within the leeway",
+			token: withClaims(func(c *jwtClaims) { c.Expiry = now.Add(-10 * time.Second).Unix() })},
+		{name: "expired", token: withClaims(func(c *jwtClaims) { c.Expiry = now.Add(-time.Minute).Unix() }),
+			wantErr: true},
+		{name: "issued in the future",
+			token:   withClaims(func(c *jwtClaims) { c.IssuedAt = now.Add(time.Minute).Unix() }),
+			wantErr: true},
+		{name: "other audience", token: withClaims(func(c *jwtClaims) { c.Audience = "https://other.example.com" }),
+			wantErr: true},
+		{name: "other issuer", token: withClaims(func(c *jwtClaims) { c.Issuer = "https://evil.example.com" }),
+			wantErr: true},
+		{name: "other service account", token: withClaims(func(c *jwtClaims) { c.Email = "other@example.com" }),
+			email: testPushAccount, wantErr: true},
+		{name: "unverified email", token: withClaims(func(c *jwtClaims) { c.EmailVerified = false }),
+			email: testPushAccount, wantErr: true},
+		{name: "signed with another key", token: certs.sign(t, certs.claims(now), otherKey, certs.keyID, "RS256"),
+			wantErr: true},
+		{name: "unknown key ID", token: certs.sign(t, certs.claims(now), certs.key, "key-2", "RS256"), wantErr: true},
+		{name: "other algorithm", token: certs.sign(t, certs.claims(now), certs.key, certs.keyID, "none"),
+			wantErr: true},
+		{name: "not a JWT", token: "not-a-token", wantErr: true},
+		{name: "malformed token", token: "eyJhbGciOiJSUzI1NiJ9.!!!.c2ln", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := certs.verifier(newFakeClock(now))
+			err := verifier.verify(context.Background(), tt.token, testPushAudience, tt.email)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("verify() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, errInvalidToken) {
+				t.Errorf("verify() error = %v, want an invalid token", err)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierKeyCache(t *testing.T) {
+	certs := newFakeGoogleCerts(t)
+	clock := newFakeClock(testPublishTime)
+	verifier := certs.verifier(clock)
+	verify := func(token string) error {
+		return verifier.verify(context.Background(), token, testPushAudience, "")
+	}
+	wantFetches := func(want int32) {
+		t.Helper()
+		if got := certs.fetches.Load(); got != want {
+			t.Errorf("%d key fetches, want %d", got, want)
+		}
+	}
+
+	if err := verify(certs.token(t, clock.Now())); err != nil {
+		t.Fatalf("verify() error = %v", err)
+	}
+	wantFetches(1)
+
+	// An unknown key ID refreshes the keys, at most once per interval
+	unknown := certs.sign(t, certs.claims(clock.Now()), certs.key, "rotated", "RS256")
+	if err := verify(unknown); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("verify() error = %v, want an invalid token", err)
+	}
+	wantFetches(1)
+	clock.Advance(certsRefreshInterval)
+	if err := verify(unknown); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("verify() error = %v, want an invalid token", err)
+	}
+	wantFetches(2)
+
+	clock.Advance(30 * time.Minute)
+	if err := verify(certs.token(t, clock.Now())); err != nil {
+		t.Fatalf("verify() with cached keys error = %v", err)
+	}
+	wantFetches(2)
+
+	// Stale keys are refreshed, and still used while Google can't be reached
+	clock.Advance(certsCacheDuration)
+	certs.failing.Store(true)
+	if err := verify(certs.token(t, clock.Now())); err != nil {
+		t.Errorf("verify() with stale keys error = %v, want the stale key used", err)
+	}
+	wantFetches(3)
+
+	// Without any key, the failure isn't the token's
+	verifier = certs.verifier(clock)
+	err := verify(certs.token(t, clock.Now()))
+	if err == nil || errors.Is(err, errInvalidToken) {
+		t.Errorf("verify() error = %v, want a key fetch error", err)
+	}
+}
@@ -0,0 +1,34 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"golang.org/x/time/rate"
+)
+
+// newRateLimiter returns a token bucket refilled with limit events per second, holding up to burst
+// events, or the limit rounded up when burst is 0.
+func newRateLimiter(limit float64, burst int) *rate.Limiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(limit))
+	}
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+// waitRateLimit waits until the limiter allows sending events, or ctx is done. A request carrying more
+// events than the burst waits for them burst by burst. It returns straight away when limiter is nil.
+func waitRateLimit(ctx context.Context, limiter *rate.Limiter, events int) error {
+	if limiter == nil {
+		return nil
+	}
+	for events > 0 {
+		n := min(events, limiter.Burst())
+		if err := limiter.WaitN(ctx, n); err != nil {
+			return withCategory(fmt.Errorf("error waiting for the honeycomb rate limit %w", err), ErrTransient)
+		}
+		events -= n
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitRateLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    float64
+		burst    int
+		requests int
+		events   int // per request
+	}{
+		{name: "single events", limit: 40, burst: 2, requests: 12, events: 1},
+		{name: "batches over the burst", limit: 100, burst: 5, requests: 3, events: 12},
+		{name: "default burst", limit: 20, requests: 30, events: 1}, // burst 20
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter(tt.limit, tt.burst)
+			burst := limiter.Burst()
+			start := time.Now()
+			for i := 0; i < tt.requests; i++ {
+				if err := waitRateLimit(context.Background(), limiter, tt.events); err != nil {
+					t.Fatalf("waitRateLimit() error = %v", err)
+				}
+			}
+			elapsed := time.Since(start)
+			// The bucket starts full: only the events over the burst wait for tokens
+			total := tt.requests * tt.events
+			minElapsed := time.Duration(float64(total-burst) / tt.limit * float64(time.Second))
+			if elapsed < minElapsed-10*time.Millisecond {
+				t.Errorf("%d events sent in %v, want at least %v at %v/s with a burst of %d",
+					total, elapsed, minElapsed, tt.limit, burst)
+			}
+		})
+	}
+}
+
+func TestWaitRateLimitContext(t *testing.T) {
+	if err := waitRateLimit(context.Background(), nil, 1000); err != nil {
+		t.Errorf("waitRateLimit() without limiter error = %v, want nil", err)
+	}
+
+	limiter := newRateLimiter(1, 1)
+	if err := waitRateLimit(context.Background(), limiter, 1); err != nil {
+		t.Fatalf("waitRateLimit() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := waitRateLimit(ctx, limiter, 1)
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("waitRateLimit() error = %v, want a transient error when the deadline is exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("waitRateLimit() returned after %v, want it to give up with the context", elapsed)
+	}
+}
+
+func TestHandlerRateLimit(t *testing.T) {
+	const (
+		limit    = 50.0
+		burst    = 2
+		messages = 22
+	)
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	cfg.RateLimit = limit
+	cfg.RateBurst = burst
+	handler, _ := NewHandler(cfg)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < messages; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := handler(context.Background(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})); err != nil {
+				t.Errorf("handler error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if n := len(honeycomb.received()); n != messages {
+		t.Fatalf("%d events received, want %d", n, messages)
+	}
+	// Concurrent invocations share the bucket: past the burst, the rate stays under the cap
+	measured := float64(messages-burst) / elapsed.Seconds()
+	if measured > limit*1.05 {
+		t.Errorf("measured rate %.1f events/s over the %.0f events/s cap (%d events in %v)",
+			measured, limit, messages, elapsed)
+	}
+}
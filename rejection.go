@@ -0,0 +1,84 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Reasons Honeycomb rejects an event for with a 400, as classified from its error message.
+const (
+	rejectOversized      = "oversized"
+	rejectTooManyColumns = "too_many_columns"
+	rejectBadTimestamp   = "bad_timestamp"
+	rejectMalformed      = "malformed"
+	rejectBadDataset     = "bad_dataset"
+	rejectOther          = "other"
+)
+
+// rejectionPatterns map fragments of the Honeycomb error messages to their reason, the first match wins.
+var rejectionPatterns = []struct {
+	fragment string
+	reason   string
+}{
+	{"column", rejectTooManyColumns},
+	{"too large", rejectOversized},
+	{"too big", rejectOversized},
+	{"exceeds", rejectOversized},
+	{"size", rejectOversized},
+	{"timestamp", rejectBadTimestamp},
+	{"time", rejectBadTimestamp},
+	{"dataset", rejectBadDataset},
+	{"json", rejectMalformed},
+	{"malformed", rejectMalformed},
+	{"parse", rejectMalformed},
+	{"invalid", rejectMalformed},
+}
+
+// classifyRejection returns the reason of a Honeycomb rejection from its error message.
+func classifyRejection(message string) string {
+	message = strings.ToLower(message)
+	for _, pattern := range rejectionPatterns {
+		if strings.Contains(message, pattern.fragment) {
+			return pattern.reason
+		}
+	}
+	return rejectOther
+}
+
+// rejectionMessage returns the error message of a Honeycomb 400 body, {"error": "..."}, or the body
+// itself when it isn't one.
+func rejectionMessage(body []byte) string {
+	var decoded struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &decoded); err == nil && decoded.Error != "" {
+		return decoded.Error
+	}
+	return string(body)
+}
+
+// rejectionReason returns the reason Honeycomb rejected the event for, or "" when the error isn't a
+// rejection: a 400 response, or the 400 statuses of a batch.
+func rejectionReason(err error) string {
+	var statusErr *honeycombStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Reason
+	}
+	var batchErr *batchError
+	if errors.As(err, &batchErr) {
+		return batchErr.mainCause()
+	}
+	return ""
+}
+
+// mainCause returns the reason most of the events of the batch were rejected for with a 400.
+func (e *batchError) mainCause() string {
+	main := ""
+	for reason, count := range e.Causes {
+		if count > e.Causes[main] || (count == e.Causes[main] && reason < main) {
+			main = reason
+		}
+	}
+	return main
+}
@@ -0,0 +1,128 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassifyRejection(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{body: `{"error":"request body is too large"}`, want: rejectOversized},
+		{body: `{"error":"event exceeds max event size of 1000000 bytes"}`, want: rejectOversized},
+		{body: `{"error":"event has too many columns, max 10000"}`, want: rejectTooManyColumns},
+		{body: `{"error":"dataset has too many columns"}`, want: rejectTooManyColumns},
+		{body: `{"error":"unable to parse timestamp: 2024-13-45"}`, want: rejectBadTimestamp},
+		{body: `{"error":"event time is too far in the past"}`, want: rejectBadTimestamp},
+		{body: `{"error":"request body should be a JSON object"}`, want: rejectMalformed},
+		{body: `{"error":"unexpected EOF, malformed body"}`, want: rejectMalformed},
+		{body: `{"error":"invalid dataset name"}`, want: rejectBadDataset},
+		{body: `{"error":"unknown problem"}`, want: rejectOther},
+		{body: `{"error":""}`, want: rejectOther},
+		{body: `Bad Request`, want: rejectOther},
+		{body: `Request Entity Too Large`, want: rejectOversized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			if got := classifyRejection(rejectionMessage([]byte(tt.body))); got != tt.want {
+				t.Errorf("classifyRejection() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectionReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "400", err: fmt.Errorf("wrapped: %w",
+			&honeycombStatusError{StatusCode: http.StatusBadRequest, Reason: rejectBadTimestamp}),
+			want: rejectBadTimestamp},
+		{name: "other status", err: &honeycombStatusError{StatusCode: http.StatusUnauthorized}},
+		{name: "batch", err: &batchError{Causes: map[string]int{rejectOversized: 1, rejectTooManyColumns: 3}},
+			want: rejectTooManyColumns},
+		{name: "batch tie", err: &batchError{Causes: map[string]int{rejectOversized: 2, rejectMalformed: 2}},
+			want: rejectMalformed},
+		{name: "batch without 400", err: &batchError{}},
+		{name: "network", err: errors.New("connection refused")},
+		{name: "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rejectionReason(tt.err); got != tt.want {
+				t.Errorf("rejectionReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordTooManyColumns(t *testing.T) {
+	clock := newFakeClock(testPublishTime)
+	m := newMetricsRegistry("http://collector", nil, time.Minute, http.DefaultClient, clock)
+	m.recordSend(time.Millisecond, &honeycombStatusError{StatusCode: http.StatusBadRequest, Dataset: "wide",
+		Reason: rejectTooManyColumns})
+	m.recordSend(time.Millisecond, &honeycombStatusError{StatusCode: http.StatusBadRequest, Dataset: "wide",
+		Reason: rejectOversized})
+	m.recordSend(time.Millisecond, &batchError{Dataset: "batched",
+		Causes: map[string]int{rejectTooManyColumns: 2, rejectMalformed: 1}})
+	if m.wideEvents["wide"] != 1 || m.wideEvents["batched"] != 2 {
+		t.Errorf("too many columns counts = %v, want wide:1 batched:2", m.wideEvents)
+	}
+}
+
+func TestHandlerRejectionReason(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		respond    func(w http.ResponseWriter, r *http.Request, body []byte)
+		wantReason string
+	}{
+		{name: "event", data: `{"a":1}`,
+			respond:    respondWith(http.StatusBadRequest, `{"error":"event has too many columns"}`),
+			wantReason: rejectTooManyColumns},
+		{name: "batch", data: `[{"a":1},{"b":2}]`,
+			respond: respondWith(http.StatusOK,
+				`[{"status":400,"error":"request body is too large"},{"status":202}]`),
+			wantReason: rejectOversized},
+		{name: "not a rejection", data: `{"a":1}`,
+			respond: respondWith(http.StatusUnauthorized, `{"error":"unknown API key"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, tt.respond)
+			var logs logRecorder
+			cfg := testConfig(honeycomb)
+			cfg.Logger = newRecordingLogger(&logs)
+
+			err := handleData(t, cfg, tt.data)
+			if !errors.Is(err, ErrPermanent) {
+				t.Fatalf("handler error = %v, want a permanent error", err)
+			}
+			if got := rejectionReason(err); got != tt.wantReason {
+				t.Errorf("rejection reason = %q, want %q", got, tt.wantReason)
+			}
+			records := logs.records(t, "event rejected by Honeycomb")
+			if tt.wantReason == "" {
+				if len(records) != 0 {
+					t.Errorf("rejection logs = %v, want none", records)
+				}
+				return
+			}
+			if len(records) == 0 {
+				t.Fatal("no rejection log")
+			}
+			for _, record := range records {
+				if record["reason"] != tt.wantReason || record["dataset"] != "test-dataset" {
+					t.Errorf("rejection log = %v, want the %s reason", record, tt.wantReason)
+				}
+			}
+		})
+	}
+}
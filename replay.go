@@ -0,0 +1,156 @@
+package HoneycombSinkHandler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// maxReplayLineBytes bounds the size of an event read by a replay.
+const maxReplayLineBytes = maxDecompressedBytes
+
+// replayStats counts the outcome of a replay.
+type replayStats struct {
+	Read   int `json:"read"`
+	Failed int `json:"failed"`
+}
+
+// ReplayFromReader forwards the newline-delimited JSON events read from r through the same filters,
+// transforms and send path as the PubSub messages, e.g: to backfill the events of an outage from a file.
+// Blank lines are skipped. Up to ReplayConcurrency events are sent in parallel, at most ReplayRateLimit
+// per second, and events are buffered into batches when BatchMaxEvents is set. A failed event doesn't stop
+// the replay: the failures are logged, and counted in the returned error.
+func ReplayFromReader(ctx context.Context, cfg Config, r io.Reader) error {
+	stats, err := replay(ctx, cfg, r)
+	if err != nil {
+		return err
+	}
+	if stats.Failed > 0 {
+		return fmt.Errorf("error replay failed for %d/%d events", stats.Failed, stats.Read)
+	}
+	return nil
+}
+
+func replay(ctx context.Context, cfg Config, r io.Reader) (replayStats, error) {
+	cfg = withDefaults(cfg)
+	if cfg.BatchMaxEvents > 0 {
+		if cfg.BatchFlushInterval <= 0 {
+			cfg.BatchFlushInterval = defaultBatchFlushInterval
+		}
+		cfg.batcher = newBatcher(cfg)
+	}
+	if cfg.Sink == nil {
+		cfg.Sink = newSink(cfg)
+	}
+	workers := cfg.ReplayConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	var limiter *rate.Limiter
+	if cfg.ReplayRateLimit > 0 {
+		limiter = newRateLimiter(cfg.ReplayRateLimit, 0)
+	}
+
+	type replayLine struct {
+		number int
+		data   []byte
+	}
+	lines := make(chan replayLine)
+	var (
+		mu    sync.Mutex
+		stats replayStats
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range lines {
+				err := waitRateLimit(ctx, limiter, 1)
+				if err == nil {
+					err = forward(ctx, cfg, MessagePublishedData{Message: PubSubMessage{Data: line.data}}, traceContext{})
+				}
+				if err != nil {
+					loggerFrom(ctx).Warn("error replaying event", "line", line.number, "error", err.Error())
+					mu.Lock()
+					stats.Failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxReplayLineBytes)
+	number := 0
+	for scanner.Scan() && ctx.Err() == nil {
+		number++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		stats.Read++
+		lines <- replayLine{number: number, data: bytes.Clone(data)}
+	}
+	close(lines)
+	wg.Wait()
+
+	loggerFrom(ctx).Info("replay done", "read", stats.Read, "failed", stats.Failed)
+	if err := scanner.Err(); err != nil {
+		return stats, fmt.Errorf("error reading the replayed events after line %d %w", number, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return stats, fmt.Errorf("error replay interrupted after line %d %w", number, err)
+	}
+	return stats, nil
+}
+
+// newReplayHandler returns the replay target: it forwards the NDJSON events of the GCS object named by
+// ?object=gs://<bucket>/<object>, or of the request body, and answers with the replay counts. The target
+// must not allow unauthenticated invocations.
+func newReplayHandler(cfg Config, cfgErr error) http.HandlerFunc {
+	cfg = withDefaults(cfg)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfgErr != nil {
+			http.Error(w, cfgErr.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "replay must be triggered with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		events := io.Reader(r.Body)
+		if object := r.URL.Query().Get("object"); object != "" {
+			reader, err := openGCSObject(r.Context(), cfg.ServicesHTTPClient, cfg.Clock, object)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			defer reader.Close()
+			events = reader
+		}
+
+		stats, err := replay(r.Context(), cfg, events)
+		status := http.StatusOK
+		report := struct {
+			replayStats
+			Error string `json:"error,omitempty"`
+		}{replayStats: stats}
+		if err != nil {
+			report.Error = err.Error()
+		}
+		if err != nil || stats.Failed > 0 {
+			status = http.StatusInternalServerError
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
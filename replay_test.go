@@ -0,0 +1,113 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rejectFailing answers the events holding "fail" with a 400, the others with a 200.
+func rejectFailing(w http.ResponseWriter, r *http.Request, body []byte) {
+	if bytes.Contains(body, []byte("fail")) {
+		respondWith(http.StatusBadRequest, `{"error":"invalid event"}`)(w, r, body)
+		return
+	}
+	acceptAll(w, r, body)
+}
+
+func TestReplayFromReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		batch       int
+		respond     func(w http.ResponseWriter, r *http.Request, body []byte)
+		events      string
+	}{
+		{name: "sequential", respond: rejectFailing, events: "{\"n\":1}\n\n  {\"n\":2,\"fail\":true}\n{\"n\":3}\n"},
+		{name: "concurrent", concurrency: 3, respond: rejectFailing,
+			events: "{\"n\":1}\n\n  {\"n\":2,\"fail\":true}\n{\"n\":3}\n"},
+		{name: "batched", concurrency: 3, batch: 3, respond: rejectBad,
+			events: "{\"n\":1}\n\n  {\"n\":2,\"bad\":true}\n{\"n\":3}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, tt.respond)
+			cfg := testConfig(honeycomb)
+			cfg.ReplayConcurrency = tt.concurrency
+			cfg.BatchMaxEvents = tt.batch
+			cfg.BatchFlushInterval = time.Hour
+
+			// A failed event doesn't stop the replay, blank lines aren't events
+			err := ReplayFromReader(quietContext(), cfg, strings.NewReader(tt.events))
+			if want := "error replay failed for 1/3 events"; err == nil || err.Error() != want {
+				t.Errorf("ReplayFromReader() error = %v, want %q", err, want)
+			}
+			var sent []string
+			for _, r := range honeycomb.received() {
+				sent = append(sent, string(r.Body))
+			}
+			sort.Strings(sent)
+			if tt.batch == 0 && (len(sent) != 3 || sent[0] != `{"n":1}` || sent[2] != `{"n":3}`) {
+				t.Errorf("sent = %v, want every event sent", sent)
+			}
+			if tt.batch > 0 && len(sent) != 1 {
+				t.Errorf("sent = %v, want the events sent in a single batch", sent)
+			}
+		})
+	}
+}
+
+func TestReplayFromReaderLineTooLong(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	events := `{"n":1}` + "\n" + `{"pad":"` + strings.Repeat("x", maxReplayLineBytes) + `"}`
+	err := ReplayFromReader(quietContext(), testConfig(honeycomb), strings.NewReader(events))
+	if err == nil || !strings.Contains(err.Error(), "error reading the replayed events after line 1") {
+		t.Errorf("ReplayFromReader() error = %v, want the line too long reported", err)
+	}
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("%d events sent, want the events before the long line sent", n)
+	}
+}
+
+func TestReplayHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		cfgErr     error
+		wantStatus int
+		wantStats  replayStats
+	}{
+		{name: "replayed", method: http.MethodPost, body: "{\"n\":1}\n{\"n\":2}\n", wantStatus: http.StatusOK,
+			wantStats: replayStats{Read: 2}},
+		{name: "failed event", method: http.MethodPost, body: "{\"n\":1}\n{\"fail\":true}\n",
+			wantStatus: http.StatusInternalServerError, wantStats: replayStats{Read: 2, Failed: 1}},
+		{name: "GET", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+		{name: "invalid configuration", method: http.MethodPost, cfgErr: errors.New("error, invalid"),
+			wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, rejectFailing)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)).WithContext(quietContext())
+			newReplayHandler(testConfig(honeycomb), tt.cfgErr)(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK && tt.wantStatus != http.StatusInternalServerError {
+				return
+			}
+			var stats replayStats
+			if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || stats != tt.wantStats {
+				t.Errorf("report = %s (%v), want %+v", rec.Body, err, tt.wantStats)
+			}
+		})
+	}
+}
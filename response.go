@@ -0,0 +1,91 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxBatchResponseBytes caps the successful responses, a batch response holds the statuses of up to
+// thousands of events.
+const maxBatchResponseBytes = 1 << 20
+
+// readResponseBody reads at most limit bytes of a response body, and reports whether it was longer.
+func readResponseBody(body io.Reader, limit int) ([]byte, bool, error) {
+	data, err := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	if len(data) > limit {
+		return data[:limit], true, err
+	}
+	return data, false, err
+}
+
+// maxErrorBodyChars caps the response body kept in the errors, proxies and load balancers may
+// answer with whole HTML pages.
+const maxErrorBodyChars = 512
+
+var (
+	htmlTitlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlSkipPattern  = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	htmlTagPattern   = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// summarizeBody returns a readable and bounded summary of an error response body, according to its
+// content type: compacted JSON, the title (or the text) of an HTML page, or the text with its
+// whitespace collapsed. Binary bodies are only described.
+func summarizeBody(contentType string, body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return "empty body"
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	if strings.Contains(mediaType, "json") || json.Valid(body) {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, body); err == nil {
+			return truncateText(compacted.String(), maxErrorBodyChars)
+		}
+	}
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" || looksLikeHTML(body) {
+		if title := htmlTitlePattern.FindSubmatch(body); title != nil {
+			if text := htmlText(title[1]); text != "" {
+				return fmt.Sprintf("html page %q (%d bytes)", truncateText(text, maxErrorBodyChars), len(body))
+			}
+		}
+		text := htmlText(htmlSkipPattern.ReplaceAll(body, nil))
+		return fmt.Sprintf("html page: %s (%d bytes)", truncateText(text, maxErrorBodyChars), len(body))
+	}
+	if !utf8.Valid(body) {
+		if mediaType == "" {
+			mediaType = "unknown type"
+		}
+		return fmt.Sprintf("%d bytes of %s", len(body), mediaType)
+	}
+	return truncateText(strings.Join(strings.Fields(string(body)), " "), maxErrorBodyChars)
+}
+
+// looksLikeHTML reports whether a body without a content type is an HTML page.
+func looksLikeHTML(body []byte) bool {
+	prefix := bytes.ToLower(body[:min(len(body), 64)])
+	return bytes.HasPrefix(prefix, []byte("<!doctype html")) || bytes.HasPrefix(prefix, []byte("<html"))
+}
+
+// htmlText strips the tags of an HTML fragment and collapses its whitespace.
+func htmlText(fragment []byte) string {
+	text := html.UnescapeString(string(htmlTagPattern.ReplaceAll(fragment, []byte(" "))))
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// truncateText cuts text to at most limit characters, marking the cut.
+func truncateText(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:limit]) + "…"
+}
@@ -0,0 +1,70 @@
+package HoneycombSinkHandler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSummarizeBody(t *testing.T) {
+	longText := strings.Repeat("word ", 200)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{name: "empty", body: "  \n", want: "empty body"},
+		{name: "JSON compacted", contentType: "application/json", body: "{\n  \"error\": \"unknown API key\"\n}",
+			want: `{"error":"unknown API key"}`},
+		{name: "JSON without content type", body: `{"error": "bad"}`, want: `{"error":"bad"}`},
+		{name: "HTML title", contentType: "text/html; charset=utf-8",
+			body: "<!DOCTYPE html><html><head><title>502 Bad &amp; Gateway</title><style>p{}</style></head>" +
+				"<body><p>nginx</p></body></html>",
+			want: `html page "502 Bad & Gateway" (120 bytes)`},
+		{name: "HTML text without title",
+			body: "<html><script>var x = 1;</script><body><h1>Not  Found</h1></body></html>",
+			want: "html page: Not Found (72 bytes)"},
+		{name: "plain text whitespace collapsed", contentType: "text/plain", body: "upstream\n\n  connect error",
+			want: "upstream connect error"},
+		{name: "binary", contentType: "application/octet-stream", body: "\xff\xfe\x00\x01",
+			want: "4 bytes of application/octet-stream"},
+		{name: "binary without content type", body: "\xff\xfe\x00", want: "3 bytes of unknown type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeBody(tt.contentType, []byte(tt.body)); got != tt.want {
+				t.Errorf("summarizeBody() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	got := summarizeBody("text/plain", []byte(longText))
+	if n := utf8.RuneCountInString(got); n != maxErrorBodyChars+1 || !strings.HasSuffix(got, "…") {
+		t.Errorf("summarizeBody() of %d bytes = %d characters, want it cut at %d",
+			len(longText), n, maxErrorBodyChars)
+	}
+}
+
+func TestHandlerHTMLErrorBody(t *testing.T) {
+	page := "<!DOCTYPE html><html><head><title>404 Not Found</title></head><body>" +
+		strings.Repeat("<p>The requested URL was not found on this server.</p>", 100) + "</body></html>"
+	honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, _ []byte) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(page))
+	})
+
+	err := handleData(t, testConfig(honeycomb), `{"a":1}`)
+	if !errors.Is(err, ErrPermanent) {
+		t.Fatalf("handler error = %v, want a permanent error", err)
+	}
+	if !strings.Contains(err.Error(), `html page "404 Not Found"`) {
+		t.Errorf("error %q doesn't summarize the HTML page", err)
+	}
+	if strings.Contains(err.Error(), "<p>") || len(err.Error()) > 2*maxErrorBodyChars {
+		t.Errorf("error holds the raw page (%d bytes): %q", len(err.Error()), err)
+	}
+}
@@ -0,0 +1,141 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// selfTestField marks the self-test events, filter them out of the queries with
+// "_sink_selftest does-not-exist".
+const selfTestField = "_sink_selftest"
+
+// Failures reported by SelfTest.
+const (
+	selfTestAuth        = "auth"
+	selfTestDataset     = "dataset"
+	selfTestRateLimited = "rate_limited"
+	selfTestAPI         = "api"
+	selfTestConfig      = "config"
+	selfTestRejected    = "rejected"
+	selfTestNetwork     = "network"
+)
+
+// SelfTestError is returned by SelfTest when a dataset didn't accept the self-test event. Failure tells
+// what went wrong: "auth" (API key refused), "dataset" (dataset unknown or invalid), "rate_limited",
+// "api" (any other response), "config" (invalid configuration), "rejected" (the event failed permanently
+// without a Honeycomb status, e.g: rejected within a batch or by another destination) or "network"
+// (Honeycomb not reached).
+type SelfTestError struct {
+	Dataset string
+	Failure string
+	Err     error
+}
+
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("error self-test of dataset %q failed (%s): %v", e.Dataset, e.Failure, e.Err)
+}
+
+func (e *SelfTestError) Unwrap() error {
+	return e.Err
+}
+
+// SelfTest sends a synthetic event, marked with "_sink_selftest": true, to each configured dataset
+// through the configured sink, and returns a SelfTestError for the first one which didn't answer with a
+// 2xx. The event skips the filters and transforms, and isn't retried. It is sent even on a dry run.
+func SelfTest(ctx context.Context, cfg Config) error {
+	cfg = withDefaults(cfg)
+	cfg.DryRun = false
+	cfg.MaxRetries = 0
+	if cfg.Sink == nil {
+		cfg.Sink = newSink(cfg)
+	}
+	now := cfg.Clock.Now()
+	data, err := json.Marshal(map[string]any{
+		selfTestField: true,
+		"message":     "gcp-sink-to-honeycomb self-test",
+		"version":     Version,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding self-test event %w", err)
+	}
+
+	datasets := configuredDatasets(cfg)
+	if len(datasets) == 0 {
+		return errors.New("error self-test needs a configured dataset")
+	}
+	for _, dataset := range datasets {
+		event := Event{
+			PubSub:  MessagePublishedData{Message: PubSubMessage{Data: data, PublishTime: now}},
+			Dataset: dataset,
+		}
+		if err := cfg.Sink.Send(ctx, event); err != nil {
+			return &SelfTestError{Dataset: dataset, Failure: selfTestFailure(err), Err: err}
+		}
+	}
+	return nil
+}
+
+// selfTestFailure classifies the error of a self-test send: by its Honeycomb status, by its category
+// otherwise. Only the errors of no other category are network failures.
+func selfTestFailure(err error) string {
+	var statusErr *honeycombStatusError
+	switch {
+	case errors.As(err, &statusErr):
+	case errors.Is(err, ErrConfig):
+		return selfTestConfig
+	case errors.Is(err, ErrRateLimited):
+		return selfTestRateLimited
+	case errors.Is(err, ErrPermanent):
+		return selfTestRejected
+	default:
+		return selfTestNetwork
+	}
+	switch statusErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return selfTestAuth
+	case http.StatusBadRequest, http.StatusNotFound:
+		return selfTestDataset
+	case http.StatusTooManyRequests:
+		return selfTestRateLimited
+	}
+	return selfTestAPI
+}
+
+// newSelfTestHandler returns the self-test target: it runs SelfTest and answers 200 when every configured
+// dataset accepted the event, 502 with the failure otherwise. The target must not allow unauthenticated
+// invocations.
+func newSelfTestHandler(cfg Config, cfgErr error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfgErr != nil {
+			http.Error(w, cfgErr.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "self-test must be triggered with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		report := struct {
+			Status  string `json:"status"`
+			Dataset string `json:"dataset,omitempty"`
+			Failure string `json:"failure,omitempty"`
+			Error   string `json:"error,omitempty"`
+		}{Status: "ok"}
+		status := http.StatusOK
+		if err := SelfTest(r.Context(), cfg); err != nil {
+			report.Status = "failed"
+			report.Error = err.Error()
+			var selfTestErr *SelfTestError
+			if errors.As(err, &selfTestErr) {
+				report.Dataset = selfTestErr.Dataset
+				report.Failure = selfTestErr.Failure
+			}
+			status = http.StatusBadGateway
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,156 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSelfTestFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "unauthorized", err: &honeycombStatusError{StatusCode: http.StatusUnauthorized},
+			want: selfTestAuth},
+		{name: "unknown dataset", err: &honeycombStatusError{StatusCode: http.StatusNotFound},
+			want: selfTestDataset},
+		{name: "429", err: &honeycombStatusError{StatusCode: http.StatusTooManyRequests},
+			want: selfTestRateLimited},
+		{name: "5xx", err: &honeycombStatusError{StatusCode: http.StatusBadGateway}, want: selfTestAPI},
+		{name: "wrapped status", err: fmt.Errorf("error sending %w", &honeycombStatusError{StatusCode: 403}),
+			want: selfTestAuth},
+		{name: "configuration", err: withCategory(errors.New("error invalid setting"), ErrConfig),
+			want: selfTestConfig},
+		{name: "rate limited", err: withCategory(errors.New("error rate limit wait"), ErrRateLimited),
+			want: selfTestRateLimited},
+		{name: "batch rejection", err: &batchError{Dataset: "test-dataset", Rejected: 1, Total: 1},
+			want: selfTestRejected},
+		{name: "invalid dataset", err: withCategory(errors.New("error invalid dataset"), ErrPermanent),
+			want: selfTestRejected},
+		{name: "network", err: withCategory(errors.New("error connection refused"), ErrTransient),
+			want: selfTestNetwork},
+		{name: "unclassified", err: errors.New("error"), want: selfTestNetwork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selfTestFailure(tt.err); got != tt.want {
+				t.Errorf("selfTestFailure(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	tests := []struct {
+		name        string
+		respond     func(w http.ResponseWriter, r *http.Request, body []byte)
+		batch       bool
+		wantDataset string
+		wantFailure string
+	}{
+		{name: "accepted"},
+		{name: "dataset refused", respond: func(w http.ResponseWriter, r *http.Request, body []byte) {
+			if strings.HasSuffix(r.URL.Path, "/team-a") {
+				respondWith(http.StatusNotFound, `{"error":"unknown dataset"}`)(w, r, body)
+				return
+			}
+			acceptAll(w, r, body)
+		}, wantDataset: "team-a", wantFailure: selfTestDataset},
+		{name: "rejected within the batch", batch: true,
+			respond:     respondWith(http.StatusOK, `[{"status":400,"error":"invalid event"}]`),
+			wantDataset: "test-dataset", wantFailure: selfTestRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, tt.respond)
+			cfg := testConfig(honeycomb)
+			cfg.FanOutDatasets = []string{"team-a"}
+			cfg.Batch = tt.batch
+			cfg.DryRun = true
+
+			err := SelfTest(quietContext(), cfg)
+			var selfTestErr *SelfTestError
+			if tt.wantFailure == "" {
+				if err != nil {
+					t.Fatalf("SelfTest() error = %v", err)
+				}
+				if n := len(honeycomb.received()); n != 2 {
+					t.Errorf("%d self-test events sent, want one per dataset, even on a dry run", n)
+				}
+				if got := honeycomb.lastEvent(t)[selfTestField]; got != true {
+					t.Errorf("%s = %v, want true", selfTestField, got)
+				}
+				return
+			}
+			if !errors.As(err, &selfTestErr) || selfTestErr.Dataset != tt.wantDataset ||
+				selfTestErr.Failure != tt.wantFailure {
+				t.Errorf("SelfTest() error = %v, want a %s failure of %s", err, tt.wantFailure, tt.wantDataset)
+			}
+		})
+	}
+
+	// Honeycomb not reached
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	honeycomb.Close()
+	var selfTestErr *SelfTestError
+	err := SelfTest(quietContext(), cfg)
+	if !errors.As(err, &selfTestErr) || selfTestErr.Failure != selfTestNetwork {
+		t.Errorf("SelfTest() error = %v, want a network failure", err)
+	}
+}
+
+func TestSelfTestHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		cfgErr      error
+		status      int
+		wantStatus  int
+		wantFailure string
+	}{
+		{name: "passed", method: http.MethodPost, wantStatus: http.StatusOK},
+		{name: "failed", method: http.MethodPost, status: http.StatusUnauthorized,
+			wantStatus: http.StatusBadGateway, wantFailure: selfTestAuth},
+		{name: "GET", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+		{name: "invalid configuration", method: http.MethodPost, cfgErr: errors.New("error, invalid"),
+			wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+				if tt.status != 0 {
+					respondWith(tt.status, `{"error":"unknown API key"}`)(w, r, body)
+					return
+				}
+				acceptAll(w, r, body)
+			})
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/", nil).WithContext(quietContext())
+			newSelfTestHandler(testConfig(honeycomb), tt.cfgErr)(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK && tt.wantStatus != http.StatusBadGateway {
+				return
+			}
+			var report struct {
+				Status  string `json:"status"`
+				Dataset string `json:"dataset"`
+				Failure string `json:"failure"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("error decoding the report %s: %v", rec.Body, err)
+			}
+			if report.Failure != tt.wantFailure || (tt.wantFailure != "") != (report.Status == "failed") {
+				t.Errorf("report = %+v, want the failure %q", report, tt.wantFailure)
+			}
+		})
+	}
+}
@@ -2,30 +2,92 @@ package HoneycombSinkHandler
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
+	"golang.org/x/sync/semaphore"
 )
 
 // As we use Cloud Events (EventArc under the hood). we have to specify the function target that will process
 // the events and its name (i.e: sendToHoneycomb ). Events will be pushed to the route "/"
+// The configuration is validated there too, so a misconfigured deployment fails at startup rather than
+// on the first event. Outside the functions framework (e.g: in tests), FUNCTION_TARGET isn't set and a
+// configuration error is only logged.
+// The "health", "replay" and "selftest" HTTP targets report an invalid configuration, for load balancers,
+// smoke tests and operators, so they start with an invalid configuration too.
+// The package doesn't replace the default logger of the programs importing it, only a deployed function
+// (FUNCTION_TARGET set) owns the process and logs everything in the Cloud Logging format, and drains the
+// handler on SIGTERM before exiting.
 func init() {
+	logger := newLogger()
+	_, deployed := os.LookupEnv("FUNCTION_TARGET")
+	if deployed {
+		slog.SetDefault(logger)
+	}
+
+	cfg, err := ConfigFromEnv()
+	cfg.Logger = logger
+	if err != nil {
+		logger.Error("invalid configuration", "error", err.Error())
+		if deployed && !diagnosticTargets[os.Getenv("FUNCTION_TARGET")] {
+			panic(err)
+		}
+	} else {
+		logger.Info("configuration loaded",
+			"dataset", cfg.Dataset, "api_url", cfg.APIURL, "api_key", redact(cfg.APIKey),
+			"api_keys", len(cfg.APIKeys))
+		if cfg.CheckDataset || cfg.CreateDataset {
+			checkDatasets(context.Background(), withDefaults(cfg))
+		}
+		if cfg.Prewarm && !cfg.DryRun {
+			prewarm(context.Background(), withDefaults(cfg))
+		}
+	}
+
+	var shutdown func(ctx context.Context) error
+	HoneycombSinkHandler, shutdown = NewHandler(cfg)
+	if deployed {
+		handleSIGTERM(shutdown, logger)
+	}
 	functions.CloudEvent("HoneycombSinkHandler", HoneycombSinkHandler)
+	functions.HTTP("health", newHealthHandler(cfg, err))
+	functions.HTTP("replay", newReplayHandler(cfg, err))
+	functions.HTTP("selftest", newSelfTestHandler(cfg, err))
+	functions.HTTP("push", newPushHandler(cfg, HoneycombSinkHandler))
 }
 
+// diagnosticTargets are the function targets which start with an invalid configuration, to report it.
+var diagnosticTargets = map[string]bool{"health": true, "replay": true, "selftest": true}
+
 // MessagePublishedData contains the full Pub/Sub message
 // See the documentation for more details:
 // https://cloud.google.com/eventarc/docs/cloudevents#pubsub
 type MessagePublishedData struct {
 	Message      PubSubMessage
 	Subscription string
+	// ceSource and ceSubject identify the resource which produced the CloudEvent carrying the message,
+	// e.g: with EventArc audit log triggers.
+	ceSource  string
+	ceSubject string
 }
 
 // PubSubMessage is the payload of a Pub/Sub event.
@@ -39,82 +101,1061 @@ type PubSubMessage struct {
 	OrderingKey string            `json:"orderingKey"`
 }
 
-var msg MessagePublishedData
+// httpClient is shared across invocations so warm instances reuse pooled keep-alive connections
+// to Honeycomb instead of paying a TLS handshake per event. Tests can set Config.HTTPClient instead.
+var httpClient = newHTTPClient()
+
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 100
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	return &http.Client{Transport: transport}
+}
+
+// timingField is the field ANNOTATE_TIMING adds: the pipeline latency of the sink before the send, not
+// the Honeycomb round trip, which the event being sent can't carry. It is prefixed as it describes the
+// sink rather than the producer.
+const timingField = "_sink_pipeline_ms"
+
+const (
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+// HoneycombSinkHandler is the function target, configured from the environment at cold start.
+var HoneycombSinkHandler func(ctx context.Context, e event.Event) error
+
+// NewHandler returns a handler which consumes a CloudEvent message, extracts the Pub/Sub message and
+// forwards it to Honeycomb according to cfg. Unset APIURL, APIPath, Timeout, FlattenDelimiter,
+// AuthHeader, UserAgent, PubSubMetaPrefix, DedupTTL, MetricsExportInterval, BatchFlushInterval,
+// CircuitBreakerCooldown, AsyncQueueSize, HTTPClient, Logger and Sink get their defaults.
+//
+// The handler returns nil, and the message is ACKed, only once Honeycomb answered the send with a 2xx
+// status, or when the message is dropped on purpose (filtered, sampled, duplicated, dead-lettered).
+// Unless AsyncWorkers is set, no event is ever ACKed before it is sent.
+//
+// The returned shutdown function flushes the buffered and queued events and waits for the in-flight
+// invocations, up to ShutdownTimeout. A program using the handler calls it when it stops, e.g: on SIGTERM.
+func NewHandler(cfg Config) (handler func(ctx context.Context, e event.Event) error,
+	shutdown func(ctx context.Context) error) {
+	if cfg.Logger == nil {
+		cfg.Logger = newLogger()
+	}
+	cfg = withDefaults(cfg)
+	drainer := newDrainer(cfg)
+	if cfg.MetricsEndpoint != "" {
+		if cfg.MetricsExportInterval <= 0 {
+			cfg.MetricsExportInterval = defaultMetricsExportInterval
+		}
+		cfg.metrics = newMetricsRegistry(cfg.MetricsEndpoint, cfg.MetricsHeaders, cfg.MetricsExportInterval,
+			cfg.ServicesHTTPClient, cfg.Clock)
+	}
+	if len(cfg.SkipIfAttr) > 0 {
+		cfg.skipped = newSkipCounter("attribute filter", cfg.Clock)
+	}
+	if cfg.ForwardIf != nil {
+		cfg.unmatched = newSkipCounter("forward condition", cfg.Clock)
+	}
+	if cfg.CircuitBreakerThreshold > 0 {
+		if cfg.CircuitBreakerCooldown <= 0 {
+			cfg.CircuitBreakerCooldown = defaultCircuitBreakerCooldown
+		}
+		cfg.breaker = newCircuitBreaker(cfg.CircuitBreakerThreshold, cfg.CircuitBreakerWindow,
+			cfg.CircuitBreakerCooldown, cfg.Clock)
+	}
+	if cfg.AlertWebhookURL != "" {
+		if cfg.AlertThreshold <= 0 {
+			cfg.AlertThreshold = defaultAlertThreshold
+		}
+		if cfg.AlertCooldown <= 0 {
+			cfg.AlertCooldown = defaultAlertCooldown
+		}
+		cfg.alerts = newAlertNotifier(cfg.AlertWebhookURL, cfg.AlertThreshold, cfg.AlertCooldown,
+			cfg.ServicesHTTPClient, cfg.Clock)
+	}
+	if cfg.RespectOrdering {
+		cfg.ordering = newKeyedMutex()
+	}
+	if cfg.MaxConcurrency > 0 {
+		cfg.concurrency = semaphore.NewWeighted(int64(cfg.MaxConcurrency))
+	}
+	if cfg.MaxInFlightBytes > 0 {
+		cfg.inFlightBytes = semaphore.NewWeighted(int64(cfg.MaxInFlightBytes))
+	}
+	if cfg.RateLimit > 0 {
+		cfg.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+	if cfg.BatchMaxEvents > 0 {
+		if cfg.BatchFlushInterval <= 0 {
+			cfg.BatchFlushInterval = defaultBatchFlushInterval
+		}
+		cfg.batcher = newBatcher(cfg)
+		drainer.onShutdown(cfg.batcher.flushAll)
+	}
+	if cfg.Sink == nil {
+		cfg.Sink = newSink(cfg)
+	}
+	if cfg.AuditSink != "" {
+		audit := newAuditSink(cfg.Sink, cfg)
+		if writer, ok := audit.writer.(*gcsAuditWriter); ok {
+			drainer.onShutdown(writer.flushAll)
+		}
+		cfg.Sink = audit
+	}
+	if cfg.AsyncWorkers > 0 {
+		if cfg.AsyncQueueSize <= 0 {
+			cfg.AsyncQueueSize = defaultAsyncQueueSize
+		}
+		async := newAsyncSink(cfg.Sink, cfg.AsyncWorkers, cfg.AsyncQueueSize, cfg.Logger)
+		drainer.onShutdown(async.drain)
+		cfg.Sink = async
+	}
+
+	var middlewares []Middleware
+	if cfg.LogInvocations {
+		middlewares = append(middlewares, loggingMiddleware(cfg.Clock))
+	}
+	if cfg.metrics != nil {
+		middlewares = append(middlewares, metricsMiddleware(cfg.metrics))
+	}
+	middlewares = append(middlewares, cfg.Middlewares...)
+	h := chain(func(ctx context.Context, e event.Event) error { return handle(ctx, cfg, e) }, middlewares...)
+	handler = func(ctx context.Context, e event.Event) error {
+		defer drainer.trackInFlight()()
+		return h(withLogger(ctx, cfg.Logger), e)
+	}
+	return handler, drainer.shutdown
+}
+
+// SendEvent validates, transforms and sends a single payload (a JSON object, or an array of events) to
+// Honeycomb according to cfg, like the handler does for the data of a PubSub message. It is the entry
+// point for other Go services reusing the sink as a client. Unset settings get the same defaults as with
+// NewHandler; buffering, metrics, the concurrency limit and the circuit breaker keep state across calls
+// and are only enabled in the handlers returned by NewHandler.
+func SendEvent(ctx context.Context, cfg Config, data []byte) error {
+	cfg = withDefaults(cfg)
+	if cfg.Sink == nil {
+		cfg.Sink = newSink(cfg)
+	}
+	ctx = withLogger(ctx, cfg.Logger)
+	return forward(ctx, cfg, MessagePublishedData{Message: PubSubMessage{Data: data}}, traceContext{})
+}
+
+// withDefaults fills the unset settings which have a default, except Sink.
+func withDefaults(cfg Config) Config {
+	if cfg.APIURL == "" {
+		cfg.APIURL = defaultAPIURL
+	}
+	cfg.APIURL = strings.TrimRight(cfg.APIURL, "/")
+	cfg.FallbackURL = strings.TrimRight(cfg.FallbackURL, "/")
+	if cfg.APIPath == "" {
+		cfg.APIPath = defaultAPIPath
+	}
+	cfg.APIPath = "/" + strings.Trim(cfg.APIPath, "/")
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxResponseBytes <= 0 {
+		cfg.MaxResponseBytes = defaultMaxResponseBytes
+	}
+	if cfg.FlattenDelimiter == "" {
+		cfg.FlattenDelimiter = defaultFlattenDelimiter
+	}
+	if cfg.PubSubMetaPrefix == "" {
+		cfg.PubSubMetaPrefix = defaultPubSubMetaPrefix
+	}
+	if cfg.DedupTTL <= 0 {
+		cfg.DedupTTL = defaultDedupTTL
+	}
+	if cfg.AuthHeader == "" {
+		cfg.AuthHeader = defaultAuthHeader
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = defaultUserAgent()
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = httpClient
+	}
+	if cfg.ServicesHTTPClient == nil {
+		cfg.ServicesHTTPClient = cfg.HTTPClient
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = realClock{}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	cfg.staticHeaders = staticHoneycombHeaders(cfg)
+	return cfg
+}
+
+func handle(ctx context.Context, cfg Config, e event.Event) error {
+	// ------------- CHECK EVENT TYPE -------------
+	// A misconfigured trigger may deliver unrelated events, which are acknowledged without decoding them
+	if len(cfg.ExpectedEventTypes) > 0 && !isExpectedEventType(e.Type(), cfg.ExpectedEventTypes) {
+		loggerFrom(ctx).Warn("unexpected CloudEvent type, skipping",
+			"event_id", e.ID(), "type", e.Type(), "source", e.Source(), "expected", cfg.ExpectedEventTypes)
+		return nil
+	}
+
+	// ------------- BIND LOGGER -------------
+	// Every log of the invocation carries the message it is about
+	logger := loggerFrom(ctx).With("event_id", e.ID())
+	ctx = withLogger(ctx, logger)
+
+	// ------------- READ INCOMING PUBSUB EVENT -------------
+	msg, err := readPubSubEvent(ctx, e, cfg)
+	if err != nil {
+		return err
+	}
+	logger = logger.With("message_id", msg.Message.MessageID, "subscription", msg.Subscription)
+
+	// ------------- READ UPSTREAM TRACE -------------
+	var trace traceContext
+	if cfg.TraceContext {
+		trace, _ = traceFromEvent(ctx, e)
+		if trace.TraceID != "" {
+			logger = logger.With("trace_id", trace.TraceID)
+		}
+	}
+	ctx = withLogger(ctx, logger)
+
+	// ------------- PRESERVE ORDERING -------------
+	// Messages sharing an ordering key are forwarded one at a time, in their delivery order
+	if cfg.ordering != nil && msg.Message.OrderingKey != "" {
+		defer cfg.ordering.lock(msg.Message.OrderingKey)()
+	}
+
+	if err := forward(ctx, cfg, msg, trace); err != nil {
+		if cfg.LogPayloadOnError {
+			logger.Warn("PubSub message data of the failed message", "data", string(msg.Message.Data))
+		}
+		return err
+	}
+	return nil
+}
+
+// forward runs a PubSub message through the filters, decoding, validation and transforms, and sends it.
+// A message is only sent as part of the producer's trace when trace holds a trace ID.
+// Redelivering a message which failed permanently (malformed, too large, rejected by Honeycomb) would
+// fail forever: when DLQTopic is set, it is parked in the dead-letter topic and ACKed.
+func forward(ctx context.Context, cfg Config, msg MessagePublishedData, trace traceContext) error {
+	err := forwardMessage(ctx, cfg, msg, trace)
+	if err == nil || cfg.DLQTopic == "" || !isPermanent(err) {
+		return err
+	}
+	dataset := failedDataset(err, msg, cfg)
+	if dlqErr := publishToDLQ(ctx, cfg, msg, dataset, err); dlqErr != nil {
+		return fmt.Errorf("%w (dead-letter failed: %v)", err, dlqErr)
+	}
+	loggerFrom(ctx).Warn("PubSub message failed permanently, published to the dead-letter topic",
+		"dataset", dataset, "topic", cfg.DLQTopic,
+		"error", err.Error())
+	return nil
+}
+
+// forwardMessage is forward without the dead-letter topic.
+func forwardMessage(ctx context.Context, cfg Config, msg MessagePublishedData, trace traceContext) error {
+	var err error
+	logger := loggerFrom(ctx)
+	received := cfg.Clock.Now()
+	original := msg
+
+	// ------------- FILTER BY ATTRIBUTE -------------
+	// Skipped messages are acknowledged without reaching Honeycomb
+	if len(cfg.SkipIfAttr) > 0 && matchesSkipFilter(msg, cfg.SkipIfAttr) {
+		logger.Debug("PubSub message skipped by the attribute filter")
+		cfg.skipped.add(ctx)
+		return nil
+	}
+
+	// ------------- DEDUPLICATE -------------
+	// PubSub delivers at least once, skip the messages already forwarded
+	if cfg.Dedup != nil && msg.Message.MessageID != "" {
+		seen, err := cfg.Dedup.Contains(ctx, msg.Message.MessageID)
+		if err != nil {
+			// Better a duplicated event than a lost one
+			logger.Warn("error checking the dedup store, forwarding anyway",
+				"error", err.Error())
+		} else if seen {
+			logger.Info("PubSub message already forwarded, skipping")
+			return nil
+		}
+	}
+
+	// ------------- DECOMPRESS PAYLOAD -------------
+	// Producers may compress the data to save PubSub costs, routing and Honeycomb need plain JSON
+	if msg.Message.Data, err = decompressPayload(msg); err != nil {
+		return err
+	}
+
+	// ------------- DECODE PAYLOAD -------------
+	if cfg.DecodeDoubleBase64 {
+		msg.Message.Data = decodeDoubleBase64(msg.Message.Data)
+	}
+
+	// ------------- BOUND IN-FLIGHT BYTES -------------
+	// Bursts of large payloads wait for the ones in flight rather than exhaust the instance memory. The
+	// decoded size is charged, as the transforms and the send hold the decompressed data
+	if cfg.inFlightBytes != nil {
+		size := int64(min(len(msg.Message.Data), cfg.MaxInFlightBytes))
+		if err := cfg.inFlightBytes.Acquire(ctx, size); err != nil {
+			return withCategory(fmt.Errorf("error waiting for in-flight bytes capacity %w", err), ErrTransient)
+		}
+		defer cfg.inFlightBytes.Release(size)
+	}
 
-// HoneycombSinkHandler consumes a CloudEvent message and extracts the Pub/Sub message.
-func HoneycombSinkHandler(ctx context.Context, e event.Event) error {
-	honeycombDataset, err := getEnvVar("HONEYCOMB_DATASET")
+	// ------------- SPLIT NDJSON PAYLOAD -------------
+	// Producers may pack several newline-delimited events in a message, they are forwarded as a batch
+	if cfg.PayloadFormat == payloadFormatNDJSON {
+		if msg.Message.Data, err = splitNDJSON(ctx, msg.Message.Data, cfg, msg.Message.MessageID); err != nil {
+			return err
+		}
+	}
+
+	// ------------- EXTRACT PAYLOAD -------------
+	// Only the event wrapped by the producer's envelope is forwarded
+	if cfg.ExtractPath != "" {
+		extracted, err := extractPayload(msg.Message.Data, cfg.ExtractPath)
+		if errors.Is(err, errExtractPathMissing) && cfg.ExtractSkipMissing {
+			logger.Warn("extract path not found in the payload, skipping",
+				"path", cfg.ExtractPath)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		msg.Message.Data = extracted
+	}
+
+	// ------------- FILTER BY PAYLOAD -------------
+	// Only the events matching the forward condition are sent, the others are acknowledged
+	if cfg.ForwardIf != nil {
+		filtered, err := filterPayload(msg.Message.Data, cfg.ForwardIf)
+		if err != nil {
+			return err
+		}
+		if filtered == nil {
+			logger.Debug("PubSub message doesn't match the forward condition", "condition", cfg.ForwardIf.String())
+			cfg.unmatched.add(ctx)
+			return nil
+		}
+		msg.Message.Data = filtered
+	}
+
+	// ------------- RESOLVE DATASET -------------
+	dataset, err := resolveDataset(msg, cfg)
 	if err != nil {
 		return err
 	}
-	honeycombAPIKey, err := getEnvVar("HONEYCOMB_API_KEY")
+
+	// ------------- CHECK SUBSCRIPTION -------------
+	// A misconfigured trigger mustn't write to a dataset reserved to other subscriptions
+	datasets := fanOutDatasets(dataset, cfg.FanOutDatasets)
+	if err := checkSubscription(cfg.SubscriptionAllowlist, msg.Subscription, datasets); err != nil {
+		if cfg.DLQTopic == "" {
+			return err
+		}
+		if dlqErr := publishToDLQ(ctx, cfg, original, dataset, err); dlqErr != nil {
+			return fmt.Errorf("%w (dead-letter failed: %v)", err, dlqErr)
+		}
+		logger.Warn("PubSub message refused for its subscription, published to the dead-letter topic",
+			"dataset", dataset, "subscription", msg.Subscription,
+			"topic", cfg.DLQTopic)
+		return nil
+	}
+
+	// ------------- SEND MARKER -------------
+	// Messages carrying the marker type attribute create a marker instead of an event
+	if isMarker(msg) {
+		result, err := sendMarker(ctx, cfg, msg, dataset)
+		if err != nil {
+			return err
+		}
+		logger.Info("Honeycomb marker created",
+			"dataset", dataset, "status_code", result.StatusCode)
+		return nil
+	}
+
+	// ------------- SAMPLE -------------
+	// Only 1 in sampleRate messages is forwarded, Honeycomb multiplies the kept ones by the sample rate.
+	// Producers may pin the rate of a message, e.g: to keep all the debug events
+	cfg.SampleRate = messageSampleRate(ctx, cfg.SampleRate, msg)
+	if !shouldSample(cfg, msg) {
+		logger.Info("PubSub message dropped by sampling",
+			"dataset", dataset, "sample_rate", cfg.SampleRate)
+		return nil
+	}
+
+	// ------------- SKIP EMPTY PAYLOAD -------------
+	// Keep-alives and misconfigured producers send messages without data, which Honeycomb would reject
+	if len(bytes.TrimSpace(msg.Message.Data)) == 0 {
+		if !cfg.SkipEmpty {
+			return withCategory(fmt.Errorf("error PubSub message %s has no data", msg.Message.MessageID), ErrPermanent)
+		}
+		logger.Warn("PubSub message has no data, skipping", "dataset", dataset)
+		return nil
+	}
+
+	// ------------- VALIDATE PAYLOAD -------------
+	msg.Message.Data, err = validatePayload(msg.Message.Data, cfg)
 	if err != nil {
 		return err
 	}
-	// ------------- READ INCOMING PUBSUB EVENT -------------
-	err = readPubSubEvent(e)
+
+	// ------------- EXPLODE ARRAY -------------
+	// A JSON array holds one event per element, unless the producer meant it as a single event
+	if isJSONArray(msg.Message.Data) && cfg.WrapArrays && cfg.PayloadFormat != payloadFormatNDJSON {
+		if msg.Message.Data, err = json.Marshal(map[string]json.RawMessage{arrayField: msg.Message.Data}); err != nil {
+			return fmt.Errorf("error wrapping json array payload %w", err)
+		}
+	} else if isJSONArray(msg.Message.Data) {
+		var events int
+		if msg.Message.Data, events, err = explodeArray(ctx, msg.Message.Data, msg.Message.MessageID); err != nil {
+			return err
+		}
+		if events == 0 {
+			logger.Warn("PubSub message holds an empty JSON array, skipping",
+				"dataset", dataset)
+			return nil
+		}
+	}
+
+	// ------------- TRANSFORM PAYLOAD -------------
+	msg.Message.Data, err = transformPayload(ctx, cfg, msg)
+	if errors.Is(err, errTransformFailed) && cfg.TransformSkipOnError {
+		logger.Warn("transform expression failed, skipping the message",
+			"dataset", dataset, "error", err.Error())
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
+	// ------------- NORMALIZE TIMESTAMP -------------
+	// The timestamp field is rewritten as RFC 3339, the missing and unparseable ones are left as is
+	if cfg.TimeField != "" && cfg.TimeFieldMode == timeFieldRewrite {
+		msg.Message.Data, _, err = rewriteTimestamps(msg.Message.Data, cfg.TimeField)
+		if err != nil {
+			return fmt.Errorf("error rewriting timestamp field %w", err)
+		}
+	}
+
+	// ------------- LIMIT COLUMNS -------------
+	// Wide events degrade the dataset, the extra columns are nested or dropped
+	if cfg.MaxColumns > 0 {
+		var limited, widest int
+		msg.Message.Data, limited, widest, err = limitColumns(msg.Message.Data, cfg.MaxColumns, cfg.ColumnOverflow)
+		if err != nil {
+			return err
+		}
+		if limited > 0 {
+			logger.Warn("events over the column limit, extra columns "+columnOverflowAction(cfg.ColumnOverflow),
+				"dataset", dataset, "events", limited, "columns", widest, "max_columns", cfg.MaxColumns)
+		}
+	}
+
+	// ------------- CHECK PUBSUB LAG -------------
+	// The delay since the publication tells whether the subscription is falling behind
+	if !msg.Message.PublishTime.IsZero() && (cfg.LagWarnThreshold > 0 || cfg.LagField != "") {
+		lag := since(cfg.Clock, msg.Message.PublishTime)
+		if cfg.LagWarnThreshold > 0 && lag > cfg.LagWarnThreshold {
+			logger.Warn("PubSub message delivered late, the subscription may be falling behind",
+				"dataset", dataset, "lag_ms", lag.Milliseconds(),
+				"threshold_ms", cfg.LagWarnThreshold.Milliseconds())
+			if cfg.metrics != nil {
+				cfg.metrics.recordLag()
+			}
+		}
+		if cfg.LagField != "" {
+			lagField := map[string]any{cfg.LagField: lag.Milliseconds()}
+			if msg.Message.Data, err = addFields(msg.Message.Data, lagField, cfg.fieldMerge(false)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// ------------- ADD ORDERING KEY -------------
+	if cfg.RespectOrdering && msg.Message.OrderingKey != "" {
+		orderingKey := map[string]any{cfg.PubSubMetaPrefix + ".ordering_key": msg.Message.OrderingKey}
+		if msg.Message.Data, err = addFields(msg.Message.Data, orderingKey, cfg.fieldMerge(false)); err != nil {
+			return err
+		}
+	}
+
+	// ------------- ADD CLOUDEVENT SOURCE -------------
+	// Attributes the event to the GCP resource which produced it
+	if cfg.IncludeCESource {
+		if source := cloudEventSourceFields(msg); len(source) > 0 {
+			if msg.Message.Data, err = addFields(msg.Message.Data, source, cfg.fieldMerge(false)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// ------------- CHECK EVENT SIZE -------------
+	msg.Message.Data, err = enforceEventSize(ctx, msg.Message.Data, cfg.MaxEventBytes, cfg.TruncateOversized,
+		msg.Message.MessageID)
+	if err != nil {
+		return err
+	}
+
+	// ------------- JOIN UPSTREAM TRACE -------------
+	// The events become children of the producer's span, or of the sink's span, itself a child of the
+	// producer's, when the sink sends it
+	if trace.TraceID != "" {
+		if cfg.TraceSpans {
+			trace.SpanID = newSpanID()
+		}
+		if msg.Message.Data, err = addFields(msg.Message.Data, trace.fields(), cfg.fieldMerge(false)); err != nil {
+			return err
+		}
+	}
+
+	// ------------- ANNOTATE TIMING -------------
+	// The pipeline latency of the message so far, an event can't carry the round trip it is sent in
+	if cfg.AnnotateTiming {
+		timing := map[string]any{timingField: float64(since(cfg.Clock, received).Microseconds()) / 1000}
+		if msg.Message.Data, err = addFields(msg.Message.Data, timing, cfg.fieldMerge(false)); err != nil {
+			return err
+		}
+	}
+
 	// ------------- SEND PAYLOAD TO HONEYCOMB -------------
-	err = sendToHoneycomb(honeycombAPIKey, honeycombDataset)
+	start := cfg.Clock.Now()
+	event := Event{PubSub: msg, TraceID: trace.TraceID, SampleRate: cfg.SampleRate}
+	err = sendFanOut(ctx, cfg.Sink, event, datasets, cfg.FanOutRequireAll)
+	if trace.SpanID != "" {
+		sendSpan(ctx, cfg, trace, msg.Message.MessageID, dataset, start, err)
+	}
+	if cfg.metrics != nil && !cfg.DryRun {
+		cfg.metrics.recordSend(since(cfg.Clock, start), err)
+	}
+	if reason := rejectionReason(err); reason != "" {
+		logger.Warn("event rejected by Honeycomb", "dataset", dataset, "reason", reason)
+	}
+	if cfg.alerts != nil && !cfg.DryRun {
+		cfg.alerts.record(ctx, dataset, err)
+	}
+	if err != nil && cfg.ErrorReporting && errors.Is(err, ErrPermanent) {
+		reportError(cfg.Logger, err, msg.Message.MessageID, dataset)
+	}
 	if err != nil {
 		return err
 	}
 
+	if cfg.Dedup != nil && msg.Message.MessageID != "" {
+		if err := cfg.Dedup.Add(ctx, msg.Message.MessageID, cfg.DedupTTL); err != nil {
+			logger.Warn("error recording the message in the dedup store",
+				"error", err.Error())
+		}
+	}
+
 	return nil
 }
 
-func readPubSubEvent(e event.Event) error {
-	// populate `msg` variable with the PubSub event Data
-	if err := e.DataAs(&msg); err != nil {
-		return fmt.Errorf("event.DataAs: %w", err)
+// cloudEventSourceFields returns the source and subject of the CloudEvent as the ce.source and
+// ce.subject fields, when they are set. Messages which didn't come from a CloudEvent have none.
+func cloudEventSourceFields(msg MessagePublishedData) map[string]any {
+	fields := map[string]any{}
+	if msg.ceSource != "" {
+		fields["ce.source"] = msg.ceSource
 	}
+	if msg.ceSubject != "" {
+		fields["ce.subject"] = msg.ceSubject
+	}
+	return fields
+}
 
-	pubSubData := string(msg.Message.Data) // Automatically decoded from base64.
-	pubSubMessageID := msg.Message.MessageID
-	pubSubSubName := msg.Subscription
+// isExpectedEventType reports whether the CloudEvent type is one of the expected ones, ignoring case and
+// surrounding whitespaces.
+func isExpectedEventType(eventType string, expected []string) bool {
+	eventType = strings.TrimSpace(eventType)
+	for _, t := range expected {
+		if strings.EqualFold(eventType, t) {
+			return true
+		}
+	}
+	return false
+}
 
-	log.Printf("PubSub Message ID: %s", pubSubMessageID)
-	log.Printf("PubSub Subscription name: %s", pubSubSubName)
-	log.Printf("PubSub data: %s", pubSubData)
+// pubSubEventType is the type of the CloudEvents EventArc delivers for PubSub messages.
+const pubSubEventType = "google.cloud.pubsub.topic.v1.messagePublished"
 
-	return nil
+// readPubSubEvent decodes the PubSub message carried by the CloudEvent. The message is returned rather
+// than stored globally since a warm instance can process several events concurrently.
+// CloudEvents from other sources aren't wrapped in a PubSub envelope: their whole data is the payload.
+// An envelope with malformed fields is decoded field by field, it only fails without message data.
+// The payload may hold tokens or PII, so it is only logged when cfg.DebugPayload is set, for 1 in
+// cfg.LogSampleRate messages.
+func readPubSubEvent(ctx context.Context, e event.Event, cfg Config) (MessagePublishedData, error) {
+	var msg MessagePublishedData
+	err := e.DataAs(&msg)
+	if e.Type() == pubSubEventType {
+		if err != nil {
+			// A malformed sibling field shouldn't cost the message when its data is fine
+			strictErr := err
+			if msg, err = decodePubSubLoose(ctx, e.Data()); err != nil {
+				return msg, withCategory(fmt.Errorf("event.DataAs: %w (%v)", strictErr, err), ErrPermanent)
+			}
+			if msg.Message.MessageID == "" {
+				// Eventarc uses the message ID as the CloudEvent ID
+				msg.Message.MessageID = e.ID()
+			}
+		}
+	} else if err != nil || len(msg.Message.Data) == 0 {
+		msg = directEventMessage(e)
+	}
+	msg.ceSource, msg.ceSubject = e.Source(), e.Subject()
+
+	logger := loggerFrom(ctx)
+	logger.Info("PubSub message received",
+		"message_id", msg.Message.MessageID,
+		"subscription", msg.Subscription,
+		"bytes", len(msg.Message.Data))
+	if cfg.DebugPayload && shouldLogPayload(cfg.LogSampleRate, msg.Message.MessageID) {
+		// The data is automatically decoded from base64. It is logged at info level, DEBUG_PAYLOAD is
+		// enough to see it whatever LOG_LEVEL
+		logger.Info("PubSub message data", "message_id", msg.Message.MessageID, "data", string(msg.Message.Data))
+	}
+
+	return msg, nil
+}
+
+// directEventMessage wraps the data of a CloudEvent which isn't a PubSub message (e.g: an Audit Log
+// entry) as a PubSub message, using the CloudEvent ID and time as message ID and publish time.
+func directEventMessage(e event.Event) MessagePublishedData {
+	return MessagePublishedData{
+		Message: PubSubMessage{
+			Data:        e.Data(),
+			MessageID:   e.ID(),
+			PublishTime: e.Time(),
+		},
+	}
 }
 
-func sendToHoneycomb(key string, dataset string) error {
-	url := "https://api.honeycomb.io:443/1/events/" + dataset
+// honeycombStatusError is returned when the Honeycomb API answers with a non-2xx status code.
+type honeycombStatusError struct {
+	StatusCode int
+	Dataset    string
+	// Body is a bounded summary of the response body, which may be an HTML page from a proxy.
+	Body string
+	// Reason is the classified reason of a 400, e.g: "too_many_columns".
+	Reason string
+	// RetryAfter is the delay requested by Honeycomb through the Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *honeycombStatusError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("error honeycomb API returned status %d (%s) for dataset %q, rejected as %s: %s",
+			e.StatusCode, http.StatusText(e.StatusCode), e.Dataset, e.Reason, e.Body)
+	}
+	return fmt.Sprintf("error honeycomb API returned status %d (%s) for dataset %q: %s",
+		e.StatusCode, http.StatusText(e.StatusCode), e.Dataset, e.Body)
+}
+
+// sendResult describes the response to a request sent to Honeycomb.
+type sendResult struct {
+	StatusCode int
+	Body       string
+	// Bytes is the size of the request body.
+	Bytes int
+	// Latency is the duration of the last attempt.
+	Latency time.Duration
+	// Attempts is the number of requests sent, retries included.
+	Attempts int
+}
+
+// sendToHoneycomb posts the PubSub data to Honeycomb, retrying on network errors and 5xx responses
+// with exponential backoff. 4xx responses are permanent and are returned straight away.
+// JSON array payloads, or any payload when batching is enabled, go through the batch endpoint.
+// The sample rate tells Honeycomb how many events the forwarded one stands for.
+// The result of the last attempt is returned along with the error, it is empty on a dry run.
+func sendToHoneycomb(ctx context.Context, cfg Config, msg MessagePublishedData, dataset string) (sendResult, error) {
+	payload := msg.Message.Data
+	var batchSize int
+	var err error
+	batch := cfg.Batch || isJSONArray(payload)
+	if batch {
+		payload, batchSize, err = buildBatchBody(cfg, msg, cfg.SampleRate)
+		if err != nil {
+			return sendResult{}, err
+		}
+	}
+
+	headers := honeycombHeaders(cfg, apiKeyFor(cfg, dataset, msg.Message.Attributes))
+	endpoint, err := sendEndpoint(cfg, dataset, batch, headers)
+	if err != nil {
+		return sendResult{}, err
+	}
+	headers.Set("X-Honeycomb-Event-Time", payloadEventTime(cfg, msg, payload).Format(time.RFC3339Nano))
+	if cfg.SampleRate > 1 {
+		headers.Set("X-Honeycomb-Samplerate", strconv.Itoa(cfg.SampleRate))
+	}
+
+	if cfg.DryRun {
+		logDryRun(ctx, cfg, endpoint, headers, payload, dataset)
+		return sendResult{}, nil
+	}
+
+	if cfg.Gzip {
+		var err error
+		payload, err = gzipPayload(payload)
+		if err != nil {
+			return sendResult{}, err
+		}
+		headers.Set("Content-Encoding", "gzip")
+	}
+
+	if err := waitRateLimit(ctx, cfg.limiter, max(batchSize, 1)); err != nil {
+		return sendResult{}, err
+	}
+	result, err := postWithFallback(ctx, cfg, endpoint, payload, headers, dataset)
+	if err == nil && batchSize > 0 {
+		// Honeycomb answers 200 to batch requests; rejected events are reported individually
+		return result, parseBatchResponse(ctx, result.Body, dataset, batchSize)
+	}
+	return result, err
+}
+
+// postWithRetries posts the payload with retries, unless the circuit breaker is open: it then fails fast
+// with a transient error.
+func postWithRetries(ctx context.Context, cfg Config, endpoint string, payload []byte, headers http.Header,
+	dataset string) (sendResult, error) {
+	if cfg.breaker == nil {
+		return postAttempts(ctx, cfg, endpoint, payload, headers, dataset)
+	}
+	if !cfg.breaker.allow(ctx) {
+		return sendResult{}, errCircuitOpen
+	}
+	result, err := postAttempts(ctx, cfg, endpoint, payload, headers, dataset)
+	cfg.breaker.record(ctx, err)
+	return result, err
+}
+
+// postWithFallback posts the payload with retries and, when it still fails with an error worth retrying
+// and FallbackURL is set, posts it to the same path on the fallback endpoint, with retries but outside of
+// the circuit breaker which tracks the primary endpoint.
+func postWithFallback(ctx context.Context, cfg Config, endpoint string, payload []byte, headers http.Header,
+	dataset string) (sendResult, error) {
+	result, err := postWithRetries(ctx, cfg, endpoint, payload, headers, dataset)
+	if err == nil || cfg.FallbackURL == "" || !isRetryable(err) || ctx.Err() != nil {
+		return result, err
+	}
+
+	logger := loggerFrom(ctx)
+	fallback := cfg.FallbackURL + strings.TrimPrefix(endpoint, cfg.APIURL)
+	logger.Warn("primary honeycomb endpoint failed, failing over to the fallback endpoint",
+		"dataset", dataset, "endpoint", fallback, "error", err.Error())
+	fallbackResult, fallbackErr := postAttempts(ctx, cfg, fallback, payload, headers, dataset)
+	fallbackResult.Attempts += result.Attempts
+	if cfg.metrics != nil {
+		cfg.metrics.recordFailover(fallbackErr)
+	}
+	if fallbackErr != nil {
+		return fallbackResult, fmt.Errorf("error fallback honeycomb endpoint failed too, primary failed with %v: %w",
+			err, fallbackErr)
+	}
+	logger.Info("Honeycomb send succeeded on the fallback endpoint",
+		"dataset", dataset, "endpoint", fallback, "status_code", fallbackResult.StatusCode,
+		"attempts", fallbackResult.Attempts)
+	return fallbackResult, nil
+}
+
+// postAttempts posts the payload, retrying on network errors, 429 and 5xx responses with exponential
+// backoff.
+func postAttempts(ctx context.Context, cfg Config, endpoint string, payload []byte, headers http.Header,
+	dataset string) (sendResult, error) {
+	if headers.Get("User-Agent") == "" {
+		headers.Set("User-Agent", cfg.UserAgent)
+	}
+	for attempt := 0; ; attempt++ {
+		if cfg.concurrency != nil {
+			// Wait for a slot rather than drop the event, the slot isn't held during the backoff
+			if err := cfg.concurrency.Acquire(ctx, 1); err != nil {
+				return sendResult{Attempts: attempt}, fmt.Errorf("error waiting for a honeycomb request slot %w", err)
+			}
+		}
+		result, err := postToHoneycomb(ctx, cfg, endpoint, payload, headers, dataset)
+		if cfg.concurrency != nil {
+			cfg.concurrency.Release(1)
+		}
+		result.Attempts = attempt + 1
+		// A cancelled or expired invocation fails fast, Pub/Sub redelivers the message
+		if err == nil || !isRetryable(err) || ctx.Err() != nil || attempt >= cfg.MaxRetries {
+			return result, err
+		}
+
+		wait := backoff(attempt)
+		var statusErr *honeycombStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+			if statusErr.RetryAfter > 0 {
+				wait = statusErr.RetryAfter
+			}
+			loggerFrom(ctx).Warn("Honeycomb is rate limiting, waiting before retrying",
+				"dataset", dataset, "attempt", attempt+1, "max_attempts", cfg.MaxRetries+1, "wait", wait.String())
+		} else {
+			loggerFrom(ctx).Warn("Honeycomb request failed, retrying",
+				"dataset", dataset, "attempt", attempt+1, "max_attempts", cfg.MaxRetries+1, "wait", wait.String(),
+				"error", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return result, withCategory(
+				fmt.Errorf("error retrying honeycomb post request %w (last error: %v)", ctx.Err(), err), ErrTransient)
+		case <-cfg.Clock.After(wait):
+		}
+	}
+}
+
+// postToHoneycomb performs a single POST attempt with the given headers and returns the response.
+// The timeout covers the whole exchange, from dialing to reading the response body.
+func postToHoneycomb(ctx context.Context, cfg Config, endpoint string, payload []byte, headers http.Header,
+	dataset string) (sendResult, error) {
+	result := sendResult{Bytes: len(payload)}
+	reqCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
 	// Send POST request to Honeycomb APIs
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(msg.Message.Data))
+	start := cfg.Clock.Now()
+	req, err := http.NewRequestWithContext(reqCtx, "POST", endpoint, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("error initializing honeycomb post request %w", err)
+		return result, fmt.Errorf("error initializing honeycomb post request %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Honeycomb-Team", key)
+	// The headers are shared by the attempts, which run one after the other and don't modify them
+	req.Header = headers
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := cfg.HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending post request to honeycomb %w", err)
+		if isRequestTimeout(ctx, reqCtx) {
+			return result, withCategory(
+				fmt.Errorf("error honeycomb post request timed out after %s %w", cfg.Timeout, err), ErrTransient)
+		}
+		if ctx.Err() == nil && !isNetworkError(err) {
+			// The request couldn't be sent at all (e.g: an unsupported scheme), retrying won't help
+			return result, fmt.Errorf("error sending post request to honeycomb %w", err)
+		}
+		return result, withCategory(fmt.Errorf("error sending post request to honeycomb %w", err), ErrTransient)
 	}
 	defer resp.Body.Close()
 
-	// Read the honeycomb API's response
-	body, err := io.ReadAll(resp.Body)
+	// Read the honeycomb API's response, a misbehaving proxy may answer with a huge body
+	limit := maxBatchResponseBytes
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		limit = cfg.MaxResponseBytes
+	}
+	body, truncated, err := readResponseBody(resp.Body, limit)
+	if truncated {
+		loggerFrom(ctx).Warn("Honeycomb response body truncated",
+			"dataset", dataset, "status_code", resp.StatusCode, "max_bytes", limit)
+	}
 	if err != nil {
-		return fmt.Errorf("error reading honeycomb post request response %w", err)
+		if isRequestTimeout(ctx, reqCtx) {
+			return result, withCategory(
+				fmt.Errorf("error honeycomb response read timed out after %s %w", cfg.Timeout, err), ErrTransient)
+		}
+		return result, withCategory(fmt.Errorf("error reading honeycomb post request response %w", err), ErrTransient)
 	}
-	stringBody := string(body)
-	log.Printf("Honeycomb API's response: %s", stringBody)
+	result.StatusCode = resp.StatusCode
+	result.Body = string(body)
+	result.Latency = since(cfg.Clock, start)
 
-	return nil
+	// A non-2xx status means Honeycomb did not accept the event (e.g: 401 for a bad API key,
+	// 404 for an unknown dataset). Returning an error makes the function framework NACK the message
+	// so Pub/Sub can redeliver it.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := &honeycombStatusError{
+			StatusCode: resp.StatusCode,
+			Dataset:    dataset,
+			Body:       summarizeBody(resp.Header.Get("Content-Type"), body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), cfg.Clock.Now()),
+		}
+		if resp.StatusCode == http.StatusBadRequest {
+			statusErr.Reason = classifyRejection(rejectionMessage(body))
+		}
+		return result, statusErr
+	}
+	return result, nil
+}
+
+// honeycombHeaders returns the headers common to the requests to the Honeycomb API: the content type,
+// the deployment's extra headers and the API key, in the AuthHeader header. An extra header named like
+// the API key one only replaces it when ExtraHeadersOverrideKey is set.
+func honeycombHeaders(cfg Config, apiKey string) http.Header {
+	static := cfg.staticHeaders
+	if static == nil {
+		static = staticHoneycombHeaders(cfg)
+	}
+	headers := static.Clone()
+	if authHeader := http.CanonicalHeaderKey(cfg.AuthHeader); headers.Get(authHeader) == "" {
+		headers.Set(authHeader, apiKey)
+	}
+	return headers
+}
+
+// staticHoneycombHeaders returns the headers which are the same for every request to the Honeycomb API,
+// computed once by withDefaults rather than for every event.
+func staticHoneycombHeaders(cfg Config) http.Header {
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+	if cfg.ContentType != "" {
+		headers.Set("Content-Type", cfg.ContentType)
+	}
+	headers.Set("User-Agent", cfg.UserAgent)
+	authHeader := http.CanonicalHeaderKey(cfg.AuthHeader)
+	for name, value := range cfg.ExtraHeaders {
+		if http.CanonicalHeaderKey(name) == authHeader && !cfg.ExtraHeadersOverrideKey {
+			continue
+		}
+		headers.Set(name, value)
+	}
+	return headers
+}
+
+// logDryRun logs the request which would have been sent, with the API key redacted.
+func logDryRun(ctx context.Context, cfg Config, endpoint string, headers http.Header, payload []byte, dataset string) {
+	logged := headers.Clone()
+	for _, name := range []string{defaultAuthHeader, cfg.AuthHeader} {
+		if value := headers.Get(name); value != "" {
+			logged.Set(name, redact(value))
+		}
+	}
+	loggerFrom(ctx).Info("dry run, request not sent to Honeycomb",
+		"dataset", dataset,
+		"url", endpoint,
+		"headers", logged,
+		"body", string(payload))
+}
+
+// eventTime is the timestamp given to the Honeycomb event: the PubSub publish time so a subscription
+// backlog doesn't skew latencies, or now when the publish time is unknown.
+func eventTime(msg MessagePublishedData, clock Clock) time.Time {
+	if msg.Message.PublishTime.IsZero() {
+		return clock.Now()
+	}
+	return msg.Message.PublishTime
+}
+
+// sampleRateAttribute overrides the sample rate of a message.
+const sampleRateAttribute = "honeycomb-samplerate"
+
+// messageSampleRate returns the sample rate of the message: the positive integer of its
+// sampleRateAttribute attribute when set, the configured rate otherwise. Invalid values are logged and
+// ignored.
+func messageSampleRate(ctx context.Context, sampleRate int, msg MessagePublishedData) int {
+	value, ok := msg.Message.Attributes[sampleRateAttribute]
+	if !ok {
+		return sampleRate
+	}
+	rate, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || rate < 1 {
+		loggerFrom(ctx).Warn("invalid sample rate attribute, using the configured rate",
+			"message_id", msg.Message.MessageID, "attribute", sampleRateAttribute, "value", value,
+			"sample_rate", sampleRate)
+		return sampleRate
+	}
+	return rate
+}
+
+// shouldSample keeps 1 in cfg.SampleRate messages. When cfg.SampleKey names a field of the payload, the
+// decision is deterministic on its value, so all the events of a trace are kept or dropped together.
+// Messages without the field are sampled randomly.
+func shouldSample(cfg Config, msg MessagePublishedData) bool {
+	if cfg.SampleRate <= 1 {
+		return true
+	}
+	if cfg.SampleKey != "" {
+		if fields, ok := decodeObject(msg.Message.Data); ok {
+			if value, ok := lookupPath(fields, cfg.SampleKey); ok && value != nil {
+				return sampleDeterministic(fmt.Sprint(value), cfg.SampleRate)
+			}
+		}
+	}
+	return rand.Intn(cfg.SampleRate) == 0
+}
+
+// sampleDeterministic keeps 1 in sampleRate values, always making the same decision for a value. It
+// matches the deterministic sampler of the Honeycomb Beelines and Refinery: the first 4 bytes of the
+// SHA-1 of the value are compared to MaxUint32 / sampleRate.
+func sampleDeterministic(value string, sampleRate int) bool {
+	sum := sha1.Sum([]byte(value))
+	return binary.BigEndian.Uint32(sum[:4]) <= math.MaxUint32/uint32(sampleRate)
+}
+
+// gzipWriters and gzipBuffers are reused across requests: a gzip writer allocates about 1 MB of
+// compression state.
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	gzipBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
+
+// gzipPayload compresses the request body, Honeycomb accepts it with a `Content-Encoding: gzip` header.
+func gzipPayload(payload []byte) ([]byte, error) {
+	buf := gzipBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer gzipBuffers.Put(buf)
+	zw := gzipWriters.Get().(*gzip.Writer)
+	zw.Reset(buf)
+	defer gzipWriters.Put(zw)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, fmt.Errorf("error compressing honeycomb payload %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing honeycomb payload %w", err)
+	}
+	// The buffer goes back to the pool, the payload outlives it for the retries
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// isRequestTimeout reports whether reqCtx expired on its own per-request timeout, as opposed to the
+// parent invocation context being cancelled.
+func isRequestTimeout(parent context.Context, reqCtx context.Context) bool {
+	return parent.Err() == nil && errors.Is(reqCtx.Err(), context.DeadlineExceeded)
+}
+
+// isNetworkError reports whether a request failed on the network (connection refused or reset, DNS,
+// timeout, connection closed mid-response), as opposed to a request the client refused to send.
+func isNetworkError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isRetryable reports whether a failed attempt is worth retrying: the transient failures (network errors,
+// timeouts, 429 and 5xx) are, like for the circuit breaker and the callers of the handler.
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+// parseRetryAfter parses a Retry-After header value, given either as a number of seconds or as an
+// HTTP-date. It returns 0 when the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
 }
 
-func getEnvVar(key string) (string, error) {
-	value, isPresent := os.LookupEnv(key)
-	if !isPresent {
-		return "", fmt.Errorf("error, %s environment variable is missing", key)
+// backoff returns the wait before the next attempt: an exponential delay with full jitter.
+func backoff(attempt int) time.Duration {
+	base := retryBaseDelay << attempt
+	if base > retryMaxDelay || base <= 0 {
+		base = retryMaxDelay
 	}
-	return value, nil
+	return time.Duration(rand.Int63n(int64(base)))
 }
@@ -0,0 +1,1396 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// testPublishTime is the publish time of the test messages.
+var testPublishTime = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+// receivedRequest is a request received by the fake Honeycomb API, its body decompressed.
+type receivedRequest struct {
+	Method     string
+	Path       string
+	Header     http.Header
+	Body       []byte
+	RemoteAddr string
+}
+
+// fakeHoneycomb is an httptest server standing for the Honeycomb API. It records the requests and
+// answers with respond, or with a 200 (the per-event 202 statuses for the batch endpoint) when nil.
+type fakeHoneycomb struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []receivedRequest
+	respond  func(w http.ResponseWriter, r *http.Request, body []byte)
+}
+
+func newFakeHoneycomb(t *testing.T, respond func(w http.ResponseWriter, r *http.Request, body []byte)) *fakeHoneycomb {
+	t.Helper()
+	f := &fakeHoneycomb{respond: respond}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil && r.Header.Get("Content-Encoding") == "gzip" {
+			body, err = gunzip(body)
+		}
+		if err != nil {
+			t.Errorf("error reading the request body: %v", err)
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, receivedRequest{Method: r.Method, Path: r.URL.EscapedPath(),
+			Header: r.Header.Clone(), Body: body, RemoteAddr: r.RemoteAddr})
+		f.mu.Unlock()
+		if f.respond != nil {
+			f.respond(w, r, body)
+			return
+		}
+		acceptAll(w, r, body)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// received returns the requests received so far.
+func (f *fakeHoneycomb) received() []receivedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]receivedRequest(nil), f.requests...)
+}
+
+// lastEvent decodes the body of the last single event request.
+func (f *fakeHoneycomb) lastEvent(t *testing.T) map[string]any {
+	t.Helper()
+	requests := f.received()
+	if len(requests) == 0 {
+		t.Fatal("no request received by Honeycomb")
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(requests[len(requests)-1].Body, &fields); err != nil {
+		t.Fatalf("error decoding the event %s: %v", requests[len(requests)-1].Body, err)
+	}
+	return fields
+}
+
+// lastBatch decodes the body of the last batch request.
+func (f *fakeHoneycomb) lastBatch(t *testing.T) []batchEvent {
+	t.Helper()
+	requests := f.received()
+	if len(requests) == 0 {
+		t.Fatal("no request received by Honeycomb")
+	}
+	var events []batchEvent
+	if err := json.Unmarshal(requests[len(requests)-1].Body, &events); err != nil {
+		t.Fatalf("error decoding the batch %s: %v", requests[len(requests)-1].Body, err)
+	}
+	return events
+}
+
+// acceptAll answers like Honeycomb accepting everything.
+func acceptAll(w http.ResponseWriter, r *http.Request, body []byte) {
+	if !strings.HasPrefix(r.URL.Path, "/1/batch") {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	var events []json.RawMessage
+	_ = json.Unmarshal(body, &events)
+	statuses := make([]batchEventResponse, len(events))
+	for i := range statuses {
+		statuses[i].Status = http.StatusAccepted
+	}
+	_ = json.NewEncoder(w).Encode(statuses)
+}
+
+// respondWith answers every request with the status and body.
+func respondWith(status int, body string) func(w http.ResponseWriter, r *http.Request, _ []byte) {
+	return func(w http.ResponseWriter, r *http.Request, _ []byte) {
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
+
+// discardLogger drops the logs of the code under test.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// logRecorder is a log output the tests can inspect, safe for concurrent writes.
+type logRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *logRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+// records decodes the JSON log records written so far with the message.
+func (r *logRecorder) records(t *testing.T, message string) []map[string]any {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records []map[string]any
+	for _, line := range bytes.Split(r.buf.Bytes(), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("error decoding the log record %s: %v", line, err)
+		}
+		if record[slog.MessageKey] == message {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// newRecordingLogger returns a logger writing JSON records to the recorder.
+func newRecordingLogger(r *logRecorder) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(r, nil))
+}
+
+// quietContext returns a context whose logger drops the logs, for the functions called without a handler.
+func quietContext() context.Context {
+	return withLogger(context.Background(), discardLogger)
+}
+
+// testConfig returns a configuration sending to the fake Honeycomb API, quietly.
+func testConfig(f *fakeHoneycomb) Config {
+	return Config{
+		Dataset:    "test-dataset",
+		APIKey:     "test-api-key",
+		APIURL:     f.URL,
+		HTTPClient: f.Client(),
+		Logger:     discardLogger,
+	}
+}
+
+// pubSubEvent returns the CloudEvent EventArc delivers for the PubSub message.
+func pubSubEvent(t testing.TB, msg PubSubMessage) event.Event {
+	t.Helper()
+	if msg.MessageID == "" {
+		msg.MessageID = "message-1"
+	}
+	if msg.PublishTime.IsZero() {
+		msg.PublishTime = testPublishTime
+	}
+	e := event.New()
+	e.SetID(msg.MessageID)
+	e.SetType(pubSubEventType)
+	e.SetSource("//pubsub.googleapis.com/projects/my-project/topics/my-topic")
+	data := MessagePublishedData{Message: msg, Subscription: "projects/my-project/subscriptions/my-sub"}
+	if err := e.SetData(event.ApplicationJSON, data); err != nil {
+		t.Fatalf("error encoding the CloudEvent data: %v", err)
+	}
+	return e
+}
+
+// handleData runs the data through a handler built with cfg.
+func handleData(t *testing.T, cfg Config, data string) error {
+	t.Helper()
+	handler, _ := NewHandler(cfg)
+	return handler(context.Background(), pubSubEvent(t, PubSubMessage{Data: []byte(data)}))
+}
+
+func TestHandlerResponseStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantErr   bool
+		permanent bool
+	}{
+		{name: "200 accepted", status: http.StatusOK},
+		{name: "202 accepted", status: http.StatusAccepted},
+		{name: "400 bad request", status: http.StatusBadRequest, wantErr: true, permanent: true},
+		{name: "401 bad API key", status: http.StatusUnauthorized, wantErr: true, permanent: true},
+		{name: "404 unknown dataset", status: http.StatusNotFound, wantErr: true, permanent: true},
+		{name: "500 internal error", status: http.StatusInternalServerError, wantErr: true},
+		{name: "503 unavailable", status: http.StatusServiceUnavailable, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, respondWith(tt.status, `{"error":"test"}`))
+
+			err := handleData(t, testConfig(honeycomb), `{"hello":"world"}`)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handler error = %v, want error: %v", err, tt.wantErr)
+			}
+			if len(honeycomb.received()) != 1 {
+				t.Errorf("Honeycomb received %d requests, want 1", len(honeycomb.received()))
+			}
+			if !tt.wantErr {
+				return
+			}
+			var statusErr *honeycombStatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status {
+				t.Errorf("error = %v, want a honeycombStatusError with status %d", err, tt.status)
+			}
+			if got := errors.Is(err, ErrPermanent); got != tt.permanent {
+				t.Errorf("errors.Is(err, ErrPermanent) = %v, want %v", got, tt.permanent)
+			}
+			if got := errors.Is(err, ErrTransient); got == tt.permanent {
+				t.Errorf("errors.Is(err, ErrTransient) = %v, want %v", got, !tt.permanent)
+			}
+		})
+	}
+}
+
+func TestHandlerConcurrentMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages int
+		batch    bool
+	}{
+		{name: "single events", messages: 50},
+		{name: "batch endpoint", messages: 50, batch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.Batch = tt.batch
+			handler, _ := NewHandler(cfg)
+
+			var wg sync.WaitGroup
+			errs := make([]error, tt.messages)
+			for i := 0; i < tt.messages; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					data, _ := json.Marshal(map[string]int{"n": i})
+					e := pubSubEvent(t, PubSubMessage{Data: data, MessageID: "message-" + strconv.Itoa(i)})
+					errs[i] = handler(context.Background(), e)
+				}(i)
+			}
+			wg.Wait()
+			for i, err := range errs {
+				if err != nil {
+					t.Errorf("message %d: handler error = %v", i, err)
+				}
+			}
+
+			// Every message reaches Honeycomb with its own data, once
+			seen := map[int]int{}
+			for _, r := range honeycomb.received() {
+				data := r.Body
+				if tt.batch {
+					var events []batchEvent
+					if err := json.Unmarshal(r.Body, &events); err != nil || len(events) != 1 {
+						t.Fatalf("batch body %s, want a single event", r.Body)
+					}
+					data = events[0].Data
+				}
+				var fields map[string]int
+				if err := json.Unmarshal(data, &fields); err != nil {
+					t.Fatalf("event %s, want the message data", data)
+				}
+				seen[fields["n"]]++
+			}
+			for i := 0; i < tt.messages; i++ {
+				if seen[i] != 1 {
+					t.Errorf("message %d sent %d times, want 1", i, seen[i])
+				}
+			}
+		})
+	}
+}
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestHandlerHTTPClientAndAPIURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		customClient bool
+		apiURLSuffix string
+		dataset      string
+		wantPath     string
+	}{
+		{name: "shared client", dataset: "test-dataset", wantPath: "/1/events/test-dataset"},
+		{name: "custom client", customClient: true, dataset: "test-dataset", wantPath: "/1/events/test-dataset"},
+		{name: "trailing slash", apiURLSuffix: "/", dataset: "test-dataset", wantPath: "/1/events/test-dataset"},
+		{name: "escaped dataset", dataset: "my dataset", wantPath: "/1/events/my%20dataset"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.APIURL += tt.apiURLSuffix
+			cfg.Dataset = tt.dataset
+			cfg.HTTPClient = nil
+			transport := &countingTransport{}
+			if tt.customClient {
+				cfg.HTTPClient = &http.Client{Transport: transport}
+			}
+			handler, _ := NewHandler(cfg)
+
+			const messages = 3
+			for i := 0; i < messages; i++ {
+				e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"n":1}`), MessageID: "message-" + strconv.Itoa(i)})
+				if err := handler(context.Background(), e); err != nil {
+					t.Fatalf("handler error = %v", err)
+				}
+			}
+
+			requests := honeycomb.received()
+			if len(requests) != messages {
+				t.Fatalf("Honeycomb received %d requests, want %d", len(requests), messages)
+			}
+			conns := map[string]bool{}
+			for _, r := range requests {
+				conns[r.RemoteAddr] = true
+				if r.Method != http.MethodPost || r.Path != tt.wantPath {
+					t.Errorf("request %s %s, want POST %s", r.Method, r.Path, tt.wantPath)
+				}
+				if got := r.Header.Get("X-Honeycomb-Team"); got != "test-api-key" {
+					t.Errorf("X-Honeycomb-Team = %q, want the API key", got)
+				}
+			}
+			if tt.customClient && transport.requests != messages {
+				t.Errorf("custom client sent %d requests, want %d", transport.requests, messages)
+			}
+			if len(conns) != 1 {
+				t.Errorf("Honeycomb accepted %d connections, want 1 reused by the invocations", len(conns))
+			}
+		})
+	}
+}
+
+// respondSequence answers the requests with the statuses in turn, the last one repeating.
+func respondSequence(statuses ...int) func(w http.ResponseWriter, r *http.Request, body []byte) {
+	var mu sync.Mutex
+	n := 0
+	return func(w http.ResponseWriter, r *http.Request, body []byte) {
+		mu.Lock()
+		status := statuses[min(n, len(statuses)-1)]
+		n++
+		mu.Unlock()
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "7")
+		}
+		if status == http.StatusOK {
+			acceptAll(w, r, body)
+			return
+		}
+		w.WriteHeader(status)
+	}
+}
+
+func TestHandlerErrorPaths(t *testing.T) {
+	tests := []struct {
+		name         string
+		respond      func(w http.ResponseWriter, r *http.Request, body []byte)
+		configure    func(cfg *Config, honeycomb *fakeHoneycomb)
+		wantRequests int
+		wantErr      error // nil for success
+		// the time waited between the attempts, the backoff has a full jitter
+		minWaited, maxWaited time.Duration
+	}{
+		{
+			name:         "5xx retried until success",
+			respond:      respondSequence(http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK),
+			wantRequests: 3,
+			maxWaited:    retryBaseDelay + 2*retryBaseDelay,
+		},
+		{
+			name:         "5xx retries exhausted",
+			respond:      respondSequence(http.StatusInternalServerError),
+			wantRequests: 4,
+			wantErr:      ErrTransient,
+		},
+		{
+			name:         "429 waits for Retry-After",
+			respond:      respondSequence(http.StatusTooManyRequests, http.StatusOK),
+			wantRequests: 2,
+			minWaited:    7 * time.Second,
+			maxWaited:    7 * time.Second,
+		},
+		{
+			name:         "4xx not retried",
+			respond:      respondSequence(http.StatusForbidden),
+			wantRequests: 1,
+			wantErr:      ErrPermanent,
+		},
+		{
+			name: "network error retried",
+			configure: func(cfg *Config, honeycomb *fakeHoneycomb) {
+				honeycomb.Close()
+			},
+			wantErr: ErrTransient,
+		},
+		{
+			name: "request timeout",
+			respond: func(w http.ResponseWriter, r *http.Request, _ []byte) {
+				<-r.Context().Done()
+			},
+			configure: func(cfg *Config, _ *fakeHoneycomb) {
+				cfg.Timeout = 50 * time.Millisecond
+				cfg.MaxRetries = 0
+			},
+			wantRequests: 1,
+			wantErr:      ErrTransient,
+		},
+		{
+			name: "invalid API URL",
+			configure: func(cfg *Config, _ *fakeHoneycomb) {
+				cfg.APIURL = "ftp://api.honeycomb.io"
+			},
+			wantErr: errors.New("honeycomb API URL must be an absolute http(s) URL"),
+		},
+		{
+			name: "invalid dataset",
+			configure: func(cfg *Config, _ *fakeHoneycomb) {
+				cfg.Dataset = "../datasets"
+			},
+			wantErr: ErrPermanent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, tt.respond)
+			clock := newFakeClock(testPublishTime)
+			clock.skipWaits = true
+			cfg := testConfig(honeycomb)
+			cfg.MaxRetries = 3
+			cfg.Clock = clock
+			if tt.configure != nil {
+				tt.configure(&cfg, honeycomb)
+			}
+
+			err := handleData(t, cfg, `{"hello":"world"}`)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("handler error = %v, want nil", err)
+			case tt.wantErr == ErrTransient || tt.wantErr == ErrPermanent:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("handler error = %v, want a %v error", err, tt.wantErr)
+				}
+			case tt.wantErr != nil && (err == nil || !strings.Contains(err.Error(), tt.wantErr.Error())):
+				t.Fatalf("handler error = %v, want %q", err, tt.wantErr)
+			}
+			if got := len(honeycomb.received()); got != tt.wantRequests {
+				t.Errorf("Honeycomb received %d requests, want %d", got, tt.wantRequests)
+			}
+			if waited := since(clock, testPublishTime); tt.maxWaited > 0 && (waited < tt.minWaited || waited > tt.maxWaited) {
+				t.Errorf("waited %v between the attempts, want between %v and %v", waited, tt.minWaited, tt.maxWaited)
+			}
+		})
+	}
+}
+
+func TestSendEvent(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	cfg.MaxEventBytes = 50
+
+	// The payload goes through the same validation as the PubSub data
+	if err := SendEvent(context.Background(), cfg, []byte(`{"message":"card declined"}`)); err != nil {
+		t.Fatalf("SendEvent() error = %v", err)
+	}
+	requests := honeycomb.received()
+	if len(requests) != 1 || requests[0].Path != "/1/events/test-dataset" ||
+		string(requests[0].Body) != `{"message":"card declined"}` ||
+		requests[0].Header.Get("X-Honeycomb-Team") != "test-api-key" {
+		t.Errorf("requests = %+v, want the event sent to the dataset", requests)
+	}
+	err := SendEvent(context.Background(), cfg, []byte(`{"message":"`+strings.Repeat("x", 50)+`"}`))
+	var tooLarge *errEventTooLarge
+	if !errors.As(err, &tooLarge) {
+		t.Errorf("SendEvent() error = %v, want the oversized event rejected", err)
+	}
+	if err := SendEvent(context.Background(), cfg, []byte(`not json`)); !errors.Is(err, ErrPermanent) {
+		t.Errorf("SendEvent() error = %v, want a permanent error for an invalid payload", err)
+	}
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("%d requests, want the invalid events not sent", n)
+	}
+}
+
+func TestHandlerDryRun(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		t.Run("batch "+strconv.FormatBool(batch), func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			var logs logRecorder
+			cfg := testConfig(honeycomb)
+			cfg.DryRun = true
+			cfg.Batch = batch
+			cfg.Logger = newRecordingLogger(&logs)
+			handler, _ := NewHandler(cfg)
+
+			if err := handler(quietContext(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if n := len(honeycomb.received()); n != 0 {
+				t.Errorf("%d requests, want none on a dry run", n)
+			}
+			records := logs.records(t, "dry run, request not sent to Honeycomb")
+			if len(records) != 1 {
+				t.Fatalf("log records = %v, want the request logged", records)
+			}
+			headers, _ := records[0]["headers"].(map[string]any)
+			if key := fmt.Sprint(headers["X-Honeycomb-Team"]); key != "[********-key]" {
+				t.Errorf("logged API key = %s, want it redacted", key)
+			}
+			if body, _ := records[0]["body"].(string); !strings.Contains(body, `"a":1`) ||
+				records[0]["dataset"] != "test-dataset" || records[0]["message_id"] != "message-1" {
+				t.Errorf("log record = %v, want the request logged with the message", records[0])
+			}
+		})
+	}
+}
+
+func TestHandlerGzip(t *testing.T) {
+	const data = `{"message":"a large payload","padding":"` + "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx" + `"}`
+	tests := []struct {
+		name         string
+		gzip         bool
+		batch        bool
+		wantEncoding string
+	}{
+		{name: "off", wantEncoding: ""},
+		{name: "on", gzip: true, wantEncoding: "gzip"},
+		{name: "on with batch", gzip: true, batch: true, wantEncoding: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.Gzip = tt.gzip
+			cfg.Batch = tt.batch
+
+			if err := handleData(t, cfg, data); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			r := honeycomb.received()[0]
+			if got := r.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			// The fake Honeycomb decompresses gzip bodies, and fails the test when they can't be
+			body := r.Body
+			if tt.batch {
+				body = honeycomb.lastBatch(t)[0].Data
+			}
+			if string(body) != data {
+				t.Errorf("Honeycomb received %s, want %s", body, data)
+			}
+		})
+	}
+}
+
+func TestGzipPayload(t *testing.T) {
+	for _, payload := range []string{"", `{"a":1}`, strings.Repeat(`{"key":"value"}`, 1000)} {
+		compressed, err := gzipPayload([]byte(payload))
+		if err != nil {
+			t.Fatalf("gzipPayload() error = %v", err)
+		}
+		decompressed, err := gunzip(compressed)
+		if err != nil {
+			t.Fatalf("error decompressing the gzipPayload(%d bytes) output: %v", len(payload), err)
+		}
+		if string(decompressed) != payload {
+			t.Errorf("gzipPayload() round trip of %d bytes returned %d bytes", len(payload), len(decompressed))
+		}
+	}
+}
+
+func TestHandlerEventTime(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 30, 0, 0, time.UTC)
+	publishTime := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	tests := []struct {
+		name        string
+		publishTime time.Time
+		batch       bool
+		want        time.Time
+	}{
+		{name: "publish time", publishTime: publishTime, want: publishTime},
+		{name: "no publish time", want: now},
+		{name: "batch publish time", publishTime: publishTime, batch: true, want: publishTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.Clock = newFakeClock(now)
+			cfg.Batch = tt.batch
+			e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})
+			// pubSubEvent stamps a publish time, the envelope is set again with the one of the test
+			data := MessagePublishedData{Message: PubSubMessage{Data: []byte(`{"a":1}`), MessageID: "message-1",
+				PublishTime: tt.publishTime}}
+			if err := e.SetData(event.ApplicationJSON, data); err != nil {
+				t.Fatal(err)
+			}
+
+			handler, _ := NewHandler(cfg)
+			if err := handler(context.Background(), e); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			header := honeycomb.received()[0].Header.Get("X-Honeycomb-Event-Time")
+			got, err := time.Parse(time.RFC3339Nano, header)
+			if err != nil || !got.Equal(tt.want) {
+				t.Errorf("X-Honeycomb-Event-Time = %q, want %s", header, tt.want.Format(time.RFC3339Nano))
+			}
+			if tt.batch {
+				batchTime := honeycomb.lastBatch(t)[0].Time
+				if got, err := time.Parse(time.RFC3339Nano, batchTime); err != nil || !got.Equal(tt.want) {
+					t.Errorf("batch event time = %q, want %s", batchTime, tt.want.Format(time.RFC3339Nano))
+				}
+			}
+		})
+	}
+}
+
+func TestReadPubSubEvent(t *testing.T) {
+	ceTime := time.Date(2024, 5, 6, 8, 0, 0, 0, time.UTC)
+	newEvent := func(t *testing.T, eventType string, data any) event.Event {
+		e := event.New()
+		e.SetID("ce-id")
+		e.SetType(eventType)
+		e.SetSource("//cloudaudit.googleapis.com/projects/my-project/logs/activity")
+		e.SetTime(ceTime)
+		if err := e.SetData(event.ApplicationJSON, data); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	envelope := MessagePublishedData{
+		Message:      PubSubMessage{Data: []byte(`{"a":1}`), MessageID: "message-1", PublishTime: testPublishTime},
+		Subscription: "projects/my-project/subscriptions/my-sub",
+	}
+	auditEntry := map[string]any{"protoPayload": map[string]any{"methodName": "SetIamPolicy"}}
+	tests := []struct {
+		name            string
+		event           event.Event
+		wantData        string
+		wantMessageID   string
+		wantPublishTime time.Time
+	}{
+		{
+			name:            "Pub/Sub-wrapped",
+			event:           newEvent(t, pubSubEventType, envelope),
+			wantData:        `{"a":1}`,
+			wantMessageID:   "message-1",
+			wantPublishTime: testPublishTime,
+		},
+		{
+			name:            "direct audit log entry",
+			event:           newEvent(t, "google.cloud.audit.log.v1.written", auditEntry),
+			wantData:        `{"protoPayload":{"methodName":"SetIamPolicy"}}`,
+			wantMessageID:   "ce-id",
+			wantPublishTime: ceTime,
+		},
+		{
+			name:            "Pub/Sub envelope of another type",
+			event:           newEvent(t, "com.example.relayed", envelope),
+			wantData:        `{"a":1}`,
+			wantMessageID:   "message-1",
+			wantPublishTime: testPublishTime,
+		},
+		{
+			name:            "direct JSON array",
+			event:           newEvent(t, "com.example.batch", []int{1, 2}),
+			wantData:        `[1,2]`,
+			wantMessageID:   "ce-id",
+			wantPublishTime: ceTime,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := readPubSubEvent(quietContext(), tt.event, Config{})
+			if err != nil {
+				t.Fatalf("readPubSubEvent() error = %v", err)
+			}
+			if string(msg.Message.Data) != tt.wantData {
+				t.Errorf("data = %s, want %s", msg.Message.Data, tt.wantData)
+			}
+			if msg.Message.MessageID != tt.wantMessageID {
+				t.Errorf("message ID = %q, want %q", msg.Message.MessageID, tt.wantMessageID)
+			}
+			if !msg.Message.PublishTime.Equal(tt.wantPublishTime) {
+				t.Errorf("publish time = %v, want %v", msg.Message.PublishTime, tt.wantPublishTime)
+			}
+		})
+	}
+}
+
+func TestHandlerDirectCloudEvent(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	e := event.New()
+	e.SetID("ce-id")
+	e.SetType("google.cloud.storage.object.v1.finalized")
+	e.SetSource("//storage.googleapis.com/projects/_/buckets/my-bucket")
+	if err := e.SetData(event.ApplicationJSON, map[string]string{"name": "object.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	handler, _ := NewHandler(testConfig(honeycomb))
+	if err := handler(context.Background(), e); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	if got := honeycomb.lastEvent(t)["name"]; got != "object.txt" {
+		t.Errorf("forwarded name = %v, want the CloudEvent data", got)
+	}
+}
+
+func TestHandlerEmptyData(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		skipEmpty bool
+		wantErr   bool
+	}{
+		{name: "empty skipped", data: "", skipEmpty: true},
+		{name: "whitespace skipped", data: " \n\t", skipEmpty: true},
+		{name: "empty fails when strict", data: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.SkipEmpty = tt.skipEmpty
+
+			err := handleData(t, cfg, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handler error = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrPermanent) {
+				t.Errorf("error = %v, want a permanent error", err)
+			}
+			if n := len(honeycomb.received()); n != 0 {
+				t.Errorf("Honeycomb received %d requests, want none", n)
+			}
+		})
+	}
+
+	t.Run("skipped by default", func(t *testing.T) {
+		t.Setenv("HONEYCOMB_DATASET", "test-dataset")
+		t.Setenv("HONEYCOMB_API_KEY", "test-api-key")
+		cfg, err := ConfigFromEnv()
+		if err != nil {
+			t.Fatalf("ConfigFromEnv() error = %v", err)
+		}
+		if !cfg.SkipEmpty {
+			t.Error("SkipEmpty = false without SKIP_EMPTY, want true")
+		}
+	})
+}
+
+func TestSampleDeterministic(t *testing.T) {
+	const rate = 4
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		value := "trace-" + strconv.Itoa(i)
+		decision := sampleDeterministic(value, rate)
+		for j := 0; j < 3; j++ {
+			if sampleDeterministic(value, rate) != decision {
+				t.Fatalf("sampleDeterministic(%q) changed its decision", value)
+			}
+		}
+		if decision {
+			kept++
+		}
+		if !sampleDeterministic(value, 1) {
+			t.Fatalf("sampleDeterministic(%q, 1) dropped the value", value)
+		}
+	}
+	// 1 in 4 on average, with a wide margin
+	if kept < 2000 || kept > 3000 {
+		t.Errorf("sampleDeterministic kept %d values out of 10000 at rate %d, want about 2500", kept, rate)
+	}
+}
+
+func TestHandlerSampleKey(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	cfg.SampleRate = 3
+	cfg.SampleKey = "trace.id"
+	handler, _ := NewHandler(cfg)
+
+	// All the events of a trace are kept or dropped together
+	for trace := 0; trace < 30; trace++ {
+		traceID := "trace-" + strconv.Itoa(trace)
+		before := len(honeycomb.received())
+		for span := 0; span < 5; span++ {
+			data := `{"trace":{"id":"` + traceID + `"},"span":` + strconv.Itoa(span) + `}`
+			e := pubSubEvent(t, PubSubMessage{Data: []byte(data), MessageID: traceID + "-" + strconv.Itoa(span)})
+			if err := handler(context.Background(), e); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+		}
+		sent := len(honeycomb.received()) - before
+		want := 0
+		if sampleDeterministic(traceID, cfg.SampleRate) {
+			want = 5
+		}
+		if sent != want {
+			t.Errorf("%s: %d of its 5 events sent, want %d", traceID, sent, want)
+		}
+	}
+	for _, r := range honeycomb.received() {
+		if got := r.Header.Get("X-Honeycomb-Samplerate"); got != "3" {
+			t.Errorf("X-Honeycomb-Samplerate = %q, want 3", got)
+		}
+	}
+
+	// Messages without the key are sampled randomly, some are kept and some dropped
+	before := len(honeycomb.received())
+	for i := 0; i < 100; i++ {
+		e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"other":1}`), MessageID: "random-" + strconv.Itoa(i)})
+		if err := handler(context.Background(), e); err != nil {
+			t.Fatalf("handler error = %v", err)
+		}
+	}
+	if sent := len(honeycomb.received()) - before; sent == 0 || sent == 100 {
+		t.Errorf("%d of 100 messages without the sample key sent, want a random sample", sent)
+	}
+}
+
+func TestMessageSampleRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]string
+		want  int
+	}{
+		{name: "no attribute", want: 10},
+		{name: "pinned", attrs: map[string]string{sampleRateAttribute: "1"}, want: 1},
+		{name: "higher", attrs: map[string]string{sampleRateAttribute: "50"}, want: 50},
+		{name: "spaces", attrs: map[string]string{sampleRateAttribute: " 5 "}, want: 5},
+		{name: "zero", attrs: map[string]string{sampleRateAttribute: "0"}, want: 10},
+		{name: "negative", attrs: map[string]string{sampleRateAttribute: "-3"}, want: 10},
+		{name: "not an integer", attrs: map[string]string{sampleRateAttribute: "2.5"}, want: 10},
+		{name: "empty", attrs: map[string]string{sampleRateAttribute: ""}, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := MessagePublishedData{Message: PubSubMessage{Attributes: tt.attrs}}
+			if got := messageSampleRate(quietContext(), 10, msg); got != tt.want {
+				t.Errorf("messageSampleRate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerSampleRateAttribute(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate int
+		attribute  string
+		wantRate   int
+	}{
+		{name: "pinned at 1 under a high global rate", sampleRate: 1000000, attribute: "1", wantRate: 1},
+		{name: "sampled under no global rate", sampleRate: 1, attribute: "4", wantRate: 4},
+		{name: "invalid attribute falls back", sampleRate: 1, attribute: "often", wantRate: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.SampleRate = tt.sampleRate
+			cfg.SampleKey = "id"
+			handler, _ := NewHandler(cfg)
+
+			want := 0
+			for i := 0; i < 40; i++ {
+				id := "id-" + strconv.Itoa(i)
+				e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"id":"` + id + `"}`), MessageID: id,
+					Attributes: map[string]string{sampleRateAttribute: tt.attribute}})
+				if err := handler(context.Background(), e); err != nil {
+					t.Fatalf("handler error = %v", err)
+				}
+				if sampleDeterministic(id, tt.wantRate) {
+					want++
+				}
+			}
+			requests := honeycomb.received()
+			if len(requests) != want {
+				t.Errorf("%d of 40 messages sent, want %d at rate %d", len(requests), want, tt.wantRate)
+			}
+			wantHeader := ""
+			if tt.wantRate > 1 {
+				wantHeader = strconv.Itoa(tt.wantRate)
+			}
+			for _, r := range requests {
+				if got := r.Header.Get("X-Honeycomb-Samplerate"); got != wantHeader {
+					t.Errorf("X-Honeycomb-Samplerate = %q, want %q", got, wantHeader)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerMaxInFlightBytes(t *testing.T) {
+	const (
+		maxInFlight = 100 << 10
+		payloadSize = 40 << 10
+		messages    = 12
+	)
+	var mu sync.Mutex
+	inFlight, peak, peakRequests, requests := 0, 0, 0, 0
+	honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		mu.Lock()
+		inFlight += len(body)
+		requests++
+		peak, peakRequests = max(peak, inFlight), max(peakRequests, requests)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight -= len(body)
+		requests--
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+	cfg := testConfig(honeycomb)
+	cfg.MaxInFlightBytes = maxInFlight
+	handler, _ := NewHandler(cfg)
+	payload := `{"pad":"` + strings.Repeat("x", payloadSize-10) + `"}`
+
+	var wg sync.WaitGroup
+	for i := 0; i < messages; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e := pubSubEvent(t, PubSubMessage{Data: []byte(payload), MessageID: "message-" + strconv.Itoa(i)})
+			if err := handler(context.Background(), e); err != nil {
+				t.Errorf("handler error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(honeycomb.received()); n != messages {
+		t.Fatalf("%d events received, want %d", n, messages)
+	}
+	if peak > maxInFlight {
+		t.Errorf("%d bytes in flight at the peak, want at most %d", peak, maxInFlight)
+	}
+	if peakRequests != maxInFlight/payloadSize {
+		t.Errorf("%d requests in flight at the peak, want the %d fitting under the cap",
+			peakRequests, maxInFlight/payloadSize)
+	}
+}
+
+func TestHandlerMaxInFlightBytesWait(t *testing.T) {
+	release := make(chan struct{})
+	honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	cfg := testConfig(honeycomb)
+	cfg.MaxInFlightBytes = 100
+	handler, _ := NewHandler(cfg)
+	large := `{"pad":"` + strings.Repeat("x", 200) + `"}`
+
+	// A message larger than the cap is processed, alone
+	done := make(chan error, 1)
+	go func() {
+		done <- handler(context.Background(), pubSubEvent(t, PubSubMessage{Data: []byte(large)}))
+	}()
+	for len(honeycomb.received()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The next one waits for it until its deadline, and is retried by PubSub
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := handler(ctx, pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`), MessageID: "message-2"}))
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("handler error = %v, want a transient error once the deadline is exceeded", err)
+	}
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("%d requests sent, want the waiting message held back", n)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("handler error for the large message = %v", err)
+	}
+	e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`), MessageID: "message-3"})
+	if err := handler(context.Background(), e); err != nil {
+		t.Errorf("handler error once the capacity is freed = %v", err)
+	}
+}
+
+func TestHandlerMaxInFlightBytesDecompressed(t *testing.T) {
+	release := make(chan struct{})
+	honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if bytes.Contains(body, []byte("pad")) {
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	cfg := testConfig(honeycomb)
+	cfg.MaxInFlightBytes = 1000
+	handler, _ := NewHandler(cfg)
+	compressed := gzipData(t, []byte(`{"pad":"`+strings.Repeat("x", 2000)+`"}`))
+
+	// A compressed message is charged its decompressed size
+	done := make(chan error, 1)
+	go func() {
+		msg := PubSubMessage{Data: compressed, Attributes: map[string]string{contentEncodingAttribute: "gzip"}}
+		done <- handler(context.Background(), pubSubEvent(t, msg))
+	}()
+	for len(honeycomb.received()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := handler(ctx, pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`), MessageID: "message-2"}))
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("handler error = %v, want the message to wait for the decompressed one", err)
+	}
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("%d events sent, want the waiting message not sent", n)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("handler error for the compressed message = %v", err)
+	}
+}
+
+func TestHandlerMaxInFlightBytesOrdering(t *testing.T) {
+	release := make(chan struct{})
+	honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if bytes.Contains(body, []byte("first")) {
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	cfg := testConfig(honeycomb)
+	cfg.MaxInFlightBytes = 100
+	cfg.RespectOrdering = true
+	handler, _ := NewHandler(cfg)
+	half := func(name string) []byte {
+		return []byte(`{"pad":"` + strings.Repeat("x", 50-len(name)-11) + name + `"}`)
+	}
+
+	// The second message of the key waits for the first one without holding in-flight bytes
+	done := make(chan error, 2)
+	for i, name := range []string{"first", "second"} {
+		msg := PubSubMessage{Data: half(name), MessageID: "message-" + strconv.Itoa(i+1), OrderingKey: "key"}
+		go func() { done <- handler(context.Background(), pubSubEvent(t, msg)) }()
+		for len(honeycomb.received()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`), MessageID: "message-3"})
+	if err := handler(ctx, e); err != nil {
+		t.Errorf("handler error = %v, want the message of another key sent", err)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("handler error for an ordered message = %v", err)
+		}
+	}
+}
+
+func TestHandlerCESource(t *testing.T) {
+	const (
+		auditSource  = "//cloudaudit.googleapis.com/projects/my-project/logs/activity"
+		auditSubject = "storage.googleapis.com/projects/_/buckets/my-bucket/objects/report.csv"
+		pubSubSource = "//pubsub.googleapis.com/projects/my-project/topics/my-topic"
+	)
+	newEvent := func(t *testing.T, source, subject string) event.Event {
+		e := event.New()
+		e.SetID("ce-id")
+		e.SetType("google.cloud.audit.log.v1.written")
+		e.SetSource(source)
+		e.SetSubject(subject)
+		data := map[string]string{"methodName": "storage.objects.create"}
+		if err := e.SetData(event.ApplicationJSON, data); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	tests := []struct {
+		name    string
+		enabled bool
+		event   func(t *testing.T) event.Event
+		want    map[string]any
+	}{
+		{
+			name:    "source and subject",
+			enabled: true,
+			event:   func(t *testing.T) event.Event { return newEvent(t, auditSource, auditSubject) },
+			want: map[string]any{"methodName": "storage.objects.create", "ce.source": auditSource,
+				"ce.subject": auditSubject},
+		},
+		{
+			name:    "no subject",
+			enabled: true,
+			event: func(t *testing.T) event.Event {
+				return pubSubEvent(t, PubSubMessage{Data: []byte(`{"methodName":"storage.objects.create"}`)})
+			},
+			want: map[string]any{"methodName": "storage.objects.create", "ce.source": pubSubSource},
+		},
+		{
+			name:  "disabled",
+			event: func(t *testing.T) event.Event { return newEvent(t, auditSource, auditSubject) },
+			want:  map[string]any{"methodName": "storage.objects.create"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.IncludeCESource = tt.enabled
+			handler, _ := NewHandler(cfg)
+			if err := handler(context.Background(), tt.event(t)); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if got := honeycomb.lastEvent(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("event = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerLogsToConfigLogger(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		setup   func(cfg *Config)
+		status  int
+		message string
+	}{
+		{name: "not an object", data: `"text"`,
+			setup:   func(cfg *Config) { cfg.StaticFields = map[string]any{"a": 1} },
+			message: "payload is not a JSON object, static fields and PubSub metadata are not added"},
+		{name: "truncated", data: `{"pad":"` + strings.Repeat("x", 100) + `"}`,
+			setup:   func(cfg *Config) { cfg.MaxEventBytes, cfg.TruncateOversized = 50, true },
+			message: "event over the size limit truncated"},
+		{name: "queued send failed", data: `{"a":1}`, status: http.StatusBadRequest,
+			setup:   func(cfg *Config) { cfg.AsyncWorkers = 1 },
+			message: "error sending a queued event, it is lost as its message was acknowledged"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+				if tt.status != 0 {
+					http.Error(w, `{"error":"rejected"}`, tt.status)
+					return
+				}
+				acceptAll(w, r, body)
+			})
+			var logs logRecorder
+			cfg := testConfig(honeycomb)
+			cfg.Logger = newRecordingLogger(&logs)
+			tt.setup(&cfg)
+			handler, shutdown := NewHandler(cfg)
+
+			if err := handler(context.Background(), pubSubEvent(t, PubSubMessage{Data: []byte(tt.data)})); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if err := shutdown(context.Background()); err != nil {
+				t.Fatalf("shutdown() error = %v", err)
+			}
+			// The logs go to the logger of the invocation, bound to its message
+			records := logs.records(t, tt.message)
+			if len(records) != 1 || records[0]["message_id"] != "message-1" {
+				t.Errorf("logs %q = %v, want one about message-1", tt.message, records)
+			}
+		})
+	}
+}
+
+func TestHandlerFallback(t *testing.T) {
+	tests := []struct {
+		name             string
+		primary          func(w http.ResponseWriter, r *http.Request, body []byte)
+		fallback         func(w http.ResponseWriter, r *http.Request, body []byte)
+		noFallback       bool
+		data             string
+		wantErr          error // nil for success
+		wantPrimary      int
+		wantFallback     int
+		wantFallbackPath string
+	}{
+		{
+			name:             "primary 5xx, fallback succeeds",
+			primary:          respondSequence(http.StatusBadGateway),
+			data:             `{"a":1}`,
+			wantPrimary:      2,
+			wantFallback:     1,
+			wantFallbackPath: "/1/events/test-dataset",
+		},
+		{
+			name:             "batch failed over",
+			primary:          respondSequence(http.StatusServiceUnavailable),
+			data:             `[{"a":1},{"b":2}]`,
+			wantPrimary:      2,
+			wantFallback:     1,
+			wantFallbackPath: "/1/batch/test-dataset",
+		},
+		{
+			name:         "primary succeeds after a retry",
+			primary:      respondSequence(http.StatusBadGateway, http.StatusOK),
+			data:         `{"a":1}`,
+			wantPrimary:  2,
+			wantFallback: 0,
+		},
+		{
+			name:         "primary 4xx not failed over",
+			primary:      respondSequence(http.StatusForbidden),
+			data:         `{"a":1}`,
+			wantErr:      ErrPermanent,
+			wantPrimary:  1,
+			wantFallback: 0,
+		},
+		{
+			name:             "fallback fails too",
+			primary:          respondSequence(http.StatusBadGateway),
+			fallback:         respondSequence(http.StatusInternalServerError),
+			data:             `{"a":1}`,
+			wantErr:          ErrTransient,
+			wantPrimary:      2,
+			wantFallback:     2,
+			wantFallbackPath: "/1/events/test-dataset",
+		},
+		{
+			name:        "no fallback",
+			primary:     respondSequence(http.StatusBadGateway),
+			noFallback:  true,
+			data:        `{"a":1}`,
+			wantErr:     ErrTransient,
+			wantPrimary: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := newFakeHoneycomb(t, tt.primary)
+			fallback := newFakeHoneycomb(t, tt.fallback)
+			clock := newFakeClock(testPublishTime)
+			clock.skipWaits = true
+			var logs logRecorder
+			cfg := testConfig(primary)
+			cfg.Logger = newRecordingLogger(&logs)
+			cfg.Clock = clock
+			cfg.MaxRetries = 1
+			if !tt.noFallback {
+				cfg.FallbackURL = fallback.URL + "/"
+			}
+
+			err := handleData(t, cfg, tt.data)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("handler error = %v, want nil", err)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Fatalf("handler error = %v, want a %v error", err, tt.wantErr)
+			}
+			if n := len(primary.received()); n != tt.wantPrimary {
+				t.Errorf("primary received %d requests, want %d", n, tt.wantPrimary)
+			}
+			requests := fallback.received()
+			if len(requests) != tt.wantFallback {
+				t.Fatalf("fallback received %d requests, want %d", len(requests), tt.wantFallback)
+			}
+			if tt.wantFallback == 0 {
+				return
+			}
+			key := requests[0].Header.Get("X-Honeycomb-Team")
+			if requests[0].Path != tt.wantFallbackPath || key != "test-api-key" {
+				t.Errorf("fallback request = %s with key %q, want %s with the API key",
+					requests[0].Path, key, tt.wantFallbackPath)
+			}
+			succeeded := logs.records(t, "Honeycomb send succeeded on the fallback endpoint")
+			if tt.wantErr != nil {
+				if len(succeeded) != 0 {
+					t.Errorf("fallback success logs = %v, want none", succeeded)
+				}
+				return
+			}
+			if len(succeeded) != 1 || succeeded[0]["endpoint"] != fallback.URL+tt.wantFallbackPath {
+				t.Errorf("fallback success logs = %v, want one for %s", succeeded, fallback.URL+tt.wantFallbackPath)
+			}
+		})
+	}
+}
+
+func TestRecordFailover(t *testing.T) {
+	clock := newFakeClock(testPublishTime)
+	m := newMetricsRegistry("http://collector", nil, time.Minute, http.DefaultClient, clock)
+	m.recordFailover(nil)
+	m.recordFailover(nil)
+	m.recordFailover(errors.New("fallback down"))
+	if m.failovers["ok"] != 2 || m.failovers["error"] != 1 {
+		t.Errorf("failovers = %v, want ok:2 error:1", m.failovers)
+	}
+}
+
+// stubTransport answers every request with a 200 without going through the network, so the benchmarks
+// measure the sink rather than the loopback.
+type stubTransport struct{}
+
+func (stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_ = r.Body.Close()
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: r}, nil
+}
+
+func BenchmarkHandler(b *testing.B) {
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "gzip"
+		}
+		b.Run(name, func(b *testing.B) {
+			handler, _ := NewHandler(Config{
+				Dataset:    "test-dataset",
+				APIKey:     "test-api-key",
+				APIURL:     "http://honeycomb.invalid",
+				HTTPClient: &http.Client{Transport: stubTransport{}},
+				Gzip:       compress,
+				Logger:     discardLogger,
+			})
+			e := pubSubEvent(b, PubSubMessage{Data: []byte(`{"level":"info","msg":"request served","status":200}`)})
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := handler(ctx, e); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultShutdownTimeout fits in the 10s Cloud Run gives an instance between SIGTERM and SIGKILL.
+const defaultShutdownTimeout = 8 * time.Second
+
+// drainer shuts a handler down: it runs the hooks flushing its buffered events and waits for its
+// in-flight invocations. Each handler has its own, so building handlers doesn't accumulate hooks.
+type drainer struct {
+	timeout time.Duration
+	clock   Clock
+	logger  *slog.Logger
+	clients []*http.Client
+
+	mu       sync.Mutex
+	hooks    []func()
+	inFlight sync.WaitGroup
+	once     sync.Once
+	err      error
+}
+
+func newDrainer(cfg Config) *drainer {
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	return &drainer{timeout: timeout, clock: cfg.Clock, logger: cfg.Logger,
+		clients: []*http.Client{cfg.HTTPClient, cfg.ServicesHTTPClient}}
+}
+
+// onShutdown registers a hook run on shutdown, e.g: to flush buffered events before Cloud Run / Cloud
+// Functions scales the instance down. Hooks run in the reverse order of their registration, so a
+// component is drained before the ones it feeds, which were built first.
+func (d *drainer) onShutdown(hook func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks = append(d.hooks, hook)
+}
+
+// trackInFlight records an invocation the shutdown waits for, and returns the function ending it.
+func (d *drainer) trackInFlight() func() {
+	d.inFlight.Add(1)
+	return d.inFlight.Done
+}
+
+// shutdown runs the hooks and waits for the in-flight invocations, up to the shutdown timeout or the end
+// of ctx, then closes the idle connections. Only the first call drains, the next ones return its result.
+func (d *drainer) shutdown(ctx context.Context) error {
+	d.once.Do(func() {
+		d.mu.Lock()
+		hooks := d.hooks
+		d.mu.Unlock()
+
+		drained := make(chan struct{})
+		go func() {
+			for i := len(hooks) - 1; i >= 0; i-- {
+				hooks[i]()
+			}
+			d.inFlight.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-d.clock.After(d.timeout):
+			d.err = fmt.Errorf("error draining the handler, the shutdown timeout %s was reached", d.timeout)
+		case <-ctx.Done():
+			d.err = fmt.Errorf("error draining the handler %w", ctx.Err())
+		}
+		if d.err != nil {
+			d.logger.Warn("shutdown ended before the end of the drain, pending events may be lost",
+				"timeout", d.timeout.String(), "error", d.err.Error())
+		}
+		for _, client := range d.clients {
+			client.CloseIdleConnections()
+		}
+	})
+	return d.err
+}
+
+// handleSIGTERM drains the handler with shutdown and exits when the instance receives SIGTERM. Only the
+// deployed function installs it, as it exits the process: a program importing the package calls the
+// shutdown function returned by NewHandler from its own handler.
+func handleSIGTERM(shutdown func(ctx context.Context) error, logger *slog.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	go drainOnSignal(signals, shutdown, logger, os.Exit)
+}
+
+// drainOnSignal waits for a signal, then drains with shutdown and exits with exit.
+func drainOnSignal(signals <-chan os.Signal, shutdown func(ctx context.Context) error, logger *slog.Logger,
+	exit func(code int)) {
+	<-signals
+	logger.Info("SIGTERM received, draining")
+	if err := shutdown(context.Background()); err == nil {
+		logger.Info("drained, exiting")
+	}
+	exit(0)
+}
@@ -0,0 +1,132 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDrainerShutdown(t *testing.T) {
+	clock := newFakeClock(testPublishTime)
+	d := newDrainer(withDefaults(Config{Clock: clock, Logger: discardLogger}))
+	var order []int
+	for i := 1; i <= 3; i++ {
+		i := i
+		d.onShutdown(func() { order = append(order, i) })
+	}
+
+	// The shutdown waits for the in-flight invocations, after running the hooks last registered first
+	done := d.trackInFlight()
+	result := make(chan error, 1)
+	go func() { result <- d.shutdown(context.Background()) }()
+	select {
+	case err := <-result:
+		t.Fatalf("shutdown() = %v before the end of the in-flight invocation", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	done()
+	if err := <-result; err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+	if !reflect.DeepEqual(order, []int{3, 2, 1}) {
+		t.Errorf("hooks ran in the order %v, want [3 2 1]", order)
+	}
+
+	// The next calls don't run the hooks again
+	if err := d.shutdown(context.Background()); err != nil || len(order) != 3 {
+		t.Errorf("second shutdown() = %v with the hooks run %d times, want nil and 3", err, len(order))
+	}
+}
+
+func TestDrainerShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		cancel  bool
+		wantErr string
+	}{
+		{name: "timeout reached", wantErr: "the shutdown timeout 5s was reached"},
+		{name: "context canceled", cancel: true, wantErr: context.Canceled.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(testPublishTime)
+			cfg := Config{Clock: clock, Logger: discardLogger, ShutdownTimeout: 5 * time.Second}
+			d := newDrainer(withDefaults(cfg))
+			defer d.trackInFlight()()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			result := make(chan error, 1)
+			go func() { result <- d.shutdown(ctx) }()
+			for clock.Waiters() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			if tt.cancel {
+				cancel()
+			} else {
+				clock.Advance(5 * time.Second)
+			}
+			if err := <-result; err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("shutdown() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDrainOnSignal(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	var drained bool
+	exited := make(chan int, 1)
+	shutdown := func(context.Context) error {
+		drained = true
+		return errors.New("error draining the handler, the shutdown timeout 8s was reached")
+	}
+	go drainOnSignal(signals, shutdown, discardLogger, func(code int) { exited <- code })
+
+	signals <- syscall.SIGTERM
+	if code := <-exited; code != 0 || !drained {
+		t.Errorf("exited with %d, drained: %v, want 0 after the drain", code, drained)
+	}
+}
+
+func TestNewHandlerShutdown(t *testing.T) {
+	release := make(chan struct{})
+	honeycomb := newFakeHoneycomb(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		<-release
+		acceptAll(w, r, body)
+	})
+	cfg := testConfig(honeycomb)
+	cfg.AsyncWorkers = 1
+	handler, shutdown := NewHandler(cfg)
+	_, otherShutdown := NewHandler(cfg)
+	for i := 0; i < 3; i++ {
+		if err := handler(quietContext(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)})); err != nil {
+			t.Fatalf("handler error = %v", err)
+		}
+	}
+
+	// Another handler has its own hooks, its shutdown doesn't wait for the queued events
+	if err := otherShutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() of another handler error = %v", err)
+	}
+	result := make(chan error, 1)
+	go func() { result <- shutdown(context.Background()) }()
+	select {
+	case err := <-result:
+		t.Fatalf("shutdown() = %v before the queued events were sent", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-result; err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+	if got := len(honeycomb.received()); got != 3 {
+		t.Errorf("%d events sent before the end of the shutdown, want 3", got)
+	}
+}
@@ -0,0 +1,122 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"net/http"
+)
+
+// Event is a PubSub message ready to be forwarded: decoded, validated and transformed, with its
+// destination dataset resolved.
+type Event struct {
+	// PubSub is the PubSub message, PubSub.Message.Data holds the payload to forward.
+	PubSub MessagePublishedData
+	// Dataset is the Honeycomb dataset the event is routed to.
+	Dataset string
+	// TraceID is the ID of the producer's trace, when the sink joined it.
+	TraceID string
+	// SampleRate is the number of events the event stands for, the configured sample rate unless the
+	// message overrides it. 0 means the configured sample rate.
+	SampleRate int
+}
+
+// Sink forwards events to a destination. The handler takes care of the PubSub decoding and of the
+// transforms, Send only has to ship the payload. Returning an error NACKs the message, so Send must only
+// return nil once the destination confirmed it received the event.
+type Sink interface {
+	Send(ctx context.Context, event Event) error
+}
+
+// newSink returns the sink selected by cfg.SinkMode.
+func newSink(cfg Config) Sink {
+	switch cfg.SinkMode {
+	case sinkModeOTLP:
+		return &otlpSink{cfg: cfg}
+	case sinkModeWebhook:
+		return &webhookSink{cfg: cfg}
+	default:
+		return &honeycombSink{cfg: cfg}
+	}
+}
+
+// honeycombSink sends the events to the Honeycomb events API, through the buffer when enabled.
+type honeycombSink struct {
+	cfg Config
+}
+
+func (s *honeycombSink) Send(ctx context.Context, event Event) error {
+	cfg := s.cfg
+	if event.SampleRate > 0 {
+		cfg.SampleRate = event.SampleRate
+	}
+	if cfg.batcher != nil {
+		return cfg.batcher.add(ctx, event.PubSub, event.Dataset, cfg.SampleRate)
+	}
+	result, err := sendToHoneycomb(ctx, cfg, event.PubSub, event.Dataset)
+	if err == nil {
+		logSendResult(ctx, "Honeycomb API's response", event, result)
+	}
+	return err
+}
+
+// otlpSink sends the events as OTLP log records.
+type otlpSink struct {
+	cfg Config
+}
+
+func (s *otlpSink) Send(ctx context.Context, event Event) error {
+	result, err := sendOTLPLogs(ctx, s.cfg, event.PubSub, event.Dataset)
+	if err == nil {
+		logSendResult(ctx, "OTLP endpoint's response", event, result)
+	}
+	return err
+}
+
+// webhookSink posts the payload as is to a generic HTTP endpoint (e.g: a custom collector), with the
+// dataset in the X-Dataset header.
+type webhookSink struct {
+	cfg Config
+}
+
+func (s *webhookSink) Send(ctx context.Context, event Event) error {
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json")
+	for name, value := range s.cfg.WebhookHeaders {
+		headers.Set(name, value)
+	}
+	if event.Dataset != "" {
+		headers.Set("X-Dataset", event.Dataset)
+	}
+	payload := event.PubSub.Message.Data
+	if s.cfg.DryRun {
+		logDryRun(ctx, s.cfg, s.cfg.WebhookURL, headers, payload, event.Dataset)
+		return nil
+	}
+	if s.cfg.Gzip {
+		var err error
+		if payload, err = gzipPayload(payload); err != nil {
+			return err
+		}
+		headers.Set("Content-Encoding", "gzip")
+	}
+	result, err := postWithRetries(ctx, s.cfg, s.cfg.WebhookURL, payload, headers, event.Dataset)
+	if err == nil {
+		logSendResult(ctx, "webhook's response", event, result)
+	}
+	return err
+}
+
+// logSendResult logs the response to a forwarded event. Nothing is logged on a dry run.
+func logSendResult(ctx context.Context, message string, event Event, result sendResult) {
+	if result.StatusCode == 0 {
+		return
+	}
+	loggerFrom(ctx).Info(message,
+		"message_id", event.PubSub.Message.MessageID,
+		"dataset", event.Dataset,
+		"trace_id", event.TraceID,
+		"bytes", result.Bytes,
+		"status_code", result.StatusCode,
+		"latency_ms", result.Latency.Milliseconds(),
+		"attempts", result.Attempts,
+		"response", result.Body)
+}
@@ -0,0 +1,126 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// defaultMaxEventBytes matches the maximum size of a single Honeycomb event.
+const defaultMaxEventBytes = 100 * 1024
+
+// errEventTooLarge is returned for an event over the size limit which can't be truncated.
+type errEventTooLarge struct {
+	Size  int
+	Limit int
+}
+
+func (e *errEventTooLarge) Error() string {
+	return fmt.Sprintf("error event is %d bytes, over the %d bytes limit", e.Size, e.Limit)
+}
+
+// enforceEventSize checks each event of the payload against the size limit. Oversized events are
+// rejected, or truncated when truncate is set.
+func enforceEventSize(ctx context.Context, data []byte, limit int, truncate bool,
+	messageID string) ([]byte, error) {
+	if limit <= 0 {
+		return data, nil
+	}
+	if !isJSONArray(data) {
+		return enforceSingleEventSize(ctx, data, limit, truncate, messageID)
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("error decoding json array payload %w", err)
+	}
+	changed := false
+	for i, event := range events {
+		checked, err := enforceSingleEventSize(ctx, event, limit, truncate, messageID)
+		if err != nil {
+			return nil, fmt.Errorf("error event %d of the batch: %w", i, err)
+		}
+		if len(checked) != len(event) {
+			events[i], changed = checked, true
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(events)
+}
+
+func enforceSingleEventSize(ctx context.Context, data []byte, limit int, truncate bool,
+	messageID string) ([]byte, error) {
+	if len(data) <= limit {
+		return data, nil
+	}
+	if !truncate {
+		return nil, &errEventTooLarge{Size: len(data), Limit: limit}
+	}
+	fields, ok := decodeObject(data)
+	if !ok {
+		return nil, &errEventTooLarge{Size: len(data), Limit: limit}
+	}
+
+	truncated, err := truncateFields(fields, limit)
+	if err != nil {
+		return nil, err
+	}
+	loggerFrom(ctx).Warn("event over the size limit truncated",
+		"message_id", messageID, "bytes", len(data), "truncated_bytes", len(truncated), "limit", limit)
+	return truncated, nil
+}
+
+// truncateFields shortens the largest string fields of the event, dropping them when needed, until the
+// encoded event fits in limit. A `_truncated: true` field flags the event.
+func truncateFields(fields map[string]any, limit int) ([]byte, error) {
+	fields["_truncated"] = true
+	for {
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding truncated event %w", err)
+		}
+		if len(encoded) <= limit {
+			return encoded, nil
+		}
+
+		parent, key, value := largestString(fields)
+		if parent == nil {
+			return nil, &errEventTooLarge{Size: len(encoded), Limit: limit}
+		}
+		excess := len(encoded) - limit
+		keep := len(value) - excess - len("...")
+		for keep > 0 && !utf8.RuneStart(value[keep]) {
+			keep--
+		}
+		if keep > 0 {
+			parent[key] = value[:keep] + "..."
+		} else {
+			delete(parent, key)
+		}
+	}
+}
+
+// largestString finds the longest string value of the event, nested objects included, and returns the
+// object holding it.
+func largestString(fields map[string]any) (map[string]any, string, string) {
+	var parent map[string]any
+	var key, value string
+	var walk func(object map[string]any)
+	walk = func(object map[string]any) {
+		for k, v := range object {
+			switch v := v.(type) {
+			case string:
+				if parent == nil || len(v) > len(value) {
+					parent, key, value = object, k, v
+				}
+			case map[string]any:
+				walk(v)
+			}
+		}
+	}
+	walk(fields)
+	return parent, key, value
+}
@@ -0,0 +1,99 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEnforceEventSize(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	tests := []struct {
+		name     string
+		data     string
+		limit    int
+		truncate bool
+		wantErr  string
+		check    func(t *testing.T, got []byte)
+	}{
+		{name: "under the limit", data: `{"a":1}`, limit: 50, check: func(t *testing.T, got []byte) {
+			if string(got) != `{"a":1}` {
+				t.Errorf("event = %s, want it unchanged", got)
+			}
+		}},
+		{name: "no limit", data: `{"msg":"` + long + `"}`, check: func(t *testing.T, got []byte) {
+			if len(got) != 210 {
+				t.Errorf("event of %d bytes, want it unchanged", len(got))
+			}
+		}},
+		{name: "rejected", data: `{"msg":"` + long + `"}`, limit: 100,
+			wantErr: "error event is 210 bytes, over the 100 bytes limit"},
+		{name: "not an object", data: `"` + long + `"`, limit: 100, truncate: true,
+			wantErr: "over the 100 bytes limit"},
+		{name: "truncated", data: `{"msg":"` + long + `","short":"kept","n":1}`, limit: 100, truncate: true,
+			check: func(t *testing.T, got []byte) {
+				var fields map[string]any
+				if err := json.Unmarshal(got, &fields); err != nil || len(got) > 100 {
+					t.Fatalf("event = %s (%v), want a JSON object under 100 bytes", got, err)
+				}
+				if msg, _ := fields["msg"].(string); !strings.HasSuffix(msg, "...") || fields["short"] != "kept" ||
+					fields["_truncated"] != true {
+					t.Errorf("event = %s, want the largest field shortened and flagged", got)
+				}
+			}},
+		{name: "truncated on a rune boundary", data: `{"msg":"` + strings.Repeat("é", 100) + `"}`, limit: 101,
+			truncate: true, check: func(t *testing.T, got []byte) {
+				if !utf8.Valid(got) || len(got) > 101 {
+					t.Errorf("event = %q, want valid UTF-8 under 101 bytes", got)
+				}
+			}},
+		{name: "nested fields dropped", data: `{"a":{"b":"` + long + `"},"c":"` + long + `"}`, limit: 40,
+			truncate: true, check: func(t *testing.T, got []byte) {
+				if len(got) > 40 || !strings.Contains(string(got), `"_truncated":true`) {
+					t.Errorf("event = %s, want the fields shortened or dropped under 40 bytes", got)
+				}
+			}},
+		{name: "array element truncated", data: `[{"a":1},{"msg":"` + long + `"}]`, limit: 100, truncate: true,
+			check: func(t *testing.T, got []byte) {
+				var events []map[string]any
+				err := json.Unmarshal(got, &events)
+				if err != nil || len(events) != 2 || events[1]["_truncated"] != true || events[0]["_truncated"] != nil {
+					t.Errorf("events = %s, want only the oversized element truncated", got)
+				}
+			}},
+		{name: "array element rejected", data: `[{"a":1},{"msg":"` + long + `"}]`, limit: 100,
+			wantErr: "error event 1 of the batch: error event is 210 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := enforceEventSize(quietContext(), []byte(tt.data), tt.limit, tt.truncate, "message-1")
+			if tt.wantErr != "" {
+				var tooLarge *errEventTooLarge
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !errors.As(err, &tooLarge) {
+					t.Errorf("enforceEventSize() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("enforceEventSize() error = %v", err)
+			}
+			tt.check(t, got)
+		})
+	}
+}
+
+func TestEnforceEventSizeLogsTruncation(t *testing.T) {
+	var logs logRecorder
+	ctx := withLogger(quietContext(), newRecordingLogger(&logs))
+	data := []byte(`{"msg":"` + strings.Repeat("x", 200) + `"}`)
+	if _, err := enforceEventSize(ctx, data, 100, true, "message-1"); err != nil {
+		t.Fatalf("enforceEventSize() error = %v", err)
+	}
+	records := logs.records(t, "event over the size limit truncated")
+	if len(records) != 1 || records[0]["message_id"] != "message-1" || records[0]["bytes"] != 210.0 ||
+		records[0]["limit"] != 100.0 {
+		t.Errorf("log records = %v, want the truncation logged", records)
+	}
+}
@@ -0,0 +1,51 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// skipLogInterval is the minimum delay between two logs of the skipped messages count.
+const skipLogInterval = time.Minute
+
+// matchesSkipFilter reports whether a message carries one of the filter's attributes with the given
+// value. A "*" value matches any value of the attribute.
+func matchesSkipFilter(msg MessagePublishedData, filter map[string]string) bool {
+	for key, want := range filter {
+		if value, ok := msg.Message.Attributes[key]; ok && (want == "*" || value == want) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipCounter counts the skipped messages and logs the count at most once per skipLogInterval, as
+// filtered messages (e.g: health pings) can be frequent. A nil counter counts nothing.
+type skipCounter struct {
+	filter string // the filter skipping the messages, for the log
+	clock  Clock
+
+	mu      sync.Mutex
+	count   int
+	lastLog time.Time
+}
+
+func newSkipCounter(filter string, clock Clock) *skipCounter {
+	return &skipCounter{filter: filter, clock: clock}
+}
+
+func (c *skipCounter) add(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	if since(c.clock, c.lastLog) < skipLogInterval {
+		return
+	}
+	loggerFrom(ctx).Info("PubSub messages skipped by the "+c.filter, "count", c.count, "since", c.lastLog)
+	c.count = 0
+	c.lastLog = c.clock.Now()
+}
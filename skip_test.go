@@ -0,0 +1,69 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMatchesSkipFilter(t *testing.T) {
+	filter := map[string]string{"type": "healthcheck", "synthetic": "*"}
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		want       bool
+	}{
+		{name: "matching value", attributes: map[string]string{"type": "healthcheck"}, want: true},
+		{name: "other value", attributes: map[string]string{"type": "order"}},
+		{name: "wildcard", attributes: map[string]string{"synthetic": "false"}, want: true},
+		{name: "empty value, wildcard", attributes: map[string]string{"synthetic": ""}, want: true},
+		{name: "no attributes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := MessagePublishedData{Message: PubSubMessage{Attributes: tt.attributes}}
+			if got := matchesSkipFilter(msg, filter); got != tt.want {
+				t.Errorf("matchesSkipFilter(%v) = %v, want %v", tt.attributes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipCounter(t *testing.T) {
+	var logs logRecorder
+	ctx := withLogger(context.Background(), newRecordingLogger(&logs))
+	clock := newFakeClock(testPublishTime)
+	c := newSkipCounter("attribute filter", clock)
+
+	// The first skip is logged, the next ones are counted until skipLogInterval passed
+	for i := 0; i < 3; i++ {
+		c.add(ctx)
+		clock.Advance(10 * time.Second)
+	}
+	clock.Advance(skipLogInterval)
+	c.add(ctx)
+	records := logs.records(t, "PubSub messages skipped by the attribute filter")
+	if len(records) != 2 || records[0]["count"] != 1.0 || records[1]["count"] != 3.0 {
+		t.Errorf("log records = %v, want the counts of each interval", records)
+	}
+
+	var nilCounter *skipCounter
+	nilCounter.add(ctx)
+}
+
+func TestHandlerSkipIfAttr(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	cfg.SkipIfAttr = map[string]string{"type": "healthcheck"}
+	handler, _ := NewHandler(cfg)
+
+	for _, value := range []string{"healthcheck", "order"} {
+		msg := PubSubMessage{Data: []byte(`{"a":1}`), Attributes: map[string]string{"type": value}}
+		if err := handler(quietContext(), pubSubEvent(t, msg)); err != nil {
+			t.Fatalf("handler error = %v", err)
+		}
+	}
+	if n := len(honeycomb.received()); n != 1 {
+		t.Errorf("%d events sent, want the healthcheck skipped", n)
+	}
+}
@@ -0,0 +1,151 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Uses of the TimeField timestamp, selected with HONEYCOMB_TIME_FIELD_MODE.
+const (
+	timeFieldEventTime = "event_time"
+	timeFieldRewrite   = "rewrite"
+)
+
+// timestampLayouts are the string timestamps understood besides the epoch numbers, tried in order.
+// Layouts without a zone are read as UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// parseTimestamp reads a timestamp given as an epoch number, or numeric string, in seconds,
+// milliseconds, microseconds or nanoseconds (told apart by their magnitude: seconds until year 5138),
+// or as an RFC 3339 like string.
+func parseTimestamp(value any) (time.Time, bool) {
+	var text string
+	switch v := value.(type) {
+	case json.Number:
+		text = v.String()
+	case float64:
+		return epochTime(v)
+	case string:
+		text = strings.TrimSpace(v)
+	default:
+		return time.Time{}, false
+	}
+	if number, err := strconv.ParseInt(text, 10, 64); err == nil && number >= 1e17 {
+		// Nanoseconds don't fit the precision of a float
+		return time.Unix(0, number).UTC(), true
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		return epochTime(number)
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// epochTime converts an epoch number to a time, guessing its unit from its magnitude.
+func epochTime(epoch float64) (time.Time, bool) {
+	if epoch <= 0 || math.IsInf(epoch, 0) || math.IsNaN(epoch) {
+		return time.Time{}, false
+	}
+	switch {
+	case epoch < 1e11:
+		seconds, fraction := math.Modf(epoch)
+		return time.Unix(int64(seconds), int64(fraction*1e9)).UTC(), true
+	case epoch < 1e14:
+		return time.UnixMicro(int64(epoch * 1e3)).UTC(), true
+	case epoch < 1e17:
+		return time.UnixMicro(int64(epoch)).UTC(), true
+	case epoch < math.MaxInt64:
+		return time.Unix(0, int64(epoch)).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// fieldTime returns the timestamp of the TimeField of a JSON object event, when it can be parsed.
+func fieldTime(data []byte, field string) (time.Time, bool) {
+	fields, ok := decodeObject(data)
+	if !ok {
+		return time.Time{}, false
+	}
+	value, ok := lookupPath(fields, field)
+	if !ok {
+		return time.Time{}, false
+	}
+	return parseTimestamp(value)
+}
+
+// payloadEventTime is the timestamp of an event of the message: the one of its TimeField in the
+// event_time mode, or eventTime when the field is missing or can't be parsed.
+func payloadEventTime(cfg Config, msg MessagePublishedData, data []byte) time.Time {
+	if cfg.TimeField != "" && cfg.TimeFieldMode != timeFieldRewrite {
+		if t, ok := fieldTime(data, cfg.TimeField); ok {
+			return t
+		}
+	}
+	return eventTime(msg, cfg.Clock)
+}
+
+// rewriteTimestamps rewrites the TimeField of a JSON object payload, or of each JSON object of an array
+// payload, as an RFC 3339 string. Missing and unparseable fields are left as is. It returns the number of
+// fields rewritten.
+func rewriteTimestamps(data []byte, field string) ([]byte, int, error) {
+	rewrite := func(event []byte) ([]byte, bool, error) {
+		fields, ok := decodeObject(event)
+		if !ok {
+			return event, false, nil
+		}
+		value, ok := lookupPath(fields, field)
+		if !ok {
+			return event, false, nil
+		}
+		t, ok := parseTimestamp(value)
+		if !ok {
+			return event, false, nil
+		}
+		setPath(fields, field, t.Format(time.RFC3339Nano))
+		rewritten, err := json.Marshal(fields)
+		return rewritten, true, err
+	}
+
+	if !isJSONArray(data) {
+		rewritten, ok, err := rewrite(data)
+		if err != nil || !ok {
+			return data, 0, err
+		}
+		return rewritten, 1, nil
+	}
+	var events []json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, 0, err
+	}
+	count := 0
+	for i, event := range events {
+		rewritten, ok, err := rewrite(event)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			events[i] = rewritten
+			count++
+		}
+	}
+	if count == 0 {
+		return data, 0, nil
+	}
+	rewritten, err := json.Marshal(events)
+	return rewritten, count, err
+}
@@ -0,0 +1,141 @@
+package HoneycombSinkHandler
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	base := testPublishTime // 2024-05-06T07:08:09Z
+	seconds := strconv.FormatInt(base.Unix(), 10)
+	tests := []struct {
+		name  string
+		value any
+		want  time.Time // zero when unparseable
+	}{
+		{name: "epoch seconds", value: json.Number(seconds), want: base},
+		{name: "epoch seconds with a fraction", value: json.Number(seconds + ".25"),
+			want: base.Add(250 * time.Millisecond)},
+		{name: "epoch milliseconds", value: json.Number(seconds + "123"), want: base.Add(123 * time.Millisecond)},
+		{name: "epoch microseconds", value: json.Number(seconds + "123456"),
+			want: base.Add(123456 * time.Microsecond)},
+		{name: "epoch nanoseconds", value: json.Number(seconds + "123456789"), want: base.Add(123456789)},
+		{name: "epoch milliseconds float", value: float64(base.UnixMilli() + 5),
+			want: base.Add(5 * time.Millisecond)},
+		{name: "epoch seconds string", value: " " + seconds + " ", want: base},
+		{name: "epoch milliseconds string", value: seconds + "007", want: base.Add(7 * time.Millisecond)},
+		{name: "RFC 3339", value: "2024-05-06T07:08:09Z", want: base},
+		{name: "RFC 3339 with offset", value: "2024-05-06T09:08:09+02:00", want: base},
+		{name: "RFC 3339 nanoseconds", value: "2024-05-06T07:08:09.123456789Z", want: base.Add(123456789)},
+		{name: "no zone", value: "2024-05-06T07:08:09.5", want: base.Add(500 * time.Millisecond)},
+		{name: "space separated", value: "2024-05-06 07:08:09", want: base},
+		{name: "space separated with offset", value: "2024-05-06 08:08:09 +0100", want: base},
+		{name: "RFC 1123", value: "Mon, 06 May 2024 07:08:09 UTC", want: base},
+		{name: "not a timestamp", value: "yesterday"},
+		{name: "empty", value: ""},
+		{name: "zero", value: json.Number("0")},
+		{name: "negative", value: json.Number("-5")},
+		{name: "boolean", value: true},
+		{name: "object", value: map[string]any{"seconds": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseTimestamp(tt.value)
+			if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+				t.Errorf("parseTimestamp(%v) = %v, %v, want %v", tt.value, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteTimestamps(t *testing.T) {
+	millis := strconv.FormatInt(testPublishTime.UnixMilli(), 10)
+	tests := []struct {
+		name      string
+		field     string
+		data      string
+		want      string
+		wantCount int
+	}{
+		{name: "epoch milliseconds", field: "ts", data: `{"ts":` + millis + `,"a":1}`,
+			want: `{"a":1,"ts":"2024-05-06T07:08:09Z"}`, wantCount: 1},
+		{name: "epoch seconds string", field: "ts", data: `{"ts":"1714979289"}`,
+			want: `{"ts":"2024-05-06T07:08:09Z"}`, wantCount: 1},
+		{name: "RFC 3339 kept with its offset", field: "ts", data: `{"ts":"2024-05-06T09:08:09.5+02:00"}`,
+			want: `{"ts":"2024-05-06T09:08:09.5+02:00"}`, wantCount: 1},
+		{name: "nested field", field: "meta.time", data: `{"meta":{"time":1714979289}}`,
+			want: `{"meta":{"time":"2024-05-06T07:08:09Z"}}`, wantCount: 1},
+		{name: "missing", field: "ts", data: `{"a":1}`, want: `{"a":1}`},
+		{name: "unparseable", field: "ts", data: `{"ts":"soon"}`, want: `{"ts":"soon"}`},
+		{name: "not an object", field: "ts", data: `"1714979289"`, want: `"1714979289"`},
+		{name: "array", field: "ts", data: `[{"ts":1714979289},{"a":1},{"ts":"bad"}]`,
+			want: `[{"ts":"2024-05-06T07:08:09Z"},{"a":1},{"ts":"bad"}]`, wantCount: 1},
+		{name: "array without timestamps", field: "ts", data: `[{"a":1}, {"b":2}]`, want: `[{"a":1}, {"b":2}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, count, err := rewriteTimestamps([]byte(tt.data), tt.field)
+			if err != nil {
+				t.Fatalf("rewriteTimestamps() error = %v", err)
+			}
+			if string(got) != tt.want || count != tt.wantCount {
+				t.Errorf("rewriteTimestamps() = %s, %d, want %s, %d", got, count, tt.want, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestHandlerTimeField(t *testing.T) {
+	fieldTime := time.Date(2024, 5, 6, 6, 0, 0, 0, time.UTC)
+	millis := strconv.FormatInt(fieldTime.UnixMilli(), 10)
+	tests := []struct {
+		name     string
+		mode     string
+		data     string
+		batch    bool
+		wantTime time.Time
+		wantBody string
+	}{
+		{name: "event time from epoch milliseconds", mode: timeFieldEventTime, data: `{"ts":` + millis + `}`,
+			wantTime: fieldTime, wantBody: `{"ts":` + millis + `}`},
+		{name: "event time from RFC 3339", mode: timeFieldEventTime, data: `{"ts":"2024-05-06T06:00:00Z"}`,
+			wantTime: fieldTime, wantBody: `{"ts":"2024-05-06T06:00:00Z"}`},
+		{name: "event time of batch events", mode: timeFieldEventTime, data: `[{"ts":` + millis + `},{"a":1}]`,
+			batch: true},
+		{name: "missing field falls back", mode: timeFieldEventTime, data: `{"a":1}`,
+			wantTime: testPublishTime, wantBody: `{"a":1}`},
+		{name: "unparseable field falls back", mode: timeFieldEventTime, data: `{"ts":"soon"}`,
+			wantTime: testPublishTime, wantBody: `{"ts":"soon"}`},
+		{name: "rewrite", mode: timeFieldRewrite, data: `{"ts":` + millis + `}`,
+			wantTime: testPublishTime, wantBody: `{"ts":"2024-05-06T06:00:00Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.TimeField = "ts"
+			cfg.TimeFieldMode = tt.mode
+
+			if err := handleData(t, cfg, tt.data); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if tt.batch {
+				events := honeycomb.lastBatch(t)
+				if len(events) != 2 || events[0].Time != fieldTime.Format(time.RFC3339Nano) ||
+					events[1].Time != testPublishTime.Format(time.RFC3339Nano) {
+					t.Errorf("batch events = %+v, want the field time, then the publish time", events)
+				}
+				return
+			}
+			r := honeycomb.received()[0]
+			if got := r.Header.Get("X-Honeycomb-Event-Time"); got != tt.wantTime.Format(time.RFC3339Nano) {
+				t.Errorf("X-Honeycomb-Event-Time = %s, want %s", got, tt.wantTime.Format(time.RFC3339Nano))
+			}
+			if string(r.Body) != tt.wantBody {
+				t.Errorf("body = %s, want %s", r.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// tlsConfigFromEnv builds the TLS configuration of the Honeycomb client from HONEYCOMB_CA_CERT, a PEM
+// bundle (or the path to one) trusted on top of the system roots, and HONEYCOMB_TLS_PIN, comma-separated
+// SHA-256 fingerprints of the accepted server certificates. It returns nil when neither is set.
+func tlsConfigFromEnv() (*tls.Config, error) {
+	caCert := os.Getenv("HONEYCOMB_CA_CERT")
+	pins := getListEnvVar("HONEYCOMB_TLS_PIN")
+	if caCert == "" && len(pins) == 0 {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caCert != "" {
+		pool, err := caCertPool(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("error, invalid HONEYCOMB_CA_CERT environment variable: %w", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(pins) > 0 {
+		fingerprints := make([][]byte, 0, len(pins))
+		for _, pin := range pins {
+			fingerprint, err := parsePin(pin)
+			if err != nil {
+				return nil, fmt.Errorf("error, invalid HONEYCOMB_TLS_PIN environment variable: %w", err)
+			}
+			fingerprints = append(fingerprints, fingerprint)
+		}
+		tlsConfig.VerifyConnection = verifyPins(fingerprints)
+	}
+	return tlsConfig, nil
+}
+
+// caCertPool returns the system roots plus the certificates of a PEM bundle, given inline or as a path.
+func caCertPool(caCert string) (*x509.CertPool, error) {
+	pem := []byte(caCert)
+	if !strings.Contains(caCert, "-----BEGIN") {
+		var err error
+		if pem, err = os.ReadFile(caCert); err != nil {
+			return nil, fmt.Errorf("error reading CA bundle %w", err)
+		}
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no PEM certificate could be parsed")
+	}
+	return pool, nil
+}
+
+// parsePin decodes a SHA-256 certificate fingerprint given in hex (colons allowed) or base64, with an
+// optional "sha256/" prefix.
+func parsePin(pin string) ([]byte, error) {
+	pin = strings.TrimPrefix(pin, "sha256/")
+	if fingerprint, err := hex.DecodeString(strings.ReplaceAll(pin, ":", "")); err == nil &&
+		len(fingerprint) == sha256.Size {
+		return fingerprint, nil
+	}
+	if fingerprint, err := base64.StdEncoding.DecodeString(pin); err == nil && len(fingerprint) == sha256.Size {
+		return fingerprint, nil
+	}
+	return nil, fmt.Errorf("%q is not a SHA-256 fingerprint", pin)
+}
+
+// verifyPins accepts a connection only when the server certificate matches one of the fingerprints.
+// The usual chain verification still applies.
+func verifyPins(fingerprints [][]byte) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("error TLS pinning: no server certificate")
+		}
+		sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+		for _, fingerprint := range fingerprints {
+			if bytes.Equal(sum[:], fingerprint) {
+				return nil
+			}
+		}
+		return fmt.Errorf("error TLS pinning: server certificate %x doesn't match HONEYCOMB_TLS_PIN", sum)
+	}
+}
+
+// newServicesHTTPClient returns the client of the other services than Honeycomb: it trusts the same CA
+// bundle, without the pins.
+func newServicesHTTPClient(tlsConfig *tls.Config) *http.Client {
+	if tlsConfig.RootCAs == nil {
+		return httpClient
+	}
+	unpinned := tlsConfig.Clone()
+	unpinned.VerifyConnection = nil
+	return newTLSHTTPClient(unpinned)
+}
+
+// newTLSHTTPClient returns a pooled client like the shared one, with a custom TLS configuration.
+func newTLSHTTPClient(tlsConfig *tls.Config) *http.Client {
+	client := newHTTPClient()
+	client.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	return client
+}
@@ -0,0 +1,110 @@
+package HoneycombSinkHandler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// redirectAllTransport sends every request to the target server, through base.
+type redirectAllTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t redirectAllTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+	return t.base.RoundTrip(r)
+}
+
+// certificateSum returns the SHA-256 fingerprint of the server certificate.
+func certificateSum(server *httptest.Server) []byte {
+	sum := sha256.Sum256(server.Certificate().Raw)
+	return sum[:]
+}
+
+func TestTLSPinFromEnv(t *testing.T) {
+	honeycomb := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(honeycomb.Close)
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: honeycomb.Certificate().Raw})
+	tests := []struct {
+		name    string
+		pin     string
+		wantErr string
+	}{
+		{name: "matching pin", pin: hex.EncodeToString(certificateSum(honeycomb))},
+		{name: "base64 pin among others", pin: strings.Repeat("ab", sha256.Size) + ",sha256/" +
+			base64.StdEncoding.EncodeToString(certificateSum(honeycomb))},
+		{name: "other pin", pin: strings.Repeat("ab", sha256.Size), wantErr: "doesn't match HONEYCOMB_TLS_PIN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HONEYCOMB_DATASET", "test-dataset")
+			t.Setenv("HONEYCOMB_API_KEY", "test-api-key")
+			t.Setenv("HONEYCOMB_CA_CERT", string(caCert))
+			t.Setenv("HONEYCOMB_TLS_PIN", tt.pin)
+			cfg, err := ConfigFromEnv()
+			if err != nil {
+				t.Fatalf("ConfigFromEnv() error = %v", err)
+			}
+			cfg.APIURL = honeycomb.URL
+			cfg.Logger = discardLogger
+
+			handler, _ := NewHandler(cfg)
+			err = handler(quietContext(), pubSubEvent(t, PubSubMessage{Data: []byte(`{"a":1}`)}))
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("handler error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("handler error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSPinWithSecretManager(t *testing.T) {
+	const secret = "projects/my-project/secrets/honeycomb/versions/1"
+	google := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			_, _ = w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+		case "/v1/" + secret + ":access":
+			data := base64.StdEncoding.EncodeToString([]byte("secret-api-key"))
+			_, _ = w.Write([]byte(`{"payload":{"data":"` + data + `"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(google.Close)
+	target, _ := url.Parse(google.URL)
+	services := &http.Client{Transport: redirectAllTransport{target: target, base: google.Client().Transport}}
+	shared := httpClient
+	httpClient = services
+	t.Cleanup(func() { httpClient = shared })
+
+	// The pin is the one of Honeycomb, the Google APIs are reached without it
+	t.Setenv("HONEYCOMB_DATASET", "test-dataset")
+	t.Setenv("HONEYCOMB_API_KEY_SECRET", secret)
+	t.Setenv("HONEYCOMB_TLS_PIN", strings.Repeat("ab", sha256.Size))
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConfigFromEnv() error = %v", err)
+	}
+	if cfg.APIKey != "secret-api-key" {
+		t.Errorf("APIKey = %q, want the secret value", cfg.APIKey)
+	}
+	if cfg.ServicesHTTPClient != services {
+		t.Error("ServicesHTTPClient isn't the shared unpinned client")
+	}
+	transport, _ := cfg.HTTPClient.Transport.(*http.Transport)
+	if transport == nil || transport.TLSClientConfig == nil || transport.TLSClientConfig.VerifyConnection == nil {
+		t.Error("HTTPClient doesn't pin the Honeycomb certificates")
+	}
+}
@@ -0,0 +1,122 @@
+package HoneycombSinkHandler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// traceparentPattern matches a W3C traceparent (version-traceid-parentid-flags). All-zero IDs are invalid.
+// See the documentation for more details:
+// https://www.w3.org/TR/trace-context/#traceparent-header
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// traceContext places the events, and the sink's span if any, in the trace of the producer.
+type traceContext struct {
+	TraceID string
+	// ParentID is the span of the producer, SpanID the one of the sink when it sends its span.
+	ParentID string
+	SpanID   string
+}
+
+// traceFromEvent reads the trace context of the CloudEvent distributed tracing extension (the
+// traceparent attribute EventArc copies from the producer).
+func traceFromEvent(ctx context.Context, e event.Event) (traceContext, bool) {
+	traceparent, ok := e.Extensions()["traceparent"].(string)
+	if !ok {
+		return traceContext{}, false
+	}
+	match := traceparentPattern.FindStringSubmatch(traceparent)
+	if match == nil || match[1] == "00000000000000000000000000000000" || match[2] == "0000000000000000" {
+		loggerFrom(ctx).Warn("invalid traceparent CloudEvent extension, ignoring it",
+			"traceparent", traceparent)
+		return traceContext{}, false
+	}
+	return traceContext{TraceID: match[1], ParentID: match[2]}, true
+}
+
+func newSpanID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// fields returns the Honeycomb trace fields making an event a child of the sink's span, or of the
+// producer's span when the sink doesn't send its own.
+func (t traceContext) fields() map[string]any {
+	parent := t.SpanID
+	if parent == "" {
+		parent = t.ParentID
+	}
+	return map[string]any{"trace.trace_id": t.TraceID, "trace.parent_id": parent}
+}
+
+// sendSpan forwards the sink's span, covering the forward of the message, to the dataset of its events,
+// with the sample rate of the message: it is only sent for the messages kept by the sampling. A failure
+// is only logged as the events themselves were handled.
+func sendSpan(ctx context.Context, cfg Config, t traceContext, msgID string, dataset string, start time.Time,
+	sendErr error) {
+	span := map[string]any{
+		"name":            "forward",
+		"service.name":    serviceName,
+		"trace.trace_id":  t.TraceID,
+		"trace.span_id":   t.SpanID,
+		"trace.parent_id": t.ParentID,
+		"duration_ms":     float64(since(cfg.Clock, start).Microseconds()) / 1000,
+		"dataset":         dataset,
+		"message_id":      msgID,
+	}
+	if sendErr != nil {
+		span["error"] = sendErr.Error()
+	}
+	data, err := json.Marshal(span)
+	if err == nil {
+		err = cfg.Sink.Send(ctx, Event{
+			PubSub:     MessagePublishedData{Message: PubSubMessage{Data: data, MessageID: msgID, PublishTime: start}},
+			Dataset:    dataset,
+			TraceID:    t.TraceID,
+			SampleRate: cfg.SampleRate,
+		})
+	}
+	if err != nil {
+		loggerFrom(ctx).Warn("error sending the sink span", "message_id", msgID, "trace_id", t.TraceID,
+			"error", err.Error())
+	}
+}
+
+// addFields merges the extra fields into a JSON object payload, or into each JSON object of an array
+// payload. Other payloads are returned as is.
+func addFields(data []byte, extra map[string]any, merge fieldMerge) ([]byte, error) {
+	if !isJSONArray(data) {
+		fields, ok := decodeObject(data)
+		if !ok {
+			return data, nil
+		}
+		merge.merge(fields, extra)
+		return json.Marshal(fields)
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("error decoding json array payload %w", err)
+	}
+	for i, event := range events {
+		fields, ok := decodeObject(event)
+		if !ok {
+			continue
+		}
+		merge.merge(fields, extra)
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		events[i] = encoded
+	}
+	return json.Marshal(events)
+}
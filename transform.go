@@ -0,0 +1,371 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// validatePayload checks the PubSub data is well-formed JSON before spending an HTTP round trip to
+// have Honeycomb reject it. When cfg.WrapNonJSON is set, an invalid payload is wrapped as
+// {"message": "<data>", "content_type": "<detected>"} instead, or as {"raw_message": "<data>"} when
+// cfg.WrapInvalidJSON is set.
+func validatePayload(data []byte, cfg Config) ([]byte, error) {
+	if json.Valid(data) {
+		return data, nil
+	}
+	switch {
+	case cfg.WrapNonJSON:
+		contentType := detectContentType(data)
+		message := string(data)
+		if contentType == "application/octet-stream" {
+			message = base64.StdEncoding.EncodeToString(data)
+		}
+		return json.Marshal(map[string]string{"message": message, "content_type": contentType})
+	case cfg.WrapInvalidJSON:
+		return json.Marshal(map[string]string{"raw_message": string(data)})
+	default:
+		return nil, withCategory(fmt.Errorf("error PubSub data is not valid JSON (%d bytes)", len(data)), ErrPermanent)
+	}
+}
+
+// detectContentType guesses the type of a payload which isn't valid JSON: malformed JSON, NDJSON, CSV,
+// plain text, or binary data (which is wrapped base64-encoded).
+func detectContentType(data []byte) string {
+	if !utf8.Valid(data) {
+		return "application/octet-stream"
+	}
+	trimmed := bytes.TrimSpace(data)
+	lines := bytes.Split(trimmed, []byte("\n"))
+	if len(lines) > 1 {
+		ndjson, csv := true, true
+		commas := bytes.Count(lines[0], []byte(","))
+		for _, line := range lines {
+			line = bytes.TrimSpace(line)
+			ndjson = ndjson && json.Valid(line)
+			csv = csv && commas > 0 && bytes.Count(line, []byte(",")) == commas
+		}
+		switch {
+		case ndjson:
+			return "application/x-ndjson"
+		case csv:
+			return "text/csv"
+		}
+	}
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return "application/json"
+	}
+	return "text/plain"
+}
+
+// errExtractPathMissing is returned when the payload has nothing at the extract path.
+var errExtractPathMissing = errors.New("error extract path not found in the payload")
+
+// errTransformFailed is returned when the transform expression failed on an event.
+var errTransformFailed = withCategory(errors.New("transform expression failed"), ErrPermanent)
+
+// extractPayload returns the JSON object at a dotted path of a JSON object payload (e.g: the "payload"
+// of {"payload": {...}, "meta": {...}}). Other payloads are returned as is. errExtractPathMissing is
+// returned when there is nothing at the path, an error when it isn't an object.
+func extractPayload(data []byte, path string) ([]byte, error) {
+	fields, ok := decodeObject(data)
+	if !ok {
+		return data, nil
+	}
+	value, ok := lookupPath(fields, path)
+	if !ok {
+		return nil, withCategory(fmt.Errorf("%w: %q", errExtractPathMissing, path), ErrPermanent)
+	}
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil, withCategory(fmt.Errorf("error the payload at extract path %q is not a JSON object", path),
+			ErrPermanent)
+	}
+	return json.Marshal(object)
+}
+
+// transformPayload applies the configured transforms to the PubSub data. A JSON array payload is
+// transformed element by element. The data is passed through untouched when no transform is enabled.
+func transformPayload(ctx context.Context, cfg Config, msg MessagePublishedData) ([]byte, error) {
+	data := msg.Message.Data
+	if !cfg.hasTransforms() {
+		return data, nil
+	}
+	if !isJSONArray(data) {
+		return transformEvent(ctx, cfg, msg, data)
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("error decoding json array payload %w", err)
+	}
+	for i, event := range events {
+		transformed, err := transformEvent(ctx, cfg, msg, event)
+		if err != nil {
+			return nil, err
+		}
+		events[i] = transformed
+	}
+	return json.Marshal(events)
+}
+
+// transformEvent applies the configured transforms to a single event. Only JSON objects are
+// transformed, other payloads are returned as is.
+func transformEvent(ctx context.Context, cfg Config, msg MessagePublishedData, data []byte) ([]byte, error) {
+	fields, ok := decodeObject(data)
+	if !ok {
+		if len(cfg.StaticFields) > 0 || cfg.IncludePubSubMeta || len(cfg.IncludeAttrs) > 0 {
+			loggerFrom(ctx).Warn(
+				"payload is not a JSON object, static fields and PubSub metadata are not added",
+				"message_id", msg.Message.MessageID)
+		}
+		return data, nil
+	}
+
+	// The expression maps the event as sent by the producer, the other transforms apply to its result
+	if cfg.Transform != nil {
+		var err error
+		if fields, err = cfg.Transform.apply(fields, msg.Message.Attributes); err != nil {
+			return nil, withCategory(fmt.Errorf("error transforming message %s %w", msg.Message.MessageID, err),
+				errTransformFailed)
+		}
+	}
+	// Rename the producer's fields first, so the other transforms see the final names
+	if len(cfg.FieldMap) > 0 {
+		if err := renameFields(fields, cfg.FieldMap, cfg.FieldMapOverwrite); err != nil {
+			return nil, withCategory(
+				fmt.Errorf("error renaming the fields of message %s %w", msg.Message.MessageID, err), ErrPermanent)
+		}
+	}
+	// Filter the producer's fields first, so the ones added by the sink are kept
+	if len(cfg.AllowFields) > 0 {
+		fields = allowPaths(fields, cfg.AllowFields)
+	}
+	for _, path := range cfg.DropFields {
+		deletePath(fields, path)
+	}
+	if cfg.ScrubPattern != nil {
+		scrub(fields, cfg.ScrubPattern, cfg.ScrubMask)
+	}
+	if len(cfg.StaticFields) > 0 {
+		cfg.fieldMerge(true).merge(fields, cfg.StaticFields)
+	}
+	if cfg.Flatten {
+		fields = flatten(fields, cfg.FlattenDelimiter)
+	}
+	if len(cfg.IncludeAttrs) > 0 {
+		casing := cfg.AttrCase
+		if casing == "" {
+			casing = attrCaseSnake
+		}
+		cfg.fieldMerge(false).merge(fields, includedAttributes(msg.Message.Attributes, cfg.IncludeAttrs, casing))
+	}
+	if cfg.IncludePubSubMeta {
+		cfg.fieldMerge(false).merge(fields, pubSubMeta(msg, cfg.PubSubMetaPrefix))
+	}
+
+	return json.Marshal(fields)
+}
+
+// hasTransforms reports whether any transform is enabled.
+func (cfg Config) hasTransforms() bool {
+	return cfg.Flatten || len(cfg.StaticFields) > 0 || cfg.IncludePubSubMeta || len(cfg.IncludeAttrs) > 0 ||
+		len(cfg.DropFields) > 0 || len(cfg.AllowFields) > 0 || len(cfg.FieldMap) > 0 || cfg.ScrubPattern != nil ||
+		cfg.Transform != nil
+}
+
+// decodeObject decodes data when it is a JSON object. Numbers are kept as json.Number so they are
+// re-encoded without loss of precision.
+func decodeObject(data []byte) (map[string]any, bool) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// pubSubMeta returns the PubSub metadata of the message as fields under prefix (e.g:
+// pubsub.message_id, pubsub.attr.<key>), so a Honeycomb event can be traced back to its message.
+func pubSubMeta(msg MessagePublishedData, prefix string) map[string]any {
+	meta := map[string]any{
+		prefix + ".message_id": msg.Message.MessageID,
+	}
+	if msg.Subscription != "" {
+		meta[prefix+".subscription"] = msg.Subscription
+	}
+	if msg.Message.OrderingKey != "" {
+		meta[prefix+".ordering_key"] = msg.Message.OrderingKey
+	}
+	if !msg.Message.PublishTime.IsZero() {
+		meta[prefix+".publish_time"] = msg.Message.PublishTime.Format(time.RFC3339Nano)
+	}
+	for key, value := range msg.Message.Attributes {
+		meta[prefix+".attr."+key] = value
+	}
+	return meta
+}
+
+// renameFields renames the top-level keys of a decoded JSON object according to names (old -> new). When
+// the new name is already taken, the renamed value replaces it if overwrite is set, an error is returned
+// otherwise.
+func renameFields(fields map[string]any, names map[string]string, overwrite bool) error {
+	renamed := make(map[string]any, len(names))
+	for from, to := range names {
+		value, ok := fields[from]
+		if !ok || from == to {
+			continue
+		}
+		delete(fields, from)
+		renamed[to] = value
+	}
+	for to, value := range renamed {
+		if _, exists := fields[to]; exists && !overwrite {
+			return fmt.Errorf("field %q already exists", to)
+		}
+		fields[to] = value
+	}
+	return nil
+}
+
+// lookupPath returns the value at a dotted path (e.g: "user.id") of a decoded JSON object.
+func lookupPath(fields map[string]any, path string) (any, bool) {
+	var value any = fields
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// deletePath removes the field at a dotted path (e.g: "user.ssn") of a decoded JSON object.
+func deletePath(fields map[string]any, path string) {
+	keys := strings.Split(path, ".")
+	object := fields
+	for _, key := range keys[:len(keys)-1] {
+		nested, ok := object[key].(map[string]any)
+		if !ok {
+			return
+		}
+		object = nested
+	}
+	delete(object, keys[len(keys)-1])
+}
+
+// setPath sets the field at a dotted path (e.g: "log.time") of a decoded JSON object, when its parent
+// objects exist.
+func setPath(fields map[string]any, path string, value any) {
+	keys := strings.Split(path, ".")
+	object := fields
+	for _, key := range keys[:len(keys)-1] {
+		nested, ok := object[key].(map[string]any)
+		if !ok {
+			return
+		}
+		object = nested
+	}
+	object[keys[len(keys)-1]] = value
+}
+
+// allowPaths returns a copy of a decoded JSON object holding only the fields at the given dotted paths.
+// A path naming an object keeps it whole.
+func allowPaths(fields map[string]any, paths []string) map[string]any {
+	allowed := make(map[string]any)
+	for _, path := range paths {
+		value, ok := lookupPath(fields, path)
+		if !ok {
+			continue
+		}
+		keys := strings.Split(path, ".")
+		object := allowed
+		for _, key := range keys[:len(keys)-1] {
+			nested, ok := object[key].(map[string]any)
+			if !ok {
+				nested = make(map[string]any)
+				object[key] = nested
+			}
+			object = nested
+		}
+		object[keys[len(keys)-1]] = value
+	}
+	return allowed
+}
+
+// compileScrubPatterns combines the scrub patterns into a single regexp, so each string is scanned once
+// whatever the number of patterns.
+func compileScrubPatterns(patterns []string) (*regexp.Regexp, error) {
+	alternatives := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("error compiling scrub pattern %q %w", pattern, err)
+		}
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+	return regexp.Compile(strings.Join(alternatives, "|"))
+}
+
+// scrub replaces the parts of the string values matching pattern with mask, in nested objects and
+// arrays too. Keys are left untouched.
+func scrub(fields map[string]any, pattern *regexp.Regexp, mask string) {
+	for key, value := range fields {
+		fields[key] = scrubValue(value, pattern, mask)
+	}
+}
+
+func scrubValue(value any, pattern *regexp.Regexp, mask string) any {
+	switch v := value.(type) {
+	case string:
+		if pattern.MatchString(v) {
+			return pattern.ReplaceAllLiteralString(v, mask)
+		}
+		return v
+	case map[string]any:
+		scrub(v, pattern, mask)
+		return v
+	case []any:
+		for i, elem := range v {
+			v[i] = scrubValue(elem, pattern, mask)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// flatten expands nested objects into top-level keys joined by delimiter (e.g: {"user":{"id":1}}
+// becomes {"user.id":1}) so Honeycomb indexes them as columns. Arrays are left intact.
+func flatten(fields map[string]any, delimiter string) map[string]any {
+	flat := make(map[string]any, len(fields))
+	var walk func(prefix string, fields map[string]any)
+	walk = func(prefix string, fields map[string]any) {
+		for key, value := range fields {
+			if prefix != "" {
+				key = prefix + delimiter + key
+			}
+			if nested, ok := value.(map[string]any); ok && len(nested) > 0 {
+				walk(key, nested)
+				continue
+			}
+			flat[key] = value
+		}
+	}
+	walk("", fields)
+	return flat
+}
@@ -0,0 +1,432 @@
+package HoneycombSinkHandler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTransformFieldFilters(t *testing.T) {
+	const payload = `{"user":{"id":7,"ssn":"123-45-6789","address":{"city":"Paris","street":"1 rue"}},` +
+		`"credit_card":"4111","level":"info"}`
+	tests := []struct {
+		name  string
+		drop  []string
+		allow []string
+		data  string
+		want  string
+	}{
+		{
+			name: "drop top-level field",
+			drop: []string{"credit_card"},
+			data: payload,
+			want: `{"level":"info","user":{"address":{"city":"Paris","street":"1 rue"},"id":7,"ssn":"123-45-6789"}}`,
+		},
+		{
+			name: "drop nested fields",
+			drop: []string{"user.ssn", "user.address.street"},
+			data: payload,
+			want: `{"credit_card":"4111","level":"info","user":{"address":{"city":"Paris"},"id":7}}`,
+		},
+		{
+			name: "drop missing paths",
+			drop: []string{"absent", "user.absent", "level.absent"},
+			data: `{"level":"info"}`,
+			want: `{"level":"info"}`,
+		},
+		{
+			name:  "allow nested fields",
+			allow: []string{"level", "user.id", "user.address.city"},
+			data:  payload,
+			want:  `{"level":"info","user":{"address":{"city":"Paris"},"id":7}}`,
+		},
+		{
+			name:  "allow an object keeps it whole",
+			allow: []string{"user.address"},
+			data:  payload,
+			want:  `{"user":{"address":{"city":"Paris","street":"1 rue"}}}`,
+		},
+		{
+			name:  "allow then drop",
+			allow: []string{"user"},
+			drop:  []string{"user.ssn"},
+			data:  payload,
+			want:  `{"user":{"address":{"city":"Paris","street":"1 rue"},"id":7}}`,
+		},
+		{
+			name: "array elements filtered one by one",
+			drop: []string{"secret"},
+			data: `[{"a":1,"secret":"x"},{"b":2,"secret":"y"}]`,
+			want: `[{"a":1},{"b":2}]`,
+		},
+		{
+			name:  "non-object payload untouched",
+			drop:  []string{"secret"},
+			allow: []string{"a"},
+			data:  `"just a string"`,
+			want:  `"just a string"`,
+		},
+		{
+			name: "array of scalars untouched",
+			drop: []string{"secret"},
+			data: `[1,2,3]`,
+			want: `[1,2,3]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{DropFields: tt.drop, AllowFields: tt.allow}
+			got, err := transformPayload(quietContext(), cfg, MessagePublishedData{Message: PubSubMessage{Data: []byte(tt.data)}})
+			if err != nil {
+				t.Fatalf("transformPayload() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("transformPayload() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPayload(t *testing.T) {
+	const wrapped = `{"payload":{"user":{"id":7},"level":"info"},"meta":{"source":"api"}}`
+	tests := []struct {
+		name        string
+		data        string
+		path        string
+		want        string
+		wantMissing bool
+		wantErr     bool
+	}{
+		{name: "present", data: wrapped, path: "payload", want: `{"level":"info","user":{"id":7}}`},
+		{name: "nested", data: wrapped, path: "payload.user", want: `{"id":7}`},
+		{name: "missing", data: wrapped, path: "body", wantMissing: true},
+		{name: "missing nested", data: wrapped, path: "payload.body", wantMissing: true},
+		{name: "non-object", data: wrapped, path: "payload.level", wantErr: true},
+		{name: "null", data: `{"payload":null}`, path: "payload", wantErr: true},
+		{name: "non-object payload untouched", data: `[{"payload":{}}]`, path: "payload", want: `[{"payload":{}}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractPayload([]byte(tt.data), tt.path)
+			switch {
+			case tt.wantMissing:
+				if !errors.Is(err, errExtractPathMissing) || !errors.Is(err, ErrPermanent) {
+					t.Errorf("extractPayload() error = %v, want a permanent errExtractPathMissing", err)
+				}
+			case tt.wantErr:
+				if err == nil || errors.Is(err, errExtractPathMissing) || !errors.Is(err, ErrPermanent) {
+					t.Errorf("extractPayload() error = %v, want a permanent error", err)
+				}
+			case err != nil || string(got) != tt.want:
+				t.Errorf("extractPayload() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerExtractPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		skipMissing bool
+		data        string
+		want        string // the forwarded event, none when empty
+		wantErr     bool
+	}{
+		{name: "unset", data: `{"payload":{"a":1},"meta":{}}`, want: `{"payload":{"a":1},"meta":{}}`},
+		{name: "present", path: "payload", data: `{"payload":{"a":1},"meta":{}}`, want: `{"a":1}`},
+		{name: "missing fails", path: "payload", data: `{"meta":{}}`, wantErr: true},
+		{name: "missing skipped", path: "payload", skipMissing: true, data: `{"meta":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			honeycomb := newFakeHoneycomb(t, nil)
+			cfg := testConfig(honeycomb)
+			cfg.ExtractPath = tt.path
+			cfg.ExtractSkipMissing = tt.skipMissing
+
+			err := handleData(t, cfg, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handler error = %v, want error: %v", err, tt.wantErr)
+			}
+			requests := honeycomb.received()
+			switch {
+			case tt.want == "" && len(requests) != 0:
+				t.Errorf("Honeycomb received %s, want nothing", requests[0].Body)
+			case tt.want != "" && (len(requests) != 1 || string(requests[0].Body) != tt.want):
+				t.Errorf("Honeycomb received %v, want %s", requests, tt.want)
+			}
+		})
+	}
+}
+
+func TestScrub(t *testing.T) {
+	const email, card = `[\w.+-]+@[\w-]+\.[\w.]+`, `\b(?:\d[ -]?){13,16}\b`
+	tests := []struct {
+		name     string
+		patterns []string
+		data     string
+		want     string
+	}{
+		{name: "whole value", patterns: []string{email}, data: `{"user":"jane@example.com","id":7}`,
+			want: `{"id":7,"user":"[REDACTED]"}`},
+		{name: "part of a value", patterns: []string{email}, data: `{"msg":"mail sent to jane@example.com today"}`,
+			want: `{"msg":"mail sent to [REDACTED] today"}`},
+		{name: "every match", patterns: []string{email}, data: `{"msg":"a@b.io, c@d.io"}`,
+			want: `{"msg":"[REDACTED], [REDACTED]"}`},
+		{name: "combined patterns", patterns: []string{email, card},
+			data: `{"msg":"jane@example.com paid with 4111 1111 1111 1111"}`,
+			want: `{"msg":"[REDACTED] paid with [REDACTED]"}`},
+		{name: "nested objects and arrays", patterns: []string{email},
+			data: `{"user":{"contact":{"email":"jane@example.com"}},"cc":["a@b.io",{"to":"c@d.io"},3]}`,
+			want: `{"cc":["[REDACTED]",{"to":"[REDACTED]"},3],"user":{"contact":{"email":"[REDACTED]"}}}`},
+		{name: "keys untouched", patterns: []string{email}, data: `{"jane@example.com":"x"}`,
+			want: `{"jane@example.com":"x"}`},
+		{name: "numbers untouched", patterns: []string{card},
+			data: `{"amount":4111111111111111,"n":"4111111111111111"}`,
+			want: `{"amount":4111111111111111,"n":"[REDACTED]"}`},
+		{name: "no match", patterns: []string{email}, data: `{"msg":"nothing to hide"}`,
+			want: `{"msg":"nothing to hide"}`},
+		{name: "each array event", patterns: []string{email}, data: `[{"u":"a@b.io"},{"u":"anonymous"}]`,
+			want: `[{"u":"[REDACTED]"},{"u":"anonymous"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, err := compileScrubPatterns(tt.patterns)
+			if err != nil {
+				t.Fatalf("compileScrubPatterns() error = %v", err)
+			}
+			cfg := Config{ScrubPattern: pattern, ScrubMask: defaultScrubMask}
+			got, err := transformPayload(quietContext(), cfg, MessagePublishedData{Message: PubSubMessage{Data: []byte(tt.data)}})
+			if err != nil {
+				t.Fatalf("transformPayload() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("transformPayload() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileScrubPatterns(t *testing.T) {
+	// Each pattern is grouped, so the flags of one don't apply to the others
+	pattern, err := compileScrubPatterns([]string{`(?i)secret`, `TOKEN`})
+	if err != nil {
+		t.Fatalf("compileScrubPatterns() error = %v", err)
+	}
+	if got := pattern.ReplaceAllLiteralString("SECRET token TOKEN", "*"); got != "* token *" {
+		t.Errorf("scrubbed = %q, want %q", got, "* token *")
+	}
+	if _, err := compileScrubPatterns([]string{`ok`, `(unclosed`}); err == nil ||
+		!strings.Contains(err.Error(), "(unclosed") {
+		t.Errorf("compileScrubPatterns() error = %v, want the invalid pattern named", err)
+	}
+}
+
+func TestScrubPatternFromEnv(t *testing.T) {
+	t.Setenv("HONEYCOMB_DATASET", "test-dataset")
+	t.Setenv("HONEYCOMB_API_KEY", "test-api-key")
+	tests := []struct {
+		name         string
+		patterns     string
+		mask         string
+		wantDisabled bool
+		wantMask     string
+		wantErr      bool
+	}{
+		{name: "unset", wantDisabled: true, wantMask: defaultScrubMask},
+		{name: "empty list", patterns: `[]`, wantDisabled: true, wantMask: defaultScrubMask},
+		{name: "patterns", patterns: `["[\\w.+-]+@[\\w-]+\\.[\\w.]+"]`, mask: "***", wantMask: "***"},
+		{name: "not a list", patterns: `[\w]+`, wantErr: true},
+		{name: "invalid pattern", patterns: `["(unclosed"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HONEYCOMB_SCRUB_PATTERNS", tt.patterns)
+			t.Setenv("HONEYCOMB_SCRUB_MASK", tt.mask)
+			if tt.mask == "" {
+				os.Unsetenv("HONEYCOMB_SCRUB_MASK")
+			}
+			cfg, err := ConfigFromEnv()
+			if tt.wantErr {
+				if !errors.Is(err, ErrConfig) {
+					t.Errorf("ConfigFromEnv() error = %v, want a configuration error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConfigFromEnv() error = %v", err)
+			}
+			if (cfg.ScrubPattern == nil) != tt.wantDisabled || cfg.ScrubMask != tt.wantMask {
+				t.Errorf("ScrubPattern = %v, ScrubMask = %q, want disabled: %v, mask %q", cfg.ScrubPattern,
+					cfg.ScrubMask, tt.wantDisabled, tt.wantMask)
+			}
+		})
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		data      string
+		want      string
+	}{
+		{name: "nested", delimiter: ".", data: `{"user":{"id":7,"address":{"city":"Paris"}},"level":"info"}`,
+			want: `{"level":"info","user.address.city":"Paris","user.id":7}`},
+		{name: "delimiter", delimiter: "_", data: `{"user":{"id":7}}`, want: `{"user_id":7}`},
+		{name: "arrays kept", delimiter: ".", data: `{"tags":[{"a":1}],"user":{"roles":["admin"]}}`,
+			want: `{"tags":[{"a":1}],"user.roles":["admin"]}`},
+		{name: "empty object kept", delimiter: ".", data: `{"user":{},"a":null}`, want: `{"a":null,"user":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Flatten: true, FlattenDelimiter: tt.delimiter}
+			got, err := transformPayload(quietContext(), cfg, MessagePublishedData{Message: PubSubMessage{Data: []byte(tt.data)}})
+			if err != nil {
+				t.Fatalf("transformPayload() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("transformPayload() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     map[string]string
+		overwrite bool
+		data      string
+		want      string
+		wantErr   bool
+	}{
+		{name: "renamed", names: map[string]string{"msg": "message", "lvl": "level"},
+			data: `{"msg":"hi","lvl":"info","a":1}`, want: `{"a":1,"level":"info","message":"hi"}`},
+		{name: "missing field", names: map[string]string{"msg": "message"}, data: `{"a":1}`, want: `{"a":1}`},
+		{name: "same name", names: map[string]string{"msg": "msg"}, data: `{"msg":"hi"}`, want: `{"msg":"hi"}`},
+		{name: "swapped", names: map[string]string{"a": "b", "b": "a"}, data: `{"a":1,"b":2}`,
+			want: `{"a":2,"b":1}`},
+		{name: "taken", names: map[string]string{"msg": "message"}, data: `{"msg":"hi","message":"kept"}`,
+			wantErr: true},
+		{name: "taken overwritten", names: map[string]string{"msg": "message"}, overwrite: true,
+			data: `{"msg":"hi","message":"replaced"}`, want: `{"message":"hi"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{FieldMap: tt.names, FieldMapOverwrite: tt.overwrite}
+			got, err := transformPayload(quietContext(), cfg, MessagePublishedData{Message: PubSubMessage{Data: []byte(tt.data)}})
+			if tt.wantErr {
+				if !errors.Is(err, ErrPermanent) {
+					t.Errorf("transformPayload() error = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("transformPayload() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPubSubMeta(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  MessagePublishedData
+		want map[string]any
+	}{
+		{
+			name: "all metadata",
+			msg: MessagePublishedData{Subscription: "projects/p/subscriptions/s", Message: PubSubMessage{
+				MessageID: "m-1", OrderingKey: "user-7", PublishTime: testPublishTime,
+				Attributes: map[string]string{"team": "payments"},
+			}},
+			want: map[string]any{"pubsub.message_id": "m-1", "pubsub.subscription": "projects/p/subscriptions/s",
+				"pubsub.ordering_key": "user-7", "pubsub.publish_time": "2024-05-06T07:08:09Z",
+				"pubsub.attr.team": "payments"},
+		},
+		{
+			name: "only the message ID",
+			msg:  MessagePublishedData{Message: PubSubMessage{MessageID: "m-1"}},
+			want: map[string]any{"pubsub.message_id": "m-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pubSubMeta(tt.msg, "pubsub"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pubSubMeta() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerScrub(t *testing.T) {
+	honeycomb := newFakeHoneycomb(t, nil)
+	cfg := testConfig(honeycomb)
+	pattern, err := compileScrubPatterns([]string{`[\w.+-]+@[\w-]+\.[\w.]+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ScrubPattern, cfg.ScrubMask = pattern, "***"
+	cfg.IncludePubSubMeta, cfg.PubSubMetaPrefix = true, "pubsub"
+
+	// The metadata added by the sink isn't scrubbed, only the producer's fields
+	e := pubSubEvent(t, PubSubMessage{Data: []byte(`{"user":{"email":"jane@example.com"}}`),
+		Attributes: map[string]string{"owner": "ops@example.com"}})
+	handler, _ := NewHandler(cfg)
+	if err := handler(quietContext(), e); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	got := honeycomb.lastEvent(t)
+	if user, _ := got["user"].(map[string]any); user["email"] != "***" {
+		t.Errorf("user = %v, want the email masked", got["user"])
+	}
+	if got["pubsub.attr.owner"] != "ops@example.com" {
+		t.Errorf("pubsub.attr.owner = %v, want the attribute as is", got["pubsub.attr.owner"])
+	}
+}
+
+func BenchmarkScrub(b *testing.B) {
+	// A 62 KB object of nested log entries, a few of the strings holding an email or a card number
+	entries := make([]string, 0, 500)
+	for i := 0; i < cap(entries); i++ {
+		msg := fmt.Sprintf("request %d served in %d ms", i, i%97)
+		if i%50 == 0 {
+			msg = fmt.Sprintf("payment by user%d@example.com with card 4111 1111 1111 %04d", i, i)
+		}
+		entries = append(entries, fmt.Sprintf(`{"level":"info","msg":%q,"http":{"path":"/v1/items/%d",`+
+			`"status":200},"tags":["api","eu-west1"]}`, msg, i))
+	}
+	data := []byte(`{"service":"checkout","entries":[` + strings.Join(entries, ",") + `]}`)
+	patterns := map[string][]string{
+		"email": {`[\w.+-]+@[\w-]+\.[\w.]+`},
+		"4 patterns": {`[\w.+-]+@[\w-]+\.[\w.]+`, `\b(?:\d[ -]?){13,16}\b`, `\b\d{3}-\d{2}-\d{4}\b`,
+			`(?i)bearer [\w.-]+`},
+	}
+	for _, name := range []string{"email", "4 patterns"} {
+		b.Run(name, func(b *testing.B) {
+			pattern, err := compileScrubPatterns(patterns[name])
+			if err != nil {
+				b.Fatal(err)
+			}
+			cfg := Config{ScrubPattern: pattern, ScrubMask: defaultScrubMask}
+			msg := MessagePublishedData{Message: PubSubMessage{MessageID: "message-1", Data: data}}
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				got, err := transformPayload(quietContext(), cfg, msg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if bytes.Contains(got, []byte("@example.com")) {
+					b.Fatalf("payload not scrubbed: %s", got)
+				}
+			}
+		})
+	}
+}